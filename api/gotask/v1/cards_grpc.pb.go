@@ -0,0 +1,256 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: gotask/v1/cards.proto
+
+package gotaskv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// CardsServiceClient is the client API for CardsService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CardsServiceClient interface {
+	GetCard(ctx context.Context, in *GetCardRequest, opts ...grpc.CallOption) (*Card, error)
+	ListCards(ctx context.Context, in *ListCardsRequest, opts ...grpc.CallOption) (*ListCardsResponse, error)
+	CreateCard(ctx context.Context, in *CreateCardRequest, opts ...grpc.CallOption) (*Card, error)
+	RefillCard(ctx context.Context, in *RefillCardRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// TransferBalance moves money between cards. Transfers above the step-up
+	// threshold are not executed, they are returned as pending and have to be
+	// confirmed with a two-factor code over the REST API.
+	TransferBalance(ctx context.Context, in *TransferBalanceRequest, opts ...grpc.CallOption) (*TransferBalanceResponse, error)
+}
+
+type cardsServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCardsServiceClient(cc grpc.ClientConnInterface) CardsServiceClient {
+	return &cardsServiceClient{cc}
+}
+
+func (c *cardsServiceClient) GetCard(ctx context.Context, in *GetCardRequest, opts ...grpc.CallOption) (*Card, error) {
+	out := new(Card)
+	err := c.cc.Invoke(ctx, "/gotask.v1.CardsService/GetCard", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cardsServiceClient) ListCards(ctx context.Context, in *ListCardsRequest, opts ...grpc.CallOption) (*ListCardsResponse, error) {
+	out := new(ListCardsResponse)
+	err := c.cc.Invoke(ctx, "/gotask.v1.CardsService/ListCards", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cardsServiceClient) CreateCard(ctx context.Context, in *CreateCardRequest, opts ...grpc.CallOption) (*Card, error) {
+	out := new(Card)
+	err := c.cc.Invoke(ctx, "/gotask.v1.CardsService/CreateCard", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cardsServiceClient) RefillCard(ctx context.Context, in *RefillCardRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/gotask.v1.CardsService/RefillCard", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cardsServiceClient) TransferBalance(ctx context.Context, in *TransferBalanceRequest, opts ...grpc.CallOption) (*TransferBalanceResponse, error) {
+	out := new(TransferBalanceResponse)
+	err := c.cc.Invoke(ctx, "/gotask.v1.CardsService/TransferBalance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CardsServiceServer is the server API for CardsService service.
+// All implementations must embed UnimplementedCardsServiceServer
+// for forward compatibility
+type CardsServiceServer interface {
+	GetCard(context.Context, *GetCardRequest) (*Card, error)
+	ListCards(context.Context, *ListCardsRequest) (*ListCardsResponse, error)
+	CreateCard(context.Context, *CreateCardRequest) (*Card, error)
+	RefillCard(context.Context, *RefillCardRequest) (*emptypb.Empty, error)
+	// TransferBalance moves money between cards. Transfers above the step-up
+	// threshold are not executed, they are returned as pending and have to be
+	// confirmed with a two-factor code over the REST API.
+	TransferBalance(context.Context, *TransferBalanceRequest) (*TransferBalanceResponse, error)
+	mustEmbedUnimplementedCardsServiceServer()
+}
+
+// UnimplementedCardsServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedCardsServiceServer struct {
+}
+
+func (UnimplementedCardsServiceServer) GetCard(context.Context, *GetCardRequest) (*Card, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCard not implemented")
+}
+func (UnimplementedCardsServiceServer) ListCards(context.Context, *ListCardsRequest) (*ListCardsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCards not implemented")
+}
+func (UnimplementedCardsServiceServer) CreateCard(context.Context, *CreateCardRequest) (*Card, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateCard not implemented")
+}
+func (UnimplementedCardsServiceServer) RefillCard(context.Context, *RefillCardRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefillCard not implemented")
+}
+func (UnimplementedCardsServiceServer) TransferBalance(context.Context, *TransferBalanceRequest) (*TransferBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TransferBalance not implemented")
+}
+func (UnimplementedCardsServiceServer) mustEmbedUnimplementedCardsServiceServer() {}
+
+// UnsafeCardsServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CardsServiceServer will
+// result in compilation errors.
+type UnsafeCardsServiceServer interface {
+	mustEmbedUnimplementedCardsServiceServer()
+}
+
+func RegisterCardsServiceServer(s grpc.ServiceRegistrar, srv CardsServiceServer) {
+	s.RegisterService(&CardsService_ServiceDesc, srv)
+}
+
+func _CardsService_GetCard_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCardRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CardsServiceServer).GetCard(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gotask.v1.CardsService/GetCard",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CardsServiceServer).GetCard(ctx, req.(*GetCardRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CardsService_ListCards_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCardsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CardsServiceServer).ListCards(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gotask.v1.CardsService/ListCards",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CardsServiceServer).ListCards(ctx, req.(*ListCardsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CardsService_CreateCard_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateCardRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CardsServiceServer).CreateCard(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gotask.v1.CardsService/CreateCard",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CardsServiceServer).CreateCard(ctx, req.(*CreateCardRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CardsService_RefillCard_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefillCardRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CardsServiceServer).RefillCard(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gotask.v1.CardsService/RefillCard",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CardsServiceServer).RefillCard(ctx, req.(*RefillCardRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CardsService_TransferBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CardsServiceServer).TransferBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gotask.v1.CardsService/TransferBalance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CardsServiceServer).TransferBalance(ctx, req.(*TransferBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CardsService_ServiceDesc is the grpc.ServiceDesc for CardsService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CardsService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gotask.v1.CardsService",
+	HandlerType: (*CardsServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetCard",
+			Handler:    _CardsService_GetCard_Handler,
+		},
+		{
+			MethodName: "ListCards",
+			Handler:    _CardsService_ListCards_Handler,
+		},
+		{
+			MethodName: "CreateCard",
+			Handler:    _CardsService_CreateCard_Handler,
+		},
+		{
+			MethodName: "RefillCard",
+			Handler:    _CardsService_RefillCard_Handler,
+		},
+		{
+			MethodName: "TransferBalance",
+			Handler:    _CardsService_TransferBalance_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "gotask/v1/cards.proto",
+}
@@ -1,21 +1,36 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"github.com/lenarsaitov/go-task/internals"
 	"github.com/lenarsaitov/go-task/internals/config"
 	"github.com/lenarsaitov/go-task/internals/db"
+	"github.com/lenarsaitov/go-task/internals/graphqlapi"
+	"github.com/lenarsaitov/go-task/internals/grpcapi"
+	"github.com/lenarsaitov/go-task/internals/openapi"
+	"github.com/lenarsaitov/go-task/internals/retention"
+	"github.com/lenarsaitov/go-task/internals/services/audit"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
 	"github.com/lenarsaitov/go-task/internals/services/cards"
+	"github.com/lenarsaitov/go-task/internals/services/imports"
+	"github.com/lenarsaitov/go-task/internals/services/kyc"
 	"github.com/lenarsaitov/go-task/internals/services/users"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lenarsaitov/go-task/internals/versioning"
+	"github.com/lenarsaitov/go-task/pkg/blobstore"
+	"github.com/lenarsaitov/go-task/pkg/jwt"
 	"github.com/lenarsaitov/go-task/pkg/logging"
+	"github.com/lenarsaitov/go-task/pkg/notify"
 	"github.com/lenarsaitov/go-task/pkg/shutdown"
+	"google.golang.org/grpc"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"syscall"
-	"time"
 )
 
 func main() {
@@ -29,29 +44,191 @@ func main() {
 
 	logger.Println("database initializing")
 	postgres := db.NewPostgresDB(cfg)
+	maintenance := db.NewMaintenanceDB(cfg, postgres)
 
 	logger.Println("router initializing")
 	router := internals.NewServer()
 
+	logger.Println("blob storage initializing")
+	blobs, err := blobstore.New(cfg.KYC.Storage, cfg.KYC.Dir)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	logger.Println("create and register service auth's storage, service and handlers")
+	var verifier *jwt.Verifier
+	if len(cfg.Auth.JWT.Secret) != 0 || len(cfg.Auth.JWT.JWKSFile) != 0 {
+		verifier, err = jwt.NewVerifier(jwt.Config{
+			Secret:   cfg.Auth.JWT.Secret,
+			JWKSFile: cfg.Auth.JWT.JWKSFile,
+			Issuer:   cfg.Auth.JWT.Issuer,
+			Audience: cfg.Auth.JWT.Audience,
+			Leeway:   cfg.Auth.JWT.Leeway,
+		})
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+	notifier, err := notify.New(cfg.Auth.Notifier.Type, cfg.Auth.Notifier.Path)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	// credentials are looked up before the tenant of the request is bound
+	authStorage := auth.NewAuthStorage(maintenance, audit.Auditor{})
+	authService := auth.NewAuthService(authStorage, verifier, cfg.Auth.AdminKey, auth.TokenSettings{
+		Secret:     cfg.Auth.JWT.Secret,
+		Issuer:     cfg.Auth.JWT.Issuer,
+		Audience:   cfg.Auth.JWT.Audience,
+		AccessTTL:  cfg.Auth.Tokens.AccessTTL,
+		RefreshTTL: cfg.Auth.Tokens.RefreshTTL,
+		ResetTTL:   cfg.Auth.PasswordReset.TTL,
+		BcryptCost: cfg.Auth.BcryptCost,
+	}, notifier)
+	authHandlers := auth.NewAuthHandler(authService)
+
 	logger.Println("create and register service user's storage, service and handlers")
 	userStorage := users.NewUserStorage(postgres)
-	userService := users.NewUserService(userStorage)
+	userService := users.NewUserService(userStorage, blobs, cfg.Auth.TOTPIssuer)
 	userHandlers := users.NewUserHandler(userService)
-	userRoot := router.Group("", internals.DefaultJsonContentTypeMiddleware())
 
 	logger.Println("create and register service card's storage, service and handlers")
 	cardStorage := cards.NewCardStorage(postgres)
-	cardService := cards.NewCardService(cardStorage)
+	cardService := cards.NewCardService(cardStorage, kyc.NewLimitsTable(cfg), cards.StepUpSettings{
+		Threshold:   cfg.Cards.StepUp.Threshold,
+		TTL:         cfg.Cards.StepUp.TTL,
+		MaxAttempts: cfg.Cards.StepUp.MaxAttempts,
+		Lockout:     cfg.Cards.StepUp.Lockout,
+	})
 	cardHandlers := cards.NewCardHandler(cardService)
-	cardRoot := router.Group("", internals.DefaultJsonContentTypeMiddleware())
 
-	userHandlers.Setup(userRoot)
-	cardHandlers.Setup(cardRoot)
+	logger.Println("create and register service kyc's storage, service and handlers")
+	kycStorage := kyc.NewKycStorage(postgres)
+	kycService := kyc.NewKycService(kycStorage, blobs, cfg.KYC.MaxFileSize)
+	kycHandlers := kyc.NewKycHandler(kycService)
+
+	logger.Println("create and register service audit's storage, service and handlers")
+	auditStorage := audit.NewAuditStorage(postgres)
+	auditService := audit.NewAuditService(auditStorage)
+	auditHandlers := audit.NewAuditHandler(auditService)
+
+	logger.Println("create and register service import's storage, service and handlers")
+	instance := cfg.Imports.Instance
+	if len(instance) == 0 {
+		if instance, err = os.Hostname(); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	importStorage := imports.NewImportStorage(postgres)
+	importService := imports.NewImportService(importStorage, map[string]imports.Importer{
+		imports.EntityUsers: userService,
+		imports.EntityCards: cardService,
+	}, imports.Settings{
+		MaxSize:   cfg.Imports.MaxSize,
+		MaxRows:   cfg.Imports.MaxRows,
+		Workers:   cfg.Imports.Workers,
+		QueueSize: cfg.Imports.QueueSize,
+		RLS:       cfg.Tenancy.RLS,
+		Instance:  instance,
+	})
+	importHandlers := imports.NewImportHandler(importService)
+	if n, err := importService.FailInterrupted(tenant.Maintenance(context.Background(), maintenance)); err != nil {
+		logger.Fatal(err)
+	} else if n > 0 {
+		logger.Warnf("%d imports interrupted by the last shutdown are marked failed", n)
+	}
+
+	logger.Println("create and register graphql handlers of users and cards")
+	graphqlHandlers, err := graphqlapi.NewGraphQLHandler(userService, cardService, graphqlapi.Settings{
+		MaxDepth:      cfg.GraphQL.MaxDepth,
+		MaxComplexity: cfg.GraphQL.MaxComplexity,
+	})
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	exportRouter := router
+	if len(cfg.Listen.Export.Port) != 0 {
+		exportRouter = internals.NewServer()
+	}
+
+	logger.Println("register routes of every api version")
+	deprecation, err := versioning.ParseDeprecation(cfg.API.Legacy.DeprecatedAt, cfg.API.Legacy.Sunset)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	for _, v := range versioning.Versions(cfg.API.Legacy.Enabled) {
+		publicRoot := router.Group(v.Prefix, versioning.Middleware(v, deprecation), internals.DefaultJsonContentTypeMiddleware(), tenant.Middleware(postgres, cfg.Tenancy.RLS))
+		root := router.Group(v.Prefix, versioning.Middleware(v, deprecation), internals.DefaultJsonContentTypeMiddleware(), auth.Middleware(authService), tenant.Middleware(postgres, cfg.Tenancy.RLS))
+
+		authHandlers.SetupPublic(publicRoot)
+		authHandlers.Setup(root)
+		userHandlers.Setup(root)
+		cardHandlers.Setup(root)
+		kycHandlers.Setup(root)
+		auditHandlers.Setup(root)
+		importHandlers.Setup(root)
+		graphqlHandlers.Setup(root)
+
+		exportRoot := root
+		if exportRouter != router {
+			exportRoot = exportRouter.Group(v.Prefix, versioning.Middleware(v, deprecation), internals.DefaultJsonContentTypeMiddleware(), auth.Middleware(authService), tenant.Middleware(postgres, cfg.Tenancy.RLS))
 
-	start(router, logger, cfg)
+			// on the main port the paths would be taken for ids of /users/:id and /cards/:id
+			router.GET(v.Prefix+"/users/export", internals.Misdirected(cfg.Listen.Export.Port))
+			router.GET(v.Prefix+"/cards/export", internals.Misdirected(cfg.Listen.Export.Port))
+		}
+		userHandlers.SetupExport(exportRoot)
+		cardHandlers.SetupExport(exportRoot)
+	}
+
+	logger.Println("register openapi specification and check it covers every route")
+	spec := openapi.Build()
+	openAPIHandlers, err := openapi.NewOpenAPIHandler(spec)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	openAPIHandlers.Setup(router)
+	routes := router.Routes()
+	if exportRouter != router {
+		routes = append(routes, exportRouter.Routes()...)
+	}
+	if err = openapi.Check(routes, spec); err != nil {
+		logger.Fatal(err)
+	}
+
+	logger.Println("start retention job for soft-deleted users and cards")
+	go retention.Run(tenant.Maintenance(context.Background(), maintenance), cfg.Retention.Period, cfg.Retention.Interval,
+		retention.NamedPurger{Name: "cards", Purger: cardService},
+		retention.NamedPurger{Name: "users", Purger: userService},
+	)
+
+	if cfg.GRPC.Enabled {
+		logger.Println("create gRPC server of users and cards")
+		grpcServer := grpcapi.NewServer(postgres, authService, userService, cardService, grpcapi.Settings{
+			RLS:        cfg.Tenancy.RLS,
+			Reflection: cfg.GRPC.Reflection,
+		})
+		go startGRPC(grpcServer, logger, cfg)
+		defer grpcServer.GracefulStop()
+	}
+
+	start(router, exportRouter, logger, cfg)
 }
 
-func start(router *echo.Echo, logger logging.Logger, cfg *config.Config) {
+func startGRPC(server *grpc.Server, logger logging.Logger, cfg *config.Config) {
+	logger.Infof("bind gRPC server to host: %s and port: %s", cfg.Listen.BindIP, cfg.GRPC.Port)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", cfg.Listen.BindIP, cfg.GRPC.Port))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if err = server.Serve(listener); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+func start(router *echo.Echo, exportRouter *echo.Echo, logger logging.Logger, cfg *config.Config) {
 	logger.Infof("bind application to host: %s and port: %s", cfg.Listen.BindIP, cfg.Listen.Port)
 
 	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", cfg.Listen.BindIP, cfg.Listen.Port))
@@ -61,15 +238,37 @@ func start(router *echo.Echo, logger logging.Logger, cfg *config.Config) {
 
 	server := &http.Server{
 		Handler:      router,
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+		WriteTimeout: cfg.Listen.WriteTimeout,
+		ReadTimeout:  cfg.Listen.ReadTimeout,
 	}
+	servers := []io.Closer{server}
+
+	if exportRouter != router {
+		logger.Infof("bind exports to host: %s and port: %s", cfg.Listen.BindIP, cfg.Listen.Export.Port)
 
-	go shutdown.Graceful([]os.Signal{syscall.SIGABRT, syscall.SIGQUIT, syscall.SIGHUP, os.Interrupt, syscall.SIGTERM}, server)
+		exportListener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", cfg.Listen.BindIP, cfg.Listen.Export.Port))
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		exportServer := &http.Server{
+			Handler:      exportRouter,
+			WriteTimeout: cfg.Listen.Export.WriteTimeout,
+			ReadTimeout:  cfg.Listen.ReadTimeout,
+		}
+		servers = append(servers, exportServer)
+		go serve(exportServer, exportListener, logger)
+	}
+
+	go shutdown.Graceful([]os.Signal{syscall.SIGABRT, syscall.SIGQUIT, syscall.SIGHUP, os.Interrupt, syscall.SIGTERM}, servers...)
 
 	logger.Println("application initialized and started")
 
-	if err = server.Serve(listener); err != nil {
+	serve(server, listener, logger)
+}
+
+func serve(server *http.Server, listener net.Listener, logger logging.Logger) {
+	if err := server.Serve(listener); err != nil {
 		switch {
 		case errors.Is(err, http.ErrServerClosed):
 			logger.Warn("server shutdown")
@@ -1,16 +1,344 @@
+DROP TABLE IF EXISTS import_errors;
+DROP TABLE IF EXISTS import_jobs;
+DROP TABLE IF EXISTS audit_chain_heads;
+DROP TABLE IF EXISTS audit_log;
+DROP FUNCTION IF EXISTS audit_log_immutable();
+DROP FUNCTION IF EXISTS bump_version() CASCADE;
+DROP TABLE IF EXISTS pending_transfers;
+DROP TABLE IF EXISTS user_totp;
+DROP TABLE IF EXISTS password_reset_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS user_credentials;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS user_merges;
 DROP TABLE IF EXISTS cards_history;
 DROP TABLE IF EXISTS cards;
+DROP TABLE IF EXISTS kyc_documents;
+DROP TABLE IF EXISTS user_addresses;
 DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS tenants;
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE tenants (
+       tenant_id     varchar(50) PRIMARY KEY,
+       name          varchar(100) NOT NULL,
+       create_time   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+INSERT INTO tenants (tenant_id, name) VALUES ('default', 'Default');
 
 CREATE TABLE users (
        user_id          SERIAL PRIMARY KEY,
        user_full_name   varchar(100) NOT NULL,
+       first_name       varchar(50),
+       last_name        varchar(50),
+       middle_name      varchar(50),
+       email            varchar(254),
+       phone            varchar(16),
+       birth_date       DATE,
+       kyc_level        varchar(8) NOT NULL DEFAULT 'none'
+                        CHECK (kyc_level IN ('none', 'basic', 'full')),
+       create_time      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       deleted_at       TIMESTAMP WITH TIME ZONE,
+       erased_at        TIMESTAMP WITH TIME ZONE,
+       version          BIGINT NOT NULL DEFAULT 1,
+       tenant_id        varchar(50) NOT NULL DEFAULT 'default' REFERENCES tenants (tenant_id)
+);
+
+CREATE UNIQUE INDEX users_email_key ON users(tenant_id, email) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX users_phone_key ON users(tenant_id, phone) WHERE deleted_at IS NULL;
+CREATE INDEX idx_users_tenant_id ON users(tenant_id, user_id);
+CREATE INDEX idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_users_last_name ON users(last_name);
+CREATE INDEX idx_users_birth_date ON users(birth_date);
+CREATE INDEX idx_users_full_name_trgm ON users USING gin (user_full_name gin_trgm_ops);
+CREATE INDEX idx_users_email_trgm ON users USING gin (email gin_trgm_ops);
+CREATE INDEX idx_users_phone_trgm ON users USING gin (phone gin_trgm_ops);
+CREATE INDEX idx_users_create_time ON users(create_time, user_id);
+
+CREATE TABLE user_addresses (
+       address_id    SERIAL PRIMARY KEY,
+       user_id       INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       kind          varchar(16) NOT NULL DEFAULT 'home',
+       country       char(2) NOT NULL,
+       region        varchar(100),
+       city          varchar(100) NOT NULL,
+       street        varchar(200) NOT NULL,
+       postal_code   varchar(20) NOT NULL,
+       create_time   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_user_addresses_user_id ON user_addresses(user_id);
+
+-- user ids are not foreign keys: merge records outlive purged source users
+CREATE TABLE user_merges (
+       merge_id          SERIAL PRIMARY KEY,
+       source_user_id    INT NOT NULL UNIQUE,
+       target_user_id    INT NOT NULL,
+       cards_moved       INT NOT NULL DEFAULT 0,
+       documents_moved   INT NOT NULL DEFAULT 0,
+       create_time       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_user_merges_target_user_id ON user_merges(target_user_id);
+
+CREATE TABLE kyc_documents (
+       document_id      SERIAL PRIMARY KEY,
+       user_id          INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       doc_type         varchar(32) NOT NULL,
+       status           varchar(16) NOT NULL DEFAULT 'pending'
+                        CHECK (status IN ('pending', 'approved', 'rejected')),
+       file_name        varchar(255) NOT NULL,
+       content_type     varchar(64) NOT NULL,
+       size             BIGINT NOT NULL,
+       storage_key      varchar(255) NOT NULL,
+       review_level     varchar(8),
+       review_comment   text,
+       review_time      TIMESTAMP WITH TIME ZONE,
        create_time      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
 );
 
+CREATE INDEX idx_kyc_documents_user_id ON kyc_documents(user_id);
+CREATE INDEX idx_kyc_documents_status ON kyc_documents(status);
+
 CREATE TABLE cards (
        card_id       SERIAL PRIMARY KEY,
        balance       BIGINT NOT NULL DEFAULT 0,
        create_time   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
-       user_id       INT REFERENCES users (user_id)
-);
\ No newline at end of file
+       user_id       INT REFERENCES users (user_id),
+       deleted_at    TIMESTAMP WITH TIME ZONE,
+       version       BIGINT NOT NULL DEFAULT 1,
+       tenant_id     varchar(50) NOT NULL DEFAULT 'default' REFERENCES tenants (tenant_id)
+);
+
+CREATE INDEX idx_cards_deleted_at ON cards(deleted_at) WHERE deleted_at IS NOT NULL;
+
+CREATE INDEX idx_cards_create_time ON cards(create_time, card_id);
+CREATE INDEX idx_cards_balance ON cards(balance, card_id);
+CREATE INDEX idx_cards_user_id ON cards(user_id, card_id);
+CREATE INDEX idx_cards_tenant_id ON cards(tenant_id, card_id);
+
+CREATE TABLE cards_history (
+       history_id             BIGSERIAL PRIMARY KEY,
+       card_id                INT NOT NULL REFERENCES cards (card_id) ON DELETE CASCADE,
+       operation              varchar(16) NOT NULL,
+       amount                 BIGINT NOT NULL,
+       balance_after          BIGINT NOT NULL,
+       counterparty_card_id   INT,
+       create_time            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_cards_history_card_id ON cards_history(card_id, history_id);
+
+CREATE TABLE api_keys (
+       api_key_id     SERIAL PRIMARY KEY,
+       name           varchar(100) NOT NULL,
+       prefix         varchar(16) NOT NULL UNIQUE,
+       key_hash       char(64) NOT NULL,
+       scopes         text[] NOT NULL DEFAULT '{}',
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       expires_at     TIMESTAMP WITH TIME ZONE,
+       last_used_at   TIMESTAMP WITH TIME ZONE,
+       revoked_at     TIMESTAMP WITH TIME ZONE,
+       tenant_id      varchar(50) REFERENCES tenants (tenant_id)
+);
+
+-- subjects of external identity providers, named by the issuer of their tokens,
+-- linked to the local users they act as
+CREATE TABLE user_identities (
+       issuer         varchar(255) NOT NULL,
+       subject        varchar(255) NOT NULL,
+       user_id        INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+CREATE TABLE roles (
+       role_name     varchar(50) PRIMARY KEY,
+       description   varchar(255)
+);
+
+CREATE TABLE role_permissions (
+       role_name     varchar(50) NOT NULL REFERENCES roles (role_name) ON DELETE CASCADE,
+       permission    varchar(50) NOT NULL,
+       PRIMARY KEY (role_name, permission)
+);
+
+CREATE TABLE user_roles (
+       user_id       INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       role_name     varchar(50) NOT NULL REFERENCES roles (role_name) ON DELETE CASCADE,
+       PRIMARY KEY (user_id, role_name)
+);
+
+INSERT INTO roles (role_name, description) VALUES
+       ('admin', 'Full access'),
+       ('support', 'Read access to all users, cards and KYC documents'),
+       ('customer', 'Access to own data only, held by every user');
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+       ('admin', 'users:read'),
+       ('admin', 'users:write'),
+       ('admin', 'cards:read'),
+       ('admin', 'cards:write'),
+       ('admin', 'cards:balance'),
+       ('admin', 'cards:transfer'),
+       ('admin', 'kyc:read'),
+       ('admin', 'kyc:review'),
+       ('admin', 'api_keys:manage'),
+       ('admin', 'roles:manage'),
+       ('admin', 'audit:read'),
+       ('support', 'users:read'),
+       ('support', 'cards:read'),
+       ('support', 'kyc:read');
+
+CREATE TABLE user_credentials (
+       user_id             INT PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+       password_hash       varchar(100),
+       tokens_valid_after  TIMESTAMP WITH TIME ZONE,
+       update_time         TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE refresh_tokens (
+       token_id       BIGSERIAL PRIMARY KEY,
+       user_id        INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       family_id      varchar(32) NOT NULL,
+       token_hash     char(64) NOT NULL UNIQUE,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       expires_at     TIMESTAMP WITH TIME ZONE NOT NULL,
+       used_at        TIMESTAMP WITH TIME ZONE,
+       revoked_at     TIMESTAMP WITH TIME ZONE,
+       replaced_by    BIGINT
+);
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+
+CREATE TABLE password_reset_tokens (
+       token_id       SERIAL PRIMARY KEY,
+       user_id        INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       token_hash     char(64) NOT NULL UNIQUE,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       expires_at     TIMESTAMP WITH TIME ZONE NOT NULL,
+       used_at        TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE user_totp (
+       user_id          INT PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+       secret           varchar(64) NOT NULL,
+       create_time      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       confirmed_at     TIMESTAMP WITH TIME ZONE,
+       last_used_step   BIGINT,
+       failed_attempts  INT NOT NULL DEFAULT 0,
+       locked_until     TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE pending_transfers (
+       transfer_id    BIGSERIAL PRIMARY KEY,
+       card_from      INT NOT NULL REFERENCES cards (card_id) ON DELETE CASCADE,
+       card_to        INT NOT NULL REFERENCES cards (card_id) ON DELETE CASCADE,
+       amount         BIGINT NOT NULL,
+       user_id        INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       status         varchar(16) NOT NULL DEFAULT 'pending',
+       attempts       INT NOT NULL DEFAULT 0,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       expires_at     TIMESTAMP WITH TIME ZONE NOT NULL,
+       confirmed_at   TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_pending_transfers_card_from ON pending_transfers(card_from) WHERE status = 'pending';
+
+-- Connections without app.tenant_id see no rows, jobs working across tenants connect
+-- as a role with BYPASSRLS.
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+CREATE POLICY users_tenant_isolation ON users
+       USING (tenant_id = current_setting('app.tenant_id', true));
+
+ALTER TABLE cards ENABLE ROW LEVEL SECURITY;
+ALTER TABLE cards FORCE ROW LEVEL SECURITY;
+CREATE POLICY cards_tenant_isolation ON cards
+       USING (tenant_id = current_setting('app.tenant_id', true));
+
+CREATE TABLE audit_log (
+       audit_id       BIGSERIAL PRIMARY KEY,
+       tenant_id      varchar(50) NOT NULL,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL,
+       actor_kind     varchar(16) NOT NULL,
+       actor          varchar(100) NOT NULL,
+       action         varchar(32) NOT NULL,
+       entity_type    varchar(32) NOT NULL,
+       entity_id      BIGINT NOT NULL,
+       request_id     varchar(64),
+       before         jsonb,
+       after          jsonb,
+       prev_hash      char(64) NOT NULL,
+       entry_hash     char(64) NOT NULL UNIQUE
+);
+
+CREATE INDEX idx_audit_log_tenant_id ON audit_log(tenant_id, audit_id);
+CREATE INDEX idx_audit_log_entity ON audit_log(entity_type, entity_id);
+CREATE INDEX idx_audit_log_request_id ON audit_log(request_id);
+
+CREATE TABLE audit_chain_heads (
+       tenant_id      varchar(50) PRIMARY KEY,
+       last_hash      char(64) NOT NULL
+);
+
+CREATE FUNCTION audit_log_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_immutable BEFORE UPDATE OR DELETE ON audit_log
+       FOR EACH ROW EXECUTE PROCEDURE audit_log_immutable();
+CREATE TRIGGER audit_log_immutable_truncate BEFORE TRUNCATE ON audit_log
+       FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_immutable();
+
+CREATE FUNCTION bump_version() RETURNS trigger AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_bump_version BEFORE UPDATE ON users
+       FOR EACH ROW EXECUTE PROCEDURE bump_version();
+CREATE TRIGGER cards_bump_version BEFORE UPDATE ON cards
+       FOR EACH ROW EXECUTE PROCEDURE bump_version();
+
+CREATE TABLE import_jobs (
+       import_id      BIGSERIAL PRIMARY KEY,
+       tenant_id      varchar(50) NOT NULL REFERENCES tenants (tenant_id),
+       entity         varchar(16) NOT NULL CHECK (entity IN ('users', 'cards')),
+       format         varchar(8) NOT NULL CHECK (format IN ('csv', 'jsonl')),
+       status         varchar(16) NOT NULL DEFAULT 'pending'
+                      CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+       total_rows     INT NOT NULL DEFAULT 0,
+       imported_rows  INT NOT NULL DEFAULT 0,
+       failed_rows    INT NOT NULL DEFAULT 0,
+       error          text,
+       actor          varchar(100) NOT NULL,
+       instance       varchar(255) NOT NULL,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       started_at     TIMESTAMP WITH TIME ZONE,
+       finished_at    TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_import_jobs_tenant_id ON import_jobs(tenant_id, import_id);
+CREATE INDEX idx_import_jobs_unfinished ON import_jobs(instance) WHERE status IN ('pending', 'running');
+
+CREATE TABLE import_errors (
+       import_id      BIGINT NOT NULL REFERENCES import_jobs (import_id) ON DELETE CASCADE,
+       row_number     INT NOT NULL,
+       field          varchar(64),
+       message        text NOT NULL,
+       PRIMARY KEY (import_id, row_number)
+);
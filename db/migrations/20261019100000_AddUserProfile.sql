@@ -0,0 +1,43 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN first_name    varchar(50),
+    ADD COLUMN last_name     varchar(50),
+    ADD COLUMN middle_name   varchar(50),
+    ADD COLUMN email         varchar(254),
+    ADD COLUMN phone         varchar(16),
+    ADD COLUMN birth_date    DATE;
+
+CREATE UNIQUE INDEX users_email_key ON users(email);
+CREATE UNIQUE INDEX users_phone_key ON users(phone);
+CREATE INDEX idx_users_last_name ON users(last_name);
+CREATE INDEX idx_users_birth_date ON users(birth_date);
+
+CREATE TABLE user_addresses (
+                       address_id    SERIAL PRIMARY KEY,
+                       user_id       INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+                       kind          varchar(16) NOT NULL DEFAULT 'home',
+                       country       char(2) NOT NULL,
+                       region        varchar(100),
+                       city          varchar(100) NOT NULL,
+                       street        varchar(200) NOT NULL,
+                       postal_code   varchar(20) NOT NULL,
+                       create_time   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_user_addresses_user_id ON user_addresses(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_addresses;
+
+DROP INDEX IF EXISTS users_email_key;
+DROP INDEX IF EXISTS users_phone_key;
+DROP INDEX IF EXISTS idx_users_last_name;
+DROP INDEX IF EXISTS idx_users_birth_date;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS first_name,
+    DROP COLUMN IF EXISTS last_name,
+    DROP COLUMN IF EXISTS middle_name,
+    DROP COLUMN IF EXISTS email,
+    DROP COLUMN IF EXISTS phone,
+    DROP COLUMN IF EXISTS birth_date;
@@ -0,0 +1,28 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN kyc_level   varchar(8) NOT NULL DEFAULT 'none'
+                           CHECK (kyc_level IN ('none', 'basic', 'full'));
+
+CREATE TABLE kyc_documents (
+                       document_id      SERIAL PRIMARY KEY,
+                       user_id          INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+                       doc_type         varchar(32) NOT NULL,
+                       status           varchar(16) NOT NULL DEFAULT 'pending'
+                                        CHECK (status IN ('pending', 'approved', 'rejected')),
+                       file_name        varchar(255) NOT NULL,
+                       content_type     varchar(64) NOT NULL,
+                       size             BIGINT NOT NULL,
+                       storage_key      varchar(255) NOT NULL,
+                       review_level     varchar(8),
+                       review_comment   text,
+                       review_time      TIMESTAMP WITH TIME ZONE,
+                       create_time      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_kyc_documents_user_id ON kyc_documents(user_id);
+CREATE INDEX idx_kyc_documents_status ON kyc_documents(status);
+
+-- +goose Down
+DROP TABLE IF EXISTS kyc_documents;
+
+ALTER TABLE users DROP COLUMN IF EXISTS kyc_level;
@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE cards_history (
+                       history_id             BIGSERIAL PRIMARY KEY,
+                       card_id                INT NOT NULL REFERENCES cards (card_id) ON DELETE CASCADE,
+                       operation              varchar(16) NOT NULL,
+                       amount                 BIGINT NOT NULL,
+                       balance_after          BIGINT NOT NULL,
+                       counterparty_card_id   INT,
+                       create_time            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_cards_history_card_id ON cards_history(card_id, history_id);
+CREATE INDEX idx_users_create_time ON users(create_time, user_id);
+CREATE INDEX idx_cards_create_time ON cards(create_time, card_id);
+CREATE INDEX idx_cards_balance ON cards(balance, card_id);
+CREATE INDEX idx_cards_user_id ON cards(user_id, card_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS cards_history;
+
+DROP INDEX IF EXISTS idx_users_create_time;
+DROP INDEX IF EXISTS idx_cards_create_time;
+DROP INDEX IF EXISTS idx_cards_balance;
+DROP INDEX IF EXISTS idx_cards_user_id;
@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE cards ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+DROP INDEX IF EXISTS users_email_key;
+DROP INDEX IF EXISTS users_phone_key;
+CREATE UNIQUE INDEX users_email_key ON users(email) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX users_phone_key ON users(phone) WHERE deleted_at IS NULL;
+
+CREATE INDEX idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_cards_deleted_at ON cards(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_deleted_at;
+DROP INDEX IF EXISTS idx_cards_deleted_at;
+
+DROP INDEX IF EXISTS users_email_key;
+DROP INDEX IF EXISTS users_phone_key;
+CREATE UNIQUE INDEX users_email_key ON users(email);
+CREATE UNIQUE INDEX users_phone_key ON users(phone);
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE cards DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN erased_at TIMESTAMP WITH TIME ZONE;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS erased_at;
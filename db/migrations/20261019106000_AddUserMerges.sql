@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE user_merges (
+       merge_id          SERIAL PRIMARY KEY,
+       source_user_id    INT NOT NULL UNIQUE,
+       target_user_id    INT NOT NULL,
+       cards_moved       INT NOT NULL DEFAULT 0,
+       documents_moved   INT NOT NULL DEFAULT 0,
+       create_time       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_user_merges_target_user_id ON user_merges(target_user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_merges;
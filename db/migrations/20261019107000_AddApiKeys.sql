@@ -0,0 +1,28 @@
+-- +goose Up
+CREATE TABLE api_keys (
+       api_key_id     SERIAL PRIMARY KEY,
+       name           varchar(100) NOT NULL,
+       prefix         varchar(16) NOT NULL UNIQUE,
+       key_hash       char(64) NOT NULL,
+       scopes         text[] NOT NULL DEFAULT '{}',
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       expires_at     TIMESTAMP WITH TIME ZONE,
+       last_used_at   TIMESTAMP WITH TIME ZONE,
+       revoked_at     TIMESTAMP WITH TIME ZONE
+);
+
+-- subjects of external identity providers, named by the issuer of their tokens,
+-- linked to the local users they act as
+CREATE TABLE user_identities (
+       issuer         varchar(255) NOT NULL,
+       subject        varchar(255) NOT NULL,
+       user_id        INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,42 @@
+-- +goose Up
+CREATE TABLE roles (
+       role_name     varchar(50) PRIMARY KEY,
+       description   varchar(255)
+);
+
+CREATE TABLE role_permissions (
+       role_name     varchar(50) NOT NULL REFERENCES roles (role_name) ON DELETE CASCADE,
+       permission    varchar(50) NOT NULL,
+       PRIMARY KEY (role_name, permission)
+);
+
+CREATE TABLE user_roles (
+       user_id       INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       role_name     varchar(50) NOT NULL REFERENCES roles (role_name) ON DELETE CASCADE,
+       PRIMARY KEY (user_id, role_name)
+);
+
+INSERT INTO roles (role_name, description) VALUES
+       ('admin', 'Full access'),
+       ('support', 'Read access to all users, cards and KYC documents'),
+       ('customer', 'Access to own data only, held by every user');
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+       ('admin', 'users:read'),
+       ('admin', 'users:write'),
+       ('admin', 'cards:read'),
+       ('admin', 'cards:write'),
+       ('admin', 'cards:balance'),
+       ('admin', 'cards:transfer'),
+       ('admin', 'kyc:read'),
+       ('admin', 'kyc:review'),
+       ('admin', 'api_keys:manage'),
+       ('admin', 'roles:manage'),
+       ('support', 'users:read'),
+       ('support', 'cards:read'),
+       ('support', 'kyc:read');
+
+-- +goose Down
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,36 @@
+-- +goose Up
+CREATE TABLE user_credentials (
+       user_id             INT PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+       password_hash       varchar(100),
+       tokens_valid_after  TIMESTAMP WITH TIME ZONE,
+       update_time         TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE refresh_tokens (
+       token_id       BIGSERIAL PRIMARY KEY,
+       user_id        INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       family_id      varchar(32) NOT NULL,
+       token_hash     char(64) NOT NULL UNIQUE,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       expires_at     TIMESTAMP WITH TIME ZONE NOT NULL,
+       used_at        TIMESTAMP WITH TIME ZONE,
+       revoked_at     TIMESTAMP WITH TIME ZONE,
+       replaced_by    BIGINT
+);
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+
+CREATE TABLE password_reset_tokens (
+       token_id       SERIAL PRIMARY KEY,
+       user_id        INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       token_hash     char(64) NOT NULL UNIQUE,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       expires_at     TIMESTAMP WITH TIME ZONE NOT NULL,
+       used_at        TIMESTAMP WITH TIME ZONE
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS password_reset_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS user_credentials;
@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TABLE user_totp (
+       user_id          INT PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+       secret           varchar(64) NOT NULL,
+       create_time      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       confirmed_at     TIMESTAMP WITH TIME ZONE,
+       last_used_step   BIGINT,
+       failed_attempts  INT NOT NULL DEFAULT 0,
+       locked_until     TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE pending_transfers (
+       transfer_id    BIGSERIAL PRIMARY KEY,
+       card_from      INT NOT NULL REFERENCES cards (card_id) ON DELETE CASCADE,
+       card_to        INT NOT NULL REFERENCES cards (card_id) ON DELETE CASCADE,
+       amount         BIGINT NOT NULL,
+       user_id        INT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+       status         varchar(16) NOT NULL DEFAULT 'pending',
+       attempts       INT NOT NULL DEFAULT 0,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       expires_at     TIMESTAMP WITH TIME ZONE NOT NULL,
+       confirmed_at   TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_pending_transfers_card_from ON pending_transfers(card_from) WHERE status = 'pending';
+
+-- +goose Down
+DROP TABLE IF EXISTS pending_transfers;
+DROP TABLE IF EXISTS user_totp;
@@ -0,0 +1,53 @@
+-- +goose Up
+CREATE TABLE tenants (
+       tenant_id     varchar(50) PRIMARY KEY,
+       name          varchar(100) NOT NULL,
+       create_time   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+INSERT INTO tenants (tenant_id, name) VALUES ('default', 'Default');
+
+ALTER TABLE users ADD COLUMN tenant_id varchar(50) NOT NULL DEFAULT 'default' REFERENCES tenants (tenant_id);
+ALTER TABLE cards ADD COLUMN tenant_id varchar(50) NOT NULL DEFAULT 'default' REFERENCES tenants (tenant_id);
+ALTER TABLE api_keys ADD COLUMN tenant_id varchar(50) REFERENCES tenants (tenant_id);
+
+DROP INDEX users_email_key;
+DROP INDEX users_phone_key;
+CREATE UNIQUE INDEX users_email_key ON users(tenant_id, email) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX users_phone_key ON users(tenant_id, phone) WHERE deleted_at IS NULL;
+
+CREATE INDEX idx_users_tenant_id ON users(tenant_id, user_id);
+CREATE INDEX idx_cards_tenant_id ON cards(tenant_id, card_id);
+
+-- Connections without app.tenant_id see no rows, jobs working across tenants connect
+-- as a role with BYPASSRLS.
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+CREATE POLICY users_tenant_isolation ON users
+       USING (tenant_id = current_setting('app.tenant_id', true));
+
+ALTER TABLE cards ENABLE ROW LEVEL SECURITY;
+ALTER TABLE cards FORCE ROW LEVEL SECURITY;
+CREATE POLICY cards_tenant_isolation ON cards
+       USING (tenant_id = current_setting('app.tenant_id', true));
+
+-- +goose Down
+DROP POLICY IF EXISTS cards_tenant_isolation ON cards;
+ALTER TABLE cards NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE cards DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS users_tenant_isolation ON users;
+ALTER TABLE users NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE users DISABLE ROW LEVEL SECURITY;
+
+DROP INDEX IF EXISTS idx_cards_tenant_id;
+DROP INDEX IF EXISTS idx_users_tenant_id;
+DROP INDEX users_email_key;
+DROP INDEX users_phone_key;
+CREATE UNIQUE INDEX users_email_key ON users(email) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX users_phone_key ON users(phone) WHERE deleted_at IS NULL;
+
+ALTER TABLE api_keys DROP COLUMN tenant_id;
+ALTER TABLE cards DROP COLUMN tenant_id;
+ALTER TABLE users DROP COLUMN tenant_id;
+
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,46 @@
+-- +goose Up
+CREATE TABLE audit_log (
+       audit_id       BIGSERIAL PRIMARY KEY,
+       tenant_id      varchar(50) NOT NULL,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL,
+       actor_kind     varchar(16) NOT NULL,
+       actor          varchar(100) NOT NULL,
+       action         varchar(32) NOT NULL,
+       entity_type    varchar(32) NOT NULL,
+       entity_id      BIGINT NOT NULL,
+       request_id     varchar(64),
+       before         jsonb,
+       after          jsonb,
+       prev_hash      char(64) NOT NULL,
+       entry_hash     char(64) NOT NULL UNIQUE
+);
+
+CREATE INDEX idx_audit_log_tenant_id ON audit_log(tenant_id, audit_id);
+CREATE INDEX idx_audit_log_entity ON audit_log(entity_type, entity_id);
+CREATE INDEX idx_audit_log_request_id ON audit_log(request_id);
+
+CREATE TABLE audit_chain_heads (
+       tenant_id      varchar(50) PRIMARY KEY,
+       last_hash      char(64) NOT NULL
+);
+
+-- +goose StatementBegin
+CREATE FUNCTION audit_log_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_log_immutable BEFORE UPDATE OR DELETE ON audit_log
+       FOR EACH ROW EXECUTE PROCEDURE audit_log_immutable();
+CREATE TRIGGER audit_log_immutable_truncate BEFORE TRUNCATE ON audit_log
+       FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_immutable();
+
+INSERT INTO role_permissions (role_name, permission) VALUES ('admin', 'audit:read');
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'audit:read';
+DROP TABLE IF EXISTS audit_chain_heads;
+DROP TABLE IF EXISTS audit_log;
+DROP FUNCTION IF EXISTS audit_log_immutable();
@@ -0,0 +1,24 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE cards ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+
+-- +goose StatementBegin
+CREATE FUNCTION bump_version() RETURNS trigger AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER users_bump_version BEFORE UPDATE ON users
+       FOR EACH ROW EXECUTE PROCEDURE bump_version();
+CREATE TRIGGER cards_bump_version BEFORE UPDATE ON cards
+       FOR EACH ROW EXECUTE PROCEDURE bump_version();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS cards_bump_version ON cards;
+DROP TRIGGER IF EXISTS users_bump_version ON users;
+DROP FUNCTION IF EXISTS bump_version();
+ALTER TABLE cards DROP COLUMN IF EXISTS version;
+ALTER TABLE users DROP COLUMN IF EXISTS version;
@@ -0,0 +1,33 @@
+-- +goose Up
+CREATE TABLE import_jobs (
+       import_id      BIGSERIAL PRIMARY KEY,
+       tenant_id      varchar(50) NOT NULL REFERENCES tenants (tenant_id),
+       entity         varchar(16) NOT NULL CHECK (entity IN ('users', 'cards')),
+       format         varchar(8) NOT NULL CHECK (format IN ('csv', 'jsonl')),
+       status         varchar(16) NOT NULL DEFAULT 'pending'
+                      CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+       total_rows     INT NOT NULL DEFAULT 0,
+       imported_rows  INT NOT NULL DEFAULT 0,
+       failed_rows    INT NOT NULL DEFAULT 0,
+       error          text,
+       actor          varchar(100) NOT NULL,
+       instance       varchar(255) NOT NULL,
+       create_time    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+       started_at     TIMESTAMP WITH TIME ZONE,
+       finished_at    TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_import_jobs_tenant_id ON import_jobs(tenant_id, import_id);
+CREATE INDEX idx_import_jobs_unfinished ON import_jobs(instance) WHERE status IN ('pending', 'running');
+
+CREATE TABLE import_errors (
+       import_id      BIGINT NOT NULL REFERENCES import_jobs (import_id) ON DELETE CASCADE,
+       row_number     INT NOT NULL,
+       field          varchar(64),
+       message        text NOT NULL,
+       PRIMARY KEY (import_id, row_number)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS import_errors;
+DROP TABLE IF EXISTS import_jobs;
@@ -4,14 +4,30 @@ import (
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/lenarsaitov/go-task/pkg/logging"
 	"sync"
+	"time"
 )
 
 type Config struct {
 	Listen struct {
-		Type   string `yaml:"type" env-default:"port"`
-		BindIP string `yaml:"bind_ip" env-default:"localhost"`
-		Port   string `yaml:"port" env-default:"8080"`
+		Type         string        `yaml:"type" env-default:"port"`
+		BindIP       string        `yaml:"bind_ip" env-default:"localhost"`
+		Port         string        `yaml:"port" env-default:"8080"`
+		ReadTimeout  time.Duration `yaml:"read_timeout" env-default:"15s"`
+		WriteTimeout time.Duration `yaml:"write_timeout" env-default:"15s"`
+		Export       struct {
+			Port         string        `yaml:"port"`
+			WriteTimeout time.Duration `yaml:"write_timeout" env-default:"10m"`
+		} `yaml:"export"`
 	}
+	GRPC struct {
+		Enabled    bool   `yaml:"enabled" env-default:"false"`
+		Port       string `yaml:"port" env-default:"9090"`
+		Reflection bool   `yaml:"reflection" env-default:"false"`
+	} `yaml:"grpc"`
+	GraphQL struct {
+		MaxDepth      int `yaml:"max_depth" env-default:"6"`
+		MaxComplexity int `yaml:"max_complexity" env-default:"500"`
+	} `yaml:"graphql"`
 	Postgres struct {
 		Host     string `yaml:"host" env-default:"localhost"`
 		Port     string `yaml:"port" env-default:"8080"`
@@ -19,7 +35,80 @@ type Config struct {
 		Password string `yaml:"password" env-default:"docker"`
 		Name     string `yaml:"name" env-default:"docker"`
 		SSL      string `yaml:"ssl" env-default:"disable"`
+		// Maintenance is the role of authentication and background jobs, which work
+		// across tenants; with tenancy.rls it must have BYPASSRLS.
+		Maintenance struct {
+			Username string `yaml:"username"`
+			Password string `yaml:"password"`
+		} `yaml:"maintenance"`
 	}
+	KYC struct {
+		Storage     string `yaml:"storage" env-default:"local"`
+		Dir         string `yaml:"dir" env-default:"data/kyc"`
+		MaxFileSize int64  `yaml:"max_file_size" env-default:"10485760"`
+		Limits      struct {
+			None  KYCLimits `yaml:"none"`
+			Basic KYCLimits `yaml:"basic"`
+			Full  KYCLimits `yaml:"full"`
+		} `yaml:"limits"`
+	} `yaml:"kyc"`
+	Cards struct {
+		StepUp struct {
+			Threshold   int           `yaml:"threshold" env-default:"0"`
+			TTL         time.Duration `yaml:"ttl" env-default:"5m"`
+			MaxAttempts int           `yaml:"max_attempts" env-default:"5"`
+			Lockout     time.Duration `yaml:"lockout" env-default:"15m"`
+		} `yaml:"step_up"`
+	} `yaml:"cards"`
+	Imports struct {
+		MaxSize   int64  `yaml:"max_size" env-default:"52428800"`
+		MaxRows   int    `yaml:"max_rows" env-default:"100000"`
+		Workers   int    `yaml:"workers" env-default:"2"`
+		QueueSize int    `yaml:"queue_size" env-default:"8"`
+		Instance  string `yaml:"instance" env:"IMPORTS_INSTANCE"`
+	} `yaml:"imports"`
+	Retention struct {
+		Period   time.Duration `yaml:"period" env-default:"720h"`
+		Interval time.Duration `yaml:"interval" env-default:"1h"`
+	} `yaml:"retention"`
+	Auth struct {
+		AdminKey string `yaml:"admin_key" env:"AUTH_ADMIN_KEY"`
+		JWT      struct {
+			Secret   string        `yaml:"secret" env:"AUTH_JWT_SECRET"`
+			JWKSFile string        `yaml:"jwks_file" env:"AUTH_JWT_JWKS_FILE"`
+			Issuer   string        `yaml:"issuer"`
+			Audience string        `yaml:"audience"`
+			Leeway   time.Duration `yaml:"leeway" env-default:"30s"`
+		} `yaml:"jwt"`
+		Tokens struct {
+			AccessTTL  time.Duration `yaml:"access_ttl" env-default:"15m"`
+			RefreshTTL time.Duration `yaml:"refresh_ttl" env-default:"720h"`
+		} `yaml:"tokens"`
+		PasswordReset struct {
+			TTL time.Duration `yaml:"ttl" env-default:"1h"`
+		} `yaml:"password_reset"`
+		BcryptCost int    `yaml:"bcrypt_cost" env-default:"10"`
+		TOTPIssuer string `yaml:"totp_issuer" env-default:"go-task"`
+		Notifier   struct {
+			Type string `yaml:"type" env-default:"log"`
+			Path string `yaml:"path" env-default:"data/notifications.log"`
+		} `yaml:"notifier"`
+	} `yaml:"auth"`
+	Tenancy struct {
+		RLS bool `yaml:"rls" env-default:"false"`
+	} `yaml:"tenancy"`
+	API struct {
+		Legacy struct {
+			Enabled      bool   `yaml:"enabled" env-default:"true"`
+			DeprecatedAt string `yaml:"deprecated_at" env-default:"2026-10-19"`
+			Sunset       string `yaml:"sunset"`
+		} `yaml:"legacy"`
+	} `yaml:"api"`
+}
+
+type KYCLimits struct {
+	MaxCards    int `yaml:"max_cards"`
+	MaxTransfer int `yaml:"max_transfer"`
 }
 
 var instance *Config
@@ -9,10 +9,29 @@ import (
 )
 
 func NewPostgresDB(cfg *config.Config) *sqlx.DB {
+	return open(cfg, cfg.Postgres.Username, cfg.Postgres.Password)
+}
+
+// NewMaintenanceDB connects as the maintenance role of authentication and background
+// jobs. Without one configured they share db, which only works while row-level
+// security is disabled: with it connections without a tenant see no rows.
+func NewMaintenanceDB(cfg *config.Config, db *sqlx.DB) *sqlx.DB {
+	if len(cfg.Postgres.Maintenance.Username) == 0 {
+		if cfg.Tenancy.RLS {
+			logging.GetLogger().Fatal("postgres.maintenance.username is required with tenancy.rls")
+			return nil
+		}
+		return db
+	}
+
+	return open(cfg, cfg.Postgres.Maintenance.Username, cfg.Postgres.Maintenance.Password)
+}
+
+func open(cfg *config.Config, username string, password string) *sqlx.DB {
 	logger := logging.GetLogger()
 
 	db, err := sqlx.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=%s",
-		cfg.Postgres.Host, cfg.Postgres.Port, cfg.Postgres.Username, cfg.Postgres.Name, cfg.Postgres.Password, cfg.Postgres.SSL))
+		cfg.Postgres.Host, cfg.Postgres.Port, username, cfg.Postgres.Name, password, cfg.Postgres.SSL))
 	if err != nil {
 		logger.Fatalf("Failed to open postgres connection: %s", err)
 		return nil
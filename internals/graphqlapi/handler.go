@@ -0,0 +1,179 @@
+package graphqlapi
+
+import (
+	"errors"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/services/cards"
+	"github.com/lenarsaitov/go-task/internals/services/users"
+	"github.com/lenarsaitov/go-task/pkg/bind"
+	"github.com/lenarsaitov/go-task/pkg/logging"
+	"github.com/lenarsaitov/go-task/pkg/problem"
+	"github.com/lenarsaitov/go-task/pkg/requestid"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"github.com/lenarsaitov/go-task/pkg/validate"
+	"net/http"
+)
+
+// CodeInvalidQuery is the code of queries which can't be parsed or don't match the schema.
+const CodeInvalidQuery = "invalid_query"
+
+// internalMessage replaces messages of unexpected errors, they are logged instead.
+const internalMessage = "Internal server error, report the request id to support"
+
+type GraphQLHandler struct {
+	schema      graphql.Schema
+	userService *users.UserService
+	cardService *cards.CardService
+	settings    Settings
+}
+
+func NewGraphQLHandler(userService *users.UserService, cardService *cards.CardService, settings Settings) (*GraphQLHandler, error) {
+	schema, err := newSchema(userService, cardService)
+	if err != nil {
+		return nil, err
+	}
+	return &GraphQLHandler{schema: schema, userService: userService, cardService: cardService, settings: settings}, nil
+}
+
+var INDENT = "  "
+
+func (h *GraphQLHandler) Setup(root *echo.Group) {
+	root.POST("/graphql", h.Query)
+}
+
+// Query executes a GraphQL request. Errors of the query are returned with status 200
+// in the errors of the result, as GraphQL clients expect; only bodies which are not
+// GraphQL requests are answered with problem details.
+func (h *GraphQLHandler) Query(c echo.Context) error {
+	params := &RequestParams{}
+	if err := bind.DecodeJSONBody(c, params); err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+	if err := c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	return c.JSONPretty(http.StatusOK, h.execute(c, params), INDENT)
+}
+
+func (h *GraphQLHandler) execute(c echo.Context, params *RequestParams) *graphql.Result {
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(params.Query), Name: "GraphQL request"})})
+	if err != nil {
+		return &graphql.Result{Errors: withCode(gqlerrors.FormatErrors(err), CodeInvalidQuery)}
+	}
+
+	validation := graphql.ValidateDocument(&h.schema, doc, nil)
+	if !validation.IsValid {
+		return &graphql.Result{Errors: withCode(validation.Errors, CodeInvalidQuery)}
+	}
+
+	if err = checkLimits(doc, params.Variables, h.settings.MaxDepth, h.settings.MaxComplexity); err != nil {
+		code, _ := errorCodes.Lookup(err)
+		return &graphql.Result{Errors: withCode(gqlerrors.FormatErrors(err), code)}
+	}
+
+	res := graphql.Execute(graphql.ExecuteParams{
+		Schema:        h.schema,
+		AST:           doc,
+		OperationName: params.OperationName,
+		Args:          params.Variables,
+		Context:       withLoaders(c.Request().Context(), h.userService, h.cardService),
+	})
+	for i := range res.Errors {
+		h.resolveError(c, &res.Errors[i])
+	}
+	return res
+}
+
+// resolveError sets the code of an error returned by a resolver. Unexpected errors are
+// logged with the request id and their messages are hidden from the client.
+func (h *GraphQLHandler) resolveError(c echo.Context, e *gqlerrors.FormattedError) {
+	if len(e.Path) == 0 {
+		// variables which don't match their types are reported before execution
+		e.Extensions = map[string]interface{}{"code": CodeInvalidQuery}
+		return
+	}
+
+	err := originalError(*e)
+	var verr validate.Errors
+	var uverr *users.ValidationError
+	var serr *sqlbuilder.InvalidSortError
+	switch {
+	case errors.As(err, &verr):
+		e.Extensions = map[string]interface{}{"code": problem.CodeValidationFailed, "errors": verr}
+		return
+	case errors.As(err, &uverr):
+		verr = validate.Errors{{Field: uverr.Field, Rule: "invalid", Message: uverr.Msg}}
+		e.Extensions = map[string]interface{}{"code": problem.CodeValidationFailed, "errors": verr}
+		return
+	}
+
+	code, ok := errorCodes.Lookup(err)
+	switch {
+	case ok:
+	case errors.As(err, &serr):
+		code = "invalid_sort"
+	default:
+		logging.GetLogger().WithField("request_id", requestid.FromContext(c.Request().Context())).
+			Errorf("graphql %v: %s", e.Path, err)
+		code, e.Message = problem.CodeInternal, internalMessage
+	}
+	e.Extensions = map[string]interface{}{"code": code}
+}
+
+// originalError returns the error of the resolver which gqlerrors wrapped.
+func originalError(err error) error {
+	for {
+		switch e := err.(type) {
+		case gqlerrors.FormattedError:
+			if e.OriginalError() == nil {
+				return e
+			}
+			err = e.OriginalError()
+		case *gqlerrors.Error:
+			if e.OriginalError == nil {
+				return e
+			}
+			err = e.OriginalError
+		default:
+			return err
+		}
+	}
+}
+
+func withCode(errs []gqlerrors.FormattedError, code string) []gqlerrors.FormattedError {
+	for i := range errs {
+		errs[i].Extensions = map[string]interface{}{"code": code}
+	}
+	return errs
+}
+
+func (h *GraphQLHandler) HandleError(c echo.Context, statusCode int, err error) error {
+	return problem.Error(c, statusCode, err, errorCodes)
+}
+
+// errorCodes are stable codes of the service errors returned in the extensions of
+// GraphQL errors.
+var errorCodes = problem.Codes{
+	ErrNotFound:                 "not_found",
+	ErrTooDeep:                  "query_too_deep",
+	ErrTooComplex:               "query_too_complex",
+	sqlbuilder.ErrInvalidCursor: "invalid_cursor",
+	auth.ErrUnauthenticated:     "unauthenticated",
+	auth.ErrForbidden:           "forbidden",
+	users.ErrUnknownTenant:      "unknown_tenant",
+	cards.ErrKycLimit:           "kyc_limit_exceeded",
+	cards.ErrOwnerDeleted:       "owner_deleted",
+	cards.ErrInsufficientFunds:  "insufficient_funds",
+	cards.ErrTwoFactorRequired:  "two_factor_required",
+	cards.ErrSameCard:           "same_card_transfer",
+}
@@ -0,0 +1,117 @@
+package graphqlapi
+
+import (
+	"errors"
+	"fmt"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/lenarsaitov/go-task/internals/services/users"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrTooDeep    = errors.New("Query is too deep")
+	ErrTooComplex = errors.New("Query is too complex")
+)
+
+// listSizes are the numbers of items assumed for list fields. Fields with a limit
+// argument are assumed to return that many items instead.
+var listSizes = map[string]int{
+	"users":            users.DefaultCursorLimit,
+	"cards":            10,
+	"recent_transfers": DefaultTransfersLimit,
+}
+
+// checkLimits refuses operations of the document which nest fields deeper than
+// maxDepth or whose complexity exceeds maxComplexity. The complexity estimates the
+// number of fields resolved: every field counts 1, fields of items of lists count
+// once per item. Introspection fields are not counted.
+func checkLimits(doc *ast.Document, variables map[string]interface{}, maxDepth int, maxComplexity int) error {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, d := range doc.Definitions {
+		if f, ok := d.(*ast.FragmentDefinition); ok {
+			fragments[f.Name.Value] = f
+		}
+	}
+
+	m := &measure{fragments: fragments, variables: variables}
+	for _, d := range doc.Definitions {
+		op, ok := d.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		depth, complexity := m.selectionSet(op.SelectionSet)
+		if maxDepth > 0 && depth > maxDepth {
+			return fmt.Errorf("%w: depth %d exceeds %d", ErrTooDeep, depth, maxDepth)
+		}
+		if maxComplexity > 0 && complexity > maxComplexity {
+			return fmt.Errorf("%w: complexity %d exceeds %d", ErrTooComplex, complexity, maxComplexity)
+		}
+	}
+	return nil
+}
+
+type measure struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// selectionSet returns the depth and the complexity of the selection. The document
+// is validated before, so fragments exist and don't form cycles.
+func (m *measure) selectionSet(set *ast.SelectionSet) (int, int) {
+	if set == nil {
+		return 0, 0
+	}
+
+	depth, complexity := 0, 0
+	for _, s := range set.Selections {
+		var d, c int
+		switch s := s.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			d, c = m.selectionSet(s.SelectionSet)
+			d, c = d+1, 1+m.items(s)*c
+		case *ast.InlineFragment:
+			d, c = m.selectionSet(s.SelectionSet)
+		case *ast.FragmentSpread:
+			if f, ok := m.fragments[s.Name.Value]; ok {
+				d, c = m.selectionSet(f.SelectionSet)
+			}
+		}
+		if d > depth {
+			depth = d
+		}
+		complexity += c
+	}
+	return depth, complexity
+}
+
+// items returns the number of items assumed for the field, 1 for fields which are not lists.
+func (m *measure) items(f *ast.Field) int {
+	n, ok := listSizes[f.Name.Value]
+	if !ok {
+		return 1
+	}
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			if limit, err := strconv.Atoi(v.Value); err == nil {
+				n = limit
+			}
+		case *ast.Variable:
+			// variables are decoded from JSON, numbers are float64
+			if limit, ok := m.variables[v.Name.Value].(float64); ok {
+				n = int(limit)
+			}
+		}
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
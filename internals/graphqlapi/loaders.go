@@ -0,0 +1,118 @@
+package graphqlapi
+
+import (
+	"context"
+	"github.com/lenarsaitov/go-task/internals/services/cards"
+	"github.com/lenarsaitov/go-task/internals/services/users"
+	"github.com/lenarsaitov/go-task/pkg/dataloader"
+	"sync"
+)
+
+// loaders batch the lookups of nested fields of one request: the cards of all users of
+// a list are read with one query instead of one per user.
+type loaders struct {
+	userService *users.UserService
+	cardService *cards.CardService
+
+	users *dataloader.Loader
+	cards *dataloader.Loader
+
+	mu sync.Mutex
+	// transfers are keyed by the limit of recent transfers, which is a field argument
+	transfers map[int]*dataloader.Loader
+}
+
+type loadersKey struct{}
+
+func withLoaders(ctx context.Context, userService *users.UserService, cardService *cards.CardService) context.Context {
+	l := &loaders{userService: userService, cardService: cardService, transfers: make(map[int]*dataloader.Loader)}
+	l.users = dataloader.New(l.loadUsers)
+	l.cards = dataloader.New(l.loadCards)
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+func loadersFromContext(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+func (l *loaders) loadUsers(ctx context.Context, ids []int64) (map[int64]interface{}, error) {
+	found, err := l.userService.GetUsers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int64]interface{}, len(found))
+	for id, u := range found {
+		res[id] = u
+	}
+	return res, nil
+}
+
+func (l *loaders) loadCards(ctx context.Context, userIDs []int64) (map[int64]interface{}, error) {
+	found, err := l.cardService.GetUsersCards(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int64]interface{}, len(found))
+	for id, c := range found {
+		res[id] = c
+	}
+	return res, nil
+}
+
+func (l *loaders) transfersLoader(limit int) *dataloader.Loader {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	loader, ok := l.transfers[limit]
+	if !ok {
+		loader = dataloader.New(func(ctx context.Context, userIDs []int64) (map[int64]interface{}, error) {
+			found, err := l.cardService.GetRecentTransfers(ctx, userIDs, limit)
+			if err != nil {
+				return nil, err
+			}
+			res := make(map[int64]interface{}, len(found))
+			for id, h := range found {
+				res[id] = h
+			}
+			return res, nil
+		})
+		l.transfers[limit] = loader
+	}
+	return loader
+}
+
+// User returns the thunk of the user, nil if it is not found.
+func (l *loaders) User(ctx context.Context, userID int64) func() (interface{}, error) {
+	thunk := l.users.Load(ctx, userID)
+	return func() (interface{}, error) {
+		v, err := thunk()
+		if err != nil || v == nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+// Cards returns the thunk of the cards of the user.
+func (l *loaders) Cards(ctx context.Context, userID int64) func() (interface{}, error) {
+	thunk := l.cards.Load(ctx, userID)
+	return func() (interface{}, error) {
+		v, err := thunk()
+		if err != nil || v == nil {
+			return []*cards.CardInfo{}, err
+		}
+		return v, nil
+	}
+}
+
+// Transfers returns the thunk of at most limit recent transfers of the user.
+func (l *loaders) Transfers(ctx context.Context, userID int64, limit int) func() (interface{}, error) {
+	thunk := l.transfersLoader(limit).Load(ctx, userID)
+	return func() (interface{}, error) {
+		v, err := thunk()
+		if err != nil || v == nil {
+			return []*cards.HistoryInfo{}, err
+		}
+		return v, nil
+	}
+}
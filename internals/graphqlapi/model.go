@@ -0,0 +1,22 @@
+package graphqlapi
+
+// Limits of queries, checked before they are executed.
+type Settings struct {
+	// MaxDepth is the maximum nesting of fields.
+	MaxDepth int
+	// MaxComplexity is the maximum estimated number of resolved fields, see complexity.
+	MaxComplexity int
+}
+
+// RequestParams is a GraphQL request as sent by clients in a POST body.
+type RequestParams struct {
+	Query         string                 `json:"query" validate:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	// Extensions are accepted for compatibility with clients and ignored.
+	Extensions map[string]interface{} `json:"extensions"`
+}
+
+type TransfersParams struct {
+	Limit int `query:"limit" validate:"gte=1,lte=50"`
+}
@@ -0,0 +1,278 @@
+package graphqlapi
+
+import (
+	"errors"
+	"github.com/graphql-go/graphql"
+	"github.com/lenarsaitov/go-task/internals/services/cards"
+	"github.com/lenarsaitov/go-task/internals/services/users"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"github.com/lenarsaitov/go-task/pkg/validate"
+)
+
+// DefaultTransfersLimit is the number of recent transfers of a user returned unless
+// the query sets limit.
+const DefaultTransfersLimit = 10
+
+var ErrNotFound = errors.New("Not Found")
+
+// Fields are named like the JSON fields of the REST API, so that most of them are
+// resolved from the json tags of the service models.
+func newSchema(userService *users.UserService, cardService *cards.CardService) (graphql.Schema, error) {
+	operationType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "CardOperation",
+		Description: "An entry of the history of a card.",
+		Fields: graphql.Fields{
+			"history_id":           &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"card_id":              &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"operation":            &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"amount":               &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"balance_after":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"counterparty_card_id": &graphql.Field{Type: graphql.Int},
+			"create_time":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	cardType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Card",
+		Fields: graphql.Fields{
+			"card_id":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"balance":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"held_balance":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Description: "Reserved by transfers awaiting confirmation."},
+			"user_id":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"user_full_name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"create_time":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"deleted_at":     &graphql.Field{Type: graphql.String, Resolve: optional},
+			"etag": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*cards.CardInfo).ETag(), nil
+				},
+			},
+		},
+	})
+
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"user_id":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"user_full_name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"first_name":     &graphql.Field{Type: graphql.String, Resolve: optional},
+			"last_name":      &graphql.Field{Type: graphql.String, Resolve: optional},
+			"middle_name":    &graphql.Field{Type: graphql.String, Resolve: optional},
+			"email":          &graphql.Field{Type: graphql.String, Resolve: optional},
+			"phone":          &graphql.Field{Type: graphql.String, Resolve: optional},
+			"birth_date":     &graphql.Field{Type: graphql.String, Resolve: optional},
+			"kyc_level":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"create_time":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"deleted_at":     &graphql.Field{Type: graphql.String, Resolve: optional},
+			"erased_at":      &graphql.Field{Type: graphql.String, Resolve: optional},
+			"etag": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*users.UserInfo).ETag(), nil
+				},
+			},
+			"cards": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(cardType))),
+				Description: "Cards of the user which are not deleted.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loadersFromContext(p.Context).Cards(p.Context, p.Source.(*users.UserInfo).UserID), nil
+				},
+			},
+			"recent_transfers": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(operationType))),
+				Description: "Latest transfers to and from cards of the user, newest first.",
+				Args: graphql.FieldConfigArgument{
+					"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: DefaultTransfersLimit},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					params := &TransfersParams{Limit: p.Args["limit"].(int)}
+					if err := validate.Struct(params); err != nil {
+						return nil, err
+					}
+					return loadersFromContext(p.Context).Transfers(p.Context, p.Source.(*users.UserInfo).UserID, params.Limit), nil
+				},
+			},
+		},
+	})
+
+	cardType.AddFieldConfig("owner", &graphql.Field{
+		Type: userType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return loadersFromContext(p.Context).User(p.Context, int64(p.Source.(*cards.CardInfo).UserID)), nil
+		},
+	})
+
+	userConnectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserConnection",
+		Fields: graphql.Fields{
+			"items":       &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userType)))},
+			"next_cursor": &graphql.Field{Type: graphql.String, Resolve: optional},
+		},
+	})
+
+	pendingTransferType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "PendingTransfer",
+		Description: "A transfer held until the user confirms it with a two-factor code.",
+		Fields: graphql.Fields{
+			"transfer_id": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"card_from":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"card_to":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"amount":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"user_id":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"status":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"create_time": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"expires_at":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	transferResultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TransferResult",
+		Fields: graphql.Fields{
+			"pending":  &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Description: "The transfer awaits confirmation, see transfer."},
+			"transfer": &graphql.Field{Type: pendingTransferType},
+			"card_from": &graphql.Field{
+				Type:        cardType,
+				Description: "The card the money was taken from, after the transfer.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					res := p.Source.(*transferResult)
+					return cardService.GetCard(p.Context, res.cardFrom, false)
+				},
+			},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"user_id":         &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"include_deleted": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return userService.GetUser(p.Context, p.Args["user_id"].(int), p.Args["include_deleted"].(bool))
+				},
+			},
+			"users": &graphql.Field{
+				Type:        graphql.NewNonNull(userConnectionType),
+				Description: "Users matching the filters, a page of limit users after the cursor.",
+				Args: graphql.FieldConfigArgument{
+					"q":               &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"email":           &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"phone":           &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"sort":            &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"include_deleted": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+					"after":           &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"limit":           &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: users.DefaultCursorLimit},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					sort, err := sqlbuilder.ParseSort(p.Args["sort"].(string), users.SortableFields)
+					if err != nil {
+						return nil, err
+					}
+					params := &users.FilterParams{
+						Query:          p.Args["q"].(string),
+						Email:          p.Args["email"].(string),
+						Phone:          p.Args["phone"].(string),
+						Sort:           sort,
+						IncludeDeleted: p.Args["include_deleted"].(bool),
+						After:          p.Args["after"].(string),
+						Limit:          p.Args["limit"].(int),
+					}
+					if err = validate.Struct(params); err != nil {
+						return nil, err
+					}
+					if params.Limit == 0 {
+						params.Limit = users.DefaultCursorLimit
+					}
+					return userService.GetListUsers(p.Context, params)
+				},
+			},
+			"card": &graphql.Field{
+				Type: cardType,
+				Args: graphql.FieldConfigArgument{
+					"card_id":         &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"include_deleted": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return cardService.GetCard(p.Context, p.Args["card_id"].(int), p.Args["include_deleted"].(bool))
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"refill_card": &graphql.Field{
+				Type:        graphql.NewNonNull(cardType),
+				Description: "Adds amount to the balance of the card and returns the card.",
+				Args: graphql.FieldConfigArgument{
+					"card_id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"amount":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					params := &cards.RefillCardRequestParams{CardID: p.Args["card_id"].(int), AddBalance: p.Args["amount"].(int)}
+					if err := validate.Struct(params); err != nil {
+						return nil, err
+					}
+					ok, err := cardService.RefillCard(p.Context, params)
+					if err != nil {
+						return nil, err
+					}
+					if !ok {
+						return nil, ErrNotFound
+					}
+					return cardService.GetCard(p.Context, params.CardID, false)
+				},
+			},
+			"transfer": &graphql.Field{
+				Type:        graphql.NewNonNull(transferResultType),
+				Description: "Moves amount between cards. Transfers above the step-up threshold are held until confirmed with a two-factor code over the REST API.",
+				Args: graphql.FieldConfigArgument{
+					"card_from": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"card_to":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"amount":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					params := &cards.TransferBalanceCardRequestParams{
+						CardFrom:   p.Args["card_from"].(int),
+						CardTo:     p.Args["card_to"].(int),
+						AddBalance: p.Args["amount"].(int),
+					}
+					if err := validate.Struct(params); err != nil {
+						return nil, err
+					}
+					pending, exist, err := cardService.TransferBalanceCard(p.Context, params)
+					if err != nil {
+						return nil, err
+					}
+					if !exist {
+						return nil, ErrNotFound
+					}
+					return &transferResult{Pending: pending != nil, Transfer: pending, cardFrom: params.CardFrom}, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+type transferResult struct {
+	Pending  bool                       `json:"pending"`
+	Transfer *cards.PendingTransferInfo `json:"transfer"`
+	cardFrom int
+}
+
+// optional resolves the field like the default resolver, but empty strings, which the
+// services return for missing values, as null.
+func optional(p graphql.ResolveParams) (interface{}, error) {
+	v, err := graphql.DefaultResolveFn(p)
+	if s, ok := v.(string); ok && len(s) == 0 {
+		return nil, err
+	}
+	return v, err
+}
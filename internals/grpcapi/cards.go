@@ -0,0 +1,143 @@
+package grpcapi
+
+import (
+	"context"
+	gotaskv1 "github.com/lenarsaitov/go-task/api/gotask/v1"
+	"github.com/lenarsaitov/go-task/internals/services/cards"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"github.com/lenarsaitov/go-task/pkg/validate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type cardsServer struct {
+	gotaskv1.UnimplementedCardsServiceServer
+	service *cards.CardService
+}
+
+func (s *cardsServer) GetCard(c context.Context, req *gotaskv1.GetCardRequest) (*gotaskv1.Card, error) {
+	card, err := s.service.GetCard(c, int(req.CardId), req.IncludeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	if card == nil {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+	return cardMessage(card), nil
+}
+
+func (s *cardsServer) ListCards(c context.Context, req *gotaskv1.ListCardsRequest) (*gotaskv1.ListCardsResponse, error) {
+	sort, err := sqlbuilder.ParseSort(req.Sort, cards.SortableFields)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	params := &cards.FilterParams{
+		UserID:         int(req.UserId),
+		Sort:           sort,
+		IncludeDeleted: req.IncludeDeleted,
+		Page:           int(req.Page),
+		Size:           int(req.Size),
+		After:          req.After,
+		Limit:          int(req.Limit),
+		WithCount:      req.WithCount,
+	}
+	if err = validate.Struct(params); err != nil {
+		return nil, err
+	}
+
+	p, err := s.service.GetListCards(c, params)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &gotaskv1.ListCardsResponse{NextCursor: p.NextCursor}
+	if p.ItemsCount != nil {
+		resp.ItemsCount = int32(*p.ItemsCount)
+	}
+	if p.PagesCount != nil {
+		resp.PagesCount = int32(*p.PagesCount)
+	}
+	for _, card := range p.Items {
+		resp.Items = append(resp.Items, cardMessage(card))
+	}
+	return resp, nil
+}
+
+func (s *cardsServer) CreateCard(c context.Context, req *gotaskv1.CreateCardRequest) (*gotaskv1.Card, error) {
+	params := &cards.AddCardRequestParams{UserID: int(req.UserId), Balance: int(req.Balance)}
+	if err := validate.Struct(params); err != nil {
+		return nil, err
+	}
+
+	card, err := s.service.AddCard(c, params)
+	if err != nil {
+		return nil, err
+	}
+	if card == nil {
+		return nil, status.Error(codes.NotFound, "User Not Found")
+	}
+	return cardMessage(card), nil
+}
+
+func (s *cardsServer) RefillCard(c context.Context, req *gotaskv1.RefillCardRequest) (*emptypb.Empty, error) {
+	params := &cards.RefillCardRequestParams{CardID: int(req.CardId), AddBalance: int(req.Amount)}
+	if err := validate.Struct(params); err != nil {
+		return nil, err
+	}
+
+	ok, err := s.service.RefillCard(c, params)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *cardsServer) TransferBalance(c context.Context, req *gotaskv1.TransferBalanceRequest) (*gotaskv1.TransferBalanceResponse, error) {
+	params := &cards.TransferBalanceCardRequestParams{CardFrom: int(req.CardFrom), CardTo: int(req.CardTo), AddBalance: int(req.Amount)}
+	if err := validate.Struct(params); err != nil {
+		return nil, err
+	}
+
+	pending, exist, err := s.service.TransferBalanceCard(c, params)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+	if pending == nil {
+		return &gotaskv1.TransferBalanceResponse{}, nil
+	}
+
+	return &gotaskv1.TransferBalanceResponse{
+		Pending: true,
+		Transfer: &gotaskv1.PendingTransfer{
+			TransferId: pending.TransferID,
+			CardFrom:   int64(pending.CardFrom),
+			CardTo:     int64(pending.CardTo),
+			Amount:     int64(pending.Amount),
+			UserId:     pending.UserID,
+			Status:     pending.Status,
+			CreateTime: pending.CreateTime,
+			ExpiresAt:  pending.ExpiresAt,
+		},
+	}, nil
+}
+
+func cardMessage(card *cards.CardInfo) *gotaskv1.Card {
+	return &gotaskv1.Card{
+		CardId:       int64(card.CardID),
+		Balance:      int64(card.Balance),
+		UserId:       int64(card.UserID),
+		UserFullName: card.UserName,
+		CreateTime:   card.CreateTime,
+		DeletedAt:    card.DeletedAt,
+		HeldBalance:  int64(card.HeldBalance),
+		Etag:         card.ETag(),
+	}
+}
@@ -0,0 +1,158 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/services/cards"
+	"github.com/lenarsaitov/go-task/internals/services/users"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lenarsaitov/go-task/pkg/etag"
+	"github.com/lenarsaitov/go-task/pkg/logging"
+	"github.com/lenarsaitov/go-task/pkg/patch"
+	"github.com/lenarsaitov/go-task/pkg/requestid"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"github.com/lenarsaitov/go-task/pkg/validate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// Metadata keys are the lowercase names of the headers of the REST API.
+var (
+	mdAPIKey        = strings.ToLower(auth.HeaderAPIKey)
+	mdAuthorization = "authorization"
+	mdTenantID      = strings.ToLower(tenant.HeaderTenantID)
+	mdRequestID     = "x-request-id"
+)
+
+// internalMessage replaces messages of unexpected errors, they are logged instead.
+const internalMessage = "Internal error"
+
+func incoming(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// loggingInterceptor takes the request id from x-request-id or generates one, returns
+// it in the response header and logs every call with its status code.
+func loggingInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id := requestid.Ensure(incoming(ctx, mdRequestID))
+		ctx = requestid.WithID(ctx, id)
+		grpc.SetHeader(ctx, metadata.Pairs(mdRequestID, id))
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		logging.GetLogger().WithField("request_id", id).
+			Infof("grpc %s %s %s", info.FullMethod, status.Code(err), time.Since(start))
+		return resp, err
+	}
+}
+
+// errorInterceptor maps errors of the services to status codes. Errors which are
+// already statuses are returned as is.
+func errorInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+
+		code := errorCode(err)
+		if code == codes.Internal {
+			logging.GetLogger().WithField("request_id", requestid.FromContext(ctx)).
+				Errorf("grpc %s failed: %s", info.FullMethod, err)
+			return nil, status.Error(code, internalMessage)
+		}
+		return nil, status.Error(code, err.Error())
+	}
+}
+
+func errorCode(err error) codes.Code {
+	var verr validate.Errors
+	var uverr *users.ValidationError
+	switch {
+	case errors.As(err, &verr),
+		errors.As(err, &uverr),
+		errors.Is(err, sqlbuilder.ErrInvalidCursor),
+		errors.Is(err, users.ErrUnknownTenant),
+		errors.Is(err, users.ErrMergeSelf),
+		errors.Is(err, cards.ErrSameCard),
+		errors.Is(err, patch.ErrInvalid),
+		errors.Is(err, patch.ErrUnsupportedType):
+		return codes.InvalidArgument
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return codes.Unauthenticated
+	case errors.Is(err, auth.ErrForbidden),
+		errors.Is(err, cards.ErrKycLimit),
+		errors.Is(err, cards.ErrTwoFactorRequired),
+		errors.Is(err, cards.ErrInvalidCode),
+		errors.Is(err, cards.ErrTwoFactorLocked),
+		errors.Is(err, users.ErrInvalidCode):
+		return codes.PermissionDenied
+	case errors.Is(err, cards.ErrUserNotFound),
+		errors.Is(err, users.ErrMergeSource):
+		return codes.NotFound
+	case errors.Is(err, users.ErrAlreadyExists):
+		return codes.AlreadyExists
+	case errors.Is(err, users.ErrErased),
+		errors.Is(err, users.ErrMerged),
+		errors.Is(err, users.ErrHasCards),
+		errors.Is(err, cards.ErrOwnerDeleted),
+		errors.Is(err, cards.ErrInsufficientFunds),
+		errors.Is(err, cards.ErrTransferNotPending),
+		errors.Is(err, etag.ErrPreconditionFailed),
+		errors.Is(err, etag.ErrPreconditionRequired),
+		errors.Is(err, patch.ErrConflict):
+		return codes.FailedPrecondition
+	default:
+		return codes.Internal
+	}
+}
+
+// authInterceptor authenticates calls like auth.Middleware and resolves their tenant
+// like tenant.Middleware, from the x-api-key, authorization and x-tenant-id metadata.
+func authInterceptor(db *sqlx.DB, service *auth.AuthService, rls bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		p, err := service.Authenticate(ctx, incoming(ctx, mdAPIKey), incoming(ctx, mdAuthorization))
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if err := service.ResolvePermissions(ctx, p); err != nil {
+			return nil, err
+		}
+
+		tenantID := incoming(ctx, mdTenantID)
+		ctx = auth.WithPrincipal(ctx, p)
+		if len(p.TenantID) != 0 {
+			if len(tenantID) != 0 && tenantID != p.TenantID {
+				return nil, status.Error(codes.PermissionDenied, tenant.ErrMismatch.Error())
+			}
+			ctx = tenant.WithID(ctx, p.TenantID)
+		}
+
+		ctx, err = tenant.Resolve(ctx, tenantID)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		ctx, release, err := tenant.Bind(ctx, db, rls)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		return handler(ctx, req)
+	}
+}
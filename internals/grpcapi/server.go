@@ -0,0 +1,36 @@
+package grpcapi
+
+import (
+	"github.com/jmoiron/sqlx"
+	gotaskv1 "github.com/lenarsaitov/go-task/api/gotask/v1"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/services/cards"
+	"github.com/lenarsaitov/go-task/internals/services/users"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+type Settings struct {
+	// RLS binds a connection to the tenant of every call, see tenant.Middleware.
+	RLS bool
+	// Reflection registers the reflection service, for grpcurl and similar tools.
+	Reflection bool
+}
+
+// NewServer creates the gRPC server of the users and cards services. Calls are
+// logged, authenticated like REST requests and errors of the services are mapped
+// to status codes, in this order.
+func NewServer(db *sqlx.DB, authService *auth.AuthService, userService *users.UserService, cardService *cards.CardService, settings Settings) *grpc.Server {
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		loggingInterceptor(),
+		errorInterceptor(),
+		authInterceptor(db, authService, settings.RLS),
+	))
+
+	gotaskv1.RegisterUsersServiceServer(server, &usersServer{service: userService})
+	gotaskv1.RegisterCardsServiceServer(server, &cardsServer{service: cardService})
+	if settings.Reflection {
+		reflection.Register(server)
+	}
+	return server
+}
@@ -0,0 +1,164 @@
+package grpcapi
+
+import (
+	"context"
+	gotaskv1 "github.com/lenarsaitov/go-task/api/gotask/v1"
+	"github.com/lenarsaitov/go-task/internals/services/users"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"github.com/lenarsaitov/go-task/pkg/validate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type usersServer struct {
+	gotaskv1.UnimplementedUsersServiceServer
+	service *users.UserService
+}
+
+func (s *usersServer) GetUser(c context.Context, req *gotaskv1.GetUserRequest) (*gotaskv1.User, error) {
+	u, err := s.service.GetUser(c, int(req.UserId), req.IncludeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+	return userMessage(u), nil
+}
+
+func (s *usersServer) ListUsers(c context.Context, req *gotaskv1.ListUsersRequest) (*gotaskv1.ListUsersResponse, error) {
+	sort, err := sqlbuilder.ParseSort(req.Sort, users.SortableFields)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	params := &users.FilterParams{
+		Query:          req.Q,
+		Email:          req.Email,
+		Phone:          req.Phone,
+		Sort:           sort,
+		IncludeDeleted: req.IncludeDeleted,
+		Page:           int(req.Page),
+		Size:           int(req.Size),
+		After:          req.After,
+		Limit:          int(req.Limit),
+		WithCount:      req.WithCount,
+	}
+	if err = validate.Struct(params); err != nil {
+		return nil, err
+	}
+
+	p, err := s.service.GetListUsers(c, params)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &gotaskv1.ListUsersResponse{NextCursor: p.NextCursor}
+	if p.ItemsCount != nil {
+		resp.ItemsCount = int32(*p.ItemsCount)
+	}
+	if p.PagesCount != nil {
+		resp.PagesCount = int32(*p.PagesCount)
+	}
+	for _, u := range p.Items {
+		resp.Items = append(resp.Items, userMessage(u))
+	}
+	return resp, nil
+}
+
+func (s *usersServer) CreateUser(c context.Context, req *gotaskv1.CreateUserRequest) (*gotaskv1.User, error) {
+	params := &users.AddUserRequestParams{UserProfile: userProfile(req.Profile)}
+	if err := validate.Struct(params); err != nil {
+		return nil, err
+	}
+
+	u, err := s.service.AddUser(c, params)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+	return userMessage(u), nil
+}
+
+func (s *usersServer) UpdateUser(c context.Context, req *gotaskv1.UpdateUserRequest) (*gotaskv1.UpdateUserResponse, error) {
+	params := &users.UpdateUserRequestParams{UserID: int(req.UserId), IfMatch: req.IfMatch, UserProfile: userProfile(req.Profile)}
+	if err := validate.Struct(params); err != nil {
+		return nil, err
+	}
+
+	tag, err := s.service.UpdateUser(c, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(tag) == 0 {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+	return &gotaskv1.UpdateUserResponse{Etag: tag}, nil
+}
+
+func (s *usersServer) DeleteUser(c context.Context, req *gotaskv1.DeleteUserRequest) (*emptypb.Empty, error) {
+	ok, err := s.service.DeleteUser(c, int(req.UserId))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func userMessage(u *users.UserInfo) *gotaskv1.User {
+	m := &gotaskv1.User{
+		UserId:       u.UserID,
+		UserFullName: u.UserName,
+		FirstName:    u.FirstName,
+		LastName:     u.LastName,
+		MiddleName:   u.MiddleName,
+		Email:        u.Email,
+		Phone:        u.Phone,
+		BirthDate:    u.BirthDate,
+		KycLevel:     u.KycLevel,
+		CreateTime:   u.CreateTime,
+		DeletedAt:    u.DeletedAt,
+		ErasedAt:     u.ErasedAt,
+		Etag:         u.ETag(),
+	}
+	for _, a := range u.Addresses {
+		m.Addresses = append(m.Addresses, &gotaskv1.Address{
+			AddressId:  a.AddressID,
+			Kind:       a.Kind,
+			Country:    a.Country,
+			Region:     a.Region,
+			City:       a.City,
+			Street:     a.Street,
+			PostalCode: a.PostalCode,
+		})
+	}
+	return m
+}
+
+func userProfile(m *gotaskv1.UserProfile) users.UserProfile {
+	profile := users.UserProfile{
+		UserName:   m.GetUsername(),
+		FirstName:  m.GetFirstName(),
+		LastName:   m.GetLastName(),
+		MiddleName: m.GetMiddleName(),
+		Email:      m.GetEmail(),
+		Phone:      m.GetPhone(),
+		BirthDate:  m.GetBirthDate(),
+	}
+	for _, a := range m.GetAddresses() {
+		profile.Addresses = append(profile.Addresses, &users.AddressInfo{
+			Kind:       a.Kind,
+			Country:    a.Country,
+			Region:     a.Region,
+			City:       a.City,
+			Street:     a.Street,
+			PostalCode: a.PostalCode,
+		})
+	}
+	return profile
+}
@@ -0,0 +1,59 @@
+package openapi
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// notFoundName is the name of routes echo adds for groups with middleware, they
+// only answer 404 and are not part of the API.
+var notFoundName = runtime.FuncForPC(reflect.ValueOf(echo.NotFoundHandler).Pointer()).Name()
+
+// Check compares the routes registered on the server with the document. It fails
+// when a route has no operation, so a handler can't be added without describing
+// it, and when an operation has no route anymore. Routes of every server of the
+// document are described by the same operations.
+func Check(routes []*echo.Route, doc *Document) error {
+	registered := map[string]bool{}
+	var missing []string
+	for _, r := range routes {
+		if r.Name == notFoundName || r.Path == SpecPath || r.Path == DocsPath {
+			continue
+		}
+		p := specPath(stripServer(r.Path, doc))
+		registered[r.Method+" "+p] = true
+		if doc.Paths[p][strings.ToLower(r.Method)] == nil {
+			missing = append(missing, r.Method+" "+r.Path)
+		}
+	}
+
+	var stale []string
+	for p, item := range doc.Paths {
+		for method := range item {
+			if !registered[strings.ToUpper(method)+" "+p] {
+				stale = append(stale, strings.ToUpper(method)+" "+p)
+			}
+		}
+	}
+
+	if len(missing) == 0 && len(stale) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	return fmt.Errorf("openapi spec is out of sync with routes: without spec [%s], without route [%s]",
+		strings.Join(missing, ", "), strings.Join(stale, ", "))
+}
+
+func stripServer(p string, doc *Document) string {
+	for _, s := range doc.Servers {
+		if s.URL != "/" && strings.HasPrefix(p, s.URL+"/") {
+			return strings.TrimPrefix(p, s.URL)
+		}
+	}
+	return p
+}
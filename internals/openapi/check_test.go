@@ -0,0 +1,52 @@
+package openapi
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/internals/graphqlapi"
+	"github.com/lenarsaitov/go-task/internals/services/audit"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/services/cards"
+	"github.com/lenarsaitov/go-task/internals/services/imports"
+	"github.com/lenarsaitov/go-task/internals/services/kyc"
+	"github.com/lenarsaitov/go-task/internals/services/users"
+	"github.com/lenarsaitov/go-task/internals/versioning"
+	"testing"
+)
+
+// TestSpecCoversRoutes registers the routes like cmd/main.go does. Handlers only
+// register routes in Setup, so they are created without services.
+func TestSpecCoversRoutes(t *testing.T) {
+	graphqlHandlers, err := graphqlapi.NewGraphQLHandler(nil, nil, graphqlapi.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	for _, v := range versioning.Versions(true) {
+		publicRoot := e.Group(v.Prefix)
+		root := e.Group(v.Prefix)
+
+		authHandlers := auth.NewAuthHandler(nil)
+		authHandlers.SetupPublic(publicRoot)
+		authHandlers.Setup(root)
+		users.NewUserHandler(nil).Setup(root)
+		users.NewUserHandler(nil).SetupExport(root)
+		cards.NewCardHandler(nil).Setup(root)
+		cards.NewCardHandler(nil).SetupExport(root)
+		kyc.NewKycHandler(nil).Setup(root)
+		audit.NewAuditHandler(nil).Setup(root)
+		imports.NewImportHandler(nil).Setup(root)
+		graphqlHandlers.Setup(root)
+	}
+
+	spec := Build()
+	openAPIHandlers, err := NewOpenAPIHandler(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openAPIHandlers.Setup(e)
+
+	if err := Check(e.Routes(), spec); err != nil {
+		t.Fatal(err)
+	}
+}
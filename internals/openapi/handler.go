@@ -0,0 +1,58 @@
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+const (
+	SpecPath = "/openapi.json"
+	DocsPath = "/docs"
+)
+
+var INDENT = "  "
+
+type OpenAPIHandler struct {
+	spec []byte
+}
+
+func NewOpenAPIHandler(doc *Document) (*OpenAPIHandler, error) {
+	spec, err := json.MarshalIndent(doc, "", INDENT)
+	if err != nil {
+		return nil, err
+	}
+	return &OpenAPIHandler{spec}, nil
+}
+
+// Setup registers the routes on the server itself, they must stay reachable without
+// credentials.
+func (h *OpenAPIHandler) Setup(e *echo.Echo) {
+	e.GET(SpecPath, h.Spec)
+	e.GET(DocsPath, h.Docs)
+}
+
+func (h *OpenAPIHandler) Spec(c echo.Context) error {
+	return c.JSONBlob(http.StatusOK, h.spec)
+}
+
+func (h *OpenAPIHandler) Docs(c echo.Context) error {
+	return c.HTML(http.StatusOK, swaggerUI)
+}
+
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>` + Title + ` API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "` + SpecPath + `", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,170 @@
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/lenarsaitov/go-task/pkg/validate"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemas builds schemas of Go types the way encoding/json marshals them. Named
+// structs are stored once in components and referenced by package and type name,
+// since several services declare types with the same name.
+type schemas map[string]*Schema
+
+func (s schemas) of(v interface{}) *Schema {
+	return s.schema(reflect.TypeOf(v))
+}
+
+// sample returns v itself when it is already a schema.
+func (s schemas) sample(v interface{}) *Schema {
+	if schema, ok := v.(*Schema); ok {
+		return schema
+	}
+	return s.of(v)
+}
+
+func (s schemas) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schema(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return s.object(t, nil)
+		}
+		name := path.Base(t.PkgPath()) + "." + t.Name()
+		if _, ok := s[name]; !ok {
+			// reserve the name first so recursive types terminate
+			s[name] = nil
+			s[name] = s.object(t, nil)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+// object describes the struct inline, without the fields listed in omit. Handlers
+// fill such fields from the path, so they are not part of the request body.
+func (s schemas) object(t reflect.Type, omit []string) *Schema {
+	o := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	s.fields(o, t, omit)
+	return o
+}
+
+func (s schemas) fields(o *Schema, t reflect.Type, omit []string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		if f.Anonymous && len(name) == 0 {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.fields(o, ft, omit)
+				continue
+			}
+		}
+		if len(f.PkgPath) != 0 || contains(omit, f.Name) {
+			continue
+		}
+
+		if len(name) == 0 {
+			name = f.Name
+		}
+		property := s.schema(f.Type)
+		if rules(property, f.Tag.Get(validate.Tag)) {
+			o.Required = append(o.Required, name)
+		}
+		o.Properties[name] = property
+	}
+}
+
+// rules adds the validate rules of a field to its schema and reports whether the
+// field is required.
+func rules(schema *Schema, tag string) bool {
+	if len(tag) == 0 || len(schema.Ref) != 0 {
+		return false
+	}
+
+	required, omitempty := false, false
+	for _, rule := range strings.Split(tag, ",") {
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+		n, _ := strconv.ParseFloat(param, 64)
+		length := int(n)
+
+		switch {
+		case name == "required":
+			required = true
+		case name == "omitempty":
+			omitempty = true
+		case name == "oneof":
+			schema.Enum = strings.Fields(param)
+		case schema.Type == "string" && (name == "gte" || name == "min"):
+			schema.MinLength = &length
+			required = required || length > 0
+		case schema.Type == "string" && (name == "lte" || name == "max"):
+			schema.MaxLength = &length
+		case schema.Type == "string" && name == "len":
+			schema.MinLength, schema.MaxLength = &length, &length
+		case name == "gte" || name == "min" || name == "gt":
+			schema.Minimum, schema.ExclusiveMinimum = &n, name == "gt"
+		case name == "lte" || name == "max" || name == "lt":
+			schema.Maximum, schema.ExclusiveMaximum = &n, name == "lt"
+		}
+	}
+	return required && !omitempty
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,451 @@
+package openapi
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/internals/graphqlapi"
+	"github.com/lenarsaitov/go-task/internals/services/audit"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/services/cards"
+	"github.com/lenarsaitov/go-task/internals/services/imports"
+	"github.com/lenarsaitov/go-task/internals/services/kyc"
+	"github.com/lenarsaitov/go-task/internals/services/users"
+	"github.com/lenarsaitov/go-task/internals/versioning"
+	"github.com/lenarsaitov/go-task/pkg/etag"
+	"github.com/lenarsaitov/go-task/pkg/patch"
+	"github.com/lenarsaitov/go-task/pkg/problem"
+	"github.com/lenarsaitov/go-task/pkg/response"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	Title   = "go-task"
+	Version = "1.0.0"
+)
+
+// operation describes a route registered by one of the handlers. Paths are written
+// the way echo registers them, path parameters are derived from them.
+type operation struct {
+	method  string
+	path    string
+	id      string
+	tag     string
+	summary string
+	public  bool
+	query   []*Parameter
+	// body is a sample of the JSON request body, or a *Schema of a multipart form.
+	body interface{}
+	// upload lists media types of files accepted as the raw request body.
+	upload []string
+	// omit lists fields of body which the handler fills from the path.
+	omit []string
+	// result is a sample of the JSON response, response.Envelope when nil.
+	result interface{}
+	// created is a sample of the resource returned in the envelope with 201 Created.
+	created interface{}
+	// accepted is a sample of the resource returned with 202 Accepted, the URL to
+	// poll it at is in the Location header.
+	accepted interface{}
+	// content maps media types to samples or schemas, it replaces result for
+	// routes which don't always respond with JSON.
+	content map[string]interface{}
+	// also lists other successful statuses with their results.
+	also map[int]interface{}
+	// patch is a sample of the document a PATCH applies a merge patch or a JSON
+	// Patch to.
+	patch interface{}
+	// etag marks conditional routes: reads respond with an ETag and take
+	// If-None-Match, writes require If-Match, patches take it optionally.
+	etag bool
+}
+
+func query(name string, typ string, description string) *Parameter {
+	return &Parameter{Name: name, In: "query", Description: description, Schema: &Schema{Type: typ}}
+}
+
+// between limits an integer query parameter the way the validate tag of the
+// matching FilterParams field does.
+func between(p *Parameter, min float64, max float64) *Parameter {
+	p.Schema.Minimum = &min
+	if max > 0 {
+		p.Schema.Maximum = &max
+	}
+	return p
+}
+
+var (
+	pageParams = []*Parameter{
+		between(query("page", "integer", "Page number, starting from 1"), 1, 0),
+		between(query("size", "integer", "Page size, up to 50"), 1, 50),
+	}
+	cursorParams = []*Parameter{
+		query("after", "string", "Cursor returned as next_cursor, switches to cursor pagination"),
+		between(query("limit", "integer", "Number of items after the cursor, up to 50"), 1, 50),
+		query("count", "boolean", "Also count all matching items"),
+	}
+	rangeParams = []*Parameter{
+		query("created_from", "string", "Creation time or date lower bound"),
+		query("created_to", "string", "Creation time or date upper bound"),
+		query("balance_min", "integer", ""),
+		query("balance_max", "integer", ""),
+		query("sort", "string", "Comma separated fields, prefixed with - for descending order"),
+	}
+	includeDeleted = query("include_deleted", "boolean", "Include soft-deleted items")
+
+	userFilterParams = []*Parameter{
+		query("q", "string", "Fuzzy search by name, email and phone"),
+		query("user_name", "string", ""),
+		query("first_name", "string", ""),
+		query("last_name", "string", ""),
+		query("email", "string", ""),
+		query("phone", "string", ""),
+		query("birth_date", "string", ""),
+		includeDeleted,
+	}
+	cardFilterParams = []*Parameter{query("user_id", "integer", ""), includeDeleted}
+
+	exportParams  = []*Parameter{query("format", "string", "ndjson (default) or csv")}
+	exportContent = map[string]interface{}{
+		"application/x-ndjson": binary,
+		"text/csv":             binary,
+	}
+
+	binary = &Schema{Type: "string", Format: "binary"}
+)
+
+func params(lists ...[]*Parameter) []*Parameter {
+	var all []*Parameter
+	for _, l := range lists {
+		all = append(all, l...)
+	}
+	return all
+}
+
+var operations = []operation{
+	// auth
+	{method: http.MethodPost, path: "/auth/register", id: "register", tag: "auth", summary: "Register a user with a password", public: true,
+		body: auth.RegisterRequestParams{}, created: auth.RegisteredUser{}},
+	{method: http.MethodPost, path: "/auth/login", id: "login", tag: "auth", summary: "Exchange email and password for tokens", public: true,
+		body: auth.LoginRequestParams{}, result: auth.TokenPair{}},
+	{method: http.MethodPost, path: "/auth/refresh", id: "refresh", tag: "auth", summary: "Rotate the refresh token", public: true,
+		body: auth.RefreshRequestParams{}, result: auth.TokenPair{}},
+	{method: http.MethodPost, path: "/auth/logout", id: "logout", tag: "auth", summary: "Revoke the session of the refresh token", public: true,
+		body: auth.RefreshRequestParams{}},
+	{method: http.MethodPost, path: "/auth/password-reset", id: "requestPasswordReset", tag: "auth", summary: "Send a password reset token", public: true,
+		body: auth.PasswordResetRequestParams{}},
+	{method: http.MethodPost, path: "/auth/password-reset/confirm", id: "confirmPasswordReset", tag: "auth", summary: "Set a new password with a reset token", public: true,
+		body: auth.PasswordResetConfirmRequestParams{}},
+	{method: http.MethodGet, path: "/auth/me", id: "me", tag: "auth", summary: "Authenticated caller",
+		result: auth.Principal{}},
+	{method: http.MethodPost, path: "/auth/logout-all", id: "logoutAll", tag: "auth", summary: "Revoke all sessions and tokens of the user"},
+
+	// admin
+	{method: http.MethodGet, path: "/admin/api-keys", id: "listAPIKeys", tag: "admin", summary: "List API keys",
+		query: []*Parameter{query("include_revoked", "boolean", "Include revoked keys")}, result: []*auth.APIKeyInfo{}},
+	{method: http.MethodGet, path: "/admin/api-keys/:id", id: "getAPIKey", tag: "admin", summary: "Get an API key",
+		result: auth.APIKeyInfo{}},
+	{method: http.MethodPost, path: "/admin/api-keys", id: "addAPIKey", tag: "admin", summary: "Create an API key, the key is only returned once",
+		body: auth.AddAPIKeyRequestParams{}, created: auth.CreatedAPIKey{}},
+	{method: http.MethodDelete, path: "/admin/api-keys/:id", id: "revokeAPIKey", tag: "admin", summary: "Revoke an API key"},
+	{method: http.MethodGet, path: "/admin/permissions", id: "listPermissions", tag: "admin", summary: "Permissions with descriptions",
+		result: auth.Permissions},
+	{method: http.MethodGet, path: "/admin/roles", id: "listRoles", tag: "admin", summary: "List roles",
+		result: []*auth.RoleInfo{}},
+	{method: http.MethodPut, path: "/admin/roles/:name", id: "setRole", tag: "admin", summary: "Create or replace a role",
+		body: auth.SetRoleRequestParams{}, omit: []string{"Name"}},
+	{method: http.MethodDelete, path: "/admin/roles/:name", id: "deleteRole", tag: "admin", summary: "Delete a role"},
+	{method: http.MethodGet, path: "/admin/users/:id/roles", id: "getUserRoles", tag: "admin", summary: "Roles of a user",
+		result: auth.UserRolesInfo{}},
+	{method: http.MethodPut, path: "/admin/users/:id/roles", id: "setUserRoles", tag: "admin", summary: "Replace roles of a user",
+		body: auth.SetUserRolesRequestParams{}, omit: []string{"UserID"}},
+	{method: http.MethodGet, path: "/admin/users/:id/identities", id: "getUserIdentities", tag: "admin", summary: "Identities of external providers linked to a user",
+		result: auth.UserIdentitiesInfo{}},
+	{method: http.MethodPut, path: "/admin/users/:id/identities", id: "setUserIdentities", tag: "admin", summary: "Replace identities linked to a user, tokens of a linked identity act as the user",
+		body: auth.SetUserIdentitiesRequestParams{}, omit: []string{"UserID"}},
+
+	// users
+	{method: http.MethodGet, path: "/users", id: "listUsers", tag: "users", summary: "List users",
+		query:  params(userFilterParams, rangeParams, pageParams, cursorParams),
+		result: users.Pagination{}},
+	{method: http.MethodGet, path: "/users/export", id: "exportUsers", tag: "users", summary: "Stream all users matching the filters",
+		query: params(userFilterParams, rangeParams, exportParams), content: exportContent},
+	{method: http.MethodGet, path: "/users/:id", id: "getUser", tag: "users", summary: "Get a user, merged users redirect to the target",
+		query: []*Parameter{includeDeleted}, result: users.UserInfo{}, etag: true},
+	{method: http.MethodPost, path: "/users", id: "addUser", tag: "users", summary: "Add a user",
+		body: users.AddUserRequestParams{}, created: users.UserInfo{}},
+	{method: http.MethodPut, path: "/users/:id", id: "updateUser", tag: "users", summary: "Update a user",
+		body: users.UpdateUserRequestParams{}, omit: []string{"UserID"}, etag: true},
+	{method: http.MethodPatch, path: "/users/:id", id: "patchUser", tag: "users", summary: "Change some fields of a user",
+		patch: users.UserProfile{}, etag: true},
+	{method: http.MethodDelete, path: "/users/:id", id: "deleteUser", tag: "users", summary: "Soft-delete a user"},
+	{method: http.MethodPost, path: "/users/:id/restore", id: "restoreUser", tag: "users", summary: "Restore a soft-deleted user"},
+	{method: http.MethodPost, path: "/users/:id/merge", id: "mergeUser", tag: "users", summary: "Merge a duplicate user into this one",
+		body: users.MergeUserRequestParams{}, omit: []string{"TargetUserID"}, result: users.MergeInfo{}},
+	{method: http.MethodGet, path: "/users/:id/export", id: "exportUser", tag: "users", summary: "Export personal data of a user",
+		query: []*Parameter{query("format", "string", "json for a JSON document instead of a zip archive")},
+		content: map[string]interface{}{
+			"application/zip":  binary,
+			"application/json": users.PersonalDataExport{},
+		}},
+	{method: http.MethodPost, path: "/users/:id/erase", id: "eraseUser", tag: "users", summary: "Erase personal data of a user"},
+	{method: http.MethodGet, path: "/users/:id/2fa", id: "getTwoFactor", tag: "users", summary: "Two-factor authentication status",
+		result: users.TwoFactorInfo{}},
+	{method: http.MethodPost, path: "/users/:id/2fa", id: "enrollTwoFactor", tag: "users", summary: "Generate a TOTP secret",
+		result: users.TwoFactorEnrollment{}},
+	{method: http.MethodPost, path: "/users/:id/2fa/confirm", id: "confirmTwoFactor", tag: "users", summary: "Enable two-factor authentication with a code",
+		body: users.TwoFactorCodeRequestParams{}, omit: []string{"UserID"}},
+	{method: http.MethodDelete, path: "/users/:id/2fa", id: "disableTwoFactor", tag: "users", summary: "Disable two-factor authentication",
+		body: users.TwoFactorCodeRequestParams{}, omit: []string{"UserID"}},
+
+	// cards
+	{method: http.MethodGet, path: "/cards", id: "listCards", tag: "cards", summary: "List cards",
+		query:  params(cardFilterParams, rangeParams, pageParams, cursorParams),
+		result: cards.Pagination{}},
+	{method: http.MethodGet, path: "/cards/export", id: "exportCards", tag: "cards", summary: "Stream all cards matching the filters",
+		query: params(cardFilterParams, rangeParams, exportParams), content: exportContent},
+	{method: http.MethodGet, path: "/cards/:id", id: "getCard", tag: "cards", summary: "Get a card",
+		query: []*Parameter{includeDeleted}, result: cards.CardInfo{}, etag: true},
+	{method: http.MethodGet, path: "/cards/:id/history", id: "getCardHistory", tag: "cards", summary: "Operations of a card",
+		query: params([]*Parameter{includeDeleted}, pageParams, cursorParams), result: cards.HistoryPagination{}},
+	{method: http.MethodPost, path: "/cards", id: "addCard", tag: "cards", summary: "Add a card",
+		body: cards.AddCardRequestParams{}, created: cards.CardInfo{}},
+	{method: http.MethodPut, path: "/cards/:id", id: "updateCard", tag: "cards", summary: "Set the balance of a card",
+		body: cards.UpdateCardRequestParams{}, omit: []string{"CardID"}, etag: true},
+	{method: http.MethodPatch, path: "/cards/:id", id: "patchCard", tag: "cards", summary: "Change the balance of a card by a patch",
+		patch: cards.EditableCard{}, etag: true},
+	{method: http.MethodDelete, path: "/cards/:id", id: "deleteCard", tag: "cards", summary: "Soft-delete a card"},
+	{method: http.MethodPost, path: "/cards/:id/restore", id: "restoreCard", tag: "cards", summary: "Restore a soft-deleted card"},
+	{method: http.MethodPost, path: "/cards/:id", id: "refillCard", tag: "cards", summary: "Refill a card",
+		body: cards.RefillCardRequestParams{}, omit: []string{"CardID"}},
+	{method: http.MethodPost, path: "/cards/transfer", id: "transfer", tag: "cards", summary: "Transfer between cards, large transfers wait for confirmation with 202",
+		body: cards.TransferBalanceCardRequestParams{}, also: map[int]interface{}{http.StatusAccepted: cards.PendingTransferInfo{}}},
+	{method: http.MethodGet, path: "/cards/transfers/:id", id: "getPendingTransfer", tag: "cards", summary: "Get a pending transfer",
+		result: cards.PendingTransferInfo{}},
+	{method: http.MethodPost, path: "/cards/transfers/:id/confirm", id: "confirmTransfer", tag: "cards", summary: "Confirm a pending transfer with a TOTP code",
+		body: cards.ConfirmTransferRequestParams{}, omit: []string{"TransferID"}, result: cards.PendingTransferInfo{}},
+	{method: http.MethodDelete, path: "/cards/transfers/:id", id: "cancelTransfer", tag: "cards", summary: "Cancel a pending transfer"},
+
+	// kyc
+	{method: http.MethodGet, path: "/users/:id/kyc", id: "getUserKyc", tag: "kyc", summary: "Verification level and documents of a user",
+		result: kyc.UserKycInfo{}},
+	{method: http.MethodPost, path: "/users/:id/kyc/documents", id: "uploadDocument", tag: "kyc", summary: "Upload a document",
+		body: &Schema{Type: "object", Properties: map[string]*Schema{
+			"type": {Type: "string"},
+			"file": binary,
+		}},
+		created: kyc.DocumentInfo{}},
+	{method: http.MethodGet, path: "/admin/kyc/documents", id: "listDocuments", tag: "kyc", summary: "List documents",
+		query:  params([]*Parameter{query("user_id", "integer", ""), query("status", "string", "pending, approved or rejected")}, pageParams),
+		result: kyc.Pagination{}},
+	{method: http.MethodGet, path: "/admin/kyc/documents/:id", id: "getDocument", tag: "kyc", summary: "Get a document",
+		result: kyc.DocumentInfo{}},
+	{method: http.MethodGet, path: "/admin/kyc/documents/:id/file", id: "getDocumentFile", tag: "kyc", summary: "Download the file of a document",
+		content: map[string]interface{}{"application/octet-stream": binary}},
+	{method: http.MethodPost, path: "/admin/kyc/documents/:id/approve", id: "approveDocument", tag: "kyc", summary: "Approve a document and raise the user's level",
+		body: kyc.ApproveDocumentRequestParams{}, omit: []string{"DocumentID"}},
+	{method: http.MethodPost, path: "/admin/kyc/documents/:id/reject", id: "rejectDocument", tag: "kyc", summary: "Reject a document",
+		body: kyc.RejectDocumentRequestParams{}, omit: []string{"DocumentID"}},
+
+	// audit
+	{method: http.MethodGet, path: "/audit", id: "listAudit", tag: "audit", summary: "List audit log entries",
+		query: params([]*Parameter{
+			query("actor", "string", ""),
+			query("action", "string", ""),
+			query("entity_type", "string", ""),
+			query("entity_id", "integer", ""),
+			query("request_id", "string", ""),
+			query("from", "string", "RFC 3339 time"),
+			query("to", "string", "RFC 3339 time"),
+		}, pageParams),
+		result: audit.Pagination{}},
+	{method: http.MethodGet, path: "/audit/verify", id: "verifyAudit", tag: "audit", summary: "Verify the hash chain of the audit log",
+		result: audit.VerifyResult{}},
+
+	// imports
+	{method: http.MethodPost, path: "/imports", id: "addImport", tag: "imports", summary: "Upload a CSV or JSON Lines file of users or cards to import in the background",
+		query:  []*Parameter{{Name: "entity", In: "query", Required: true, Description: "users or cards", Schema: &Schema{Type: "string"}}},
+		upload: []string{"text/csv", "application/x-ndjson"}, accepted: imports.ImportInfo{}},
+	{method: http.MethodGet, path: "/imports/:id", id: "getImport", tag: "imports", summary: "Status and counts of an import",
+		result: imports.ImportInfo{}},
+	{method: http.MethodGet, path: "/imports/:id/errors", id: "listImportErrors", tag: "imports", summary: "Rows which were not imported, with reasons",
+		query: pageParams, result: imports.ErrorsPagination{}},
+
+	// graphql
+	{method: http.MethodPost, path: "/graphql", id: "graphql", tag: "graphql", summary: "Query users, their cards and recent transfers, refill and transfer in one request; errors of the query are returned in errors with status 200",
+		body: graphqlapi.RequestParams{}, result: graphql.Result{}},
+}
+
+// Build generates the document from the operations and the model types they refer to.
+func Build() *Document {
+	s := schemas{}
+	messageRef := s.of(response.Envelope{})
+	problemRef := s.of(problem.Problem{})
+
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info:    Info{Title: Title, Version: Version},
+		Servers: []Server{
+			{URL: versioning.V2.Prefix, Description: "Version 2, breaking changes land here"},
+			{URL: versioning.V1.Prefix, Description: "Version 1"},
+			{URL: "/", Description: "Unversioned routes, same as version 1, deprecated"},
+		},
+		Security: []map[string][]string{
+			{"ApiKey": {}},
+			{"Bearer": {}},
+		},
+		Paths: map[string]PathItem{},
+		Components: Components{
+			Schemas: s,
+			SecuritySchemes: map[string]*SecurityScheme{
+				"ApiKey": {Type: "apiKey", In: "header", Name: auth.HeaderAPIKey},
+				"Bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT or API key"},
+			},
+		},
+	}
+
+	for _, o := range operations {
+		op := &Operation{
+			Tags:        []string{o.tag},
+			Summary:     o.summary,
+			OperationID: o.id,
+			Parameters:  append(pathParams(o.path), o.query...),
+			Responses:   map[string]*Response{},
+		}
+		if o.public {
+			op.Security = &[]map[string][]string{}
+		}
+
+		switch body := o.body.(type) {
+		case nil:
+		case *Schema:
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{"multipart/form-data": {Schema: body}}}
+		default:
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{
+				"application/json": {Schema: s.object(reflect.TypeOf(body), o.omit)},
+			}}
+		}
+
+		if len(o.upload) != 0 {
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{}}
+			for _, mediaType := range o.upload {
+				op.RequestBody.Content[mediaType] = &MediaType{Schema: binary}
+			}
+		}
+
+		if o.patch != nil {
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{
+				patch.MergePatchType: {Schema: s.of(o.patch)},
+				patch.JSONPatchType:  {Schema: &Schema{Type: "array", Items: s.of(patch.Operation{})}},
+			}}
+		}
+
+		status := http.StatusOK
+		success := &Response{Description: http.StatusText(status), Content: map[string]*MediaType{}}
+		switch {
+		case o.created != nil:
+			status = http.StatusCreated
+			success.Description = http.StatusText(status)
+			success.Headers = map[string]*Header{echo.HeaderLocation: {Description: "URL of the created resource", Schema: &Schema{Type: "string"}}}
+			success.Content["application/json"] = &MediaType{Schema: &Schema{Type: "object", Properties: map[string]*Schema{
+				"status": {Type: "string"},
+				"data":   s.of(o.created),
+			}}}
+		case o.accepted != nil:
+			status = http.StatusAccepted
+			success.Description = http.StatusText(status)
+			success.Headers = map[string]*Header{echo.HeaderLocation: {Description: "URL to poll for the result", Schema: &Schema{Type: "string"}}}
+			success.Content["application/json"] = &MediaType{Schema: s.of(o.accepted)}
+		case o.content != nil:
+			for mediaType, v := range o.content {
+				success.Content[mediaType] = &MediaType{Schema: s.sample(v)}
+			}
+		case o.result != nil:
+			success.Content["application/json"] = &MediaType{Schema: s.of(o.result)}
+		default:
+			success.Content["application/json"] = &MediaType{Schema: messageRef}
+		}
+		op.Responses[strconv.Itoa(status)] = success
+		for status, v := range o.also {
+			op.Responses[strconv.Itoa(status)] = &Response{
+				Description: http.StatusText(status),
+				Content:     map[string]*MediaType{"application/json": {Schema: s.of(v)}},
+			}
+		}
+		if o.body != nil || o.upload != nil || o.patch != nil || len(o.query) != 0 {
+			op.Responses[strconv.Itoa(http.StatusBadRequest)] = &Response{
+				Description: "Invalid request, validation errors are listed per field",
+				Content:     map[string]*MediaType{problem.ContentType: {Schema: problemRef}},
+			}
+		}
+		if o.etag {
+			conditional(op, success, o.method, problemRef)
+		}
+		op.Responses["default"] = &Response{
+			Description: "Error as RFC 7807 problem details",
+			Content:     map[string]*MediaType{problem.ContentType: {Schema: problemRef}},
+		}
+
+		p := specPath(o.path)
+		if doc.Paths[p] == nil {
+			doc.Paths[p] = PathItem{}
+		}
+		doc.Paths[p][strings.ToLower(o.method)] = op
+	}
+
+	return doc
+}
+
+// conditional documents the ETag of the resource and the precondition headers of
+// the operation, see etag.NotModified and etag.IfMatch.
+func conditional(op *Operation, success *Response, method string, problemRef *Schema) {
+	if success.Headers == nil {
+		success.Headers = map[string]*Header{}
+	}
+	success.Headers[etag.HeaderETag] = &Header{Description: "Current entity tag of the resource", Schema: &Schema{Type: "string"}}
+
+	if method == http.MethodGet {
+		op.Parameters = append(op.Parameters, &Parameter{Name: etag.HeaderIfNoneMatch, In: "header",
+			Description: "ETag of a cached representation", Schema: &Schema{Type: "string"}})
+		op.Responses[strconv.Itoa(http.StatusNotModified)] = &Response{Description: "The cached representation is current"}
+		return
+	}
+
+	required := method != http.MethodPatch
+	op.Parameters = append(op.Parameters, &Parameter{Name: etag.HeaderIfMatch, In: "header", Required: required,
+		Description: "ETag of the representation the change is based on", Schema: &Schema{Type: "string"}})
+	op.Responses[strconv.Itoa(http.StatusPreconditionFailed)] = &Response{Description: "The resource was modified since it was read",
+		Content: map[string]*MediaType{problem.ContentType: {Schema: problemRef}}}
+	if required {
+		op.Responses[strconv.Itoa(http.StatusPreconditionRequired)] = &Response{Description: "If-Match header is missing",
+			Content: map[string]*MediaType{problem.ContentType: {Schema: problemRef}}}
+	}
+}
+
+// specPath converts echo path parameters, /users/:id, to /users/{id}.
+func specPath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func pathParams(p string) []*Parameter {
+	var list []*Parameter
+	for _, segment := range strings.Split(p, "/") {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		typ := "integer"
+		if segment == ":name" {
+			typ = "string"
+		}
+		list = append(list, &Parameter{Name: segment[1:], In: "path", Required: true, Schema: &Schema{Type: typ}})
+	}
+	return list
+}
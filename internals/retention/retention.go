@@ -0,0 +1,46 @@
+package retention
+
+import (
+	"context"
+	"github.com/lenarsaitov/go-task/pkg/logging"
+	"time"
+)
+
+// Purger permanently removes entities soft-deleted before the given time.
+type Purger interface {
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+}
+
+type NamedPurger struct {
+	Name   string
+	Purger Purger
+}
+
+// Run purges soft-deleted entities older than period every interval until ctx is done.
+// Purgers run in the given order, so dependent entities (cards) go before their owners (users).
+func Run(ctx context.Context, period time.Duration, interval time.Duration, purgers ...NamedPurger) {
+	logger := logging.GetLogger()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		before := time.Now().Add(-period)
+		for _, p := range purgers {
+			n, err := p.Purger.PurgeDeleted(ctx, before)
+			if err != nil {
+				logger.Errorf("failed to purge deleted %s: %s", p.Name, err)
+				continue
+			}
+			if n > 0 {
+				logger.Infof("purged %d deleted %s older than %s", n, p.Name, before.Format(time.RFC3339))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package internals
 
 import (
+	"fmt"
 	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/pkg/problem"
+	"github.com/lenarsaitov/go-task/pkg/requestid"
+	"github.com/lenarsaitov/go-task/pkg/validate"
 	"net/http"
 )
 
@@ -9,9 +13,21 @@ func NewServer() *echo.Echo {
 	e := echo.New()
 	HideBanner(e)
 	e.Use(NoCache())
+	e.Use(requestid.Middleware())
+	e.Validator = validate.New()
+	e.HTTPErrorHandler = problem.HTTPErrorHandler
 	return e
 }
 
+// Misdirected answers requests for routes which are served on another port of the
+// application, like exports, with 421 naming the port.
+func Misdirected(port string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return problem.Write(c, problem.New(c, http.StatusMisdirectedRequest, "misdirected_request",
+			fmt.Sprintf("%s is served on port %s", c.Request().URL.Path, port)))
+	}
+}
+
 func HideBanner(e *echo.Echo) {
 	e.HideBanner = true
 	e.HidePort = true
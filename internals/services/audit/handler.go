@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/pkg/problem"
+	"github.com/lenarsaitov/go-task/pkg/response"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type AuditHandler struct {
+	service *AuditService
+}
+
+func NewAuditHandler(service *AuditService) *AuditHandler {
+	return &AuditHandler{service}
+}
+
+var INDENT = "  "
+
+func (h *AuditHandler) Setup(root *echo.Group) {
+	root.GET("/audit", h.List)
+	root.GET("/audit/verify", h.Verify)
+}
+
+func (h *AuditHandler) List(c echo.Context) error {
+	var sizeInt, pageInt int
+	var entityID int64
+	var err error
+
+	pageStr := c.FormValue("page")
+	sizeStr := c.FormValue("size")
+	entityIDStr := c.FormValue("entity_id")
+
+	if len(pageStr) != 0 {
+		pageInt, err = strconv.Atoi(pageStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+	if len(sizeStr) != 0 {
+		sizeInt, err = strconv.Atoi(sizeStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+	if len(entityIDStr) != 0 {
+		entityID, err = strconv.ParseInt(entityIDStr, 10, 64)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+
+	params := &FilterParams{
+		Actor:      c.FormValue("actor"),
+		Action:     c.FormValue("action"),
+		EntityType: c.FormValue("entity_type"),
+		EntityID:   entityID,
+		RequestID:  c.FormValue("request_id"),
+		Page:       pageInt,
+		Size:       sizeInt,
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	for name, dst := range map[string]**time.Time{"from": &params.From, "to": &params.To} {
+		value := c.FormValue(name)
+		if len(value) == 0 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, fmt.Errorf("Invalid %s: must be RFC 3339 time", name))
+		}
+		*dst = &t
+	}
+
+	p, err := h.service.GetList(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *AuditHandler) Verify(c echo.Context) error {
+	p, err := h.service.Verify(c.Request().Context())
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *AuditHandler) HandleSuccess(c echo.Context, statusCode int, Message string) error {
+	return response.Success(c, statusCode, Message)
+}
+
+func (h *AuditHandler) HandleError(c echo.Context, statusCode int, err error) error {
+	return problem.Error(c, statusCode, err, errorCodes)
+}
+
+func serviceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorCodes are stable codes of the service errors returned in problem details.
+var errorCodes = problem.Codes{
+	auth.ErrUnauthenticated: "unauthenticated",
+	auth.ErrForbidden:       "forbidden",
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actions recorded by the services.
+const (
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionDelete   = "delete"
+	ActionRestore  = "restore"
+	ActionRefill   = "refill"
+	ActionTransfer = "transfer"
+	ActionMerge    = "merge"
+	ActionErase    = "erase"
+	ActionApprove  = "approve"
+	ActionReject   = "reject"
+	ActionConfirm  = "confirm"
+	ActionCancel   = "cancel"
+	ActionImport   = "import"
+	ActionRevoke   = "revoke"
+	ActionReset    = "reset_password"
+)
+
+// Types of entities changes are recorded for.
+const (
+	EntityUser     = "user"
+	EntityCard     = "card"
+	EntityDocument = "kyc_document"
+	EntityTransfer = "pending_transfer"
+	EntityImport   = "import"
+	EntityAPIKey   = "api_key"
+)
+
+// ActorSystem is recorded for changes made outside of authenticated requests.
+const ActorSystem = "system"
+
+// genesisHash is the previous hash of the first entry of every tenant.
+var genesisHash = strings.Repeat("0", 64)
+
+// Change is a mutation to be recorded. Before and After are JSON snapshots of the
+// entity, empty when it didn't exist or must not be kept, see Snapshot.
+type Change struct {
+	Action     string
+	EntityType string
+	EntityID   int64
+	Before     string
+	After      string
+}
+
+type Entry struct {
+	AuditID    int64           `json:"audit_id"`
+	TenantID   string          `json:"tenant_id"`
+	CreateTime time.Time       `json:"create_time"`
+	ActorKind  string          `json:"actor_kind"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int64           `json:"entity_id"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// computeHash covers every field but the id and the hash itself together with the
+// hash of the previous entry, so changing or removing an entry breaks the chain.
+// Fields are length-prefixed to keep their boundaries unambiguous.
+func (e *Entry) computeHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		e.PrevHash,
+		e.TenantID,
+		e.CreateTime.UTC().Format(time.RFC3339Nano),
+		e.ActorKind,
+		e.Actor,
+		e.Action,
+		e.EntityType,
+		strconv.FormatInt(e.EntityID, 10),
+		e.RequestID,
+		string(e.Before),
+		string(e.After),
+	} {
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type Pagination struct {
+	Page       int      `json:"page,omitempty"`
+	Size       int      `json:"size,omitempty"`
+	PagesCount int      `json:"pagesCount"`
+	ItemsCount int      `json:"itemsCount"`
+	Items      []*Entry `json:"items"`
+}
+
+type FilterParams struct {
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   int64
+	RequestID  string
+	From       *time.Time
+	To         *time.Time
+	Page       int `query:"page" validate:"omitempty,gte=1"`
+	Size       int `query:"size" validate:"omitempty,gte=1,lte=50"`
+}
+
+// VerifyResult reports whether the chain of the tenant is intact. FirstInvalidID
+// is the first entry which doesn't match its hash or its predecessor.
+type VerifyResult struct {
+	Valid          bool   `json:"valid"`
+	Checked        int    `json:"checked"`
+	FirstInvalidID int64  `json:"first_invalid_id,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+// verifyChain walks entries in the order they were written and checks every hash
+// and link, then compares the last entry with headHash, the hash of the last entry
+// written, which catches entries removed from the end.
+func verifyChain(entries []*Entry, headHash string) *VerifyResult {
+	res := &VerifyResult{Valid: true}
+	prevHash := genesisHash
+	for _, e := range entries {
+		res.Checked++
+
+		switch {
+		case e.PrevHash != prevHash:
+			res.Reason = "entry doesn't follow the previous one"
+		case e.computeHash() != e.Hash:
+			res.Reason = "entry doesn't match its hash"
+		}
+		if len(res.Reason) != 0 {
+			res.Valid = false
+			res.FirstInvalidID = e.AuditID
+			return res
+		}
+		prevHash = e.Hash
+	}
+
+	if headHash != prevHash {
+		res.Valid = false
+		res.Reason = "last entries of the chain are missing"
+	}
+	return res
+}
@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// chain returns n entries linked the way Record writes them.
+func chain(n int) []*Entry {
+	entries := make([]*Entry, 0, n)
+	prevHash := genesisHash
+	for i := 1; i <= n; i++ {
+		e := &Entry{
+			AuditID:    int64(i),
+			TenantID:   "default",
+			CreateTime: time.Date(2026, 10, 19, 12, 0, i, 0, time.UTC),
+			ActorKind:  "user",
+			Actor:      "1",
+			Action:     ActionUpdate,
+			EntityType: EntityCard,
+			EntityID:   int64(i),
+			Before:     json.RawMessage(`{"balance": 100}`),
+			After:      json.RawMessage(`{"balance": 200}`),
+			PrevHash:   prevHash,
+		}
+		e.Hash = e.computeHash()
+		prevHash = e.Hash
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestVerifyChain(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes a chain of 4 entries and returns it with the head hash
+		tamper         func(entries []*Entry) ([]*Entry, string)
+		valid          bool
+		firstInvalidID int64
+	}{
+		{
+			name: "intact",
+			tamper: func(entries []*Entry) ([]*Entry, string) {
+				return entries, entries[3].Hash
+			},
+			valid: true,
+		},
+		{
+			name: "empty",
+			tamper: func(entries []*Entry) ([]*Entry, string) {
+				return nil, genesisHash
+			},
+			valid: true,
+		},
+		{
+			name: "edited entry",
+			tamper: func(entries []*Entry) ([]*Entry, string) {
+				entries[1].After = json.RawMessage(`{"balance": 1000000}`)
+				return entries, entries[3].Hash
+			},
+			firstInvalidID: 2,
+		},
+		{
+			name: "edited entry with its hash recomputed",
+			tamper: func(entries []*Entry) ([]*Entry, string) {
+				entries[1].Actor = "2"
+				entries[1].Hash = entries[1].computeHash()
+				return entries, entries[3].Hash
+			},
+			firstInvalidID: 3,
+		},
+		{
+			name: "deleted last entry",
+			tamper: func(entries []*Entry) ([]*Entry, string) {
+				return entries[:3], entries[3].Hash
+			},
+		},
+		{
+			name: "deleted entry in the middle",
+			tamper: func(entries []*Entry) ([]*Entry, string) {
+				return append(entries[:1], entries[2:]...), entries[3].Hash
+			},
+			firstInvalidID: 3,
+		},
+		{
+			name: "reordered entries",
+			tamper: func(entries []*Entry) ([]*Entry, string) {
+				entries[1], entries[2] = entries[2], entries[1]
+				return entries, entries[3].Hash
+			},
+			firstInvalidID: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, headHash := tt.tamper(chain(4))
+			res := verifyChain(entries, headHash)
+			if res.Valid != tt.valid {
+				t.Fatalf("Valid = %v, want %v: %s", res.Valid, tt.valid, res.Reason)
+			}
+			if res.FirstInvalidID != tt.firstInvalidID {
+				t.Errorf("FirstInvalidID = %d, want %d", res.FirstInvalidID, tt.firstInvalidID)
+			}
+		})
+	}
+}
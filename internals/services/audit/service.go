@@ -0,0 +1,30 @@
+package audit
+
+import (
+	"context"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+)
+
+type AuditService struct {
+	storage *AuditStorage
+}
+
+func NewAuditService(storage *AuditStorage) *AuditService {
+	return &AuditService{storage: storage}
+}
+
+func (service *AuditService) GetList(c context.Context, params *FilterParams) (*Pagination, error) {
+	if err := auth.Authorize(c, auth.PermAuditRead); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindMany(c, params)
+}
+
+func (service *AuditService) Verify(c context.Context) (*VerifyResult, error) {
+	if err := auth.Authorize(c, auth.PermAuditRead); err != nil {
+		return nil, err
+	}
+
+	return service.storage.Verify(c)
+}
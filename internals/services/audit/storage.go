@@ -0,0 +1,294 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lenarsaitov/go-task/pkg/requestid"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+type AuditStorage struct {
+	db atomic.Value
+}
+
+type QueryResult interface {
+	Scan(dest ...interface{}) error
+}
+
+var (
+	_ QueryResult = &sql.Rows{}
+	_ QueryResult = &sql.Row{}
+)
+
+const entryColumns = `audit_id, tenant_id, create_time, actor_kind, actor, action, entity_type, entity_id,
+	COALESCE(request_id, ''), COALESCE(before::text, ''), COALESCE(after::text, ''), prev_hash, entry_hash`
+
+func NewAuditStorage(db *sqlx.DB) *AuditStorage {
+	res := &AuditStorage{}
+	res.db.Store(db)
+	return res
+}
+
+func (s *AuditStorage) getDB() *sqlx.DB {
+	return s.db.Load().(*sqlx.DB)
+}
+
+// querier returns the connection bound to the tenant of ctx, see tenant.DB.
+func (s *AuditStorage) querier(ctx context.Context) tenant.Querier {
+	return tenant.DB(ctx, s.getDB())
+}
+
+// Snapshot returns the JSON the query selects for id within tx, empty if there is
+// no such row. The query must select a single jsonb value, like
+// SELECT to_jsonb(cards) FROM cards WHERE card_id = $1.
+func Snapshot(ctx context.Context, tx *sql.Tx, query string, id interface{}) (string, error) {
+	var snapshot sql.NullString
+	err := tx.QueryRowContext(ctx, `SELECT (`+query+`)::text;`, id).Scan(&snapshot)
+	if err != nil {
+		return "", fmt.Errorf("Cannot take audit snapshot: %w", err)
+	}
+	return snapshot.String, nil
+}
+
+// Record appends the change to the audit log of the tenant of ctx within tx, so
+// that the entry is committed if and only if the change is. The actor and the
+// request id are taken from ctx.
+//
+// Entries of a tenant form a hash chain. The head of the chain is locked until tx
+// ends, which serialises writers of the tenant; in a repeatable read transaction a
+// concurrent writer makes Record fail with a serialization error instead of forking
+// the chain. Before and After may be any JSON, e.g. from json.Marshal; the stored
+// entry is read back and Record fails unless Verify would accept it.
+func Record(ctx context.Context, tx *sql.Tx, change *Change) error {
+	e := &Entry{
+		TenantID:   tenant.FromContext(ctx),
+		CreateTime: time.Now().UTC().Truncate(time.Microsecond),
+		ActorKind:  ActorSystem,
+		Actor:      ActorSystem,
+		Action:     change.Action,
+		EntityType: change.EntityType,
+		EntityID:   change.EntityID,
+		RequestID:  requestid.FromContext(ctx),
+	}
+	if p := auth.PrincipalFromContext(ctx); p != nil {
+		e.ActorKind = string(p.Kind)
+		e.Actor = p.Subject
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO audit_chain_heads (tenant_id, last_hash) VALUES ($1, $2)
+		 ON CONFLICT (tenant_id) DO NOTHING;`, e.TenantID, genesisHash)
+	if err != nil {
+		return fmt.Errorf("Cannot write audit log: %w", err)
+	}
+
+	// snapshots are hashed as jsonb prints them, which is what Verify reads back,
+	// not as the caller serialised them
+	var before, after string
+	err = tx.QueryRowContext(ctx,
+		`SELECT last_hash, COALESCE(NULLIF($2, '')::jsonb::text, ''), COALESCE(NULLIF($3, '')::jsonb::text, '')
+		   FROM audit_chain_heads WHERE tenant_id = $1 FOR UPDATE;`,
+		e.TenantID, change.Before, change.After).Scan(&e.PrevHash, &before, &after)
+	if err != nil {
+		return fmt.Errorf("Cannot write audit log: %w", err)
+	}
+	e.Before, e.After = []byte(before), []byte(after)
+
+	e.Hash = e.computeHash()
+
+	var storedBefore, storedAfter string
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO audit_log
+		              (tenant_id, create_time, actor_kind, actor, action, entity_type, entity_id,
+		               request_id, before, after, prev_hash, entry_hash)
+		        VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, '')::jsonb, NULLIF($10, '')::jsonb, $11, $12)
+		     RETURNING COALESCE(before::text, ''), COALESCE(after::text, '');`,
+		e.TenantID, e.CreateTime, e.ActorKind, e.Actor, e.Action, e.EntityType, e.EntityID,
+		e.RequestID, before, after, e.PrevHash, e.Hash).Scan(&storedBefore, &storedAfter)
+	if err != nil {
+		return fmt.Errorf("Cannot write audit log: %w", err)
+	}
+	if storedBefore != before || storedAfter != after {
+		return errors.New("Cannot write audit log: stored entry doesn't match its hash")
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE audit_chain_heads SET last_hash = $2 WHERE tenant_id = $1;`, e.TenantID, e.Hash)
+	if err != nil {
+		return fmt.Errorf("Cannot write audit log: %w", err)
+	}
+
+	return nil
+}
+
+// Auditor records changes of auth, which can't import this package since it
+// authorizes with auth, see auth.Auditor.
+type Auditor struct{}
+
+func (Auditor) Snapshot(ctx context.Context, tx *sql.Tx, query string, id interface{}) (string, error) {
+	return Snapshot(ctx, tx, query, id)
+}
+
+func (Auditor) Record(ctx context.Context, tx *sql.Tx, action string, entityType string, entityID int64, before string, after string) error {
+	return Record(ctx, tx, &Change{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Before:     before,
+		After:      after,
+	})
+}
+
+func (s *AuditStorage) readEntry(r QueryResult) (*Entry, error) {
+	e := &Entry{}
+	var before, after string
+	err := r.Scan(&e.AuditID, &e.TenantID, &e.CreateTime, &e.ActorKind, &e.Actor, &e.Action, &e.EntityType, &e.EntityID,
+		&e.RequestID, &before, &after, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(before) != 0 {
+		e.Before = []byte(before)
+	}
+	if len(after) != 0 {
+		e.After = []byte(after)
+	}
+	return e, nil
+}
+
+func (s *AuditStorage) buildFindManyWhereClause(b *sqlbuilder.Builder, filter *FilterParams) {
+	if len(filter.Actor) != 0 {
+		b.Where("actor = ?", filter.Actor)
+	}
+
+	if len(filter.Action) != 0 {
+		b.Where("action = ?", filter.Action)
+	}
+
+	if len(filter.EntityType) != 0 {
+		b.Where("entity_type = ?", filter.EntityType)
+	}
+
+	if filter.EntityID != 0 {
+		b.Where("entity_id = ?", filter.EntityID)
+	}
+
+	if len(filter.RequestID) != 0 {
+		b.Where("request_id = ?", filter.RequestID)
+	}
+
+	if filter.From != nil {
+		b.Where("create_time >= ?", *filter.From)
+	}
+
+	if filter.To != nil {
+		b.Where("create_time < ?", *filter.To)
+	}
+}
+
+func (s *AuditStorage) FindMany(ctx context.Context, filter *FilterParams) (*Pagination, error) {
+	limit := filter.Size
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+
+	offset := 0
+	if filter.Page > 0 {
+		offset = (filter.Page - 1) * filter.Size
+	}
+
+	b := sqlbuilder.New()
+	b.Where("tenant_id = ?", tenant.FromContext(ctx))
+	s.buildFindManyWhereClause(b, filter)
+	whereClause, whereArgs := b.WhereClause(), b.Args()
+
+	query := `SELECT ` + entryColumns + `
+	          FROM audit_log ` + whereClause + `
+	          ORDER BY (audit_id)
+	          LIMIT ` + b.Arg(limit) + ` OFFSET ` + b.Arg(offset) + `;`
+
+	rows, err := s.querier(ctx).QueryContext(ctx, query, b.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]*Entry, 0)
+	for rows.Next() {
+		e, err := s.readEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read audit entry: %w", err)
+		}
+		items = append(items, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+	// closed before the count query, which may run on the same connection
+	rows.Close()
+
+	countQuery := `SELECT COUNT(*) FROM audit_log ` + whereClause + `;`
+	row := s.querier(ctx).QueryRowContext(ctx, countQuery, whereArgs...)
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return nil, fmt.Errorf("Items count query error: %w", err)
+	}
+
+	pc := count / limit
+	if count%limit > 0 {
+		pc++
+	}
+
+	return &Pagination{
+		Page:       filter.Page,
+		Size:       filter.Size,
+		PagesCount: pc,
+		ItemsCount: count,
+		Items:      items,
+	}, nil
+}
+
+// Verify reads the chain of the tenant of ctx and its head and checks them, see
+// verifyChain.
+func (s *AuditStorage) Verify(ctx context.Context) (*VerifyResult, error) {
+	tenantID := tenant.FromContext(ctx)
+
+	rows, err := s.querier(ctx).QueryContext(ctx,
+		`SELECT `+entryColumns+` FROM audit_log WHERE tenant_id = $1 ORDER BY (audit_id);`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]*Entry, 0)
+	for rows.Next() {
+		e, err := s.readEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+	rows.Close()
+
+	headHash := genesisHash
+	err = s.querier(ctx).QueryRowContext(ctx,
+		`SELECT last_hash FROM audit_chain_heads WHERE tenant_id = $1;`, tenantID).Scan(&headHash)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return verifyChain(entries, headHash), nil
+}
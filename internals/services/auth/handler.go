@@ -0,0 +1,507 @@
+package auth
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lenarsaitov/go-task/internals/versioning"
+	"github.com/lenarsaitov/go-task/pkg/bind"
+	"github.com/lenarsaitov/go-task/pkg/problem"
+	"github.com/lenarsaitov/go-task/pkg/response"
+	"net/http"
+	"strconv"
+)
+
+type AuthHandler struct {
+	service *AuthService
+}
+
+func NewAuthHandler(service *AuthService) *AuthHandler {
+	return &AuthHandler{service}
+}
+
+var INDENT = "  "
+
+// SetupPublic registers the routes which are called without credentials.
+func (h *AuthHandler) SetupPublic(root *echo.Group) {
+	root.POST("/auth/register", h.Register)
+	root.POST("/auth/login", h.Login)
+	root.POST("/auth/refresh", h.Refresh)
+	root.POST("/auth/logout", h.Logout)
+	root.POST("/auth/password-reset", h.RequestPasswordReset)
+	root.POST("/auth/password-reset/confirm", h.ConfirmPasswordReset)
+}
+
+func (h *AuthHandler) Setup(root *echo.Group) {
+	root.GET("/auth/me", h.Me)
+	root.POST("/auth/logout-all", h.LogoutAll)
+
+	admin := root.Group("/admin")
+
+	admin.GET("/api-keys", h.ListAPIKeys)
+	admin.GET("/api-keys/:id", h.APIKeyItem)
+
+	admin.POST("/api-keys", h.AddAPIKeyItem)
+	admin.DELETE("/api-keys/:id", h.RevokeAPIKeyItem)
+
+	admin.GET("/permissions", h.ListPermissions)
+	admin.GET("/roles", h.ListRoles)
+	admin.PUT("/roles/:name", h.SetRoleItem)
+	admin.DELETE("/roles/:name", h.DeleteRoleItem)
+
+	admin.GET("/users/:id/roles", h.UserRoles)
+	admin.PUT("/users/:id/roles", h.SetUserRoles)
+
+	admin.GET("/users/:id/identities", h.UserIdentities)
+	admin.PUT("/users/:id/identities", h.SetUserIdentities)
+}
+
+func (h *AuthHandler) Me(c echo.Context) error {
+	p := PrincipalFromContext(c.Request().Context())
+	if p == nil {
+		return h.HandleError(c, http.StatusUnauthorized, ErrMissingCredentials)
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *AuthHandler) Register(c echo.Context) error {
+	params := &RegisterRequestParams{}
+	err := bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.Register(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return response.Created(c, response.Location(versioning.Path(c.Request().Context(), "/users"), *p), &RegisteredUser{UserID: *p})
+}
+
+func (h *AuthHandler) Login(c echo.Context) error {
+	params := &LoginRequestParams{}
+	err := bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.Login(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *AuthHandler) Refresh(c echo.Context) error {
+	params := &RefreshRequestParams{}
+	err := bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.Refresh(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *AuthHandler) Logout(c echo.Context) error {
+	params := &RefreshRequestParams{}
+	err := bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.Logout(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "Active Session Not Found")
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *AuthHandler) LogoutAll(c echo.Context) error {
+	err := h.service.LogoutAll(c.Request().Context())
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *AuthHandler) RequestPasswordReset(c echo.Context) error {
+	params := &PasswordResetRequestParams{}
+	err := bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.RequestPasswordReset(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "If the email is registered, a password reset token has been sent to it")
+}
+
+func (h *AuthHandler) ConfirmPasswordReset(c echo.Context) error {
+	params := &PasswordResetConfirmRequestParams{}
+	err := bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.ConfirmPasswordReset(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *AuthHandler) ListAPIKeys(c echo.Context) error {
+	var includeRevoked bool
+	var err error
+	if includeRevokedStr := c.FormValue("include_revoked"); len(includeRevokedStr) != 0 {
+		includeRevoked, err = strconv.ParseBool(includeRevokedStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+
+	p, err := h.service.GetListAPIKeys(c.Request().Context(), includeRevoked)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *AuthHandler) APIKeyItem(c echo.Context) error {
+	apiKeyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.GetAPIKey(c.Request().Context(), apiKeyID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *AuthHandler) AddAPIKeyItem(c echo.Context) error {
+	params := &AddAPIKeyRequestParams{}
+	err := bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.AddAPIKey(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return response.Created(c, response.Location(c.Request().URL.Path, p.APIKeyID), p)
+}
+
+func (h *AuthHandler) RevokeAPIKeyItem(c echo.Context) error {
+	apiKeyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.RevokeAPIKey(c.Request().Context(), apiKeyID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "Active API Key Not Found")
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *AuthHandler) ListPermissions(c echo.Context) error {
+	return c.JSONPretty(http.StatusOK, Permissions, INDENT)
+}
+
+func (h *AuthHandler) ListRoles(c echo.Context) error {
+	p, err := h.service.GetListRoles(c.Request().Context())
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *AuthHandler) SetRoleItem(c echo.Context) error {
+	params := &SetRoleRequestParams{Name: c.Param("name")}
+	err := bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.SetRole(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *AuthHandler) DeleteRoleItem(c echo.Context) error {
+	p, err := h.service.DeleteRole(c.Request().Context(), c.Param("name"))
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *AuthHandler) UserRoles(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.GetUserRoles(c.Request().Context(), userID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "User Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *AuthHandler) SetUserRoles(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	params := &SetUserRolesRequestParams{UserID: userID}
+	err = bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.SetUserRoles(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "User Not Found")
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *AuthHandler) UserIdentities(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.GetUserIdentities(c.Request().Context(), userID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "User Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *AuthHandler) SetUserIdentities(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	params := &SetUserIdentitiesRequestParams{UserID: userID}
+	err = bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	p, err := h.service.SetUserIdentities(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "User Not Found")
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *AuthHandler) HandleSuccess(c echo.Context, statusCode int, Message string) error {
+	return response.Success(c, statusCode, Message)
+}
+
+func (h *AuthHandler) HandleError(c echo.Context, statusCode int, err error) error {
+	return problem.Error(c, statusCode, err, errorCodes)
+}
+
+func serviceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNameRequired),
+		errors.Is(err, ErrInvalidScope),
+		errors.Is(err, ErrInvalidExpiresAt),
+		errors.Is(err, ErrInvalidRoleName),
+		errors.Is(err, ErrUnknownRole),
+		errors.Is(err, ErrUnknownPermission),
+		errors.Is(err, ErrUserNameRequired),
+		errors.Is(err, ErrInvalidEmail),
+		errors.Is(err, ErrWeakPassword),
+		errors.Is(err, ErrInvalidResetToken),
+		errors.Is(err, ErrUnknownTenant),
+		errors.Is(err, ErrInvalidIdentity),
+		errors.Is(err, tenant.ErrInvalidID):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnauthenticated),
+		errors.Is(err, ErrInvalidCredentials),
+		errors.Is(err, ErrInvalidRefreshToken),
+		errors.Is(err, ErrRefreshTokenReused):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden),
+		errors.Is(err, tenant.ErrMismatch):
+		return http.StatusForbidden
+	case errors.Is(err, ErrBuiltinRole),
+		errors.Is(err, ErrEmailTaken),
+		errors.Is(err, ErrIdentityTaken):
+		return http.StatusConflict
+	case errors.Is(err, ErrLoginDisabled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorCodes are stable codes of the service errors returned in problem details.
+var errorCodes = problem.Codes{
+	ErrMissingCredentials:  "missing_credentials",
+	ErrInvalidAPIKey:       "invalid_api_key",
+	ErrJWTDisabled:         "jwt_disabled",
+	ErrNameRequired:        "name_required",
+	ErrInvalidScope:        "invalid_scope",
+	ErrInvalidExpiresAt:    "invalid_expires_at",
+	ErrInvalidRoleName:     "invalid_role_name",
+	ErrUnknownRole:         "unknown_role",
+	ErrUnknownPermission:   "unknown_permission",
+	ErrBuiltinRole:         "builtin_role",
+	ErrLoginDisabled:       "login_disabled",
+	ErrUserNameRequired:    "username_required",
+	ErrInvalidEmail:        "invalid_email",
+	ErrWeakPassword:        "weak_password",
+	ErrEmailTaken:          "email_taken",
+	ErrInvalidCredentials:  "invalid_credentials",
+	ErrInvalidRefreshToken: "invalid_refresh_token",
+	ErrRefreshTokenReused:  "refresh_token_reused",
+	ErrInvalidResetToken:   "invalid_reset_token",
+	ErrTokenRevoked:        "token_revoked",
+	ErrUnknownUser:         "unknown_user",
+	ErrInvalidIdentity:     "invalid_identity",
+	ErrIdentityTaken:       "identity_taken",
+	ErrUnknownTenant:       "unknown_tenant",
+	ErrPlatformOnly:        "platform_only",
+	ErrUnauthenticated:     "unauthenticated",
+	ErrForbidden:           "forbidden",
+	tenant.ErrInvalidID:    "invalid_tenant",
+	tenant.ErrMismatch:     "tenant_mismatch",
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lenarsaitov/go-task/pkg/problem"
+	"net/http"
+	"strings"
+)
+
+const HeaderAPIKey = "X-API-Key"
+
+// Middleware authenticates every request of the group it is attached to, see
+// AuthService.Authenticate. The caller with resolved permissions is put into the
+// request context, see PrincipalFromContext. Callers pinned to a tenant act on
+// that tenant only, they are refused if X-Tenant-ID names another one.
+func Middleware(service *AuthService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			p, err := service.Authenticate(req.Context(), req.Header.Get(HeaderAPIKey), req.Header.Get(echo.HeaderAuthorization))
+			if err != nil {
+				return unauthorized(c, err)
+			}
+
+			if err := service.ResolvePermissions(req.Context(), p); err != nil {
+				return problem.Error(c, http.StatusInternalServerError, err, errorCodes)
+			}
+
+			ctx := WithPrincipal(req.Context(), p)
+			if len(p.TenantID) != 0 {
+				if id := req.Header.Get(tenant.HeaderTenantID); len(id) != 0 && id != p.TenantID {
+					return problem.Error(c, http.StatusForbidden, tenant.ErrMismatch, errorCodes)
+				}
+				ctx = tenant.WithID(ctx, p.TenantID)
+			}
+
+			c.SetRequest(req.WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+func splitAuthorization(value string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(value), " ", 2)
+	if len(parts) != 2 {
+		return parts[0], ""
+	}
+	return parts[0], strings.TrimSpace(parts[1])
+}
+
+func unauthorized(c echo.Context, err error) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="go-task"`)
+	return problem.Error(c, http.StatusUnauthorized, err, errorCodes)
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// APIKeyPrefix starts every issued key, so keys are easy to recognise in
+	// headers and to find by secret scanners.
+	APIKeyPrefix = "gtk_"
+	// RefreshTokenPrefix starts refresh tokens issued on login.
+	RefreshTokenPrefix = "gtr_"
+)
+
+type PrincipalKind string
+
+const (
+	PrincipalAPIKey PrincipalKind = "api_key"
+	PrincipalUser   PrincipalKind = "user"
+)
+
+// Principal is the authenticated caller of a request. Scopes of API keys name the
+// roles granted to the key; end users get roles assigned to them in user_roles.
+// TenantID pins the caller to one tenant, it's empty for platform API keys which
+// may act on any tenant named by the X-Tenant-ID header.
+type Principal struct {
+	Kind        PrincipalKind `json:"kind"`
+	Subject     string        `json:"subject"`
+	APIKeyID    int64         `json:"api_key_id,omitempty"`
+	UserID      int64         `json:"user_id,omitempty"`
+	TenantID    string        `json:"tenant_id,omitempty"`
+	Scopes      []string      `json:"scopes"`
+	Roles       []string      `json:"roles"`
+	Permissions []string      `json:"permissions"`
+}
+
+func (p *Principal) Can(permission string) bool {
+	for _, s := range p.Permissions {
+		if s == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Owns reports whether the caller is the end user userID.
+func (p *Principal) Owns(userID int64) bool {
+	return p.Kind == PrincipalUser && p.UserID != 0 && p.UserID == userID
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the caller put into ctx by the authentication middleware.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+type APIKeyInfo struct {
+	APIKeyID   int64    `json:"api_key_id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	TenantID   string   `json:"tenant_id,omitempty"`
+	CreateTime string   `json:"create_time"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+}
+
+// CreatedAPIKey is returned once on creation: only a hash of Key is stored.
+type CreatedAPIKey struct {
+	*APIKeyInfo
+	Key string `json:"key"`
+}
+
+type AddAPIKeyRequestParams struct {
+	Name      string   `json:"name" validate:"required,max=100"`
+	Scopes    []string `json:"scopes"`
+	TenantID  string   `json:"tenant_id"`
+	ExpiresAt string   `json:"expires_at"`
+}
+
+type apiKeyRecord struct {
+	APIKeyID int64
+	Hash     string
+	Scopes   []string
+	TenantID string
+	Active   bool
+}
+
+type RoleInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+type SetRoleRequestParams struct {
+	Name        string
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type UserRolesInfo struct {
+	UserID int64    `json:"user_id"`
+	Roles  []string `json:"roles"`
+}
+
+type SetUserRolesRequestParams struct {
+	UserID int
+	Roles  []string `json:"roles"`
+}
+
+// Identity is a subject of an identity provider, named by the issuer of its tokens.
+// Tokens of a linked identity act as the local user.
+type Identity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+type UserIdentitiesInfo struct {
+	UserID     int64       `json:"user_id"`
+	Identities []*Identity `json:"identities"`
+}
+
+type SetUserIdentitiesRequestParams struct {
+	UserID     int
+	Identities []*Identity `json:"identities"`
+}
+
+// TokenSettings configures tokens issued by password login. Access tokens are
+// HS256 JWTs signed with Secret, so they are accepted by the same verifier.
+type TokenSettings struct {
+	Secret     string
+	Issuer     string
+	Audience   string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	ResetTTL   time.Duration
+	BcryptCost int
+}
+
+type RegisterRequestParams struct {
+	UserName string `json:"username" validate:"required,max=100"`
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"min=8,max=72"`
+}
+
+type RegisteredUser struct {
+	UserID int64 `json:"user_id"`
+}
+
+type LoginRequestParams struct {
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type RefreshRequestParams struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type PasswordResetRequestParams struct {
+	Email string `json:"email" validate:"required"`
+}
+
+type PasswordResetConfirmRequestParams struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"min=8,max=72"`
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type credentialsRecord struct {
+	UserID int64
+	Hash   string
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	PermUsersRead     = "users:read"
+	PermUsersWrite    = "users:write"
+	PermCardsRead     = "cards:read"
+	PermCardsWrite    = "cards:write"
+	PermCardsBalance  = "cards:balance"
+	PermCardsTransfer = "cards:transfer"
+	PermKycRead       = "kyc:read"
+	PermKycReview     = "kyc:review"
+	PermAPIKeysManage = "api_keys:manage"
+	PermRolesManage   = "roles:manage"
+	PermAuditRead     = "audit:read"
+)
+
+// Permissions lists every permission checked by the services. Roles may only be
+// granted permissions from this list.
+var Permissions = map[string]string{
+	PermUsersRead:     "read any user",
+	PermUsersWrite:    "create, edit, delete, merge and erase any user",
+	PermCardsRead:     "read any card and its history",
+	PermCardsWrite:    "open cards for any user, delete and restore cards",
+	PermCardsBalance:  "set card balances and refill cards",
+	PermCardsTransfer: "transfer money from any card",
+	PermKycRead:       "read KYC documents of any user",
+	PermKycReview:     "approve and reject KYC documents",
+	PermAPIKeysManage: "create, list and revoke API keys",
+	PermRolesManage:   "manage roles and assign them to users",
+	PermAuditRead:     "read and verify the audit log",
+}
+
+const (
+	// RoleAdmin is granted every permission and can't be changed or deleted,
+	// so that access to role management can't be lost.
+	RoleAdmin = "admin"
+	// RoleCustomer is implicitly held by every end user. Without extra permissions
+	// a user still has access to their own data.
+	RoleCustomer = "customer"
+)
+
+var (
+	ErrUnauthenticated = errors.New("Request is not authenticated")
+	ErrForbidden       = errors.New("Not enough permissions")
+)
+
+// Authorize checks that the caller from ctx has permission.
+func Authorize(ctx context.Context, permission string) error {
+	p := PrincipalFromContext(ctx)
+	if p == nil {
+		return ErrUnauthenticated
+	}
+	if !p.Can(permission) {
+		return fmt.Errorf("%w: %s required", ErrForbidden, permission)
+	}
+	return nil
+}
+
+// AuthorizeOwner checks that the caller either has permission or is the user ownerID,
+// i.e. accesses their own data.
+func AuthorizeOwner(ctx context.Context, permission string, ownerID int64) error {
+	p := PrincipalFromContext(ctx)
+	if p == nil {
+		return ErrUnauthenticated
+	}
+	if !p.Can(permission) && !p.Owns(ownerID) {
+		return fmt.Errorf("%w: %s required", ErrForbidden, permission)
+	}
+	return nil
+}
+
+// AuthorizeOwners is AuthorizeOwner for data of several users at once.
+func AuthorizeOwners(ctx context.Context, permission string, ownerIDs []int64) error {
+	ownerID, err := OwnerScope(ctx, permission)
+	if err != nil || ownerID == 0 {
+		return err
+	}
+	for _, id := range ownerIDs {
+		if id != ownerID {
+			return fmt.Errorf("%w: %s required", ErrForbidden, permission)
+		}
+	}
+	return nil
+}
+
+// OwnerScope returns the user whose data the caller is restricted to when listing,
+// or 0 if the caller has permission to see everything.
+func OwnerScope(ctx context.Context, permission string) (int64, error) {
+	p := PrincipalFromContext(ctx)
+	if p == nil {
+		return 0, ErrUnauthenticated
+	}
+	if p.Can(permission) {
+		return 0, nil
+	}
+	if p.UserID == 0 {
+		return 0, fmt.Errorf("%w: %s required", ErrForbidden, permission)
+	}
+	return p.UserID, nil
+}
+
+// AuthorizeSelf checks that the caller is the end user userID. It guards actions no
+// permission can grant on behalf of someone else, like enrolling a second factor.
+func AuthorizeSelf(ctx context.Context, userID int64) error {
+	p := PrincipalFromContext(ctx)
+	if p == nil {
+		return ErrUnauthenticated
+	}
+	if !p.Owns(userID) {
+		return fmt.Errorf("%w: only the user themselves can do this", ErrForbidden)
+	}
+	return nil
+}
@@ -0,0 +1,691 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lenarsaitov/go-task/pkg/jwt"
+	"github.com/lenarsaitov/go-task/pkg/logging"
+	"github.com/lenarsaitov/go-task/pkg/notify"
+	"golang.org/x/crypto/bcrypt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingCredentials  = errors.New("Missing credentials: use X-API-Key header or Authorization: Bearer token")
+	ErrInvalidAPIKey       = errors.New("Invalid API key")
+	ErrJWTDisabled         = errors.New("Bearer tokens are not accepted: JWT verification is not configured")
+	ErrNameRequired        = errors.New("API key name is required")
+	ErrInvalidScope        = errors.New("Invalid scope: must consist of lowercase letters, digits and _ : . -")
+	ErrInvalidExpiresAt    = errors.New("Invalid expires_at: must be RFC 3339 time in the future")
+	ErrInvalidRoleName     = errors.New("Invalid role name: must consist of lowercase letters, digits and _ : . -")
+	ErrUnknownRole         = errors.New("Unknown role")
+	ErrUnknownPermission   = errors.New("Unknown permission")
+	ErrBuiltinRole         = errors.New("Built-in role cannot be changed")
+	ErrLoginDisabled       = errors.New("Password login is disabled: auth.jwt.secret is not configured")
+	ErrUserNameRequired    = errors.New("Username is required and must be at most 100 characters")
+	ErrInvalidEmail        = errors.New("Invalid email")
+	ErrWeakPassword        = errors.New("Password must be from 8 to 72 characters long")
+	ErrEmailTaken          = errors.New("User with this email already exists")
+	ErrInvalidCredentials  = errors.New("Invalid email or password")
+	ErrInvalidRefreshToken = errors.New("Invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("Refresh token was already used, all tokens of this session are revoked")
+	ErrInvalidResetToken   = errors.New("Invalid or expired password reset token")
+	ErrTokenRevoked        = errors.New("Token is revoked")
+	ErrUnknownUser         = errors.New("Token subject is not a known user")
+	ErrUnknownTenant       = errors.New("Unknown tenant")
+	ErrPlatformOnly        = errors.New("Only platform API keys can do this")
+	ErrInvalidIdentity     = errors.New("Invalid identity: issuer and subject are required and must be at most 255 characters")
+	ErrIdentityTaken       = errors.New("Identity is linked to another user")
+)
+
+var scopeRegexp = regexp.MustCompile(`^[a-z][a-z0-9_:.\-]*$`)
+
+var emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+type AuthService struct {
+	storage      *AuthStorage
+	verifier     *jwt.Verifier
+	adminKeyHash string
+	tokens       TokenSettings
+	notifier     notify.Notifier
+	dummyHash    []byte
+}
+
+// NewAuthService creates the service. verifier may be nil, then only API keys are accepted.
+// adminKey is a static key with admin scope, used to create the first stored API keys.
+// Password login is available when tokens.Secret is set.
+func NewAuthService(storage *AuthStorage, verifier *jwt.Verifier, adminKey string, tokens TokenSettings, notifier notify.Notifier) *AuthService {
+	service := &AuthService{storage: storage, verifier: verifier, tokens: tokens, notifier: notifier}
+	if len(adminKey) != 0 {
+		service.adminKeyHash = hashKey(adminKey)
+	}
+	if service.tokens.BcryptCost == 0 {
+		service.tokens.BcryptCost = bcrypt.DefaultCost
+	}
+	// compared against when the user is unknown, so that login takes the same time
+	service.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), service.tokens.BcryptCost)
+	return service
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitAPIKey extracts the public prefix of a key of form gtk_<prefix>_<secret>.
+func splitAPIKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, APIKeyPrefix), "_", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// Authenticate authenticates the credentials of a request: an API key, and the value
+// of the Authorization header. A bearer value with dots is verified as a JWT, a value
+// without them is an API key, X-API-Key wins over it.
+func (service *AuthService) Authenticate(c context.Context, apiKey string, authorization string) (*Principal, error) {
+	token := ""
+	if len(authorization) != 0 {
+		scheme, value := splitAuthorization(authorization)
+		if !strings.EqualFold(scheme, "Bearer") || len(value) == 0 {
+			return nil, fmt.Errorf("Unsupported authorization scheme %q", scheme)
+		}
+		if strings.Contains(value, ".") {
+			token = value
+		} else if len(apiKey) == 0 {
+			apiKey = value
+		}
+	}
+
+	switch {
+	case len(token) != 0:
+		return service.AuthenticateToken(c, token)
+	case len(apiKey) != 0:
+		return service.AuthenticateAPIKey(c, apiKey)
+	default:
+		return nil, ErrMissingCredentials
+	}
+}
+
+func (service *AuthService) AuthenticateAPIKey(c context.Context, key string) (*Principal, error) {
+	hash := hashKey(key)
+
+	if len(service.adminKeyHash) != 0 && subtle.ConstantTimeCompare([]byte(hash), []byte(service.adminKeyHash)) == 1 {
+		return &Principal{Kind: PrincipalAPIKey, Subject: "admin", Scopes: []string{RoleAdmin}}, nil
+	}
+
+	prefix, ok := splitAPIKey(key)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	r, err := service.storage.FindAPIKey(c, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil || !r.Active || subtle.ConstantTimeCompare([]byte(hash), []byte(r.Hash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if err := service.storage.TouchAPIKey(c, r.APIKeyID); err != nil {
+		logging.GetLogger().Errorf("failed to update last usage of api key %d: %s", r.APIKeyID, err)
+	}
+
+	return &Principal{
+		Kind:     PrincipalAPIKey,
+		Subject:  APIKeyPrefix + prefix,
+		APIKeyID: r.APIKeyID,
+		Scopes:   r.Scopes,
+		TenantID: r.TenantID,
+	}, nil
+}
+
+func (service *AuthService) AuthenticateToken(c context.Context, token string) (*Principal, error) {
+	if service.verifier == nil {
+		return nil, ErrJWTDisabled
+	}
+
+	claims, err := service.verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Principal{Kind: PrincipalUser, Subject: claims.Subject, Scopes: claims.Scopes()}
+	if claims.Own {
+		// tokens issued by the service name local users in their subjects
+		if userID, err := strconv.ParseInt(claims.Subject, 10, 64); err == nil {
+			p.UserID = userID
+		}
+	} else {
+		// subjects of identity providers act as the users they are linked to
+		userID, err := service.storage.FindIdentityUser(c, claims.Issuer, claims.Subject)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, ErrUnknownUser
+			}
+			return nil, err
+		}
+		p.UserID = userID
+		p.Subject = strconv.FormatInt(userID, 10)
+	}
+
+	// tokens of end users act on the tenant the user belongs to
+	if p.UserID != 0 {
+		tenantID, validAfter, err := service.storage.FindTokenOwner(c, p.UserID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, ErrUnknownUser
+			}
+			return nil, err
+		}
+		if validAfter != nil && claims.IssuedAt < validAfter.Unix() {
+			return nil, ErrTokenRevoked
+		}
+		p.TenantID = tenantID
+	}
+
+	return p, nil
+}
+
+// ResolvePermissions fills roles and permissions of p: API keys get roles named by
+// their scopes, end users get the customer role and roles assigned to them.
+func (service *AuthService) ResolvePermissions(c context.Context, p *Principal) error {
+	roles := p.Scopes
+	if p.Kind == PrincipalUser {
+		roles = []string{RoleCustomer}
+	}
+
+	var err error
+	p.Roles, p.Permissions, err = service.storage.ResolvePermissions(c, roles, p.UserID)
+	return err
+}
+
+func (service *AuthService) GetListAPIKeys(c context.Context, includeRevoked bool) ([]*APIKeyInfo, error) {
+	if err := Authorize(c, PermAPIKeysManage); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindManyAPIKeys(c, includeRevoked, callerTenant(c))
+}
+
+func (service *AuthService) GetAPIKey(c context.Context, apiKeyID int) (*APIKeyInfo, error) {
+	if err := Authorize(c, PermAPIKeysManage); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindAPIKeyItem(c, apiKeyID, callerTenant(c))
+}
+
+// AddAPIKey creates a key whose scopes name the roles it is granted. Granting roles
+// is role management, so keys with scopes also require roles:manage.
+// Keys created by a caller of a tenant belong to the same tenant, platform callers
+// create keys of any tenant or, without tenant_id, platform keys.
+func (service *AuthService) AddAPIKey(c context.Context, params *AddAPIKeyRequestParams) (*CreatedAPIKey, error) {
+	if err := Authorize(c, PermAPIKeysManage); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if len(name) == 0 || len(name) > 100 {
+		return nil, ErrNameRequired
+	}
+
+	scopes := make([]string, 0, len(params.Scopes))
+	for _, scope := range params.Scopes {
+		if !scopeRegexp.MatchString(scope) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) != 0 {
+		if err := Authorize(c, PermRolesManage); err != nil {
+			return nil, err
+		}
+		if err := service.checkRoles(c, scopes); err != nil {
+			return nil, err
+		}
+		if err := service.checkGrantable(c, scopes); err != nil {
+			return nil, err
+		}
+	}
+
+	tenantID := callerTenant(c)
+	if len(params.TenantID) != 0 {
+		if err := tenant.Validate(params.TenantID); err != nil {
+			return nil, err
+		}
+		if len(tenantID) != 0 && params.TenantID != tenantID {
+			return nil, tenant.ErrMismatch
+		}
+		tenantID = params.TenantID
+	}
+
+	var expiresAt *time.Time
+	if len(params.ExpiresAt) != 0 {
+		t, err := time.Parse(time.RFC3339, params.ExpiresAt)
+		if err != nil || !t.After(time.Now()) {
+			return nil, ErrInvalidExpiresAt
+		}
+		expiresAt = &t
+	}
+
+	prefix, err := randomHex(4)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	key := APIKeyPrefix + prefix + "_" + secret
+
+	info, err := service.storage.AddAPIKeyItem(c, name, prefix, hashKey(key), scopes, tenantID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreatedAPIKey{APIKeyInfo: info, Key: key}, nil
+}
+
+func (service *AuthService) RevokeAPIKey(c context.Context, apiKeyID int) (bool, error) {
+	if err := Authorize(c, PermAPIKeysManage); err != nil {
+		return false, err
+	}
+
+	err := service.storage.RevokeAPIKeyItem(c, apiKeyID, callerTenant(c))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// callerTenant returns the tenant the caller is pinned to, empty for platform callers.
+func callerTenant(c context.Context) string {
+	if p := PrincipalFromContext(c); p != nil {
+		return p.TenantID
+	}
+	return ""
+}
+
+// authorizePlatform allows roles shared by all tenants to be changed only by callers
+// which aren't pinned to a tenant.
+func authorizePlatform(c context.Context) error {
+	if len(callerTenant(c)) != 0 {
+		return fmt.Errorf("%w: %s", ErrForbidden, ErrPlatformOnly)
+	}
+	return nil
+}
+
+// checkGrantable refuses roles which callers pinned to a tenant can't grant. Roles are
+// shared by all tenants, so admin and roles with roles:manage are granted only by
+// platform callers.
+func (service *AuthService) checkGrantable(c context.Context, roles []string) error {
+	if len(callerTenant(c)) == 0 {
+		return nil
+	}
+
+	_, permissions, err := service.storage.ResolvePermissions(c, roles, 0)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if role == RoleAdmin {
+			return fmt.Errorf("%w: %s", ErrForbidden, ErrPlatformOnly)
+		}
+	}
+	for _, permission := range permissions {
+		if permission == PermRolesManage {
+			return fmt.Errorf("%w: %s", ErrForbidden, ErrPlatformOnly)
+		}
+	}
+	return nil
+}
+
+func (service *AuthService) checkRoles(c context.Context, roles []string) error {
+	unknown, err := service.storage.FindUnknownRoles(c, roles)
+	if err != nil {
+		return err
+	}
+	if len(unknown) != 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownRole, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func (service *AuthService) GetListRoles(c context.Context) ([]*RoleInfo, error) {
+	if err := Authorize(c, PermRolesManage); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindManyRoles(c)
+}
+
+func (service *AuthService) SetRole(c context.Context, params *SetRoleRequestParams) error {
+	if err := Authorize(c, PermRolesManage); err != nil {
+		return err
+	}
+	if err := authorizePlatform(c); err != nil {
+		return err
+	}
+	if !scopeRegexp.MatchString(params.Name) || len(params.Name) > 50 {
+		return ErrInvalidRoleName
+	}
+	if params.Name == RoleAdmin {
+		return ErrBuiltinRole
+	}
+	for _, permission := range params.Permissions {
+		if _, ok := Permissions[permission]; !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownPermission, permission)
+		}
+	}
+
+	return service.storage.SetRole(c, params)
+}
+
+func (service *AuthService) DeleteRole(c context.Context, name string) (bool, error) {
+	if err := Authorize(c, PermRolesManage); err != nil {
+		return false, err
+	}
+	if err := authorizePlatform(c); err != nil {
+		return false, err
+	}
+	if name == RoleAdmin || name == RoleCustomer {
+		return false, ErrBuiltinRole
+	}
+
+	err := service.storage.DeleteRole(c, name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (service *AuthService) GetUserRoles(c context.Context, userID int) (*UserRolesInfo, error) {
+	if err := AuthorizeOwner(c, PermRolesManage, int64(userID)); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindUserRoles(c, userID)
+}
+
+func (service *AuthService) SetUserRoles(c context.Context, params *SetUserRolesRequestParams) (bool, error) {
+	if err := Authorize(c, PermRolesManage); err != nil {
+		return false, err
+	}
+	if err := service.checkRoles(c, params.Roles); err != nil {
+		return false, err
+	}
+	if err := service.checkGrantable(c, params.Roles); err != nil {
+		return false, err
+	}
+
+	err := service.storage.SetUserRoles(c, params)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (service *AuthService) GetUserIdentities(c context.Context, userID int) (*UserIdentitiesInfo, error) {
+	if err := AuthorizeOwner(c, PermRolesManage, int64(userID)); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindUserIdentities(c, userID)
+}
+
+// SetUserIdentities replaces the identities linked to the user. A linked identity
+// acts as the user with all of their roles, so linking is role management.
+func (service *AuthService) SetUserIdentities(c context.Context, params *SetUserIdentitiesRequestParams) (bool, error) {
+	if err := Authorize(c, PermRolesManage); err != nil {
+		return false, err
+	}
+	for _, i := range params.Identities {
+		if i == nil {
+			return false, ErrInvalidIdentity
+		}
+		i.Issuer, i.Subject = strings.TrimSpace(i.Issuer), strings.TrimSpace(i.Subject)
+		if len(i.Issuer) == 0 || len(i.Issuer) > 255 || len(i.Subject) == 0 || len(i.Subject) > 255 {
+			return false, ErrInvalidIdentity
+		}
+	}
+
+	err := service.storage.SetUserIdentities(c, params)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) > 254 || !emailRegexp.MatchString(email) {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
+
+func (service *AuthService) hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), service.tokens.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (service *AuthService) Register(c context.Context, params *RegisterRequestParams) (*int64, error) {
+	userName := strings.TrimSpace(params.UserName)
+	if len(userName) == 0 || len(userName) > 100 {
+		return nil, ErrUserNameRequired
+	}
+	email, err := normalizeEmail(params.Email)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := service.hashPassword(params.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.storage.RegisterUser(c, userName, email, hash)
+}
+
+func (service *AuthService) Login(c context.Context, params *LoginRequestParams) (*TokenPair, error) {
+	if len(service.tokens.Secret) == 0 {
+		return nil, ErrLoginDisabled
+	}
+
+	r, err := service.storage.FindCredentials(c, strings.ToLower(strings.TrimSpace(params.Email)))
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		bcrypt.CompareHashAndPassword(service.dummyHash, []byte(params.Password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(r.Hash), []byte(params.Password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	familyID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken = RefreshTokenPrefix + refreshToken
+
+	err = service.storage.AddRefreshToken(c, r.UserID, familyID, hashKey(refreshToken), time.Now().Add(service.tokens.RefreshTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return service.tokenPair(r.UserID, refreshToken)
+}
+
+// Refresh rotates the refresh token: each token can be exchanged only once.
+func (service *AuthService) Refresh(c context.Context, params *RefreshRequestParams) (*TokenPair, error) {
+	if len(service.tokens.Secret) == 0 {
+		return nil, ErrLoginDisabled
+	}
+	if !strings.HasPrefix(params.RefreshToken, RefreshTokenPrefix) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	refreshToken, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken = RefreshTokenPrefix + refreshToken
+
+	userID, err := service.storage.RotateRefreshToken(c, hashKey(params.RefreshToken), hashKey(refreshToken), time.Now().Add(service.tokens.RefreshTTL))
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			logging.GetLogger().Warnf("refresh token reuse detected, session revoked")
+		}
+		return nil, err
+	}
+
+	return service.tokenPair(userID, refreshToken)
+}
+
+func (service *AuthService) tokenPair(userID int64, refreshToken string) (*TokenPair, error) {
+	jti, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := &jwt.Claims{
+		Subject:   strconv.FormatInt(userID, 10),
+		Issuer:    service.tokens.Issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(service.tokens.AccessTTL).Unix(),
+		ID:        jti,
+	}
+	if len(service.tokens.Audience) != 0 {
+		claims.Audience = jwt.Audience{service.tokens.Audience}
+	}
+
+	accessToken, err := jwt.SignHS256(claims, []byte(service.tokens.Secret))
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(service.tokens.AccessTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// Logout revokes the session of the refresh token. Access tokens of the session stay
+// valid until they expire, use LogoutAll to revoke them as well.
+func (service *AuthService) Logout(c context.Context, params *RefreshRequestParams) (bool, error) {
+	err := service.storage.RevokeRefreshFamily(c, hashKey(params.RefreshToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// LogoutAll revokes every refresh and access token of the calling user.
+func (service *AuthService) LogoutAll(c context.Context) error {
+	p := PrincipalFromContext(c)
+	if p == nil {
+		return ErrUnauthenticated
+	}
+	if p.Kind != PrincipalUser || p.UserID == 0 {
+		return fmt.Errorf("%w: only users can log out", ErrForbidden)
+	}
+
+	return service.storage.RevokeUserTokens(c, p.UserID)
+}
+
+// RequestPasswordReset sends a one-time reset token to the email. It reports no
+// error for unknown emails, so that it can't be used to find out registered ones.
+func (service *AuthService) RequestPasswordReset(c context.Context, params *PasswordResetRequestParams) error {
+	email, err := normalizeEmail(params.Email)
+	if err != nil {
+		return err
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return err
+	}
+
+	userID, err := service.storage.AddPasswordResetToken(c, email, hashKey(token), time.Now().Add(service.tokens.ResetTTL))
+	if err != nil || userID == nil {
+		return err
+	}
+
+	err = service.notifier.Send(c, &notify.Message{
+		To:      email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Use this token to set a new password via POST /auth/password-reset/confirm: %s\n"+
+			"It expires in %s. If you did not request a password reset, ignore this message.", token, service.tokens.ResetTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("Cannot send password reset token: %w", err)
+	}
+
+	return nil
+}
+
+func (service *AuthService) ConfirmPasswordReset(c context.Context, params *PasswordResetConfirmRequestParams) error {
+	hash, err := service.hashPassword(params.Password)
+	if err != nil {
+		return err
+	}
+
+	return service.storage.ResetPassword(c, hashKey(params.Token), hash)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
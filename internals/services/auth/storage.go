@@ -0,0 +1,868 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lib/pq"
+	"sync/atomic"
+	"time"
+)
+
+type AuthStorage struct {
+	db    atomic.Value
+	audit Auditor
+}
+
+// Auditor records changes to the audit log within the transaction which makes them,
+// see audit.Record. The audit package authorizes with this one, so it is passed in
+// instead of being imported.
+type Auditor interface {
+	Snapshot(ctx context.Context, tx *sql.Tx, query string, id interface{}) (string, error)
+	Record(ctx context.Context, tx *sql.Tx, action string, entityType string, entityID int64, before string, after string) error
+}
+
+// Actions and entity types of the audit log, as the audit package names them.
+const (
+	auditCreate       = "create"
+	auditUpdate       = "update"
+	auditRevoke       = "revoke"
+	auditReset        = "reset_password"
+	auditEntityUser   = "user"
+	auditEntityAPIKey = "api_key"
+)
+
+// Snapshots for the audit log leave out secrets and personal data.
+const (
+	apiKeySnapshotQuery = `SELECT to_jsonb(api_keys) - 'key_hash' FROM api_keys WHERE api_key_id = $1`
+
+	userSnapshotQuery = `SELECT to_jsonb(users) - ARRAY['user_full_name', 'first_name', 'last_name', 'middle_name',
+	'email', 'phone', 'birth_date'] FROM users WHERE user_id = $1`
+
+	// userAccessSnapshotQuery selects what the user is allowed to act as
+	userAccessSnapshotQuery = `SELECT jsonb_build_object('user_id', users.user_id,
+	'roles', COALESCE((SELECT jsonb_agg(role_name ORDER BY role_name) FROM user_roles WHERE user_roles.user_id = users.user_id), '[]'),
+	'identities', COALESCE((SELECT jsonb_agg(jsonb_build_object('issuer', issuer, 'subject', subject) ORDER BY issuer, subject)
+	   FROM user_identities WHERE user_identities.user_id = users.user_id), '[]'))
+	FROM users WHERE user_id = $1`
+)
+
+type QueryResult interface {
+	Scan(dest ...interface{}) error
+}
+
+var (
+	_ QueryResult = &sql.Rows{}
+	_ QueryResult = &sql.Row{}
+)
+
+const apiKeyColumns = `api_key_id, name, prefix, scopes, COALESCE(tenant_id, ''), create_time,
+	COALESCE(expires_at::text, ''), COALESCE(last_used_at::text, ''), COALESCE(revoked_at::text, '')`
+
+func NewAuthStorage(db *sqlx.DB, audit Auditor) *AuthStorage {
+	res := &AuthStorage{audit: audit}
+	res.db.Store(db)
+	return res
+}
+
+func (s *AuthStorage) auditChange(ctx context.Context, tx *sql.Tx, action string, entityType string, entityID int64, query string, before string) error {
+	after := ""
+	if len(query) != 0 {
+		var err error
+		after, err = s.audit.Snapshot(ctx, tx, query, entityID)
+		if err != nil {
+			return err
+		}
+	}
+	return s.audit.Record(ctx, tx, action, entityType, entityID, before, after)
+}
+
+func (s *AuthStorage) getDB() *sqlx.DB {
+	return s.db.Load().(*sqlx.DB)
+}
+
+func (s *AuthStorage) readAPIKeyInfo(r QueryResult) (*APIKeyInfo, error) {
+	k := &APIKeyInfo{}
+	err := r.Scan(&k.APIKeyID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.TenantID, &k.CreateTime, &k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// FindAPIKey looks a key up by its public prefix. Active is false for revoked and expired keys.
+func (s *AuthStorage) FindAPIKey(ctx context.Context, prefix string) (*apiKeyRecord, error) {
+	query := `SELECT api_key_id, key_hash, scopes, COALESCE(tenant_id, ''),
+	                 revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+	          FROM api_keys
+	          WHERE prefix = $1;`
+
+	row := s.getDB().QueryRowContext(ctx, query, prefix)
+
+	r := &apiKeyRecord{}
+	err := row.Scan(&r.APIKeyID, &r.Hash, pq.Array(&r.Scopes), &r.TenantID, &r.Active)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// TouchAPIKey records key usage at most once a minute to keep writes off the hot path.
+func (s *AuthStorage) TouchAPIKey(ctx context.Context, apiKeyID int64) error {
+	_, err := s.getDB().ExecContext(ctx,
+		`UPDATE api_keys SET last_used_at = now()
+		  WHERE api_key_id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute');`, apiKeyID)
+	return err
+}
+
+// FindAPIKeyItem returns the key if it belongs to tenantID. Methods on API keys take
+// the tenant of the caller, an empty tenantID means a platform caller and matches all keys.
+func (s *AuthStorage) FindAPIKeyItem(ctx context.Context, apiKeyID int, tenantID string) (*APIKeyInfo, error) {
+	query := `SELECT ` + apiKeyColumns + `
+	          FROM api_keys
+	          WHERE api_key_id = $1 AND ($2 = '' OR tenant_id = $2);`
+
+	row := s.getDB().QueryRowContext(ctx, query, apiKeyID, tenantID)
+
+	k, err := s.readAPIKeyInfo(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (s *AuthStorage) FindManyAPIKeys(ctx context.Context, includeRevoked bool, tenantID string) ([]*APIKeyInfo, error) {
+	query := `SELECT ` + apiKeyColumns + `
+	          FROM api_keys
+	          WHERE ($1 OR revoked_at IS NULL) AND ($2 = '' OR tenant_id = $2)
+	          ORDER BY (api_key_id);`
+
+	rows, err := s.getDB().QueryContext(ctx, query, includeRevoked, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]*APIKeyInfo, 0)
+	for rows.Next() {
+		k, err := s.readAPIKeyInfo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read api key info: %w", err)
+		}
+		items = append(items, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	return items, nil
+}
+
+// AddAPIKeyItem stores a key of tenantID, or a platform key if tenantID is empty.
+func (s *AuthStorage) AddAPIKeyItem(ctx context.Context, name string, prefix string, hash string, scopes []string, tenantID string, expiresAt *time.Time) (*APIKeyInfo, error) {
+	tx, err := s.getDB().BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	row := tx.QueryRowContext(ctx,
+		`INSERT INTO api_keys
+		              (name, prefix, key_hash, scopes, tenant_id, expires_at)
+		        VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+		        RETURNING `+apiKeyColumns+`;`,
+		name, prefix, hash, pq.Array(scopes), tenantID, expiresAt)
+
+	k, err := s.readAPIKeyInfo(row)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			err = ErrUnknownTenant
+		}
+		return nil, err
+	}
+
+	err = s.auditChange(ctx, tx, auditCreate, auditEntityAPIKey, k.APIKeyID, apiKeySnapshotQuery, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (s *AuthStorage) RevokeAPIKeyItem(ctx context.Context, apiKeyID int, tenantID string) error {
+	tx, err := s.getDB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	before, err := s.audit.Snapshot(ctx, tx, apiKeySnapshotQuery, apiKeyID)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = now()
+		  WHERE api_key_id = $1 AND revoked_at IS NULL AND ($2 = '' OR tenant_id = $2);`, apiKeyID, tenantID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		err = sql.ErrNoRows
+		return err
+	}
+
+	err = s.auditChange(ctx, tx, auditRevoke, auditEntityAPIKey, int64(apiKeyID), apiKeySnapshotQuery, before)
+	return err
+}
+
+// ResolvePermissions returns roles granted by name (API key scopes, the implicit
+// customer role) together with roles assigned to userID, and the union of their permissions.
+// Names which are not roles are ignored.
+func (s *AuthStorage) ResolvePermissions(ctx context.Context, roles []string, userID int64) ([]string, []string, error) {
+	query := `SELECT roles.role_name, COALESCE(role_permissions.permission, '')
+	          FROM roles LEFT JOIN role_permissions
+	          ON role_permissions.role_name = roles.role_name
+	          WHERE roles.role_name = ANY($1)
+	             OR roles.role_name IN (SELECT role_name FROM user_roles WHERE user_id = $2)
+	          ORDER BY roles.role_name, role_permissions.permission;`
+
+	rows, err := s.getDB().QueryContext(ctx, query, pq.Array(roles), userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cant query permissions: %w", err)
+	}
+	defer rows.Close()
+
+	resRoles := make([]string, 0)
+	resPermissions := make([]string, 0)
+	seen := map[string]bool{}
+	for rows.Next() {
+		var role, permission string
+		if err := rows.Scan(&role, &permission); err != nil {
+			return nil, nil, fmt.Errorf("Cannot read permission: %w", err)
+		}
+		if len(resRoles) == 0 || resRoles[len(resRoles)-1] != role {
+			resRoles = append(resRoles, role)
+		}
+		if len(permission) != 0 && !seen[permission] {
+			seen[permission] = true
+			resPermissions = append(resPermissions, permission)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	return resRoles, resPermissions, nil
+}
+
+func (s *AuthStorage) FindManyRoles(ctx context.Context) ([]*RoleInfo, error) {
+	query := `SELECT roles.role_name, COALESCE(roles.description, ''),
+	                 COALESCE(array_agg(role_permissions.permission ORDER BY role_permissions.permission)
+	                          FILTER (WHERE role_permissions.permission IS NOT NULL), '{}')
+	          FROM roles LEFT JOIN role_permissions
+	          ON role_permissions.role_name = roles.role_name
+	          GROUP BY roles.role_name
+	          ORDER BY (roles.role_name);`
+
+	rows, err := s.getDB().QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query roles: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]*RoleInfo, 0)
+	for rows.Next() {
+		r := &RoleInfo{}
+		if err := rows.Scan(&r.Name, &r.Description, pq.Array(&r.Permissions)); err != nil {
+			return nil, fmt.Errorf("Cannot read role info: %w", err)
+		}
+		items = append(items, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	return items, nil
+}
+
+// FindUnknownRoles returns those of names which are not defined roles.
+func (s *AuthStorage) FindUnknownRoles(ctx context.Context, names []string) ([]string, error) {
+	query := `SELECT name FROM unnest($1::text[]) AS name
+	          WHERE name NOT IN (SELECT role_name FROM roles);`
+
+	rows, err := s.getDB().QueryContext(ctx, query, pq.Array(names))
+	if err != nil {
+		return nil, fmt.Errorf("Cant query roles: %w", err)
+	}
+	defer rows.Close()
+
+	unknown := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("Cannot read role name: %w", err)
+		}
+		unknown = append(unknown, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	return unknown, nil
+}
+
+func (s *AuthStorage) SetRole(ctx context.Context, req *SetRoleRequestParams) error {
+	tx, err := s.getDB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO roles (role_name, description)
+		      VALUES ($1, NULLIF($2, ''))
+		 ON CONFLICT (role_name) DO UPDATE SET description = EXCLUDED.description;`,
+		req.Name, req.Description)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_name = $1;`, req.Name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO role_permissions (role_name, permission)
+		      SELECT DISTINCT $1::text, unnest($2::text[]);`,
+		req.Name, pq.Array(req.Permissions))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *AuthStorage) DeleteRole(ctx context.Context, name string) error {
+	res, err := s.getDB().ExecContext(ctx, `DELETE FROM roles WHERE role_name = $1;`, name)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *AuthStorage) FindUserRoles(ctx context.Context, userID int) (*UserRolesInfo, error) {
+	query := `SELECT users.user_id,
+	                 COALESCE(array_agg(user_roles.role_name ORDER BY user_roles.role_name)
+	                          FILTER (WHERE user_roles.role_name IS NOT NULL), '{}')
+	          FROM users LEFT JOIN user_roles
+	          ON user_roles.user_id = users.user_id
+	          WHERE users.user_id = $1 AND users.tenant_id = $2 AND users.deleted_at IS NULL
+	          GROUP BY users.user_id;`
+
+	row := s.getDB().QueryRowContext(ctx, query, userID, tenant.FromContext(ctx))
+
+	r := &UserRolesInfo{}
+	err := row.Scan(&r.UserID, pq.Array(&r.Roles))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (s *AuthStorage) SetUserRoles(ctx context.Context, req *SetUserRolesRequestParams) error {
+	tx, err := s.getDB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	userRow := tx.QueryRowContext(ctx, `SELECT user_id FROM users WHERE user_id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE;`,
+		req.UserID, tenant.FromContext(ctx))
+	var userID int64
+	err = userRow.Scan(&userID)
+	if err != nil {
+		return err
+	}
+
+	before, err := s.audit.Snapshot(ctx, tx, userAccessSnapshotQuery, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1;`, req.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_roles (user_id, role_name)
+		      SELECT DISTINCT $1::int, unnest($2::text[]);`,
+		req.UserID, pq.Array(req.Roles))
+	if err != nil {
+		return err
+	}
+
+	err = s.auditChange(ctx, tx, auditUpdate, auditEntityUser, userID, userAccessSnapshotQuery, before)
+	return err
+}
+
+// RegisterUser creates a user of the tenant of ctx together with their password.
+func (s *AuthStorage) RegisterUser(ctx context.Context, userName string, email string, passwordHash string) (*int64, error) {
+	tx, err := s.getDB().BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	row := tx.QueryRowContext(ctx,
+		`INSERT INTO users (user_full_name, email, tenant_id)
+		      VALUES ($1, $2, $3)
+		   RETURNING user_id;`, userName, email, tenant.FromContext(ctx))
+
+	var userID int64
+	err = row.Scan(&userID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			switch pqErr.Code {
+			case "23505":
+				err = ErrEmailTaken
+			case "23503":
+				err = ErrUnknownTenant
+			}
+		}
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_credentials (user_id, password_hash) VALUES ($1, $2);`, userID, passwordHash)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.auditChange(ctx, tx, auditCreate, auditEntityUser, userID, userSnapshotQuery, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &userID, nil
+}
+
+// FindCredentials returns the password hash of an active user of the tenant of ctx by
+// email, or nil if there is no such user or the user has no password.
+func (s *AuthStorage) FindCredentials(ctx context.Context, email string) (*credentialsRecord, error) {
+	query := `SELECT users.user_id, user_credentials.password_hash
+	          FROM users INNER JOIN user_credentials
+	          ON user_credentials.user_id = users.user_id
+	          WHERE users.email = $1 AND users.tenant_id = $2 AND users.deleted_at IS NULL AND users.erased_at IS NULL
+	            AND user_credentials.password_hash IS NOT NULL;`
+
+	row := s.getDB().QueryRowContext(ctx, query, email, tenant.FromContext(ctx))
+
+	r := &credentialsRecord{}
+	err := row.Scan(&r.UserID, &r.Hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// FindIdentityUser returns the user linked to the subject of the issuer. It returns
+// sql.ErrNoRows if the identity isn't linked.
+func (s *AuthStorage) FindIdentityUser(ctx context.Context, issuer string, subject string) (int64, error) {
+	var userID int64
+	err := s.getDB().QueryRowContext(ctx,
+		`SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2;`, issuer, subject).Scan(&userID)
+	return userID, err
+}
+
+func (s *AuthStorage) FindUserIdentities(ctx context.Context, userID int) (*UserIdentitiesInfo, error) {
+	var exists bool
+	err := s.getDB().QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1 AND tenant_id = $2 AND deleted_at IS NULL);`,
+		userID, tenant.FromContext(ctx)).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	rows, err := s.getDB().QueryContext(ctx,
+		`SELECT issuer, subject FROM user_identities WHERE user_id = $1 ORDER BY issuer, subject;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query identities: %w", err)
+	}
+	defer rows.Close()
+
+	r := &UserIdentitiesInfo{UserID: int64(userID), Identities: make([]*Identity, 0)}
+	for rows.Next() {
+		i := &Identity{}
+		if err := rows.Scan(&i.Issuer, &i.Subject); err != nil {
+			return nil, fmt.Errorf("Cannot read identity: %w", err)
+		}
+		r.Identities = append(r.Identities, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	return r, nil
+}
+
+// SetUserIdentities replaces the identities of the user. It returns sql.ErrNoRows
+// if there is no such user and ErrIdentityTaken if an identity is linked to
+// another user.
+func (s *AuthStorage) SetUserIdentities(ctx context.Context, req *SetUserIdentitiesRequestParams) error {
+	tx, err := s.getDB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	var userID int64
+	err = tx.QueryRowContext(ctx, `SELECT user_id FROM users WHERE user_id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE;`,
+		req.UserID, tenant.FromContext(ctx)).Scan(&userID)
+	if err != nil {
+		return err
+	}
+
+	before, err := s.audit.Snapshot(ctx, tx, userAccessSnapshotQuery, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_identities WHERE user_id = $1;`, userID)
+	if err != nil {
+		return err
+	}
+
+	issuers := make([]string, 0, len(req.Identities))
+	subjects := make([]string, 0, len(req.Identities))
+	for _, i := range req.Identities {
+		issuers = append(issuers, i.Issuer)
+		subjects = append(subjects, i.Subject)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_identities (issuer, subject, user_id)
+		      SELECT DISTINCT issuer, subject, $1::int FROM unnest($2::text[], $3::text[]) AS i (issuer, subject);`,
+		userID, pq.Array(issuers), pq.Array(subjects))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			err = ErrIdentityTaken
+		}
+		return err
+	}
+
+	err = s.auditChange(ctx, tx, auditUpdate, auditEntityUser, userID, userAccessSnapshotQuery, before)
+	return err
+}
+
+// FindTokenOwner returns the tenant of the user and the time before which tokens of
+// the user are revoked, if any. It returns sql.ErrNoRows if there is no such user or the
+// user is deleted or erased.
+func (s *AuthStorage) FindTokenOwner(ctx context.Context, userID int64) (string, *time.Time, error) {
+	row := s.getDB().QueryRowContext(ctx,
+		`SELECT users.tenant_id, user_credentials.tokens_valid_after
+		   FROM users LEFT JOIN user_credentials
+		   ON user_credentials.user_id = users.user_id
+		  WHERE users.user_id = $1 AND users.deleted_at IS NULL AND users.erased_at IS NULL;`, userID)
+
+	var tenantID string
+	var t sql.NullTime
+	err := row.Scan(&tenantID, &t)
+	if err != nil {
+		return "", nil, err
+	}
+	if !t.Valid {
+		return tenantID, nil, nil
+	}
+
+	return tenantID, &t.Time, nil
+}
+
+func (s *AuthStorage) AddRefreshToken(ctx context.Context, userID int64, familyID string, hash string, expiresAt time.Time) error {
+	_, err := s.getDB().ExecContext(ctx,
+		`INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		      VALUES ($1, $2, $3, $4);`, userID, familyID, hash, expiresAt)
+	return err
+}
+
+// RotateRefreshToken exchanges the token with hash for a new one of the same family.
+// Presenting a token which was already exchanged means it leaked, so the whole
+// family is revoked and ErrRefreshTokenReused is returned. The revocation has to be
+// committed, that's why the error is returned without being assigned to err.
+func (s *AuthStorage) RotateRefreshToken(ctx context.Context, hash string, newHash string, expiresAt time.Time) (int64, error) {
+	tx, err := s.getDB().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	tokenRow := tx.QueryRowContext(ctx,
+		`SELECT refresh_tokens.token_id, refresh_tokens.user_id, refresh_tokens.family_id,
+		        refresh_tokens.used_at IS NOT NULL, refresh_tokens.revoked_at IS NOT NULL,
+		        refresh_tokens.expires_at > now() AND users.deleted_at IS NULL AND users.erased_at IS NULL
+		   FROM refresh_tokens INNER JOIN users
+		   ON users.user_id = refresh_tokens.user_id
+		  WHERE refresh_tokens.token_hash = $1
+		    FOR UPDATE OF refresh_tokens;`, hash)
+
+	var tokenID, userID int64
+	var familyID string
+	var used, revoked, valid bool
+	err = tokenRow.Scan(&tokenID, &userID, &familyID, &used, &revoked, &valid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrInvalidRefreshToken
+		}
+		return 0, err
+	}
+
+	if used && !revoked {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL;`, familyID)
+		if err != nil {
+			return 0, err
+		}
+		return 0, ErrRefreshTokenReused
+	}
+	if used || revoked || !valid {
+		err = ErrInvalidRefreshToken
+		return 0, err
+	}
+
+	newRow := tx.QueryRowContext(ctx,
+		`INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		      VALUES ($1, $2, $3, $4)
+		   RETURNING token_id;`, userID, familyID, newHash, expiresAt)
+
+	var newTokenID int64
+	err = newRow.Scan(&newTokenID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET used_at = now(), replaced_by = $2 WHERE token_id = $1;`, tokenID, newTokenID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// RevokeRefreshFamily revokes the session the refresh token belongs to.
+func (s *AuthStorage) RevokeRefreshFamily(ctx context.Context, hash string) error {
+	res, err := s.getDB().ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = now()
+		  WHERE revoked_at IS NULL
+		    AND family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1);`, hash)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes all refresh tokens of the user and every access token
+// issued before now.
+func (s *AuthStorage) RevokeUserTokens(ctx context.Context, userID int64) error {
+	tx, err := s.getDB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	err = RevokeTokens(ctx, tx, userID)
+	return err
+}
+
+// RevokeTokens revokes refresh tokens of the user and access tokens issued before now
+// in tx, so that they are revoked together with the change that needs it.
+func RevokeTokens(ctx context.Context, tx *sql.Tx, userID int64) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL;`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_credentials (user_id, tokens_valid_after)
+		      VALUES ($1, date_trunc('second', now()))
+		 ON CONFLICT (user_id) DO UPDATE SET tokens_valid_after = EXCLUDED.tokens_valid_after;`, userID)
+	return err
+}
+
+// AddPasswordResetToken stores a reset token for the active user of the tenant of ctx with email.
+// It returns nil if there is no such user.
+func (s *AuthStorage) AddPasswordResetToken(ctx context.Context, email string, hash string, expiresAt time.Time) (*int64, error) {
+	row := s.getDB().QueryRowContext(ctx,
+		`INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		      SELECT user_id, $2, $3
+		        FROM users
+		       WHERE email = $1 AND tenant_id = $4 AND deleted_at IS NULL AND erased_at IS NULL
+		   RETURNING user_id;`, email, hash, expiresAt, tenant.FromContext(ctx))
+
+	var userID int64
+	err := row.Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &userID, nil
+}
+
+// ResetPassword sets a new password by a one-time reset token and signs the user
+// out everywhere, since the old password may be compromised.
+func (s *AuthStorage) ResetPassword(ctx context.Context, hash string, passwordHash string) error {
+	tx, err := s.getDB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	tokenRow := tx.QueryRowContext(ctx,
+		`SELECT password_reset_tokens.token_id, users.user_id, users.tenant_id
+		   FROM password_reset_tokens INNER JOIN users
+		   ON users.user_id = password_reset_tokens.user_id
+		  WHERE password_reset_tokens.token_hash = $1 AND password_reset_tokens.used_at IS NULL
+		    AND password_reset_tokens.expires_at > now()
+		    FOR UPDATE OF password_reset_tokens;`, hash)
+
+	var tokenID, userID int64
+	var tenantID string
+	err = tokenRow.Scan(&tokenID, &userID, &tenantID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrInvalidResetToken
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE password_reset_tokens SET used_at = now() WHERE token_id = $1;`, tokenID)
+	if err != nil {
+		return err
+	}
+
+	err = RevokeTokens(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE user_credentials SET password_hash = $2, update_time = now() WHERE user_id = $1;`, userID, passwordHash)
+	if err != nil {
+		return err
+	}
+
+	// the reset is recorded in the log of the user's tenant, whichever one the request named
+	err = s.auditChange(tenant.WithID(ctx, tenantID), tx, auditReset, auditEntityUser, userID, "", "")
+	return err
+}
@@ -2,10 +2,18 @@ package cards
 
 import (
 	"errors"
-	"fmt"
 	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
 	"github.com/lenarsaitov/go-task/pkg/bind"
+	"github.com/lenarsaitov/go-task/pkg/etag"
+	"github.com/lenarsaitov/go-task/pkg/export"
+	"github.com/lenarsaitov/go-task/pkg/patch"
+	"github.com/lenarsaitov/go-task/pkg/problem"
+	"github.com/lenarsaitov/go-task/pkg/response"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"github.com/lenarsaitov/go-task/pkg/validate"
 	"net/http"
+	"path"
 	"strconv"
 )
 
@@ -17,16 +25,6 @@ func NewCardHandler(service *CardService) *CardHandler {
 	return &CardHandler{service}
 }
 
-type StatusResponse string
-
-var OK StatusResponse = "OK"
-var Error StatusResponse = "Error"
-
-type Response struct {
-	Status  StatusResponse `json:"status"`
-	Message string         `json:"message,omitempty"`
-}
-
 var INDENT = "  "
 
 func (h *CardHandler) Setup(root *echo.Group) {
@@ -34,40 +32,70 @@ func (h *CardHandler) Setup(root *echo.Group) {
 
 	g.GET("", h.ListCards)
 	g.GET("/:id", h.CardItem)
+	g.GET("/:id/history", h.CardHistory)
 
 	g.POST("", h.AddCardItem)
 
 	g.PUT("/:id", h.UpdateCardItem)
+	g.PATCH("/:id", h.PatchCardItem)
 	g.DELETE("/:id", h.DeleteCardItem)
+	g.POST("/:id/restore", h.RestoreCardItem)
 
 	g.POST("/transfer", h.TransferAmount)
+	g.GET("/transfers/:id", h.PendingTransferItem)
+	g.POST("/transfers/:id/confirm", h.ConfirmTransfer)
+	g.DELETE("/transfers/:id", h.CancelTransfer)
 	g.POST("/:id", h.RefillBalance)
 }
 
+// SetupExport registers the streaming export, which outlasts the write timeout of
+// the other routes and may be served by a server of its own.
+func (h *CardHandler) SetupExport(root *echo.Group) {
+	root.GET("/cards/export", h.ExportCards)
+}
+
 func (h *CardHandler) CardItem(c echo.Context) error {
 	cardID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		return h.HandleError(c, http.StatusBadRequest, err)
 	}
 
-	p, err := h.service.GetCard(c.Request().Context(), cardID)
+	var includeDeleted bool
+	if includeDeletedStr := c.FormValue("include_deleted"); len(includeDeletedStr) != 0 {
+		includeDeleted, err = strconv.ParseBool(includeDeletedStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+
+	p, err := h.service.GetCard(c.Request().Context(), cardID, includeDeleted)
 	if err != nil {
-		return h.HandleError(c, http.StatusInternalServerError, err)
+		return h.HandleError(c, serviceErrorStatus(err), err)
 	}
 	if p == nil {
-		return c.JSONPretty(http.StatusNotFound, Response{Status: OK, Message: "Not Found"}, INDENT)
+		return problem.NotFound(c, "Not Found")
+	}
+
+	if ok, err := etag.NotModified(c, p.ETag()); ok {
+		return err
 	}
 
 	return c.JSONPretty(http.StatusOK, p, INDENT)
 }
 
 func (h *CardHandler) ListCards(c echo.Context) error {
-	var sizeInt, pageInt, userIDInt int
-	var err error
+	var sizeInt, pageInt, limitInt int
+	var withCount bool
 
 	pageStr := c.FormValue("page")
 	sizeStr := c.FormValue("size")
-	userID := c.FormValue("user_id")
+	limitStr := c.FormValue("limit")
+	countStr := c.FormValue("count")
+
+	params, err := filterParams(c)
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
 
 	if len(pageStr) != 0 {
 		pageInt, err = strconv.Atoi(pageStr)
@@ -81,18 +109,177 @@ func (h *CardHandler) ListCards(c echo.Context) error {
 			return h.HandleError(c, http.StatusBadRequest, err)
 		}
 	}
+	if len(limitStr) != 0 {
+		limitInt, err = strconv.Atoi(limitStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+	if len(countStr) != 0 {
+		withCount, err = strconv.ParseBool(countStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+
+	params.Page = pageInt
+	params.Size = sizeInt
+	params.After = c.FormValue("after")
+	params.Limit = limitInt
+	params.WithCount = withCount
 
-	if len(userID) != 0 {
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+	p, err := h.service.GetListCards(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+// ExportCards streams all cards matching the filters of ListCards as NDJSON or CSV.
+func (h *CardHandler) ExportCards(c echo.Context) error {
+	params, err := filterParams(c)
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	w, err := export.NewWriter(c, c.FormValue("format"), "cards", ExportColumns)
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	err = w.Close(h.service.ExportCards(c.Request().Context(), params, func(card *CardInfo) error {
+		return w.Write(card)
+	}))
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	return nil
+}
+
+// filterParams reads the filters shared by ListCards and ExportCards.
+func filterParams(c echo.Context) (*FilterParams, error) {
+	var userIDInt int
+	var includeDeleted bool
+	var err error
+
+	if userID := c.FormValue("user_id"); len(userID) != 0 {
 		userIDInt, err = strconv.Atoi(userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if includeDeletedStr := c.FormValue("include_deleted"); len(includeDeletedStr) != 0 {
+		includeDeleted, err = strconv.ParseBool(includeDeletedStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	createdFrom, err := bind.ParseTimeFrom("created_from", c.FormValue("created_from"))
+	if err != nil {
+		return nil, err
+	}
+	createdTo, err := bind.ParseTimeTo("created_to", c.FormValue("created_to"))
+	if err != nil {
+		return nil, err
+	}
+	balanceMin, err := bind.ParseOptionalInt64("balance_min", c.FormValue("balance_min"))
+	if err != nil {
+		return nil, err
+	}
+	balanceMax, err := bind.ParseOptionalInt64("balance_max", c.FormValue("balance_max"))
+	if err != nil {
+		return nil, err
+	}
+	sort, err := sqlbuilder.ParseSort(c.FormValue("sort"), SortableFields)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FilterParams{
+		UserID:         userIDInt,
+		CreatedFrom:    createdFrom,
+		CreatedTo:      createdTo,
+		BalanceMin:     balanceMin,
+		BalanceMax:     balanceMax,
+		Sort:           sort,
+		IncludeDeleted: includeDeleted,
+	}, nil
+}
+
+func (h *CardHandler) CardHistory(c echo.Context) error {
+	var sizeInt, pageInt, limitInt int
+	var withCount, includeDeleted bool
+
+	cardID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	pageStr := c.FormValue("page")
+	sizeStr := c.FormValue("size")
+	limitStr := c.FormValue("limit")
+	countStr := c.FormValue("count")
+	after := c.FormValue("after")
+	includeDeletedStr := c.FormValue("include_deleted")
+
+	if len(pageStr) != 0 {
+		pageInt, err = strconv.Atoi(pageStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+	if len(sizeStr) != 0 {
+		sizeInt, err = strconv.Atoi(sizeStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+	if len(limitStr) != 0 {
+		limitInt, err = strconv.Atoi(limitStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+	if len(countStr) != 0 {
+		withCount, err = strconv.ParseBool(countStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+	if len(includeDeletedStr) != 0 {
+		includeDeleted, err = strconv.ParseBool(includeDeletedStr)
 		if err != nil {
 			return h.HandleError(c, http.StatusBadRequest, err)
 		}
 	}
 
-	params := &FilterParams{UserID: userIDInt, Page: pageInt, Size: sizeInt}
-	p, err := h.service.GetListCards(c.Request().Context(), params)
+	params := &HistoryFilterParams{
+		CardID:         cardID,
+		IncludeDeleted: includeDeleted,
+		Page:           pageInt,
+		Size:           sizeInt,
+		After:          after,
+		Limit:          limitInt,
+		WithCount:      withCount,
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+	p, err := h.service.GetCardHistory(c.Request().Context(), params)
 	if err != nil {
-		return h.HandleError(c, http.StatusInternalServerError, err)
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "Not Found")
 	}
 
 	return c.JSONPretty(http.StatusOK, p, INDENT)
@@ -109,15 +296,19 @@ func (h *CardHandler) AddCardItem(c echo.Context) error {
 		return h.HandleError(c, http.StatusInternalServerError, err)
 	}
 
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
 	p, err := h.service.AddCard(c.Request().Context(), params)
 	if err != nil {
-		return h.HandleError(c, http.StatusInternalServerError, err)
+		return h.HandleError(c, serviceErrorStatus(err), err)
 	}
 	if p == nil {
-		return c.JSON(http.StatusNotFound, Response{Status: Error, Message: "User Not Found"})
+		return problem.NotFound(c, "User Not Found")
 	}
 
-	return h.HandleSuccess(c, http.StatusOK, fmt.Sprintf("Successfully added. Card ID: %d", *p))
+	return response.Created(c, response.Location(c.Request().URL.Path, int64(p.CardID)), p)
 }
 
 func (h *CardHandler) UpdateCardItem(c echo.Context) error {
@@ -126,7 +317,12 @@ func (h *CardHandler) UpdateCardItem(c echo.Context) error {
 		return h.HandleError(c, http.StatusBadRequest, err)
 	}
 
-	params := &UpdateCardRequestParams{CardID: cardID}
+	ifMatch, err := etag.IfMatch(c)
+	if err != nil {
+		return h.HandleError(c, http.StatusPreconditionRequired, err)
+	}
+
+	params := &UpdateCardRequestParams{CardID: cardID, IfMatch: ifMatch}
 	err = bind.DecodeJSONBody(c, params)
 	if err != nil {
 		var mr *bind.MalformedRequest
@@ -136,14 +332,52 @@ func (h *CardHandler) UpdateCardItem(c echo.Context) error {
 		return h.HandleError(c, http.StatusInternalServerError, err)
 	}
 
-	p, err := h.service.UpdateCard(c.Request().Context(), params)
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	tag, err := h.service.UpdateCard(c.Request().Context(), params)
 	if err != nil {
-		return h.HandleError(c, http.StatusInternalServerError, err)
+		return h.HandleError(c, serviceErrorStatus(err), err)
 	}
-	if p == false {
-		return c.JSON(http.StatusNotFound, Response{Status: Error, Message: "Not Found"})
+	if len(tag) == 0 {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	c.Response().Header().Set(etag.HeaderETag, tag)
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *CardHandler) PatchCardItem(c echo.Context) error {
+	cardID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	body, err := bind.ReadBody(c)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	params := &PatchCardRequestParams{
+		CardID:      cardID,
+		IfMatch:     c.Request().Header.Get(etag.HeaderIfMatch),
+		ContentType: patch.ContentType(c.Request()),
+		Patch:       body,
+	}
+	tag, err := h.service.PatchCard(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if len(tag) == 0 {
+		return problem.NotFound(c, "Not Found")
 	}
 
+	c.Response().Header().Set(etag.HeaderETag, tag)
 	return h.HandleSuccess(c, http.StatusOK, "")
 }
 
@@ -155,10 +389,10 @@ func (h *CardHandler) DeleteCardItem(c echo.Context) error {
 
 	p, err := h.service.DeleteCard(c.Request().Context(), cardID)
 	if err != nil {
-		return h.HandleError(c, http.StatusInternalServerError, err)
+		return h.HandleError(c, serviceErrorStatus(err), err)
 	}
 	if p == false {
-		return c.JSON(http.StatusNotFound, Response{Status: Error, Message: "Not Found"})
+		return problem.NotFound(c, "Not Found")
 	}
 
 	return h.HandleSuccess(c, http.StatusOK, "")
@@ -180,16 +414,16 @@ func (h *CardHandler) RefillBalance(c echo.Context) error {
 		return h.HandleError(c, http.StatusInternalServerError, err)
 	}
 
-	if params.AddBalance < 0 {
-		return h.HandleError(c, http.StatusBadRequest, errors.New("Can add balance only. Not subtract"))
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
 	}
 
 	p, err := h.service.RefillCard(c.Request().Context(), params)
 	if err != nil {
-		return h.HandleError(c, http.StatusInternalServerError, err)
+		return h.HandleError(c, serviceErrorStatus(err), err)
 	}
 	if p == false {
-		return c.JSON(http.StatusNotFound, Response{Status: Error, Message: "Not Found"})
+		return problem.NotFound(c, "Not Found")
 	}
 
 	return h.HandleSuccess(c, http.StatusOK, "")
@@ -206,30 +440,168 @@ func (h *CardHandler) TransferAmount(c echo.Context) error {
 		return h.HandleError(c, http.StatusInternalServerError, err)
 	}
 
-	switch {
-	case params.AddBalance < 0:
-		return h.HandleError(c, http.StatusBadRequest, errors.New("Can add balance only. Not subtract"))
-	case params.CardTo < 0:
-		return h.HandleError(c, http.StatusBadRequest, errors.New("Invalid value of cardID to"))
-	case params.CardFrom < 0:
-		return h.HandleError(c, http.StatusBadRequest, errors.New("Invalid value of cardID from"))
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
 	}
 
-	exist, err := h.service.TransferBalanceCard(c.Request().Context(), params)
+	pending, exist, err := h.service.TransferBalanceCard(c.Request().Context(), params)
 	if err != nil {
-		return h.HandleError(c, http.StatusInternalServerError, err)
+		return h.HandleError(c, serviceErrorStatus(err), err)
 	}
 	if exist == false {
-		return c.JSON(http.StatusNotFound, Response{Status: Error, Message: "Not Found"})
+		return problem.NotFound(c, "Not Found")
+	}
+	if pending != nil {
+		c.Response().Header().Set(echo.HeaderLocation, response.Location(path.Join(path.Dir(c.Request().URL.Path), "transfers"), pending.TransferID))
+		return c.JSONPretty(http.StatusAccepted, pending, INDENT)
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *CardHandler) PendingTransferItem(c echo.Context) error {
+	transferID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.GetPendingTransfer(c.Request().Context(), transferID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *CardHandler) ConfirmTransfer(c echo.Context) error {
+	transferID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	params := &ConfirmTransferRequestParams{TransferID: transferID}
+	err = bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.ConfirmTransfer(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *CardHandler) CancelTransfer(c echo.Context) error {
+	transferID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.CancelTransfer(c.Request().Context(), transferID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "Pending Transfer Not Found")
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *CardHandler) RestoreCardItem(c echo.Context) error {
+	cardID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.RestoreCard(c.Request().Context(), cardID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "Deleted Card Not Found")
 	}
 
 	return h.HandleSuccess(c, http.StatusOK, "")
 }
 
 func (h *CardHandler) HandleSuccess(c echo.Context, statusCode int, Message string) error {
-	return c.JSONPretty(statusCode, Response{Status: OK, Message: Message}, INDENT)
+	return response.Success(c, statusCode, Message)
 }
 
 func (h *CardHandler) HandleError(c echo.Context, statusCode int, err error) error {
-	return c.JSONPretty(statusCode, Response{Status: Error, Message: fmt.Sprintf("%s", err)}, INDENT)
+	return problem.Error(c, statusCode, err, errorCodes)
+}
+
+func serviceErrorStatus(err error) int {
+	var verr validate.Errors
+	var mr *bind.MalformedRequest
+	switch {
+	case errors.As(err, &mr):
+		return mr.Status
+	case errors.As(err, &verr),
+		errors.Is(err, sqlbuilder.ErrInvalidCursor),
+		errors.Is(err, patch.ErrInvalid),
+		errors.Is(err, ErrSameCard):
+		return http.StatusBadRequest
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrKycLimit),
+		errors.Is(err, auth.ErrForbidden),
+		errors.Is(err, ErrTwoFactorRequired),
+		errors.Is(err, ErrInvalidCode),
+		errors.Is(err, ErrTwoFactorLocked):
+		return http.StatusForbidden
+	case errors.Is(err, ErrOwnerDeleted),
+		errors.Is(err, ErrInsufficientFunds),
+		errors.Is(err, ErrTransferNotPending),
+		errors.Is(err, patch.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, patch.ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, etag.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorCodes are stable codes of the service errors returned in problem details.
+var errorCodes = problem.Codes{
+	sqlbuilder.ErrInvalidCursor:  "invalid_cursor",
+	auth.ErrUnauthenticated:      "unauthenticated",
+	auth.ErrForbidden:            "forbidden",
+	ErrKycLimit:                  "kyc_limit_exceeded",
+	ErrOwnerDeleted:              "owner_deleted",
+	ErrInsufficientFunds:         "insufficient_funds",
+	ErrTwoFactorRequired:         "two_factor_required",
+	ErrInvalidCode:               "invalid_two_factor_code",
+	ErrTwoFactorLocked:           "two_factor_locked",
+	ErrTransferNotPending:        "transfer_not_pending",
+	ErrUserNotFound:              "user_not_found",
+	ErrSameCard:                  "same_card_transfer",
+	etag.ErrPreconditionRequired: "precondition_required",
+	etag.ErrPreconditionFailed:   "precondition_failed",
+	patch.ErrUnsupportedType:     "unsupported_patch_type",
+	patch.ErrInvalid:             "invalid_patch",
+	patch.ErrConflict:            "patch_conflict",
+	export.ErrUnsupportedFormat:  "unsupported_export_format",
 }
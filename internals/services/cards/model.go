@@ -1,11 +1,76 @@
 package cards
 
+import (
+	"github.com/lenarsaitov/go-task/pkg/etag"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"strconv"
+	"time"
+)
+
+const DefaultCursorLimit = 20
+
+const (
+	OperationOpen        = "open"
+	OperationAdjustment  = "adjustment"
+	OperationRefill      = "refill"
+	OperationTransferIn  = "transfer_in"
+	OperationTransferOut = "transfer_out"
+)
+
+const (
+	TransferPending   = "pending"
+	TransferConfirmed = "confirmed"
+	TransferCancelled = "cancelled"
+	// TransferExpired is never stored, pending transfers are reported as expired
+	// once their expires_at passes.
+	TransferExpired = "expired"
+)
+
+var SortableFields = map[string]string{
+	"card_id":        "cards.card_id",
+	"balance":        "cards.balance",
+	"user_id":        "cards.user_id",
+	"user_full_name": "users.user_full_name",
+	"create_time":    "cards.create_time",
+}
+
 type CardInfo struct {
 	CardID     int    `json:"card_id"`
 	Balance    int    `json:"balance"`
 	UserID     int    `json:"user_id"`
 	UserName   string `json:"user_full_name"`
 	CreateTime string `json:"create_time"`
+	DeletedAt  string `json:"deleted_at,omitempty"`
+	// HeldBalance is reserved by transfers awaiting confirmation and can't be spent.
+	HeldBalance int `json:"held_balance"`
+	// Version and UserVersion are incremented by the database on every update of
+	// the card and its owner.
+	Version     int64 `json:"-"`
+	UserVersion int64 `json:"-"`
+}
+
+// ETag identifies the representation for conditional requests. It changes with the
+// card, the name of the owner and the held balance, which changes as holds expire.
+func (c *CardInfo) ETag() string {
+	return cardTag(c.Version, c.UserVersion, c.HeldBalance)
+}
+
+// ExportColumns are the columns of CSV exports, in the order of CardInfo.CSV.
+var ExportColumns = []string{"card_id", "balance", "user_id", "user_full_name", "create_time", "deleted_at", "held_balance"}
+
+// CSV returns the values of ExportColumns.
+func (c *CardInfo) CSV() []string {
+	return []string{strconv.Itoa(c.CardID), strconv.Itoa(c.Balance), strconv.Itoa(c.UserID), c.UserName,
+		c.CreateTime, c.DeletedAt, strconv.Itoa(c.HeldBalance)}
+}
+
+// Editable returns the editable fields of the card.
+func (c *CardInfo) Editable() *EditableCard {
+	return &EditableCard{Balance: c.Balance}
+}
+
+func cardTag(version int64, userVersion int64, held int) string {
+	return etag.Tag(version, userVersion, int64(held))
 }
 
 type UserInfo struct {
@@ -14,18 +79,69 @@ type UserInfo struct {
 	CreateTime string `json:"create_time"`
 }
 
+type HistoryInfo struct {
+	HistoryID          int64  `json:"history_id"`
+	CardID             int    `json:"card_id"`
+	Operation          string `json:"operation"`
+	Amount             int    `json:"amount"`
+	BalanceAfter       int    `json:"balance_after"`
+	CounterpartyCardID *int   `json:"counterparty_card_id,omitempty"`
+	CreateTime         string `json:"create_time"`
+}
+
 type Pagination struct {
 	Page       int         `json:"page,omitempty"`
 	Size       int         `json:"size,omitempty"`
-	PagesCount int         `json:"pagesCount"`
-	ItemsCount int         `json:"itemsCount"`
+	Limit      int         `json:"limit,omitempty"`
+	PagesCount *int        `json:"pagesCount,omitempty"`
+	ItemsCount *int        `json:"itemsCount,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
 	Items      []*CardInfo `json:"items"`
 }
 
+type HistoryPagination struct {
+	Page       int            `json:"page,omitempty"`
+	Size       int            `json:"size,omitempty"`
+	Limit      int            `json:"limit,omitempty"`
+	PagesCount *int           `json:"pagesCount,omitempty"`
+	ItemsCount *int           `json:"itemsCount,omitempty"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	Items      []*HistoryInfo `json:"items"`
+}
+
 type FilterParams struct {
-	UserID int
-	Page   int `validate:"gte=1"`
-	Size   int `validate:"gte=1,lte=50"`
+	UserID         int
+	CreatedFrom    time.Time
+	CreatedTo      time.Time
+	BalanceMin     *int64
+	BalanceMax     *int64
+	Sort           []sqlbuilder.SortField
+	IncludeDeleted bool
+	Page           int `query:"page" validate:"omitempty,gte=1"`
+	Size           int `query:"size" validate:"omitempty,gte=1,lte=50"`
+	After          string
+	Limit          int `query:"limit" validate:"omitempty,gte=1,lte=50"`
+	WithCount      bool
+}
+
+// CursorMode reports whether keyset pagination (after/limit) is requested
+// instead of page/size.
+func (f *FilterParams) CursorMode() bool {
+	return len(f.After) != 0 || f.Limit > 0
+}
+
+type HistoryFilterParams struct {
+	CardID         int
+	IncludeDeleted bool
+	Page           int `query:"page" validate:"omitempty,gte=1"`
+	Size           int `query:"size" validate:"omitempty,gte=1,lte=50"`
+	After          string
+	Limit          int `query:"limit" validate:"omitempty,gte=1,lte=50"`
+	WithCount      bool
+}
+
+func (f *HistoryFilterParams) CursorMode() bool {
+	return len(f.After) != 0 || f.Limit > 0
 }
 
 type AddCardRequestParams struct {
@@ -33,18 +149,64 @@ type AddCardRequestParams struct {
 	Balance int
 }
 
+// EditableCard holds the editable fields of a card, the document PATCH applies to.
+type EditableCard struct {
+	Balance int `json:"balance"`
+}
+
 type UpdateCardRequestParams struct {
-	CardID  int
-	Balance int
+	CardID int
+	// IfMatch is the If-Match header, the update is refused unless it matches the ETag.
+	// Empty updates unconditionally, handlers require the header.
+	IfMatch string `json:"-"`
+	EditableCard
+}
+
+// PatchCardRequestParams holds a merge patch or a JSON Patch of EditableCard.
+// IfMatch is optional, the patch is applied to the current card anyway.
+type PatchCardRequestParams struct {
+	CardID      int
+	IfMatch     string
+	ContentType string
+	Patch       []byte
 }
 
 type RefillCardRequestParams struct {
 	CardID     int
-	AddBalance int
+	AddBalance int `validate:"gte=0"`
 }
 
 type TransferBalanceCardRequestParams struct {
-	CardFrom   int
-	CardTo     int
-	AddBalance int
+	CardFrom   int `validate:"gte=0"`
+	CardTo     int `validate:"gte=0"`
+	AddBalance int `validate:"gte=0"`
+}
+
+// StepUpSettings configures confirmation of high-value transfers with a TOTP code.
+// Transfers of more than Threshold are held until the user confirms them; 0 disables it.
+// After MaxAttempts wrong codes in a row the user can't start or confirm transfers
+// for Lockout.
+type StepUpSettings struct {
+	Threshold   int
+	TTL         time.Duration
+	MaxAttempts int
+	Lockout     time.Duration
+}
+
+type PendingTransferInfo struct {
+	TransferID  int64  `json:"transfer_id"`
+	CardFrom    int    `json:"card_from"`
+	CardTo      int    `json:"card_to"`
+	Amount      int    `json:"amount"`
+	UserID      int64  `json:"user_id"`
+	Status      string `json:"status"`
+	Attempts    int    `json:"attempts"`
+	CreateTime  string `json:"create_time"`
+	ExpiresAt   string `json:"expires_at"`
+	ConfirmedAt string `json:"confirmed_at,omitempty"`
+}
+
+type ConfirmTransferRequestParams struct {
+	TransferID int64
+	Code       string `json:"code" validate:"required"`
 }
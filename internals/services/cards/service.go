@@ -1,50 +1,203 @@
 package cards
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/services/imports"
+	"github.com/lenarsaitov/go-task/internals/services/kyc"
+	"github.com/lenarsaitov/go-task/pkg/bind"
+	"github.com/lenarsaitov/go-task/pkg/etag"
+	"github.com/lenarsaitov/go-task/pkg/patch"
+	"github.com/lenarsaitov/go-task/pkg/totp"
+	"github.com/lenarsaitov/go-task/pkg/validate"
+	"strconv"
+	"time"
 )
 
+var (
+	ErrKycLimit           = errors.New("Operation exceeds limits of user's KYC level")
+	ErrOwnerDeleted       = errors.New("Cant restore card, because its owner is deleted")
+	ErrInsufficientFunds  = errors.New("Dont exist essential balance")
+	ErrTwoFactorRequired  = errors.New("Transfer requires confirmation with a two-factor code, enroll it at /users/:id/2fa")
+	ErrInvalidCode        = errors.New("Invalid two-factor code")
+	ErrTwoFactorLocked    = errors.New("Too many invalid two-factor codes, try again later")
+	ErrTransferNotPending = errors.New("Transfer is not awaiting confirmation")
+	ErrUserNotFound       = errors.New("User Not Found")
+	ErrSameCard           = errors.New("Cant transfer to the same card")
+)
+
+// totpSkew is the number of time steps before and after the current one in which
+// codes are accepted, to tolerate clock drift of the user's device.
+const totpSkew = 1
+
 type CardService struct {
 	storage *CardStorage
+	limits  kyc.LimitsTable
+	stepUp  StepUpSettings
 }
 
-func NewCardService(storage *CardStorage) *CardService {
-	return &CardService{storage: storage}
+func NewCardService(storage *CardStorage, limits kyc.LimitsTable, stepUp StepUpSettings) *CardService {
+	return &CardService{storage: storage, limits: limits, stepUp: stepUp}
 }
 
-func (service *CardService) GetCard(c context.Context, cardID int) (*CardInfo, error) {
-	return service.storage.FindOne(c, cardID)
+func (service *CardService) GetCard(c context.Context, cardID int, includeDeleted bool) (*CardInfo, error) {
+	card, err := service.storage.FindOne(c, cardID, includeDeleted)
+	if err != nil || card == nil {
+		return nil, err
+	}
+	if err := auth.AuthorizeOwner(c, auth.PermCardsRead, int64(card.UserID)); err != nil {
+		return nil, err
+	}
+
+	return card, nil
 }
 
+// GetListCards lists all cards for callers with cards:read, other callers only get their own cards.
 func (service *CardService) GetListCards(c context.Context, params *FilterParams) (*Pagination, error) {
+	ownerID, err := auth.OwnerScope(c, auth.PermCardsRead)
+	if err != nil {
+		return nil, err
+	}
+	if ownerID != 0 {
+		if params.UserID != 0 && int64(params.UserID) != ownerID {
+			return nil, fmt.Errorf("%w: %s required to list cards of other users", auth.ErrForbidden, auth.PermCardsRead)
+		}
+		params.UserID = int(ownerID)
+	}
+
 	return service.storage.FindMany(c, params)
 }
 
-func (service *CardService) AddCard(c context.Context, params *AddCardRequestParams) (*int64, error) {
-	isExist, err := service.storage.isExistUser(c, params.UserID)
+// GetUsersCards returns the cards of the users, keyed by user id.
+func (service *CardService) GetUsersCards(c context.Context, userIDs []int64) (map[int64][]*CardInfo, error) {
+	if err := auth.AuthorizeOwners(c, auth.PermCardsRead, userIDs); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindByUsers(c, userIDs)
+}
+
+// GetRecentTransfers returns at most limit latest transfers to and from the cards of
+// every user, keyed by user id.
+func (service *CardService) GetRecentTransfers(c context.Context, userIDs []int64, limit int) (map[int64][]*HistoryInfo, error) {
+	if err := auth.AuthorizeOwners(c, auth.PermCardsRead, userIDs); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindRecentTransfers(c, userIDs, limit)
+}
+
+// ExportCards passes the cards matching the filter to fn one by one, in the scope of
+// GetListCards. Paging parameters are ignored.
+func (service *CardService) ExportCards(c context.Context, params *FilterParams, fn func(*CardInfo) error) error {
+	ownerID, err := auth.OwnerScope(c, auth.PermCardsRead)
 	if err != nil {
+		return err
+	}
+	if ownerID != 0 {
+		if params.UserID != 0 && int64(params.UserID) != ownerID {
+			return fmt.Errorf("%w: %s required to export cards of other users", auth.ErrForbidden, auth.PermCardsRead)
+		}
+		params.UserID = int(ownerID)
+	}
+
+	return service.storage.ExportMany(c, params, fn)
+}
+
+func (service *CardService) GetCardHistory(c context.Context, params *HistoryFilterParams) (*HistoryPagination, error) {
+	card, err := service.storage.FindOne(c, params.CardID, params.IncludeDeleted)
+	if err != nil || card == nil {
 		return nil, err
 	}
-	if !isExist {
+	if err := auth.AuthorizeOwner(c, auth.PermCardsRead, int64(card.UserID)); err != nil {
 		return nil, err
 	}
 
-	return service.storage.AddCardItem(c, params)
+	return service.storage.FindHistory(c, params)
 }
 
-func (service *CardService) UpdateCard(c context.Context, params *UpdateCardRequestParams) (bool, error) {
-	err := service.storage.UpdateCardItem(c, params)
+// AddCard opens a card. Users may open their own cards, but only with zero balance
+// unless they can edit balances.
+func (service *CardService) AddCard(c context.Context, params *AddCardRequestParams) (*CardInfo, error) {
+	if err := auth.AuthorizeOwner(c, auth.PermCardsWrite, int64(params.UserID)); err != nil {
+		return nil, err
+	}
+	if params.Balance != 0 {
+		if err := auth.Authorize(c, auth.PermCardsBalance); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := service.storage.AddCardItem(c, params, service.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindOne(c, int(id), false)
+}
+
+// UpdateCard returns the new ETag of the card, empty if the card is not found.
+func (service *CardService) UpdateCard(c context.Context, params *UpdateCardRequestParams) (string, error) {
+	if err := auth.Authorize(c, auth.PermCardsBalance); err != nil {
+		return "", err
+	}
+
+	tag, err := service.storage.UpdateCardItem(c, params)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return false, nil
+			return "", nil
 		}
-		return false, err
+		return "", err
 	}
-	return true, nil
+	return tag, nil
+}
+
+// PatchCard applies the patch to the current card and updates it with the result
+// like UpdateCard, conditionally on the ETag the patch was applied to.
+func (service *CardService) PatchCard(c context.Context, params *PatchCardRequestParams) (string, error) {
+	if err := auth.Authorize(c, auth.PermCardsBalance); err != nil {
+		return "", err
+	}
+
+	card, err := service.storage.FindOne(c, params.CardID, false)
+	if err != nil || card == nil {
+		return "", err
+	}
+	if len(params.IfMatch) != 0 && !etag.StrongMatch(params.IfMatch, card.ETag()) {
+		return "", etag.ErrPreconditionFailed
+	}
+
+	doc, err := json.Marshal(card.Editable())
+	if err != nil {
+		return "", err
+	}
+	doc, err = patch.Apply(params.ContentType, doc, params.Patch)
+	if err != nil {
+		return "", err
+	}
+
+	editable := &EditableCard{}
+	if err = bind.DecodeJSON(bytes.NewReader(doc), editable); err != nil {
+		return "", err
+	}
+	update := &UpdateCardRequestParams{CardID: params.CardID, IfMatch: card.ETag(), EditableCard: *editable}
+	if err = validate.Struct(update); err != nil {
+		return "", err
+	}
+
+	return service.UpdateCard(c, update)
 }
 
 func (service *CardService) DeleteCard(c context.Context, cardID int) (bool, error) {
+	if err := auth.Authorize(c, auth.PermCardsWrite); err != nil {
+		return false, err
+	}
+
 	err := service.storage.DeleteCardItem(c, cardID)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -56,6 +209,10 @@ func (service *CardService) DeleteCard(c context.Context, cardID int) (bool, err
 }
 
 func (service *CardService) RefillCard(c context.Context, refillParams *RefillCardRequestParams) (bool, error) {
+	if err := auth.Authorize(c, auth.PermCardsBalance); err != nil {
+		return false, err
+	}
+
 	err := service.storage.RefillCard(c, refillParams)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -66,8 +223,120 @@ func (service *CardService) RefillCard(c context.Context, refillParams *RefillCa
 	return true, nil
 }
 
-func (service *CardService) TransferBalanceCard(c context.Context, params *TransferBalanceCardRequestParams) (bool, error) {
-	err := service.storage.TransferBalanceCard(c, params)
+// TransferBalanceCard moves money from a card of the caller, or from any card with cards:transfer.
+// Transfers of users above the step-up threshold are not executed: the amount is held
+// and the returned pending transfer has to be confirmed with ConfirmTransfer.
+// API keys are not subject to step-up, there is no user to confirm with a code.
+func (service *CardService) TransferBalanceCard(c context.Context, params *TransferBalanceCardRequestParams) (*PendingTransferInfo, bool, error) {
+	if params.CardFrom == params.CardTo {
+		return nil, false, ErrSameCard
+	}
+
+	ownerID, level, err := service.storage.cardOwner(c, params.CardFrom)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if err := auth.AuthorizeOwner(c, auth.PermCardsTransfer, ownerID); err != nil {
+		return nil, false, err
+	}
+
+	if limit := service.limits.For(level).MaxTransfer; limit > 0 && params.AddBalance > limit {
+		return nil, false, fmt.Errorf("%w: %s level allows transfers up to %d", ErrKycLimit, level, limit)
+	}
+
+	if p := auth.PrincipalFromContext(c); p.Kind == auth.PrincipalUser &&
+		service.stepUp.Threshold > 0 && params.AddBalance > service.stepUp.Threshold {
+		t, err := service.storage.AddPendingTransfer(c, p.UserID, params, time.Now().Add(service.stepUp.TTL))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, false, nil
+			}
+			return nil, false, err
+		}
+		return t, true, nil
+	}
+
+	err = service.storage.TransferBalanceCard(c, params)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return nil, true, nil
+}
+
+func (service *CardService) GetPendingTransfer(c context.Context, transferID int64) (*PendingTransferInfo, error) {
+	t, err := service.storage.FindPendingTransfer(c, transferID)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	if err := auth.AuthorizeOwner(c, auth.PermCardsRead, t.UserID); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// ConfirmTransfer executes a held transfer once the user who started it enters a valid
+// TOTP code. Wrong codes are counted per user, not per transfer, so that starting new
+// transfers doesn't give more guesses: after too many of them the transfer is cancelled
+// and the user is locked out of step-up for a while.
+func (service *CardService) ConfirmTransfer(c context.Context, params *ConfirmTransferRequestParams) (*PendingTransferInfo, error) {
+	t, err := service.storage.FindPendingTransfer(c, params.TransferID)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	if err := auth.AuthorizeSelf(c, t.UserID); err != nil {
+		return nil, err
+	}
+	if t.Status != TransferPending {
+		return nil, fmt.Errorf("%w: transfer is %s", ErrTransferNotPending, t.Status)
+	}
+
+	secret, lastStep, locked, err := service.storage.findTwoFactorSecret(c, t.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTwoFactorRequired
+		}
+		return nil, err
+	}
+	if locked {
+		return nil, ErrTwoFactorLocked
+	}
+
+	step, ok := totp.Validate(secret, params.Code, time.Now(), totpSkew)
+	if !ok || (lastStep != nil && step <= *lastStep) {
+		if err := service.storage.FailPendingTransfer(c, t.TransferID, t.UserID, service.stepUp.MaxAttempts, service.stepUp.Lockout); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCode
+	}
+
+	t, err = service.storage.ConfirmPendingTransfer(c, t.TransferID, step)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// CancelTransfer releases the hold of a pending transfer.
+func (service *CardService) CancelTransfer(c context.Context, transferID int64) (bool, error) {
+	t, err := service.storage.FindPendingTransfer(c, transferID)
+	if err != nil || t == nil {
+		return false, err
+	}
+	if err := auth.AuthorizeOwner(c, auth.PermCardsTransfer, t.UserID); err != nil {
+		return false, err
+	}
+
+	err = service.storage.CancelPendingTransfer(c, transferID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
@@ -76,3 +345,101 @@ func (service *CardService) TransferBalanceCard(c context.Context, params *Trans
 	}
 	return true, nil
 }
+
+func (service *CardService) RestoreCard(c context.Context, cardID int) (bool, error) {
+	if err := auth.Authorize(c, auth.PermCardsWrite); err != nil {
+		return false, err
+	}
+
+	err := service.storage.RestoreCardItem(c, cardID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (service *CardService) PurgeDeleted(c context.Context, before time.Time) (int64, error) {
+	return service.storage.PurgeDeleted(c, before)
+}
+
+// importColumns are the columns of card imports.
+var importColumns = []string{"user_id", "balance"}
+
+// Authorize implements imports.Importer.
+func (service *CardService) Authorize(c context.Context) error {
+	return auth.Authorize(c, auth.PermCardsWrite)
+}
+
+// Columns implements imports.Importer.
+func (service *CardService) Columns() []string {
+	return importColumns
+}
+
+// Import implements imports.Importer. Cards are opened like by AddCard: the owner
+// must exist, cards with a balance require cards:balance and the cards of the file
+// count towards the KYC limit of the owner.
+func (service *CardService) Import(c context.Context, importID int64, rows []*imports.Row, errs []*imports.RowError) (*imports.Result, error) {
+	res := &imports.Result{Errors: errs}
+	total := len(rows) + len(errs)
+	rowError := func(row *imports.Row, field string, msg string) {
+		res.Errors = append(res.Errors, &imports.RowError{Row: row.Number, Field: field, Message: msg})
+	}
+	canBalance := auth.PrincipalFromContext(c).Can(auth.PermCardsBalance)
+
+	valid := make([]*imports.Row, 0, len(rows))
+	params := make(map[*imports.Row]*AddCardRequestParams, len(rows))
+	var userIDs []int64
+	for _, row := range rows {
+		userID, err := strconv.Atoi(row.Fields["user_id"])
+		if err != nil || userID <= 0 {
+			rowError(row, "user_id", "must be a positive integer")
+			continue
+		}
+
+		var balance int
+		if value := row.Fields["balance"]; len(value) != 0 {
+			balance, err = strconv.Atoi(value)
+			if err != nil || balance < 0 {
+				rowError(row, "balance", "must be a non-negative integer")
+				continue
+			}
+		}
+		if balance != 0 && !canBalance {
+			rowError(row, "balance", fmt.Sprintf("%s required to open cards with a balance", auth.PermCardsBalance))
+			continue
+		}
+
+		valid = append(valid, row)
+		params[row] = &AddCardRequestParams{UserID: userID, Balance: balance}
+		userIDs = append(userIDs, int64(userID))
+	}
+
+	owners, err := service.storage.FindImportOwners(c, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*AddCardRequestParams, 0, len(valid))
+	for _, row := range valid {
+		p := params[row]
+		owner, ok := owners[p.UserID]
+		if !ok {
+			rowError(row, "user_id", "user not found")
+			continue
+		}
+		if limit := service.limits.For(owner.Level).MaxCards; limit > 0 && owner.Cards >= limit {
+			rowError(row, "user_id", fmt.Sprintf("%s level allows at most %d cards", owner.Level, limit))
+			continue
+		}
+		owner.Cards++
+		items = append(items, p)
+	}
+
+	if err = service.storage.ImportCards(c, importID, total, items, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
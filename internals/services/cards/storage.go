@@ -3,14 +3,43 @@ package cards
 import (
 	"context"
 	"database/sql"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/lenarsaitov/go-task/internals/services/audit"
+	"github.com/lenarsaitov/go-task/internals/services/imports"
+	"github.com/lenarsaitov/go-task/internals/services/kyc"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lenarsaitov/go-task/pkg/etag"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"github.com/lib/pq"
 	"math"
 	"strings"
 	"sync/atomic"
+	"time"
 )
 
+const cardColumns = `cards.card_id, users.user_id, users.user_full_name, cards.balance, cards.create_time,
+	COALESCE(cards.deleted_at::text, ''), ` + heldBalanceExpr + `, cards.version, users.version`
+
+const heldBalanceExpr = `(SELECT COALESCE(SUM(pending_transfers.amount), 0) FROM pending_transfers
+	WHERE pending_transfers.card_from = cards.card_id AND pending_transfers.status = 'pending' AND pending_transfers.expires_at > now())`
+
+const pendingTransferColumns = `transfer_id, card_from, card_to, amount, user_id,
+	CASE WHEN status = 'pending' AND expires_at <= now() THEN 'expired' ELSE status END,
+	attempts, create_time, expires_at, COALESCE(confirmed_at::text, '')`
+
+const historyColumns = `history_id, card_id, operation, amount, balance_after, counterparty_card_id, create_time`
+
+// Snapshots of entities kept in the audit log, see audit.Snapshot.
+const (
+	cardSnapshotQuery            = `SELECT to_jsonb(cards) FROM cards WHERE card_id = $1`
+	pendingTransferSnapshotQuery = `SELECT to_jsonb(pending_transfers) FROM pending_transfers WHERE transfer_id = $1`
+)
+
+// exportBatchSize is the number of rows fetched at once by ExportMany.
+const exportBatchSize = 1000
+
 type CardStorage struct {
 	db atomic.Value
 }
@@ -34,13 +63,18 @@ func (s *CardStorage) getDB() *sqlx.DB {
 	return s.db.Load().(*sqlx.DB)
 }
 
-func (s *CardStorage) FindOne(ctx context.Context, cardID int) (*CardInfo, error) {
-	query := `SELECT cards.card_id, users.user_id, users.user_full_name, cards.balance, cards.create_time
+// querier returns the connection bound to the tenant of the request, see tenant.DB.
+func (s *CardStorage) querier(ctx context.Context) tenant.Querier {
+	return tenant.DB(ctx, s.getDB())
+}
+
+func (s *CardStorage) FindOne(ctx context.Context, cardID int, includeDeleted bool) (*CardInfo, error) {
+	query := `SELECT ` + cardColumns + `
 	          FROM cards INNER JOIN users
 			  ON 	cards.user_id = users.user_id
-	          WHERE cards.card_id = $1;`
+	          WHERE cards.card_id = $1 AND ($2 OR cards.deleted_at IS NULL) AND cards.tenant_id = $3;`
 
-	row := s.getDB().QueryRowContext(ctx, query, cardID)
+	row := s.querier(ctx).QueryRowContext(ctx, query, cardID, includeDeleted, tenant.FromContext(ctx))
 
 	m, err := s.readCardInfo(row)
 	if err != nil {
@@ -53,34 +87,118 @@ func (s *CardStorage) FindOne(ctx context.Context, cardID int) (*CardInfo, error
 	return m, nil
 }
 
-func (s *CardStorage) readCardInfo(r QueryResult) (*CardInfo, error) {
+func (s *CardStorage) readCardInfo(r QueryResult, extra ...interface{}) (*CardInfo, error) {
 	cardInfo := &CardInfo{}
-	err := r.Scan(&cardInfo.CardID, &cardInfo.UserID, &cardInfo.UserName, &cardInfo.Balance, &cardInfo.CreateTime)
+	dest := []interface{}{&cardInfo.CardID, &cardInfo.UserID, &cardInfo.UserName, &cardInfo.Balance, &cardInfo.CreateTime, &cardInfo.DeletedAt, &cardInfo.HeldBalance,
+		&cardInfo.Version, &cardInfo.UserVersion}
+	err := r.Scan(append(dest, extra...)...)
 	if err != nil {
 		return nil, err
 	}
 	return cardInfo, nil
 }
 
-func (s *CardStorage) buildFindManyWhereClause(filter *FilterParams, pos int) (clause string, args []interface{}) {
-	var predicates []string
+func (s *CardStorage) buildFindManyWhereClause(b *sqlbuilder.Builder, filter *FilterParams) {
+	if !filter.IncludeDeleted {
+		b.Where("cards.deleted_at IS NULL")
+	}
 
 	if filter.UserID != 0 {
-		predicates = append(predicates,
-			fmt.Sprintf("user_id = $%d", pos))
-		args = append(args, filter.UserID)
-		pos++
+		b.Where("cards.user_id = ?", filter.UserID)
+	}
+
+	if !filter.CreatedFrom.IsZero() {
+		b.Where("cards.create_time >= ?", filter.CreatedFrom)
+	}
+
+	if !filter.CreatedTo.IsZero() {
+		b.Where("cards.create_time < ?", filter.CreatedTo)
+	}
+
+	if filter.BalanceMin != nil {
+		b.Where("cards.balance >= ?", *filter.BalanceMin)
+	}
+
+	if filter.BalanceMax != nil {
+		b.Where("cards.balance <= ?", *filter.BalanceMax)
+	}
+}
+
+// ExportMany reads the cards matching the filter through a server-side cursor in
+// batches of exportBatchSize, so that memory use doesn't grow with the result. The
+// transaction is read only and repeatable read, rows are read from one snapshot.
+func (s *CardStorage) ExportMany(ctx context.Context, filter *FilterParams, fn func(*CardInfo) error) error {
+	b := sqlbuilder.New()
+	b.Where("cards.tenant_id = ?", tenant.FromContext(ctx))
+	s.buildFindManyWhereClause(b, filter)
+	b.Sort(filter.Sort)
+	b.OrderBy("cards.card_id", false)
+
+	tx, err := s.querier(ctx).BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DECLARE cards_export NO SCROLL CURSOR FOR
+	          SELECT `+cardColumns+`
+	          FROM cards INNER JOIN users
+	          ON cards.user_id = users.user_id
+	          `+b.WhereClause()+`
+	          `+b.OrderClause()+`;`, b.Args()...)
+	if err != nil {
+		return fmt.Errorf("Cant query cards: %w", err)
+	}
+
+	for {
+		n, err := s.fetchExport(ctx, tx, fn)
+		if err != nil {
+			return err
+		}
+		if n < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+func (s *CardStorage) fetchExport(ctx context.Context, tx *sql.Tx, fn func(*CardInfo) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`FETCH FORWARD %d FROM cards_export;`, exportBatchSize))
+	if err != nil {
+		return 0, fmt.Errorf("Cant query cards: %w", err)
 	}
+	defer rows.Close()
 
-	clause = strings.Join(predicates, " and ")
-	if len(clause) > 0 {
-		clause = "where " + clause
+	n := 0
+	for rows.Next() {
+		cardInfo, err := s.readCardInfo(rows)
+		if err != nil {
+			return 0, fmt.Errorf("Cannot read card info: %w", err)
+		}
+		if err := fn(cardInfo); err != nil {
+			return 0, err
+		}
+		n++
 	}
 
-	return
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("Query error: %w", err)
+	}
+	return n, nil
 }
 
 func (s *CardStorage) FindMany(ctx context.Context, filter *FilterParams) (*Pagination, error) {
+	b := sqlbuilder.New()
+	b.Where("cards.tenant_id = ?", tenant.FromContext(ctx))
+	s.buildFindManyWhereClause(b, filter)
+	countWhereClause, countArgs := b.WhereClause(), b.Args()
+
+	b.Sort(filter.Sort)
+	b.OrderBy("cards.card_id", false)
+
+	if filter.CursorMode() {
+		return s.findManyAfter(ctx, b, filter, countWhereClause, countArgs)
+	}
+
 	limit := filter.Size
 	if limit <= 0 {
 		limit = math.MaxInt32
@@ -91,19 +209,14 @@ func (s *CardStorage) FindMany(ctx context.Context, filter *FilterParams) (*Pagi
 		offset = (filter.Page - 1) * filter.Size
 	}
 
-	paginationArgs := []interface{}{limit, offset}
-
-	whereClause, whereArgs := s.buildFindManyWhereClause(filter, 3)
-
-	template := `SELECT cards.card_id, users.user_id, users.user_full_name, cards.balance, cards.create_time
-	             FROM cards INNER JOIN users 
-				 ON cards.user_id = users.user_id
-				 %s
-	             ORDER BY (cards.card_id)
-	             LIMIT $1 OFFSET $2;`
+	query := `SELECT ` + cardColumns + `
+	          FROM cards INNER JOIN users
+	          ON cards.user_id = users.user_id
+	          ` + b.WhereClause() + `
+	          ` + b.OrderClause() + `
+	          LIMIT ` + b.Arg(limit) + ` OFFSET ` + b.Arg(offset) + `;`
 
-	query := fmt.Sprintf(template, whereClause)
-	rows, err := s.getDB().QueryContext(ctx, query, append(paginationArgs, whereArgs...)...)
+	rows, err := s.querier(ctx).QueryContext(ctx, query, b.Args()...)
 	if err != nil {
 		return nil, fmt.Errorf("Cant query cards: %w", err)
 	}
@@ -122,12 +235,9 @@ func (s *CardStorage) FindMany(ctx context.Context, filter *FilterParams) (*Pagi
 		return nil, fmt.Errorf("Query error: %w", err)
 	}
 
-	countWhereClause, countWhereArgs := s.buildFindManyWhereClause(filter, 1)
-	countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM cards %s;`, countWhereClause)
-	row := s.getDB().QueryRowContext(ctx, countQuery, countWhereArgs...)
-	var count int
-	if err := row.Scan(&count); err != nil {
-		return nil, fmt.Errorf("Items count query error: %w", err)
+	count, err := s.count(ctx, `cards`, countWhereClause, countArgs)
+	if err != nil {
+		return nil, err
 	}
 
 	pc := count / limit
@@ -138,58 +248,331 @@ func (s *CardStorage) FindMany(ctx context.Context, filter *FilterParams) (*Pagi
 	return &Pagination{
 		Page:       filter.Page,
 		Size:       filter.Size,
-		PagesCount: pc,
-		ItemsCount: count,
+		PagesCount: &pc,
+		ItemsCount: &count,
 		Items:      items,
 	}, nil
 }
 
-func (s *CardStorage) readUserInfo(r QueryResult) (*UserInfo, error) {
-	deviceInfo := &UserInfo{}
-	err := r.Scan(&deviceInfo.UserID, &deviceInfo.UserName, &deviceInfo.CreateTime)
+// findManyAfter reads one page in keyset mode: the cursor holds ORDER BY values of
+// the last row of the previous page, so rows inserted meanwhile don't shift pages.
+func (s *CardStorage) findManyAfter(ctx context.Context, b *sqlbuilder.Builder, filter *FilterParams,
+	countWhereClause string, countArgs []interface{}) (*Pagination, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultCursorLimit
+	}
+
+	if len(filter.After) != 0 {
+		values, err := sqlbuilder.DecodeCursor(filter.After)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.After(values); err != nil {
+			return nil, err
+		}
+	}
+
+	orderColumns := b.OrderColumns()
+	query := `SELECT ` + cardColumns + `, ` + strings.Join(orderColumns, ", ") + `
+	          FROM cards INNER JOIN users
+	          ON cards.user_id = users.user_id
+	          ` + b.WhereClause() + `
+	          ` + b.OrderClause() + `
+	          LIMIT ` + b.Arg(limit+1) + `;`
+
+	rows, err := s.querier(ctx).QueryContext(ctx, query, b.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query cards: %w", err)
+	}
+	defer rows.Close()
+
+	values, dests := sqlbuilder.CursorValues(len(orderColumns))
+	var last []interface{}
+	hasMore := false
+
+	items := make([]*CardInfo, 0, limit)
+	for rows.Next() {
+		if len(items) == limit {
+			hasMore = true
+			break
+		}
+		cardInfo, err := s.readCardInfo(rows, dests...)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read card info: %w", err)
+		}
+		items = append(items, cardInfo)
+		last = append(last[:0], values...)
+	}
+
+	// closed before the count query, which may run on the same connection
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	res := &Pagination{Limit: limit, Items: items}
+
+	if hasMore {
+		res.NextCursor, err = sqlbuilder.EncodeCursor(last)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if filter.WithCount {
+		count, err := s.count(ctx, `cards`, countWhereClause, countArgs)
+		if err != nil {
+			return nil, err
+		}
+		res.ItemsCount = &count
+	}
+
+	return res, nil
+}
+
+func (s *CardStorage) count(ctx context.Context, table string, whereClause string, args []interface{}) (int, error) {
+	countQuery := `SELECT COUNT(*) FROM ` + table + ` ` + whereClause + `;`
+	row := s.querier(ctx).QueryRowContext(ctx, countQuery, args...)
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return 0, fmt.Errorf("Items count query error: %w", err)
+	}
+	return count, nil
+}
+
+func (s *CardStorage) readHistoryInfo(r QueryResult, extra ...interface{}) (*HistoryInfo, error) {
+	h := &HistoryInfo{}
+	dest := []interface{}{&h.HistoryID, &h.CardID, &h.Operation, &h.Amount, &h.BalanceAfter, &h.CounterpartyCardID, &h.CreateTime}
+	err := r.Scan(append(dest, extra...)...)
 	if err != nil {
 		return nil, err
 	}
-	return deviceInfo, nil
+	return h, nil
 }
 
-func (s *CardStorage) isExistUser(ctx context.Context, id int) (bool, error) {
-	query := `SELECT user_id, user_full_name, create_time
-	          FROM users
-	          WHERE user_id = $1;`
+func (s *CardStorage) FindHistory(ctx context.Context, filter *HistoryFilterParams) (*HistoryPagination, error) {
+	b := sqlbuilder.New()
+	b.Where("card_id = ?", filter.CardID)
+	countWhereClause, countArgs := b.WhereClause(), b.Args()
+
+	b.OrderBy("history_id", true)
+
+	var limit, offset int
+	if filter.CursorMode() {
+		limit = filter.Limit
+		if limit <= 0 {
+			limit = DefaultCursorLimit
+		}
+		if len(filter.After) != 0 {
+			values, err := sqlbuilder.DecodeCursor(filter.After)
+			if err != nil {
+				return nil, err
+			}
+			if err := b.After(values); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		limit = filter.Size
+		if limit <= 0 {
+			limit = math.MaxInt32
+		}
+		if filter.Page > 0 {
+			offset = (filter.Page - 1) * filter.Size
+		}
+	}
 
-	row := s.getDB().QueryRowContext(ctx, query, id)
+	query := `SELECT ` + historyColumns + `
+	          FROM cards_history ` + b.WhereClause() + `
+	          ` + b.OrderClause() + `
+	          LIMIT ` + b.Arg(limit+1) + ` OFFSET ` + b.Arg(offset) + `;`
 
-	_, err := s.readUserInfo(row)
+	rows, err := s.querier(ctx).QueryContext(ctx, query, b.Args()...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil
+		return nil, fmt.Errorf("Cant query card history: %w", err)
+	}
+	defer rows.Close()
+
+	hasMore := false
+	items := make([]*HistoryInfo, 0)
+	for rows.Next() {
+		if len(items) == limit {
+			hasMore = true
+			break
+		}
+		historyInfo, err := s.readHistoryInfo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read card history: %w", err)
 		}
-		return false, err
+		items = append(items, historyInfo)
 	}
 
-	return true, nil
-}
+	// closed before the count query, which may run on the same connection
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
 
-func (s *CardStorage) AddCardItem(ctx context.Context, req *AddCardRequestParams) (*int64, error) {
-	row := s.getDB().QueryRowContext(ctx,
-		`INSERT INTO cards
-		              (user_id, balance)
-		        VALUES ($1, $2)
-		        RETURNING card_id;`, req.UserID, req.Balance)
+	res := &HistoryPagination{Items: items}
 
-	var requestID int64
-	err := row.Scan(&requestID)
+	if filter.CursorMode() {
+		res.Limit = limit
+		if hasMore {
+			res.NextCursor, err = sqlbuilder.EncodeCursor([]interface{}{items[len(items)-1].HistoryID})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !filter.WithCount {
+			return res, nil
+		}
+	}
+
+	count, err := s.count(ctx, `cards_history`, countWhereClause, countArgs)
 	if err != nil {
 		return nil, err
 	}
-	return &requestID, nil
+	res.ItemsCount = &count
+
+	if !filter.CursorMode() {
+		pc := count / limit
+		if count%limit > 0 {
+			pc++
+		}
+		res.Page = filter.Page
+		res.Size = filter.Size
+		res.PagesCount = &pc
+	}
+
+	return res, nil
 }
 
-func (s *CardStorage) UpdateCardItem(ctx context.Context, req *UpdateCardRequestParams) error {
-	tx, err := s.getDB().BeginTx(ctx, nil)
+// FindByUsers returns the cards of the users which are not deleted, keyed by user id.
+func (s *CardStorage) FindByUsers(ctx context.Context, userIDs []int64) (map[int64][]*CardInfo, error) {
+	rows, err := s.querier(ctx).QueryContext(ctx,
+		`SELECT `+cardColumns+`
+		   FROM cards INNER JOIN users
+		     ON cards.user_id = users.user_id
+		  WHERE cards.user_id = ANY($1) AND cards.deleted_at IS NULL AND cards.tenant_id = $2
+		  ORDER BY cards.card_id;`,
+		pq.Array(userIDs), tenant.FromContext(ctx))
 	if err != nil {
-		return fmt.Errorf("Cannot start transaction: %w", err)
+		return nil, fmt.Errorf("Cant query cards: %w", err)
+	}
+	defer rows.Close()
+
+	res := make(map[int64][]*CardInfo)
+	for rows.Next() {
+		c, err := s.readCardInfo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read card: %w", err)
+		}
+		res[int64(c.UserID)] = append(res[int64(c.UserID)], c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+	return res, nil
+}
+
+// FindRecentTransfers returns at most limit latest transfer operations on the cards
+// of every user, newest first, keyed by user id.
+func (s *CardStorage) FindRecentTransfers(ctx context.Context, userIDs []int64, limit int) (map[int64][]*HistoryInfo, error) {
+	rows, err := s.querier(ctx).QueryContext(ctx,
+		`SELECT `+historyColumns+`, user_id
+		   FROM (SELECT cards_history.*, cards.user_id,
+		                ROW_NUMBER() OVER (PARTITION BY cards.user_id ORDER BY cards_history.history_id DESC) AS n
+		           FROM cards_history INNER JOIN cards
+		             ON cards_history.card_id = cards.card_id
+		          WHERE cards.user_id = ANY($1) AND cards.tenant_id = $2 AND cards_history.operation = ANY($3)) recent
+		  WHERE n <= $4
+		  ORDER BY user_id, history_id DESC;`,
+		pq.Array(userIDs), tenant.FromContext(ctx), pq.Array([]string{OperationTransferIn, OperationTransferOut}), limit)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query card history: %w", err)
+	}
+	defer rows.Close()
+
+	res := make(map[int64][]*HistoryInfo)
+	for rows.Next() {
+		var userID int64
+		h, err := s.readHistoryInfo(rows, &userID)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read card history: %w", err)
+		}
+		res[userID] = append(res[userID], h)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+	return res, nil
+}
+
+func (s *CardStorage) addHistory(ctx context.Context, tx *sql.Tx, cardID int, operation string, amount int, balanceAfter int, counterparty *int) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO cards_history
+		              (card_id, operation, amount, balance_after, counterparty_card_id)
+		        VALUES ($1, $2, $3, $4, $5);`,
+		cardID, operation, amount, balanceAfter, counterparty)
+	return err
+}
+
+// auditCard records the change of the card within tx, before is the snapshot taken prior to it.
+func (s *CardStorage) auditCard(ctx context.Context, tx *sql.Tx, action string, cardID int, before string) error {
+	after, err := audit.Snapshot(ctx, tx, cardSnapshotQuery, cardID)
+	if err != nil {
+		return err
+	}
+	return audit.Record(ctx, tx, &audit.Change{
+		Action:     action,
+		EntityType: audit.EntityCard,
+		EntityID:   int64(cardID),
+		Before:     before,
+		After:      after,
+	})
+}
+
+func (s *CardStorage) auditTransfer(ctx context.Context, tx *sql.Tx, action string, transferID int64, before string) error {
+	after, err := audit.Snapshot(ctx, tx, pendingTransferSnapshotQuery, transferID)
+	if err != nil {
+		return err
+	}
+	return audit.Record(ctx, tx, &audit.Change{
+		Action:     action,
+		EntityType: audit.EntityTransfer,
+		EntityID:   transferID,
+		Before:     before,
+		After:      after,
+	})
+}
+
+func (s *CardStorage) cardOwner(ctx context.Context, cardID int) (int64, kyc.Level, error) {
+	query := `SELECT users.user_id, users.kyc_level
+	          FROM cards INNER JOIN users
+	          ON cards.user_id = users.user_id
+	          WHERE cards.card_id = $1 AND cards.tenant_id = $2;`
+
+	row := s.querier(ctx).QueryRowContext(ctx, query, cardID, tenant.FromContext(ctx))
+
+	var userID int64
+	var level kyc.Level
+	err := row.Scan(&userID, &level)
+	if err != nil {
+		return 0, "", err
+	}
+	return userID, level, nil
+}
+
+// AddCardItem opens a card of the user if the KYC level of the user allows one more.
+// The user is locked while the cards are counted, so parallel requests can't open
+// more cards than the limit.
+func (s *CardStorage) AddCardItem(ctx context.Context, req *AddCardRequestParams, limits kyc.LimitsTable) (int64, error) {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot start transaction: %w", err)
 	}
 	defer func() {
 		if err != nil {
@@ -199,25 +582,62 @@ func (s *CardStorage) UpdateCardItem(ctx context.Context, req *UpdateCardRequest
 		}
 	}()
 
-	cardRow := tx.QueryRowContext(ctx, `SELECT balance FROM cards WHERE card_id = $1 FOR UPDATE;`, req.CardID)
-	var balance int
-	err = cardRow.Scan(&balance)
+	var level kyc.Level
+	err = tx.QueryRowContext(ctx,
+		`SELECT kyc_level FROM users
+		  WHERE user_id = $1 AND deleted_at IS NULL AND tenant_id = $2
+		    FOR UPDATE;`, req.UserID, tenant.FromContext(ctx)).Scan(&level)
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	}
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if limit := limits.For(level).MaxCards; limit > 0 {
+		var count int
+		err = tx.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM cards WHERE user_id = $1 AND deleted_at IS NULL;`, req.UserID).Scan(&count)
+		if err != nil {
+			return 0, err
+		}
+		if count >= limit {
+			err = fmt.Errorf("%w: %s level allows at most %d cards", ErrKycLimit, level, limit)
+			return 0, err
+		}
 	}
 
-	_, err = tx.ExecContext(ctx, `UPDATE cards SET balance = $2 WHERE card_id = $1;`, req.CardID, req.Balance)
+	row := tx.QueryRowContext(ctx,
+		`INSERT INTO cards
+		              (user_id, balance, tenant_id)
+		        VALUES ($1, $2, $3)
+		        RETURNING card_id;`, req.UserID, req.Balance, tenant.FromContext(ctx))
+
+	var requestID int64
+	err = row.Scan(&requestID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	err = s.addHistory(ctx, tx, int(requestID), OperationOpen, req.Balance, req.Balance, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.auditCard(ctx, tx, audit.ActionCreate, int(requestID), "")
+	if err != nil {
+		return 0, err
+	}
+
+	return requestID, nil
 }
 
-func (s *CardStorage) DeleteCardItem(ctx context.Context, cardID int) error {
-	tx, err := s.getDB().BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+// UpdateCardItem sets the balance if req.IfMatch matches the current ETag of the
+// card and returns the new one.
+func (s *CardStorage) UpdateCardItem(ctx context.Context, req *UpdateCardRequestParams) (string, error) {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("Cannot start transaction: %w", err)
+		return "", fmt.Errorf("Cannot start transaction: %w", err)
 	}
 	defer func() {
 		if err != nil {
@@ -227,26 +647,53 @@ func (s *CardStorage) DeleteCardItem(ctx context.Context, cardID int) error {
 		}
 	}()
 
-	cardRow := tx.QueryRowContext(ctx, `SELECT balance FROM cards WHERE card_id = $1 FOR UPDATE;`, cardID)
+	cardRow := tx.QueryRowContext(ctx,
+		`SELECT cards.balance, cards.version, users.version
+		   FROM cards INNER JOIN users ON cards.user_id = users.user_id
+		  WHERE cards.card_id = $1 AND cards.deleted_at IS NULL AND cards.tenant_id = $2
+		    FOR UPDATE OF cards;`,
+		req.CardID, tenant.FromContext(ctx))
 	var balance int
-	err = cardRow.Scan(&balance)
+	var version, userVersion int64
+	err = cardRow.Scan(&balance, &version, &userVersion)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	res, err := tx.ExecContext(ctx, "DELETE FROM cards WHERE card_id=$1", cardID)
-	if err == nil {
-		_, err := res.RowsAffected()
-		if err != nil {
-			return err
-		}
+	held, err := s.heldBalance(ctx, tx, req.CardID)
+	if err != nil {
+		return "", err
+	}
+	if len(req.IfMatch) != 0 && !etag.StrongMatch(req.IfMatch, cardTag(version, userVersion, held)) {
+		err = etag.ErrPreconditionFailed
+		return "", err
 	}
 
-	return nil
+	before, err := audit.Snapshot(ctx, tx, cardSnapshotQuery, req.CardID)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.QueryRowContext(ctx, `UPDATE cards SET balance = $2 WHERE card_id = $1 RETURNING version;`, req.CardID, req.Balance).Scan(&version)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.addHistory(ctx, tx, req.CardID, OperationAdjustment, req.Balance-balance, req.Balance, nil)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.auditCard(ctx, tx, audit.ActionUpdate, req.CardID, before)
+	if err != nil {
+		return "", err
+	}
+
+	return cardTag(version, userVersion, held), nil
 }
 
-func (s *CardStorage) RefillCard(ctx context.Context, req *RefillCardRequestParams) error {
-	tx, err := s.getDB().BeginTx(ctx, nil)
+func (s *CardStorage) DeleteCardItem(ctx context.Context, cardID int) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
 	if err != nil {
 		return fmt.Errorf("Cannot start transaction: %w", err)
 	}
@@ -258,14 +705,25 @@ func (s *CardStorage) RefillCard(ctx context.Context, req *RefillCardRequestPara
 		}
 	}()
 
-	cardRow := tx.QueryRowContext(ctx, `SELECT balance FROM cards WHERE card_id = $1 FOR UPDATE;`, req.CardID)
+	cardRow := tx.QueryRowContext(ctx, `SELECT balance FROM cards WHERE card_id = $1 AND deleted_at IS NULL AND tenant_id = $2 FOR UPDATE;`,
+		cardID, tenant.FromContext(ctx))
 	var balance int
 	err = cardRow.Scan(&balance)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `UPDATE cards SET balance = $2 WHERE card_id = $1;`, req.CardID, balance+req.AddBalance)
+	before, err := audit.Snapshot(ctx, tx, cardSnapshotQuery, cardID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE cards SET deleted_at = now() WHERE card_id = $1;`, cardID)
+	if err != nil {
+		return err
+	}
+
+	err = s.auditCard(ctx, tx, audit.ActionDelete, cardID, before)
 	if err != nil {
 		return err
 	}
@@ -273,8 +731,8 @@ func (s *CardStorage) RefillCard(ctx context.Context, req *RefillCardRequestPara
 	return nil
 }
 
-func (s *CardStorage) TransferBalanceCard(ctx context.Context, req *TransferBalanceCardRequestParams) error {
-	tx, err := s.getDB().BeginTx(ctx, nil)
+func (s *CardStorage) RestoreCardItem(ctx context.Context, cardID int) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("Cannot start transaction: %w", err)
 	}
@@ -286,33 +744,596 @@ func (s *CardStorage) TransferBalanceCard(ctx context.Context, req *TransferBala
 		}
 	}()
 
-	cardRow := tx.QueryRowContext(ctx, `SELECT balance FROM cards WHERE card_id = $1 FOR UPDATE;`, req.CardFrom)
-	var balanceFrom int
-	err = cardRow.Scan(&balanceFrom)
+	query := `SELECT users.deleted_at IS NOT NULL
+	          FROM cards INNER JOIN users
+	          ON cards.user_id = users.user_id
+	          WHERE cards.card_id = $1 AND cards.deleted_at IS NOT NULL AND cards.tenant_id = $2
+	          FOR UPDATE OF cards;`
+
+	var userDeleted bool
+	err = tx.QueryRowContext(ctx, query, cardID, tenant.FromContext(ctx)).Scan(&userDeleted)
 	if err != nil {
 		return err
 	}
-
-	cardRow = tx.QueryRowContext(ctx, `SELECT balance FROM cards WHERE card_id = $1 FOR UPDATE;`, req.CardTo)
-	var balanceTo int
-	err = cardRow.Scan(&balanceTo)
-	if err != nil {
+	if userDeleted {
+		err = ErrOwnerDeleted
 		return err
 	}
 
-	if balanceFrom-req.AddBalance < 0 {
-		return errors.New("Dont exist essential balance")
+	before, err := audit.Snapshot(ctx, tx, cardSnapshotQuery, cardID)
+	if err != nil {
+		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `UPDATE cards SET balance = $2 WHERE card_id = $1;`, req.CardFrom, balanceFrom-req.AddBalance)
+	_, err = tx.ExecContext(ctx, `UPDATE cards SET deleted_at = NULL WHERE card_id = $1;`, cardID)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `UPDATE cards SET balance = $2 WHERE card_id = $1;`, req.CardTo, balanceTo+req.AddBalance)
+	err = s.auditCard(ctx, tx, audit.ActionRestore, cardID, before)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// PurgeDeleted removes cards soft-deleted before the given time together with their history.
+// It is run by the retention job for all tenants at once.
+func (s *CardStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.querier(ctx).ExecContext(ctx, `DELETE FROM cards WHERE deleted_at < $1;`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *CardStorage) RefillCard(ctx context.Context, req *RefillCardRequestParams) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	cardRow := tx.QueryRowContext(ctx, `SELECT balance FROM cards WHERE card_id = $1 AND deleted_at IS NULL AND tenant_id = $2 FOR UPDATE;`,
+		req.CardID, tenant.FromContext(ctx))
+	var balance int
+	err = cardRow.Scan(&balance)
+	if err != nil {
+		return err
+	}
+
+	before, err := audit.Snapshot(ctx, tx, cardSnapshotQuery, req.CardID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE cards SET balance = $2 WHERE card_id = $1;`, req.CardID, balance+req.AddBalance)
+	if err != nil {
+		return err
+	}
+
+	err = s.addHistory(ctx, tx, req.CardID, OperationRefill, req.AddBalance, balance+req.AddBalance, nil)
+	if err != nil {
+		return err
+	}
+
+	err = s.auditCard(ctx, tx, audit.ActionRefill, req.CardID, before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *CardStorage) TransferBalanceCard(ctx context.Context, req *TransferBalanceCardRequestParams) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	err = s.transfer(ctx, tx, req)
+	return err
+}
+
+// transfer moves money between cards within tx. Money held by pending transfers
+// of the source card can't be spent.
+func (s *CardStorage) transfer(ctx context.Context, tx *sql.Tx, req *TransferBalanceCardRequestParams) error {
+	balances, err := s.lockCards(ctx, tx, req.CardFrom, req.CardTo)
+	if err != nil {
+		return err
+	}
+	balanceFrom, balanceTo := balances[req.CardFrom], balances[req.CardTo]
+
+	held, err := s.heldBalance(ctx, tx, req.CardFrom)
+	if err != nil {
+		return err
+	}
+
+	if balanceFrom-held-req.AddBalance < 0 {
+		return ErrInsufficientFunds
+	}
+
+	beforeFrom, err := audit.Snapshot(ctx, tx, cardSnapshotQuery, req.CardFrom)
+	if err != nil {
+		return err
+	}
+	beforeTo, err := audit.Snapshot(ctx, tx, cardSnapshotQuery, req.CardTo)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE cards SET balance = $2 WHERE card_id = $1;`, req.CardFrom, balanceFrom-req.AddBalance)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE cards SET balance = $2 WHERE card_id = $1;`, req.CardTo, balanceTo+req.AddBalance)
+	if err != nil {
+		return err
+	}
+
+	err = s.addHistory(ctx, tx, req.CardFrom, OperationTransferOut, -req.AddBalance, balanceFrom-req.AddBalance, &req.CardTo)
+	if err != nil {
+		return err
+	}
+
+	err = s.addHistory(ctx, tx, req.CardTo, OperationTransferIn, req.AddBalance, balanceTo+req.AddBalance, &req.CardFrom)
+	if err != nil {
+		return err
+	}
+
+	err = s.auditCard(ctx, tx, audit.ActionTransfer, req.CardFrom, beforeFrom)
+	if err != nil {
+		return err
+	}
+
+	err = s.auditCard(ctx, tx, audit.ActionTransfer, req.CardTo, beforeTo)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// lockCards locks the cards of the tenant which are not deleted and returns their
+// balances, sql.ErrNoRows if one of them is not found. Rows are locked in the order
+// of ids, so that opposite transfers running at once don't deadlock.
+func (s *CardStorage) lockCards(ctx context.Context, tx *sql.Tx, cardIDs ...int) (map[int]int, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT card_id, balance FROM cards
+		  WHERE card_id = ANY($1) AND deleted_at IS NULL AND tenant_id = $2
+		  ORDER BY card_id
+		    FOR UPDATE;`, pq.Array(cardIDs), tenant.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make(map[int]int, len(cardIDs))
+	for rows.Next() {
+		var cardID, balance int
+		if err := rows.Scan(&cardID, &balance); err != nil {
+			return nil, err
+		}
+		balances[cardID] = balance
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range cardIDs {
+		if _, ok := balances[id]; !ok {
+			return nil, sql.ErrNoRows
+		}
+	}
+	return balances, nil
+}
+
+// heldBalance sums active holds of the card. The card row must be locked by the
+// caller, so that no hold is added concurrently.
+func (s *CardStorage) heldBalance(ctx context.Context, tx *sql.Tx, cardID int) (int, error) {
+	row := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(amount), 0) FROM pending_transfers
+		  WHERE card_from = $1 AND status = 'pending' AND expires_at > now();`, cardID)
+
+	var held int
+	err := row.Scan(&held)
+	if err != nil {
+		return 0, err
+	}
+	return held, nil
+}
+
+func (s *CardStorage) readPendingTransferInfo(r QueryResult) (*PendingTransferInfo, error) {
+	t := &PendingTransferInfo{}
+	err := r.Scan(&t.TransferID, &t.CardFrom, &t.CardTo, &t.Amount, &t.UserID, &t.Status,
+		&t.Attempts, &t.CreateTime, &t.ExpiresAt, &t.ConfirmedAt)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *CardStorage) FindPendingTransfer(ctx context.Context, transferID int64) (*PendingTransferInfo, error) {
+	row := s.querier(ctx).QueryRowContext(ctx,
+		`SELECT `+pendingTransferColumns+` FROM pending_transfers
+		  WHERE transfer_id = $1 AND card_from IN (SELECT card_id FROM cards WHERE tenant_id = $2);`,
+		transferID, tenant.FromContext(ctx))
+
+	t, err := s.readPendingTransferInfo(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// AddPendingTransfer holds the amount on the source card until the transfer is confirmed
+// by userID with a TOTP code or expires.
+func (s *CardStorage) AddPendingTransfer(ctx context.Context, userID int64, req *TransferBalanceCardRequestParams, expiresAt time.Time) (*PendingTransferInfo, error) {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	var locked bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(locked_until > now(), false) FROM user_totp
+		  WHERE user_id = $1 AND confirmed_at IS NOT NULL;`, userID).Scan(&locked)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrTwoFactorRequired
+		}
+		return nil, err
+	}
+	if locked {
+		err = ErrTwoFactorLocked
+		return nil, err
+	}
+
+	balances, err := s.lockCards(ctx, tx, req.CardFrom, req.CardTo)
+	if err != nil {
+		return nil, err
+	}
+	balanceFrom := balances[req.CardFrom]
+
+	held, err := s.heldBalance(ctx, tx, req.CardFrom)
+	if err != nil {
+		return nil, err
+	}
+	if balanceFrom-held-req.AddBalance < 0 {
+		err = ErrInsufficientFunds
+		return nil, err
+	}
+
+	row := tx.QueryRowContext(ctx,
+		`INSERT INTO pending_transfers
+		              (card_from, card_to, amount, user_id, expires_at)
+		        VALUES ($1, $2, $3, $4, $5)
+		     RETURNING `+pendingTransferColumns+`;`,
+		req.CardFrom, req.CardTo, req.AddBalance, userID, expiresAt)
+
+	t, err := s.readPendingTransferInfo(row)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.auditTransfer(ctx, tx, audit.ActionCreate, t.TransferID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// findTwoFactorSecret returns the confirmed TOTP secret of the user, the last time
+// step a code was accepted for and whether the user is locked out after wrong codes.
+func (s *CardStorage) findTwoFactorSecret(ctx context.Context, userID int64) (string, *int64, bool, error) {
+	row := s.querier(ctx).QueryRowContext(ctx,
+		`SELECT secret, last_used_step, COALESCE(locked_until > now(), false) FROM user_totp
+		  WHERE user_id = $1 AND confirmed_at IS NOT NULL;`, userID)
+
+	var secret string
+	var lastStep *int64
+	var locked bool
+	err := row.Scan(&secret, &lastStep, &locked)
+	if err != nil {
+		return "", nil, false, err
+	}
+	return secret, lastStep, locked, nil
+}
+
+// ConfirmPendingTransfer executes the held transfer. step is the time step of the
+// TOTP code it was confirmed with; a code can't confirm twice.
+func (s *CardStorage) ConfirmPendingTransfer(ctx context.Context, transferID int64, step int64) (*PendingTransferInfo, error) {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	row := tx.QueryRowContext(ctx,
+		`SELECT `+pendingTransferColumns+` FROM pending_transfers
+		  WHERE transfer_id = $1 AND card_from IN (SELECT card_id FROM cards WHERE tenant_id = $2)
+		    FOR UPDATE;`, transferID, tenant.FromContext(ctx))
+	t, err := s.readPendingTransferInfo(row)
+	if err != nil {
+		return nil, err
+	}
+	if t.Status != TransferPending {
+		err = fmt.Errorf("%w: transfer is %s", ErrTransferNotPending, t.Status)
+		return nil, err
+	}
+
+	// a valid code clears the count of wrong ones, unless the user got locked out meanwhile
+	res, err := tx.ExecContext(ctx,
+		`UPDATE user_totp SET last_used_step = $2, failed_attempts = 0
+		  WHERE user_id = $1 AND (last_used_step IS NULL OR last_used_step < $2)
+		    AND (locked_until IS NULL OR locked_until <= now());`, t.UserID, step)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		err = ErrInvalidCode
+		return nil, err
+	}
+
+	before, err := audit.Snapshot(ctx, tx, pendingTransferSnapshotQuery, transferID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the hold is released first, so that transfer doesn't count it
+	row = tx.QueryRowContext(ctx,
+		`UPDATE pending_transfers SET status = 'confirmed', confirmed_at = now()
+		  WHERE transfer_id = $1
+		 RETURNING `+pendingTransferColumns+`;`, transferID)
+	t, err = s.readPendingTransferInfo(row)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.auditTransfer(ctx, tx, audit.ActionConfirm, transferID, before)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.transfer(ctx, tx, &TransferBalanceCardRequestParams{CardFrom: t.CardFrom, CardTo: t.CardTo, AddBalance: t.Amount})
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// FailPendingTransfer counts a wrong code of the user who confirms the transfer. After
+// maxAttempts wrong codes in a row the user is locked out for lockout and the transfer
+// is cancelled.
+func (s *CardStorage) FailPendingTransfer(ctx context.Context, transferID int64, userID int64, maxAttempts int, lockout time.Duration) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	var locked bool
+	err = tx.QueryRowContext(ctx,
+		`UPDATE user_totp
+		    SET failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END,
+		        locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN now() + $3 * interval '1 second' ELSE locked_until END
+		  WHERE user_id = $1
+		 RETURNING failed_attempts = 0;`, userID, maxAttempts, lockout.Seconds()).Scan(&locked)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE pending_transfers
+		    SET attempts = attempts + 1,
+		        status = CASE WHEN $2 THEN 'cancelled' ELSE status END
+		  WHERE transfer_id = $1 AND status = 'pending';`, transferID, locked)
+	return err
+}
+
+// CancelPendingTransfer releases the hold of a transfer which is still awaiting confirmation.
+func (s *CardStorage) CancelPendingTransfer(ctx context.Context, transferID int64) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	before, err := audit.Snapshot(ctx, tx, pendingTransferSnapshotQuery, transferID)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE pending_transfers SET status = 'cancelled'
+		  WHERE transfer_id = $1 AND status = 'pending' AND expires_at > now()
+		    AND card_from IN (SELECT card_id FROM cards WHERE tenant_id = $2);`, transferID, tenant.FromContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		err = sql.ErrNoRows
+		return err
+	}
+
+	err = s.auditTransfer(ctx, tx, audit.ActionCancel, transferID, before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// importOwner is the owner of imported cards, Cards counts the cards they have.
+type importOwner struct {
+	Level kyc.Level
+	Cards int
+}
+
+// FindImportOwners returns the users of the tenant with the ids which are not deleted.
+func (s *CardStorage) FindImportOwners(ctx context.Context, userIDs []int64) (map[int]*importOwner, error) {
+	rows, err := s.querier(ctx).QueryContext(ctx,
+		`SELECT users.user_id, users.kyc_level,
+		        (SELECT COUNT(*) FROM cards WHERE cards.user_id = users.user_id AND cards.deleted_at IS NULL)
+		   FROM users
+		  WHERE users.user_id = ANY($1) AND users.deleted_at IS NULL AND users.tenant_id = $2;`,
+		pq.Array(userIDs), tenant.FromContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Cant query users: %w", err)
+	}
+	defer rows.Close()
+
+	owners := make(map[int]*importOwner)
+	for rows.Next() {
+		var userID int
+		owner := &importOwner{}
+		if err := rows.Scan(&userID, &owner.Level, &owner.Cards); err != nil {
+			return nil, fmt.Errorf("Cannot read user: %w", err)
+		}
+		owners[userID] = owner
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+	return owners, nil
+}
+
+// ImportCards copies the cards into a temporary table and opens them from it in one
+// statement, with the open operations in their history. The import is recorded in
+// the audit log as a single entry with the ids of the opened cards and completed
+// with res in the same transaction, see imports.Complete.
+func (s *CardStorage) ImportCards(ctx context.Context, importID int64, total int, cards []*AddCardRequestParams, res *imports.Result) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `CREATE TEMP TABLE import_cards (ord INT, user_id INT, balance BIGINT) ON COMMIT DROP;`)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("import_cards", "ord", "user_id", "balance"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, c := range cards {
+		if _, err = stmt.ExecContext(ctx, i, c.UserID, c.Balance); err != nil {
+			return err
+		}
+	}
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`WITH opened AS (
+		      INSERT INTO cards (user_id, balance, tenant_id)
+		      SELECT user_id, balance, $1 FROM import_cards ORDER BY ord
+		   RETURNING card_id, balance
+		 ), history AS (
+		      INSERT INTO cards_history (card_id, operation, amount, balance_after)
+		      SELECT card_id, $2, balance, balance FROM opened
+		 )
+		 SELECT card_id FROM opened;`, tenant.FromContext(ctx), OperationOpen)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int64, 0, len(cards))
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	after, err := json.Marshal(map[string]interface{}{"entity": "cards", "card_ids": ids})
+	if err != nil {
+		return err
+	}
+	err = audit.Record(ctx, tx, &audit.Change{
+		Action:     audit.ActionImport,
+		EntityType: audit.EntityImport,
+		EntityID:   importID,
+		After:      string(after),
+	})
+	if err != nil {
+		return err
+	}
+
+	res.Imported = len(ids)
+	err = imports.Complete(ctx, tx, importID, total, res)
+	return err
+}
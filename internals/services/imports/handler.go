@@ -0,0 +1,151 @@
+package imports
+
+import (
+	"errors"
+	"github.com/golang/gddo/httputil/header"
+	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/versioning"
+	"github.com/lenarsaitov/go-task/pkg/problem"
+	"github.com/lenarsaitov/go-task/pkg/response"
+	"net/http"
+	"strconv"
+)
+
+type ImportHandler struct {
+	service *ImportService
+}
+
+func NewImportHandler(service *ImportService) *ImportHandler {
+	return &ImportHandler{service}
+}
+
+var INDENT = "  "
+
+func (h *ImportHandler) Setup(root *echo.Group) {
+	g := root.Group("/imports")
+
+	g.POST("", h.AddImport)
+	g.GET("/:id", h.ImportItem)
+	g.GET("/:id/errors", h.ImportErrors)
+}
+
+// AddImport accepts the file as the request body, its format is taken from
+// Content-Type. The import runs in the background, the response is 202 with the
+// URL of the import to poll.
+func (h *ImportHandler) AddImport(c echo.Context) error {
+	contentType, _ := header.ParseValueAndParams(c.Request().Header, echo.HeaderContentType)
+	format, err := FormatOf(contentType)
+	if err != nil {
+		return h.HandleError(c, http.StatusUnsupportedMediaType, err)
+	}
+
+	params := &AddImportRequestParams{Entity: c.QueryParam("entity"), Format: format}
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.StartImport(c.Request().Context(), params, c.Request().Body)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, response.Location(versioning.Path(c.Request().Context(), "/imports"), p.ImportID))
+	return c.JSONPretty(http.StatusAccepted, p, INDENT)
+}
+
+func (h *ImportHandler) ImportItem(c echo.Context) error {
+	importID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.GetImport(c.Request().Context(), importID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *ImportHandler) ImportErrors(c echo.Context) error {
+	var sizeInt, pageInt int
+
+	importID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	pageStr := c.FormValue("page")
+	sizeStr := c.FormValue("size")
+
+	if len(pageStr) != 0 {
+		pageInt, err = strconv.Atoi(pageStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+	if len(sizeStr) != 0 {
+		sizeInt, err = strconv.Atoi(sizeStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+
+	params := &ErrorsFilterParams{ImportID: importID, Page: pageInt, Size: sizeInt}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+	p, err := h.service.GetErrors(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *ImportHandler) HandleSuccess(c echo.Context, statusCode int, Message string) error {
+	return response.Success(c, statusCode, Message)
+}
+
+func (h *ImportHandler) HandleError(c echo.Context, statusCode int, err error) error {
+	return problem.Error(c, statusCode, err, errorCodes)
+}
+
+func serviceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnknownEntity),
+		errors.Is(err, ErrUnknownTenant):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnsupportedFormat):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrQueueFull):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorCodes are stable codes of the service errors returned in problem details.
+var errorCodes = problem.Codes{
+	ErrUnknownEntity:        "unknown_entity",
+	ErrUnsupportedFormat:    "unsupported_import_format",
+	ErrFileTooLarge:         "file_too_large",
+	ErrUnknownTenant:        "unknown_tenant",
+	ErrQueueFull:            "import_queue_full",
+	auth.ErrUnauthenticated: "unauthenticated",
+	auth.ErrForbidden:       "forbidden",
+}
@@ -0,0 +1,115 @@
+package imports
+
+import (
+	"context"
+)
+
+// Entities which can be imported, see Importer.
+const (
+	EntityUsers = "users"
+	EntityCards = "cards"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// formats maps media types of uploads to formats.
+var formats = map[string]string{
+	"text/csv":             FormatCSV,
+	"application/x-ndjson": FormatJSONL,
+	"application/jsonl":    FormatJSONL,
+	"application/ndjson":   FormatJSONL,
+}
+
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// Row is a record of the file, values are kept as text for both formats. Number
+// counts data rows from 1, the CSV header is not counted.
+type Row struct {
+	Number int
+	Fields map[string]string
+}
+
+// RowError tells why a row was not imported. Field is empty when the row as a
+// whole is invalid.
+type RowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Result of Importer.Import. Rows with errors are skipped, the others are imported.
+type Result struct {
+	Imported int
+	Errors   []*RowError
+}
+
+// Importer validates and writes rows of an entity. It is implemented by the
+// services of the entities and runs outside of the request, with the tenant and
+// the principal of the request which started the import in ctx.
+type Importer interface {
+	// Authorize checks the caller may import the entity and read its imports.
+	Authorize(ctx context.Context) error
+	// Columns lists the fields of a row, CSV headers may contain only these.
+	Columns() []string
+	// Import validates every row and writes the valid ones within a single
+	// transaction, which also completes the import with Complete, so that the rows
+	// are kept if and only if the import is completed. errs are the rows of the
+	// file which couldn't be parsed.
+	Import(ctx context.Context, importID int64, rows []*Row, errs []*RowError) (*Result, error)
+}
+
+type ImportInfo struct {
+	ImportID     int64  `json:"import_id"`
+	Entity       string `json:"entity"`
+	Format       string `json:"format"`
+	Status       string `json:"status"`
+	TotalRows    int    `json:"total_rows"`
+	ImportedRows int    `json:"imported_rows"`
+	FailedRows   int    `json:"failed_rows"`
+	Error        string `json:"error,omitempty"`
+	Actor        string `json:"actor"`
+	CreateTime   string `json:"create_time"`
+	StartedAt    string `json:"started_at,omitempty"`
+	FinishedAt   string `json:"finished_at,omitempty"`
+}
+
+// Settings limit the size of imports, the number of imports running at once and
+// the number of imports waiting for them. RLS binds the connections of imports to
+// their tenant, see tenant.Bind. Instance names the server in the imports it runs,
+// it must stay the same across restarts and differ between servers sharing the
+// database.
+type Settings struct {
+	MaxSize   int64
+	MaxRows   int
+	Workers   int
+	QueueSize int
+	RLS       bool
+	Instance  string
+}
+
+type AddImportRequestParams struct {
+	Entity string `query:"entity" validate:"oneof=users cards"`
+	Format string
+}
+
+type ErrorsFilterParams struct {
+	ImportID int64
+	Page     int `query:"page" validate:"omitempty,gte=1"`
+	Size     int `query:"size" validate:"omitempty,gte=1,lte=50"`
+}
+
+type ErrorsPagination struct {
+	Page       int         `json:"page,omitempty"`
+	Size       int         `json:"size,omitempty"`
+	PagesCount int         `json:"pagesCount"`
+	ItemsCount int         `json:"itemsCount"`
+	Items      []*RowError `json:"items"`
+}
@@ -0,0 +1,158 @@
+package imports
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxLineSize limits a line of JSONL files.
+const maxLineSize = 1 << 20
+
+// parse splits the file into rows. Rows which can't be read are returned as row
+// errors; errors of the file as a whole, like unknown CSV columns, wrap
+// ErrInvalidFile and fail the import.
+func parse(format string, data []byte, columns []string, maxRows int) ([]*Row, []*RowError, error) {
+	known := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		known[c] = true
+	}
+
+	var rows []*Row
+	var errs []*RowError
+	var err error
+	switch format {
+	case FormatCSV:
+		rows, errs, err = parseCSV(data, columns, known, maxRows)
+	case FormatJSONL:
+		rows, errs, err = parseJSONL(data, known, maxRows)
+	default:
+		err = fmt.Errorf("%w: unknown format %q", ErrInvalidFile, format)
+	}
+	return rows, errs, err
+}
+
+func tooManyRows(maxRows int) error {
+	return fmt.Errorf("%w: more than %d rows, split the file", ErrInvalidFile, maxRows)
+}
+
+func parseCSV(data []byte, columns []string, known map[string]bool, maxRows int) ([]*Row, []*RowError, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("%w: CSV header is missing", ErrInvalidFile)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid CSV header: %s", ErrInvalidFile, err)
+	}
+
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !known[name] {
+			return nil, nil, fmt.Errorf("%w: unknown column %q, expected some of: %s", ErrInvalidFile, name, strings.Join(columns, ", "))
+		}
+		if seen[name] {
+			return nil, nil, fmt.Errorf("%w: duplicate column %q", ErrInvalidFile, name)
+		}
+		seen[name] = true
+		header[i] = name
+	}
+
+	var rows []*Row
+	var errs []*RowError
+	for n := 1; ; n++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if n > maxRows {
+			return nil, nil, tooManyRows(maxRows)
+		}
+
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			errs = append(errs, &RowError{Row: n, Message: pe.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidFile, err)
+		}
+
+		row := &Row{Number: n, Fields: make(map[string]string, len(header))}
+		for i, name := range header {
+			row.Fields[name] = strings.TrimSpace(record[i])
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs, nil
+}
+
+// parseJSONL reads an object per line, numbered by lines. Blank lines are skipped.
+// Values must be scalars, they are converted to text like CSV fields.
+func parseJSONL(data []byte, known map[string]bool, maxRows int) ([]*Row, []*RowError, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	var rows []*Row
+	var errs []*RowError
+	count := 0
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if count++; count > maxRows {
+			return nil, nil, tooManyRows(maxRows)
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		var object map[string]interface{}
+		if err := dec.Decode(&object); err != nil || dec.More() {
+			errs = append(errs, &RowError{Row: n, Message: "must be a JSON object"})
+			continue
+		}
+
+		row, rowErr := jsonRow(n, object, known)
+		if rowErr != nil {
+			errs = append(errs, rowErr)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidFile, err)
+	}
+	return rows, errs, nil
+}
+
+func jsonRow(n int, object map[string]interface{}, known map[string]bool) (*Row, *RowError) {
+	row := &Row{Number: n, Fields: make(map[string]string, len(object))}
+	for name, value := range object {
+		if !known[name] {
+			return nil, &RowError{Row: n, Field: name, Message: "is unknown"}
+		}
+		switch v := value.(type) {
+		case nil:
+			row.Fields[name] = ""
+		case string:
+			row.Fields[name] = strings.TrimSpace(v)
+		case json.Number:
+			row.Fields[name] = v.String()
+		case bool:
+			row.Fields[name] = strconv.FormatBool(v)
+		default:
+			return nil, &RowError{Row: n, Field: name, Message: "must be a string, a number or null"}
+		}
+	}
+	return row, nil
+}
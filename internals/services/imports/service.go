@@ -0,0 +1,204 @@
+package imports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lenarsaitov/go-task/pkg/logging"
+	"github.com/lenarsaitov/go-task/pkg/requestid"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	ErrUnknownEntity     = errors.New("Unknown entity, expected users or cards")
+	ErrUnsupportedFormat = errors.New("Content-Type must be text/csv or application/x-ndjson")
+	ErrFileTooLarge      = errors.New("File is too large")
+	ErrInvalidFile       = errors.New("Invalid file")
+	ErrUnknownTenant     = errors.New("Unknown tenant")
+	ErrQueueFull         = errors.New("Too many imports are waiting, try again later")
+)
+
+// Messages stored as errors of failed imports.
+const (
+	internalFailure    = "Internal error, report the import id to support"
+	interruptedFailure = "Interrupted by restart of the server, upload the file again"
+)
+
+type ImportService struct {
+	storage   *ImportStorage
+	importers map[string]Importer
+	settings  Settings
+	// slots limits the number of imports running at once
+	slots chan struct{}
+	// queue limits the number of imports accepted and not finished, each of them
+	// holds its file in memory
+	queue chan struct{}
+}
+
+// NewImportService creates the service, importers are keyed by entity.
+func NewImportService(storage *ImportStorage, importers map[string]Importer, settings Settings) *ImportService {
+	workers := settings.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	queueSize := settings.QueueSize
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &ImportService{storage: storage, importers: importers, settings: settings,
+		slots: make(chan struct{}, workers), queue: make(chan struct{}, workers+queueSize)}
+}
+
+// FormatOf returns the format of files of the media type.
+func FormatOf(contentType string) (string, error) {
+	format, ok := formats[contentType]
+	if !ok {
+		return "", ErrUnsupportedFormat
+	}
+	return format, nil
+}
+
+// StartImport records the import and runs it in the background. The returned
+// import is pending, clients poll GetImport for its status. ErrQueueFull is
+// returned while as many imports as the queue holds are unfinished.
+func (service *ImportService) StartImport(c context.Context, params *AddImportRequestParams, body io.Reader) (job *ImportInfo, err error) {
+	importer, ok := service.importers[params.Entity]
+	if !ok {
+		return nil, ErrUnknownEntity
+	}
+	if err := importer.Authorize(c); err != nil {
+		return nil, err
+	}
+
+	select {
+	case service.queue <- struct{}{}:
+	default:
+		return nil, ErrQueueFull
+	}
+	defer func() {
+		if err != nil {
+			<-service.queue
+		}
+	}()
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, service.settings.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > service.settings.MaxSize {
+		return nil, fmt.Errorf("%w: at most %d bytes are accepted", ErrFileTooLarge, service.settings.MaxSize)
+	}
+
+	var actor string
+	if p := auth.PrincipalFromContext(c); p != nil {
+		actor = p.Subject
+	}
+	job, err = service.storage.AddImport(c, params.Entity, params.Format, actor, service.settings.Instance)
+	if err != nil {
+		return nil, err
+	}
+
+	go service.run(detach(c), job.ImportID, importer, params.Format, data)
+
+	return job, nil
+}
+
+// detach copies the tenant, the principal and the request id of the request into a
+// context which outlives it. The connection bound to the tenant is not copied, it
+// is released with the request.
+func detach(c context.Context) context.Context {
+	ctx := tenant.WithID(context.Background(), tenant.FromContext(c))
+	ctx = requestid.WithID(ctx, requestid.FromContext(c))
+	if p := auth.PrincipalFromContext(c); p != nil {
+		ctx = auth.WithPrincipal(ctx, p)
+	}
+	return ctx
+}
+
+// run imports the file once a slot is free. The import is marked failed through
+// ctx, which isn't bound to a connection, so that failures of binding and panics
+// after the release of the bound connection are recorded too.
+func (service *ImportService) run(ctx context.Context, importID int64, importer Importer, format string, data []byte) {
+	defer func() { <-service.queue }()
+	service.slots <- struct{}{}
+	defer func() { <-service.slots }()
+
+	logger := logging.GetLogger().WithField("import_id", importID)
+	fail := func(err error) {
+		message := internalFailure
+		if errors.Is(err, ErrInvalidFile) {
+			message = err.Error()
+		} else {
+			logger.Errorf("import failed: %s", err)
+		}
+		if err := service.storage.FailImport(ctx, importID, message); err != nil {
+			logger.Errorf("failed to mark import failed: %s", err)
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			fail(fmt.Errorf("panic: %v", r))
+		}
+	}()
+
+	bound, release, err := tenant.Bind(ctx, service.storage.getDB(), service.settings.RLS)
+	if err != nil {
+		fail(fmt.Errorf("Cannot bind tenant: %w", err))
+		return
+	}
+	defer release()
+
+	if err := service.storage.StartImport(bound, importID); err != nil {
+		fail(err)
+		return
+	}
+
+	rows, errs, err := parse(format, data, importer.Columns(), service.settings.MaxRows)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	res, err := importer.Import(bound, importID, rows, errs)
+	if err != nil {
+		fail(err)
+		return
+	}
+	logger.Infof("imported %d rows, %d failed", res.Imported, len(res.Errors))
+}
+
+// GetImport returns the import if the caller may import its entity.
+func (service *ImportService) GetImport(c context.Context, importID int64) (*ImportInfo, error) {
+	job, err := service.storage.FindOne(c, importID)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	if err := service.authorize(c, job.Entity); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (service *ImportService) GetErrors(c context.Context, params *ErrorsFilterParams) (*ErrorsPagination, error) {
+	job, err := service.GetImport(c, params.ImportID)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	return service.storage.FindErrors(c, params)
+}
+
+// FailInterrupted fails the imports which were running when the instance stopped.
+func (service *ImportService) FailInterrupted(ctx context.Context) (int64, error) {
+	return service.storage.FailInterrupted(ctx, service.settings.Instance, interruptedFailure)
+}
+
+func (service *ImportService) authorize(c context.Context, entity string) error {
+	importer, ok := service.importers[entity]
+	if !ok {
+		return ErrUnknownEntity
+	}
+	return importer.Authorize(c)
+}
@@ -0,0 +1,209 @@
+package imports
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lib/pq"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+const importColumns = `import_id, entity, format, status, total_rows, imported_rows, failed_rows,
+	COALESCE(error, ''), actor, create_time, COALESCE(started_at::text, ''), COALESCE(finished_at::text, '')`
+
+type ImportStorage struct {
+	db atomic.Value
+}
+
+type QueryResult interface {
+	Scan(dest ...interface{}) error
+}
+
+var (
+	_ QueryResult = &sql.Rows{}
+	_ QueryResult = &sql.Row{}
+)
+
+func NewImportStorage(db *sqlx.DB) *ImportStorage {
+	res := &ImportStorage{}
+	res.db.Store(db)
+	return res
+}
+
+func (s *ImportStorage) getDB() *sqlx.DB {
+	return s.db.Load().(*sqlx.DB)
+}
+
+// querier returns the connection bound to the tenant of the request, see tenant.DB.
+func (s *ImportStorage) querier(ctx context.Context) tenant.Querier {
+	return tenant.DB(ctx, s.getDB())
+}
+
+func (s *ImportStorage) readImportInfo(r QueryResult) (*ImportInfo, error) {
+	i := &ImportInfo{}
+	err := r.Scan(&i.ImportID, &i.Entity, &i.Format, &i.Status, &i.TotalRows, &i.ImportedRows, &i.FailedRows,
+		&i.Error, &i.Actor, &i.CreateTime, &i.StartedAt, &i.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// AddImport records a pending import run by the server instance.
+func (s *ImportStorage) AddImport(ctx context.Context, entity string, format string, actor string, instance string) (*ImportInfo, error) {
+	row := s.querier(ctx).QueryRowContext(ctx,
+		`INSERT INTO import_jobs (tenant_id, entity, format, actor, instance)
+		        VALUES ($1, $2, $3, $4, $5)
+		     RETURNING `+importColumns+`;`,
+		tenant.FromContext(ctx), entity, format, actor, instance)
+
+	i, err := s.readImportInfo(row)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" && pqErr.Constraint == "import_jobs_tenant_id_fkey" {
+			return nil, ErrUnknownTenant
+		}
+		return nil, err
+	}
+	return i, nil
+}
+
+func (s *ImportStorage) FindOne(ctx context.Context, importID int64) (*ImportInfo, error) {
+	row := s.querier(ctx).QueryRowContext(ctx,
+		`SELECT `+importColumns+` FROM import_jobs WHERE import_id = $1 AND tenant_id = $2;`,
+		importID, tenant.FromContext(ctx))
+
+	i, err := s.readImportInfo(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return i, nil
+}
+
+func (s *ImportStorage) StartImport(ctx context.Context, importID int64) error {
+	_, err := s.querier(ctx).ExecContext(ctx,
+		`UPDATE import_jobs SET status = 'running', started_at = now() WHERE import_id = $1;`, importID)
+	return err
+}
+
+// Complete stores the row errors of res with COPY and completes the import within
+// tx, the transaction of the importer which writes the rows. total counts the rows
+// of the file.
+func Complete(ctx context.Context, tx *sql.Tx, importID int64, total int, res *Result) error {
+	sort.Slice(res.Errors, func(i, j int) bool { return res.Errors[i].Row < res.Errors[j].Row })
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("import_errors", "import_id", "row_number", "field", "message"))
+	if err != nil {
+		return fmt.Errorf("Cannot complete import: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, e := range res.Errors {
+		var field interface{}
+		if len(e.Field) != 0 {
+			field = e.Field
+		}
+		if _, err = stmt.ExecContext(ctx, importID, e.Row, field, e.Message); err != nil {
+			return fmt.Errorf("Cannot complete import: %w", err)
+		}
+	}
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("Cannot complete import: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE import_jobs
+		    SET status = 'completed', total_rows = $2, imported_rows = $3, failed_rows = $4, finished_at = now()
+		  WHERE import_id = $1;`, importID, total, res.Imported, len(res.Errors))
+	if err != nil {
+		return fmt.Errorf("Cannot complete import: %w", err)
+	}
+	return nil
+}
+
+// FailImport marks the import failed, message is shown to clients.
+func (s *ImportStorage) FailImport(ctx context.Context, importID int64, message string) error {
+	_, err := s.querier(ctx).ExecContext(ctx,
+		`UPDATE import_jobs SET status = 'failed', error = $2, finished_at = now() WHERE import_id = $1;`,
+		importID, message)
+	return err
+}
+
+// FailInterrupted fails imports of all tenants which were pending or running on the
+// instance when it stopped; imports of other instances still run. Imports write
+// their rows in the transaction which completes them, so nothing of them was kept
+// and the files can be uploaded again.
+func (s *ImportStorage) FailInterrupted(ctx context.Context, instance string, message string) (int64, error) {
+	res, err := s.querier(ctx).ExecContext(ctx,
+		`UPDATE import_jobs SET status = 'failed', error = $2, finished_at = now()
+		  WHERE status IN ('pending', 'running') AND instance = $1;`, instance, message)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *ImportStorage) FindErrors(ctx context.Context, filter *ErrorsFilterParams) (*ErrorsPagination, error) {
+	limit := filter.Size
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+
+	offset := 0
+	if filter.Page > 0 {
+		offset = (filter.Page - 1) * filter.Size
+	}
+
+	rows, err := s.querier(ctx).QueryContext(ctx,
+		`SELECT row_number, COALESCE(field, ''), message
+		   FROM import_errors
+		  WHERE import_id = $1
+		  ORDER BY row_number
+		  LIMIT $2 OFFSET $3;`, filter.ImportID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query import errors: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]*RowError, 0)
+	for rows.Next() {
+		e := &RowError{}
+		if err := rows.Scan(&e.Row, &e.Field, &e.Message); err != nil {
+			return nil, fmt.Errorf("Cannot read import error: %w", err)
+		}
+		items = append(items, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+	// closed before the count query, which may run on the same connection
+	rows.Close()
+
+	var count int
+	row := s.querier(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM import_errors WHERE import_id = $1;`, filter.ImportID)
+	if err := row.Scan(&count); err != nil {
+		return nil, fmt.Errorf("Items count query error: %w", err)
+	}
+
+	pc := count / limit
+	if count%limit > 0 {
+		pc++
+	}
+
+	return &ErrorsPagination{
+		Page:       filter.Page,
+		Size:       filter.Size,
+		PagesCount: pc,
+		ItemsCount: count,
+		Items:      items,
+	}, nil
+}
@@ -0,0 +1,278 @@
+package kyc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/versioning"
+	"github.com/lenarsaitov/go-task/pkg/bind"
+	"github.com/lenarsaitov/go-task/pkg/blobstore"
+	"github.com/lenarsaitov/go-task/pkg/problem"
+	"github.com/lenarsaitov/go-task/pkg/response"
+	"net/http"
+	"strconv"
+)
+
+type KycHandler struct {
+	service *KycService
+}
+
+func NewKycHandler(service *KycService) *KycHandler {
+	return &KycHandler{service}
+}
+
+var INDENT = "  "
+
+// formOverhead is the room for multipart headers and the other fields of the upload
+// form on top of the size limit of the file.
+const formOverhead = 65536
+
+func (h *KycHandler) Setup(root *echo.Group) {
+	g := root.Group("/users/:id/kyc")
+
+	g.GET("", h.UserKyc)
+	g.POST("/documents", h.UploadDocument)
+
+	admin := root.Group("/admin/kyc/documents")
+
+	admin.GET("", h.ListDocuments)
+	admin.GET("/:id", h.DocumentItem)
+	admin.GET("/:id/file", h.DocumentFile)
+
+	admin.POST("/:id/approve", h.ApproveDocument)
+	admin.POST("/:id/reject", h.RejectDocument)
+}
+
+func (h *KycHandler) UserKyc(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.GetUserKyc(c.Request().Context(), userID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "User Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *KycHandler) UploadDocument(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	// FormFile buffers the whole form, so the body is limited before it is parsed
+	bind.LimitBody(c, h.service.MaxFileSize()+formOverhead)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		if bind.IsTooLarge(err) {
+			return h.HandleError(c, serviceErrorStatus(ErrFileTooLarge), ErrFileTooLarge)
+		}
+		return h.HandleError(c, http.StatusBadRequest, errors.New("Request must be multipart/form-data with document in field file"))
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+	defer file.Close()
+
+	p, err := h.service.UploadDocument(c.Request().Context(), userID, c.FormValue("type"), fileHeader.Filename, file)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "User Not Found")
+	}
+
+	return response.Created(c, response.Location(versioning.Path(c.Request().Context(), "/admin/kyc/documents"), p.DocumentID), p)
+}
+
+func (h *KycHandler) ListDocuments(c echo.Context) error {
+	var sizeInt, pageInt, userIDInt int
+	var err error
+
+	pageStr := c.FormValue("page")
+	sizeStr := c.FormValue("size")
+	userID := c.FormValue("user_id")
+	status := c.FormValue("status")
+
+	if len(pageStr) != 0 {
+		pageInt, err = strconv.Atoi(pageStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+	if len(sizeStr) != 0 {
+		sizeInt, err = strconv.Atoi(sizeStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+	if len(userID) != 0 {
+		userIDInt, err = strconv.Atoi(userID)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+
+	params := &FilterParams{UserID: userIDInt, Status: status, Page: pageInt, Size: sizeInt}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+	p, err := h.service.GetListDocuments(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *KycHandler) DocumentItem(c echo.Context) error {
+	documentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.GetDocument(c.Request().Context(), documentID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *KycHandler) DocumentFile(c echo.Context) error {
+	documentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	doc, file, err := h.service.OpenDocumentFile(c.Request().Context(), documentID)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return problem.NotFound(c, "Document File Not Found")
+	}
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if doc == nil {
+		return problem.NotFound(c, "Not Found")
+	}
+	defer file.Close()
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", doc.FileName))
+	return c.Stream(http.StatusOK, doc.ContentType, file)
+}
+
+func (h *KycHandler) ApproveDocument(c echo.Context) error {
+	documentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	params := &ApproveDocumentRequestParams{DocumentID: documentID}
+	err = bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.ApproveDocument(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *KycHandler) RejectDocument(c echo.Context) error {
+	documentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	params := &RejectDocumentRequestParams{DocumentID: documentID}
+	err = bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.RejectDocument(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *KycHandler) HandleSuccess(c echo.Context, statusCode int, Message string) error {
+	return response.Success(c, statusCode, Message)
+}
+
+func (h *KycHandler) HandleError(c echo.Context, statusCode int, err error) error {
+	return problem.Error(c, statusCode, err, errorCodes)
+}
+
+func serviceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidDocumentType),
+		errors.Is(err, ErrInvalidLevel),
+		errors.Is(err, ErrCommentRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnsupportedContentType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrAlreadyReviewed):
+		return http.StatusConflict
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorCodes are stable codes of the service errors returned in problem details.
+var errorCodes = problem.Codes{
+	auth.ErrUnauthenticated:   "unauthenticated",
+	auth.ErrForbidden:         "forbidden",
+	ErrInvalidDocumentType:    "invalid_document_type",
+	ErrUnsupportedContentType: "unsupported_content_type",
+	ErrFileTooLarge:           "file_too_large",
+	ErrInvalidLevel:           "invalid_kyc_level",
+	ErrCommentRequired:        "comment_required",
+	ErrAlreadyReviewed:        "already_reviewed",
+}
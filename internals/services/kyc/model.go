@@ -0,0 +1,123 @@
+package kyc
+
+import "github.com/lenarsaitov/go-task/internals/config"
+
+type Level string
+
+const (
+	LevelNone  Level = "none"
+	LevelBasic Level = "basic"
+	LevelFull  Level = "full"
+)
+
+var levelRanks = map[Level]int{
+	LevelNone:  0,
+	LevelBasic: 1,
+	LevelFull:  2,
+}
+
+func (l Level) Valid() bool {
+	_, ok := levelRanks[l]
+	return ok
+}
+
+func (l Level) Rank() int {
+	return levelRanks[l]
+}
+
+type DocumentStatus string
+
+const (
+	StatusPending  DocumentStatus = "pending"
+	StatusApproved DocumentStatus = "approved"
+	StatusRejected DocumentStatus = "rejected"
+)
+
+var documentTypes = map[string]bool{
+	"passport":         true,
+	"id_card":          true,
+	"driver_license":   true,
+	"proof_of_address": true,
+	"selfie":           true,
+}
+
+var allowedContentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+type Limits struct {
+	MaxCards    int
+	MaxTransfer int
+}
+
+type LimitsTable map[Level]Limits
+
+func NewLimitsTable(cfg *config.Config) LimitsTable {
+	return LimitsTable{
+		LevelNone:  Limits(cfg.KYC.Limits.None),
+		LevelBasic: Limits(cfg.KYC.Limits.Basic),
+		LevelFull:  Limits(cfg.KYC.Limits.Full),
+	}
+}
+
+func (t LimitsTable) For(level Level) Limits {
+	return t[level]
+}
+
+type DocumentInfo struct {
+	DocumentID    int64          `json:"document_id"`
+	UserID        int64          `json:"user_id"`
+	DocType       string         `json:"doc_type"`
+	Status        DocumentStatus `json:"status"`
+	FileName      string         `json:"file_name"`
+	ContentType   string         `json:"content_type"`
+	Size          int64          `json:"size"`
+	ReviewLevel   Level          `json:"review_level,omitempty"`
+	ReviewComment string         `json:"review_comment,omitempty"`
+	ReviewTime    string         `json:"review_time,omitempty"`
+	CreateTime    string         `json:"create_time"`
+	StorageKey    string         `json:"-"`
+}
+
+type UserKycInfo struct {
+	UserID    int64           `json:"user_id"`
+	Level     Level           `json:"kyc_level"`
+	Documents []*DocumentInfo `json:"documents"`
+}
+
+type Pagination struct {
+	Page       int             `json:"page,omitempty"`
+	Size       int             `json:"size,omitempty"`
+	PagesCount int             `json:"pagesCount"`
+	ItemsCount int             `json:"itemsCount"`
+	Items      []*DocumentInfo `json:"items"`
+}
+
+type FilterParams struct {
+	UserID int
+	Status string
+	Page   int `query:"page" validate:"omitempty,gte=1"`
+	Size   int `query:"size" validate:"omitempty,gte=1,lte=50"`
+}
+
+type UploadDocumentParams struct {
+	UserID      int
+	DocType     string
+	FileName    string
+	ContentType string
+	StorageKey  string
+	Size        int64
+}
+
+type ApproveDocumentRequestParams struct {
+	DocumentID int
+	Level      Level `validate:"oneof=basic full"`
+	Comment    string
+}
+
+type RejectDocumentRequestParams struct {
+	DocumentID int
+	Comment    string `validate:"required"`
+}
@@ -0,0 +1,199 @@
+package kyc
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/pkg/blobstore"
+	"io"
+	"net/http"
+	"path/filepath"
+)
+
+var (
+	ErrInvalidDocumentType    = errors.New("Invalid document type. Allowed: passport, id_card, driver_license, proof_of_address, selfie")
+	ErrUnsupportedContentType = errors.New("Unsupported file type. Allowed: jpeg, png, pdf")
+	ErrFileTooLarge           = errors.New("Document file is too large")
+	ErrInvalidLevel           = errors.New("Invalid KYC level. Allowed: basic, full")
+	ErrCommentRequired        = errors.New("Comment is required when rejecting document")
+	ErrAlreadyReviewed        = errors.New("Document is already reviewed")
+)
+
+type KycService struct {
+	storage     *KycStorage
+	blobs       blobstore.Store
+	maxFileSize int64
+}
+
+func NewKycService(storage *KycStorage, blobs blobstore.Store, maxFileSize int64) *KycService {
+	return &KycService{storage: storage, blobs: blobs, maxFileSize: maxFileSize}
+}
+
+// MaxFileSize is the size limit of document files.
+func (service *KycService) MaxFileSize() int64 {
+	return service.maxFileSize
+}
+
+func (service *KycService) GetUserKyc(c context.Context, userID int) (*UserKycInfo, error) {
+	if err := auth.AuthorizeOwner(c, auth.PermKycRead, int64(userID)); err != nil {
+		return nil, err
+	}
+
+	level, err := service.storage.FindUserLevel(c, userID)
+	if err != nil {
+		return nil, err
+	}
+	if level == nil {
+		return nil, nil
+	}
+
+	docs, err := service.storage.FindManyDocuments(c, &FilterParams{UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserKycInfo{UserID: int64(userID), Level: *level, Documents: docs.Items}, nil
+}
+
+func (service *KycService) GetListDocuments(c context.Context, params *FilterParams) (*Pagination, error) {
+	if err := auth.Authorize(c, auth.PermKycRead); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindManyDocuments(c, params)
+}
+
+func (service *KycService) GetDocument(c context.Context, documentID int) (*DocumentInfo, error) {
+	if err := auth.Authorize(c, auth.PermKycRead); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindDocument(c, documentID)
+}
+
+func (service *KycService) OpenDocumentFile(c context.Context, documentID int) (*DocumentInfo, io.ReadCloser, error) {
+	if err := auth.Authorize(c, auth.PermKycRead); err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := service.storage.FindDocument(c, documentID)
+	if err != nil || doc == nil {
+		return nil, nil, err
+	}
+	if len(doc.StorageKey) == 0 {
+		return nil, nil, blobstore.ErrNotFound
+	}
+
+	r, err := service.blobs.Get(c, doc.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return doc, r, nil
+}
+
+func (service *KycService) UploadDocument(c context.Context, userID int, docType string, fileName string, file io.Reader) (*DocumentInfo, error) {
+	if err := auth.AuthorizeOwner(c, auth.PermUsersWrite, int64(userID)); err != nil {
+		return nil, err
+	}
+	if !documentTypes[docType] {
+		return nil, ErrInvalidDocumentType
+	}
+
+	level, err := service.storage.FindUserLevel(c, userID)
+	if err != nil {
+		return nil, err
+	}
+	if level == nil {
+		return nil, nil
+	}
+
+	br := bufio.NewReaderSize(file, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	contentType := http.DetectContentType(head)
+	ext, ok := allowedContentTypes[contentType]
+	if !ok {
+		return nil, ErrUnsupportedContentType
+	}
+
+	key, err := newStorageKey(userID, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := service.blobs.Put(c, key, io.LimitReader(br, service.maxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot store document: %w", err)
+	}
+	if size > service.maxFileSize {
+		service.blobs.Delete(c, key)
+		return nil, ErrFileTooLarge
+	}
+
+	id, err := service.storage.AddDocumentItem(c, &UploadDocumentParams{
+		UserID:      userID,
+		DocType:     docType,
+		FileName:    filepath.Base(fileName),
+		ContentType: contentType,
+		StorageKey:  key,
+		Size:        size,
+	})
+	if err != nil {
+		service.blobs.Delete(c, key)
+		return nil, err
+	}
+
+	return service.storage.FindDocument(c, int(*id))
+}
+
+func (service *KycService) ApproveDocument(c context.Context, params *ApproveDocumentRequestParams) (bool, error) {
+	if err := auth.Authorize(c, auth.PermKycReview); err != nil {
+		return false, err
+	}
+	if params.Level != LevelBasic && params.Level != LevelFull {
+		return false, ErrInvalidLevel
+	}
+
+	err := service.storage.ApproveDocument(c, params)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (service *KycService) RejectDocument(c context.Context, params *RejectDocumentRequestParams) (bool, error) {
+	if err := auth.Authorize(c, auth.PermKycReview); err != nil {
+		return false, err
+	}
+	if len(params.Comment) == 0 {
+		return false, ErrCommentRequired
+	}
+
+	err := service.storage.RejectDocument(c, params)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func newStorageKey(userID int, ext string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("users/%d/%s%s", userID, hex.EncodeToString(b), ext), nil
+}
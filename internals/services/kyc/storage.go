@@ -0,0 +1,322 @@
+package kyc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lenarsaitov/go-task/internals/services/audit"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"math"
+	"sync/atomic"
+)
+
+type KycStorage struct {
+	db atomic.Value
+}
+
+type QueryResult interface {
+	Scan(dest ...interface{}) error
+}
+
+var (
+	_ QueryResult = &sql.Rows{}
+	_ QueryResult = &sql.Row{}
+)
+
+const documentColumns = `document_id, user_id, doc_type, status, file_name, content_type, size,
+	COALESCE(review_level, ''), COALESCE(review_comment, ''), COALESCE(review_time::text, ''), create_time, storage_key`
+
+// documentSnapshotQuery selects the document for the audit log, see audit.Snapshot.
+const documentSnapshotQuery = `SELECT to_jsonb(kyc_documents) - 'storage_key' FROM kyc_documents WHERE document_id = $1`
+
+func NewKycStorage(db *sqlx.DB) *KycStorage {
+	res := &KycStorage{}
+	res.db.Store(db)
+	return res
+}
+
+func (s *KycStorage) getDB() *sqlx.DB {
+	return s.db.Load().(*sqlx.DB)
+}
+
+// querier returns the connection bound to the tenant of ctx, see tenant.DB.
+func (s *KycStorage) querier(ctx context.Context) tenant.Querier {
+	return tenant.DB(ctx, s.getDB())
+}
+
+func (s *KycStorage) readDocumentInfo(r QueryResult) (*DocumentInfo, error) {
+	d := &DocumentInfo{}
+	err := r.Scan(&d.DocumentID, &d.UserID, &d.DocType, &d.Status, &d.FileName, &d.ContentType, &d.Size,
+		&d.ReviewLevel, &d.ReviewComment, &d.ReviewTime, &d.CreateTime, &d.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (s *KycStorage) FindUserLevel(ctx context.Context, userID int) (*Level, error) {
+	row := s.querier(ctx).QueryRowContext(ctx, `SELECT kyc_level FROM users WHERE user_id = $1 AND tenant_id = $2 AND deleted_at IS NULL;`,
+		userID, tenant.FromContext(ctx))
+
+	var level Level
+	err := row.Scan(&level)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &level, nil
+}
+
+func (s *KycStorage) FindDocument(ctx context.Context, documentID int) (*DocumentInfo, error) {
+	query := `SELECT ` + documentColumns + `
+	          FROM kyc_documents
+	          WHERE document_id = $1 AND user_id IN (SELECT user_id FROM users WHERE tenant_id = $2);`
+
+	row := s.querier(ctx).QueryRowContext(ctx, query, documentID, tenant.FromContext(ctx))
+
+	m, err := s.readDocumentInfo(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (s *KycStorage) buildFindManyWhereClause(b *sqlbuilder.Builder, filter *FilterParams) {
+	if filter.UserID != 0 {
+		b.Where("user_id = ?", filter.UserID)
+	}
+
+	if len(filter.Status) != 0 {
+		b.Where("status = ?", filter.Status)
+	}
+}
+
+func (s *KycStorage) FindManyDocuments(ctx context.Context, filter *FilterParams) (*Pagination, error) {
+	limit := filter.Size
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+
+	offset := 0
+	if filter.Page > 0 {
+		offset = (filter.Page - 1) * filter.Size
+	}
+
+	b := sqlbuilder.New()
+	b.Where("user_id IN (SELECT user_id FROM users WHERE tenant_id = ?)", tenant.FromContext(ctx))
+	s.buildFindManyWhereClause(b, filter)
+	whereClause, whereArgs := b.WhereClause(), b.Args()
+
+	query := `SELECT ` + documentColumns + `
+	          FROM kyc_documents ` + whereClause + `
+	          ORDER BY (document_id)
+	          LIMIT ` + b.Arg(limit) + ` OFFSET ` + b.Arg(offset) + `;`
+
+	rows, err := s.querier(ctx).QueryContext(ctx, query, b.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query documents: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]*DocumentInfo, 0)
+	for rows.Next() {
+		d, err := s.readDocumentInfo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read document info: %w", err)
+		}
+		items = append(items, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	countQuery := `SELECT COUNT(*) FROM kyc_documents ` + whereClause + `;`
+	row := s.querier(ctx).QueryRowContext(ctx, countQuery, whereArgs...)
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return nil, fmt.Errorf("Items count query error: %w", err)
+	}
+
+	pc := count / limit
+	if count%limit > 0 {
+		pc++
+	}
+
+	return &Pagination{
+		Page:       filter.Page,
+		Size:       filter.Size,
+		PagesCount: pc,
+		ItemsCount: count,
+		Items:      items,
+	}, nil
+}
+
+// auditDocument records the change of the document within tx, before is the snapshot taken prior to it.
+func (s *KycStorage) auditDocument(ctx context.Context, tx *sql.Tx, action string, documentID int64, before string) error {
+	after, err := audit.Snapshot(ctx, tx, documentSnapshotQuery, documentID)
+	if err != nil {
+		return err
+	}
+	return audit.Record(ctx, tx, &audit.Change{
+		Action:     action,
+		EntityType: audit.EntityDocument,
+		EntityID:   documentID,
+		Before:     before,
+		After:      after,
+	})
+}
+
+func (s *KycStorage) AddDocumentItem(ctx context.Context, req *UploadDocumentParams) (*int64, error) {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	row := tx.QueryRowContext(ctx,
+		`INSERT INTO kyc_documents
+		              (user_id, doc_type, status, file_name, content_type, size, storage_key)
+		        VALUES ($1, $2, $3, $4, $5, $6, $7)
+		        RETURNING document_id;`,
+		req.UserID, req.DocType, StatusPending, req.FileName, req.ContentType, req.Size, req.StorageKey)
+
+	var documentID int64
+	err = row.Scan(&documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.auditDocument(ctx, tx, audit.ActionCreate, documentID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &documentID, nil
+}
+
+func (s *KycStorage) ApproveDocument(ctx context.Context, req *ApproveDocumentRequestParams) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	docRow := tx.QueryRowContext(ctx, `SELECT user_id, status FROM kyc_documents
+		  WHERE document_id = $1 AND user_id IN (SELECT user_id FROM users WHERE tenant_id = $2)
+		    FOR UPDATE;`, req.DocumentID, tenant.FromContext(ctx))
+	var userID int64
+	var status DocumentStatus
+	err = docRow.Scan(&userID, &status)
+	if err != nil {
+		return err
+	}
+	if status != StatusPending {
+		err = ErrAlreadyReviewed
+		return err
+	}
+
+	userRow := tx.QueryRowContext(ctx, `SELECT kyc_level FROM users WHERE user_id = $1 FOR UPDATE;`, userID)
+	var level Level
+	err = userRow.Scan(&level)
+	if err != nil {
+		return err
+	}
+
+	before, err := audit.Snapshot(ctx, tx, documentSnapshotQuery, req.DocumentID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE kyc_documents
+		    SET status = $2, review_level = $3, review_comment = NULLIF($4, ''), review_time = now()
+		  WHERE document_id = $1;`,
+		req.DocumentID, StatusApproved, req.Level, req.Comment)
+	if err != nil {
+		return err
+	}
+
+	if req.Level.Rank() > level.Rank() {
+		_, err = tx.ExecContext(ctx, `UPDATE users SET kyc_level = $2 WHERE user_id = $1;`, userID, req.Level)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.auditDocument(ctx, tx, audit.ActionApprove, int64(req.DocumentID), before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *KycStorage) RejectDocument(ctx context.Context, req *RejectDocumentRequestParams) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	docRow := tx.QueryRowContext(ctx, `SELECT status FROM kyc_documents
+		  WHERE document_id = $1 AND user_id IN (SELECT user_id FROM users WHERE tenant_id = $2)
+		    FOR UPDATE;`, req.DocumentID, tenant.FromContext(ctx))
+	var status DocumentStatus
+	err = docRow.Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status != StatusPending {
+		err = ErrAlreadyReviewed
+		return err
+	}
+
+	before, err := audit.Snapshot(ctx, tx, documentSnapshotQuery, req.DocumentID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE kyc_documents
+		    SET status = $2, review_comment = $3, review_time = now()
+		  WHERE document_id = $1;`,
+		req.DocumentID, StatusRejected, req.Comment)
+	if err != nil {
+		return err
+	}
+
+	err = s.auditDocument(ctx, tx, audit.ActionReject, int64(req.DocumentID), before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
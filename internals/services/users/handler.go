@@ -4,8 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
 	"github.com/lenarsaitov/go-task/pkg/bind"
+	"github.com/lenarsaitov/go-task/pkg/etag"
+	"github.com/lenarsaitov/go-task/pkg/export"
+	"github.com/lenarsaitov/go-task/pkg/patch"
+	"github.com/lenarsaitov/go-task/pkg/problem"
+	"github.com/lenarsaitov/go-task/pkg/response"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"github.com/lenarsaitov/go-task/pkg/validate"
 	"net/http"
+	"path"
 	"strconv"
 )
 
@@ -17,16 +26,6 @@ func NewUserHandler(service *UserService) *UserHandler {
 	return &UserHandler{service}
 }
 
-type StatusResponse string
-
-var OK StatusResponse = "OK"
-var Error StatusResponse = "Error"
-
-type Response struct {
-	Status  StatusResponse `json:"status"`
-	Message string         `json:"message,omitempty"`
-}
-
 var INDENT = "  "
 
 func (h *UserHandler) Setup(root *echo.Group) {
@@ -38,7 +37,24 @@ func (h *UserHandler) Setup(root *echo.Group) {
 	g.POST("", h.AddUserItem)
 
 	g.PUT("/:id", h.UpdateUserItem)
+	g.PATCH("/:id", h.PatchUserItem)
 	g.DELETE("/:id", h.DeleteUserItem)
+	g.POST("/:id/restore", h.RestoreUserItem)
+	g.POST("/:id/merge", h.MergeUserItem)
+
+	g.GET("/:id/export", h.ExportUserItem)
+	g.POST("/:id/erase", h.EraseUserItem)
+
+	g.GET("/:id/2fa", h.TwoFactorItem)
+	g.POST("/:id/2fa", h.EnrollTwoFactor)
+	g.POST("/:id/2fa/confirm", h.ConfirmTwoFactor)
+	g.DELETE("/:id/2fa", h.DisableTwoFactor)
+}
+
+// SetupExport registers the streaming export, which outlasts the write timeout of
+// the other routes and may be served by a server of its own.
+func (h *UserHandler) SetupExport(root *echo.Group) {
+	root.GET("/users/export", h.ExportUsers)
 }
 
 func (h *UserHandler) UserItem(c echo.Context) error {
@@ -47,23 +63,50 @@ func (h *UserHandler) UserItem(c echo.Context) error {
 		return h.HandleError(c, http.StatusBadRequest, err)
 	}
 
-	p, err := h.service.GetUser(c.Request().Context(), userID)
+	var includeDeleted bool
+	if includeDeletedStr := c.FormValue("include_deleted"); len(includeDeletedStr) != 0 {
+		includeDeleted, err = strconv.ParseBool(includeDeletedStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+
+	p, err := h.service.GetUser(c.Request().Context(), userID, includeDeleted)
 	if err != nil {
-		return h.HandleError(c, http.StatusInternalServerError, err)
+		return h.HandleError(c, serviceErrorStatus(err), err)
 	}
 	if p == nil {
-		return c.JSON(http.StatusNotFound, Response{Status: OK, Message: "Not Found"})
+		targetID, err := h.service.GetMergeTarget(c.Request().Context(), userID)
+		if err != nil {
+			return h.HandleError(c, serviceErrorStatus(err), err)
+		}
+		if targetID != nil {
+			u := *c.Request().URL
+			u.Path = path.Join(path.Dir(u.Path), strconv.FormatInt(*targetID, 10))
+			return c.Redirect(http.StatusMovedPermanently, u.RequestURI())
+		}
+		return problem.NotFound(c, "Not Found")
+	}
+
+	if ok, err := etag.NotModified(c, p.ETag()); ok {
+		return err
 	}
 
 	return c.JSONPretty(http.StatusOK, p, INDENT)
 }
 
 func (h *UserHandler) ListUsers(c echo.Context) error {
-	var sizeInt, pageInt int
-	var err error
+	var sizeInt, pageInt, limitInt int
+	var withCount bool
 	pageStr := c.FormValue("page")
 	sizeStr := c.FormValue("size")
-	userName := c.FormValue("user_name")
+	limitStr := c.FormValue("limit")
+	countStr := c.FormValue("count")
+
+	params, err := filterParams(c)
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
 
 	if len(pageStr) != 0 {
 		pageInt, err = strconv.Atoi(pageStr)
@@ -77,17 +120,114 @@ func (h *UserHandler) ListUsers(c echo.Context) error {
 			return h.HandleError(c, http.StatusBadRequest, err)
 		}
 	}
+	if len(limitStr) != 0 {
+		limitInt, err = strconv.Atoi(limitStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+	if len(countStr) != 0 {
+		withCount, err = strconv.ParseBool(countStr)
+		if err != nil {
+			return h.HandleError(c, http.StatusBadRequest, err)
+		}
+	}
+
+	params.Page = pageInt
+	params.Size = sizeInt
+	params.After = c.FormValue("after")
+	params.Limit = limitInt
+	params.WithCount = withCount
 
-	params := &FilterParams{UserName: userName, Page: pageInt, Size: sizeInt}
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
 
 	p, err := h.service.GetListUsers(c.Request().Context(), params)
 	if err != nil {
-		return h.HandleError(c, http.StatusInternalServerError, err)
+		return h.HandleError(c, serviceErrorStatus(err), err)
 	}
 
 	return c.JSONPretty(http.StatusOK, p, INDENT)
 }
 
+// ExportUsers streams all users matching the filters of ListUsers as NDJSON or CSV.
+func (h *UserHandler) ExportUsers(c echo.Context) error {
+	params, err := filterParams(c)
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	w, err := export.NewWriter(c, c.FormValue("format"), "users", ExportColumns)
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	err = w.Close(h.service.ExportUsers(c.Request().Context(), params, func(u *UserInfo) error {
+		return w.Write(u)
+	}))
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	return nil
+}
+
+// filterParams reads the filters shared by ListUsers and ExportUsers.
+func filterParams(c echo.Context) (*FilterParams, error) {
+	var includeDeleted bool
+	var err error
+	if includeDeletedStr := c.FormValue("include_deleted"); len(includeDeletedStr) != 0 {
+		includeDeleted, err = strconv.ParseBool(includeDeletedStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	createdFrom, err := bind.ParseTimeFrom("created_from", c.FormValue("created_from"))
+	if err != nil {
+		return nil, err
+	}
+	createdTo, err := bind.ParseTimeTo("created_to", c.FormValue("created_to"))
+	if err != nil {
+		return nil, err
+	}
+	balanceMin, err := bind.ParseOptionalInt64("balance_min", c.FormValue("balance_min"))
+	if err != nil {
+		return nil, err
+	}
+	balanceMax, err := bind.ParseOptionalInt64("balance_max", c.FormValue("balance_max"))
+	if err != nil {
+		return nil, err
+	}
+	birthDate, err := bind.ParseDate("birth_date", c.FormValue("birth_date"))
+	if err != nil {
+		return nil, err
+	}
+	sort, err := sqlbuilder.ParseSort(c.FormValue("sort"), SortableFields)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FilterParams{
+		Query:          c.FormValue("q"),
+		UserName:       c.FormValue("user_name"),
+		FirstName:      c.FormValue("first_name"),
+		LastName:       c.FormValue("last_name"),
+		Email:          c.FormValue("email"),
+		Phone:          c.FormValue("phone"),
+		BirthDate:      birthDate,
+		CreatedFrom:    createdFrom,
+		CreatedTo:      createdTo,
+		BalanceMin:     balanceMin,
+		BalanceMax:     balanceMax,
+		Sort:           sort,
+		IncludeDeleted: includeDeleted,
+	}, nil
+}
+
 func (h *UserHandler) AddUserItem(c echo.Context) error {
 	params := &AddUserRequestParams{}
 	err := bind.DecodeJSONBody(c, params)
@@ -99,15 +239,19 @@ func (h *UserHandler) AddUserItem(c echo.Context) error {
 		return h.HandleError(c, http.StatusInternalServerError, err)
 	}
 
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
 	p, err := h.service.AddUser(c.Request().Context(), params)
 	if err != nil {
-		return h.HandleError(c, http.StatusInternalServerError, err)
+		return h.HandleError(c, serviceErrorStatus(err), err)
 	}
 	if p == nil {
-		return c.JSON(http.StatusNotFound, Response{Status: "Error", Message: "Not Found"})
+		return problem.NotFound(c, "Not Found")
 	}
 
-	return h.HandleSuccess(c, http.StatusOK, fmt.Sprintf("Successfully added. User ID: %d", *p))
+	return response.Created(c, response.Location(c.Request().URL.Path, p.UserID), p)
 }
 
 func (h *UserHandler) UpdateUserItem(c echo.Context) error {
@@ -116,7 +260,12 @@ func (h *UserHandler) UpdateUserItem(c echo.Context) error {
 		return h.HandleError(c, http.StatusBadRequest, err)
 	}
 
-	params := &UpdateUserRequestParams{UserID: userID}
+	ifMatch, err := etag.IfMatch(c)
+	if err != nil {
+		return h.HandleError(c, http.StatusPreconditionRequired, err)
+	}
+
+	params := &UpdateUserRequestParams{UserID: userID, IfMatch: ifMatch}
 	err = bind.DecodeJSONBody(c, params)
 	if err != nil {
 		var mr *bind.MalformedRequest
@@ -126,14 +275,52 @@ func (h *UserHandler) UpdateUserItem(c echo.Context) error {
 		return h.HandleError(c, http.StatusInternalServerError, err)
 	}
 
-	p, err := h.service.UpdateUser(c.Request().Context(), params)
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	tag, err := h.service.UpdateUser(c.Request().Context(), params)
 	if err != nil {
-		return h.HandleError(c, http.StatusInternalServerError, err)
+		return h.HandleError(c, serviceErrorStatus(err), err)
 	}
-	if p == false {
-		return c.JSON(http.StatusNotFound, Response{Status: "Error", Message: "Not Found"})
+	if len(tag) == 0 {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	c.Response().Header().Set(etag.HeaderETag, tag)
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *UserHandler) PatchUserItem(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	body, err := bind.ReadBody(c)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	params := &PatchUserRequestParams{
+		UserID:      userID,
+		IfMatch:     c.Request().Header.Get(etag.HeaderIfMatch),
+		ContentType: patch.ContentType(c.Request()),
+		Patch:       body,
+	}
+	tag, err := h.service.PatchUser(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if len(tag) == 0 {
+		return problem.NotFound(c, "Not Found")
 	}
 
+	c.Response().Header().Set(etag.HeaderETag, tag)
 	return h.HandleSuccess(c, http.StatusOK, "")
 }
 
@@ -145,19 +332,269 @@ func (h *UserHandler) DeleteUserItem(c echo.Context) error {
 
 	p, err := h.service.DeleteUser(c.Request().Context(), userID)
 	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *UserHandler) RestoreUserItem(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.RestoreUser(c.Request().Context(), userID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "Deleted User Not Found")
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *UserHandler) MergeUserItem(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	params := &MergeUserRequestParams{TargetUserID: userID}
+	err = bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
 		return h.HandleError(c, http.StatusInternalServerError, err)
 	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.MergeUsers(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *UserHandler) ExportUserItem(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.ExportUser(c.Request().Context(), userID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	if c.FormValue("format") == "json" {
+		return c.JSONPretty(http.StatusOK, p, INDENT)
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/zip")
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"user-%d-export.zip\"", userID))
+	res.WriteHeader(http.StatusOK)
+
+	return h.service.WriteExportArchive(c.Request().Context(), p, res)
+}
+
+func (h *UserHandler) EraseUserItem(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.EraseUser(c.Request().Context(), userID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
 	if p == false {
-		return c.JSON(http.StatusNotFound, Response{Status: "Error", Message: "Not Found"})
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "")
+}
+
+func (h *UserHandler) TwoFactorItem(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.GetTwoFactor(c.Request().Context(), userID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *UserHandler) EnrollTwoFactor(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.EnrollTwoFactor(c.Request().Context(), userID)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == nil {
+		return problem.NotFound(c, "Not Found")
+	}
+
+	return c.JSONPretty(http.StatusOK, p, INDENT)
+}
+
+func (h *UserHandler) ConfirmTwoFactor(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	params := &TwoFactorCodeRequestParams{UserID: userID}
+	err = bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.ConfirmTwoFactor(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+
+	return h.HandleSuccess(c, http.StatusOK, "Two-factor authentication is enabled")
+}
+
+func (h *UserHandler) DisableTwoFactor(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	params := &TwoFactorCodeRequestParams{UserID: userID}
+	err = bind.DecodeJSONBody(c, params)
+	if err != nil {
+		var mr *bind.MalformedRequest
+		if errors.As(err, &mr) {
+			return h.HandleError(c, mr.Status, err)
+		}
+		return h.HandleError(c, http.StatusInternalServerError, err)
+	}
+
+	if err = c.Validate(params); err != nil {
+		return h.HandleError(c, http.StatusBadRequest, err)
+	}
+
+	p, err := h.service.DisableTwoFactor(c.Request().Context(), params)
+	if err != nil {
+		return h.HandleError(c, serviceErrorStatus(err), err)
+	}
+	if p == false {
+		return problem.NotFound(c, "Two-factor Authentication Not Found")
 	}
 
 	return h.HandleSuccess(c, http.StatusOK, "")
 }
 
 func (h *UserHandler) HandleSuccess(c echo.Context, statusCode int, Message string) error {
-	return c.JSONPretty(statusCode, Response{Status: OK, Message: Message}, INDENT)
+	return response.Success(c, statusCode, Message)
 }
 
 func (h *UserHandler) HandleError(c echo.Context, statusCode int, err error) error {
-	return c.JSONPretty(statusCode, Response{Status: Error, Message: fmt.Sprintf("%s", err)}, INDENT)
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return problem.Validation(c, validate.Errors{{Field: ve.Field, Rule: "invalid", Message: ve.Msg}})
+	}
+	return problem.Error(c, statusCode, err, errorCodes)
+}
+
+func serviceErrorStatus(err error) int {
+	var ve *ValidationError
+	var verr validate.Errors
+	var mr *bind.MalformedRequest
+	switch {
+	case errors.As(err, &ve),
+		errors.As(err, &verr):
+		return http.StatusBadRequest
+	case errors.As(err, &mr):
+		return mr.Status
+	case errors.Is(err, sqlbuilder.ErrInvalidCursor),
+		errors.Is(err, patch.ErrInvalid),
+		errors.Is(err, ErrMergeSelf),
+		errors.Is(err, ErrUnknownTenant):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrMergeSource):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrForbidden),
+		errors.Is(err, ErrInvalidCode):
+		return http.StatusForbidden
+	case errors.Is(err, ErrAlreadyExists),
+		errors.Is(err, ErrHasCards),
+		errors.Is(err, ErrErased),
+		errors.Is(err, ErrMerged),
+		errors.Is(err, ErrTwoFactorEnabled),
+		errors.Is(err, ErrTwoFactorNotEnrolled),
+		errors.Is(err, patch.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, patch.ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, etag.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorCodes are stable codes of the service errors returned in problem details.
+var errorCodes = problem.Codes{
+	sqlbuilder.ErrInvalidCursor:  "invalid_cursor",
+	auth.ErrUnauthenticated:      "unauthenticated",
+	auth.ErrForbidden:            "forbidden",
+	ErrAlreadyExists:             "already_exists",
+	ErrErased:                    "user_erased",
+	ErrMerged:                    "user_merged",
+	ErrMergeSelf:                 "merge_into_itself",
+	ErrMergeSource:               "merge_source_not_found",
+	ErrUnknownTenant:             "unknown_tenant",
+	ErrHasCards:                  "user_has_cards",
+	export.ErrUnsupportedFormat:  "unsupported_export_format",
+	ErrTwoFactorEnabled:          "two_factor_enabled",
+	ErrTwoFactorNotEnrolled:      "two_factor_not_enrolled",
+	ErrInvalidCode:               "invalid_two_factor_code",
+	etag.ErrPreconditionRequired: "precondition_required",
+	etag.ErrPreconditionFailed:   "precondition_failed",
+	patch.ErrUnsupportedType:     "unsupported_patch_type",
+	patch.ErrInvalid:             "invalid_patch",
+	patch.ErrConflict:            "patch_conflict",
 }
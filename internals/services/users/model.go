@@ -1,9 +1,85 @@
 package users
 
+import (
+	"github.com/lenarsaitov/go-task/pkg/etag"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"strconv"
+	"time"
+)
+
+const DefaultCursorLimit = 20
+
+// SortableFields maps sort parameter names to SQL expressions. Nullable columns are
+// coalesced, since keyset pagination can't compare NULLs.
+var SortableFields = map[string]string{
+	"user_id":        "user_id",
+	"user_full_name": "user_full_name",
+	"first_name":     "COALESCE(first_name, '')",
+	"last_name":      "COALESCE(last_name, '')",
+	"email":          "COALESCE(email, '')",
+	"birth_date":     "COALESCE(birth_date, DATE '0001-01-01')",
+	"create_time":    "create_time",
+}
+
 type UserInfo struct {
-	UserID     int64  `json:"user_id"`
-	UserName   string `json:"user_full_name"`
-	CreateTime string `json:"create_time"`
+	UserID     int64          `json:"user_id"`
+	UserName   string         `json:"user_full_name"`
+	FirstName  string         `json:"first_name,omitempty"`
+	LastName   string         `json:"last_name,omitempty"`
+	MiddleName string         `json:"middle_name,omitempty"`
+	Email      string         `json:"email,omitempty"`
+	Phone      string         `json:"phone,omitempty"`
+	BirthDate  string         `json:"birth_date,omitempty"`
+	Addresses  []*AddressInfo `json:"addresses,omitempty"`
+	KycLevel   string         `json:"kyc_level"`
+	CreateTime string         `json:"create_time"`
+	DeletedAt  string         `json:"deleted_at,omitempty"`
+	ErasedAt   string         `json:"erased_at,omitempty"`
+	// Version is incremented by the database on every update of the row.
+	Version int64 `json:"-"`
+}
+
+// ETag identifies the representation for conditional requests.
+func (u *UserInfo) ETag() string {
+	return userTag(u.Version)
+}
+
+// Profile returns the editable fields of the user, the document PATCH applies to.
+func (u *UserInfo) Profile() *UserProfile {
+	return &UserProfile{
+		UserName:   u.UserName,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		MiddleName: u.MiddleName,
+		Email:      u.Email,
+		Phone:      u.Phone,
+		BirthDate:  u.BirthDate,
+		Addresses:  u.Addresses,
+	}
+}
+
+// ExportColumns are the columns of CSV exports, in the order of UserInfo.CSV.
+var ExportColumns = []string{"user_id", "user_full_name", "first_name", "last_name", "middle_name",
+	"email", "phone", "birth_date", "kyc_level", "create_time", "deleted_at", "erased_at"}
+
+// CSV returns the values of ExportColumns.
+func (u *UserInfo) CSV() []string {
+	return []string{strconv.FormatInt(u.UserID, 10), u.UserName, u.FirstName, u.LastName, u.MiddleName,
+		u.Email, u.Phone, u.BirthDate, u.KycLevel, u.CreateTime, u.DeletedAt, u.ErasedAt}
+}
+
+func userTag(version int64) string {
+	return etag.Tag(version)
+}
+
+type AddressInfo struct {
+	AddressID  int64  `json:"address_id,omitempty"`
+	Kind       string `json:"kind"`
+	Country    string `json:"country"`
+	Region     string `json:"region,omitempty"`
+	City       string `json:"city"`
+	Street     string `json:"street"`
+	PostalCode string `json:"postal_code"`
 }
 
 type CardInfo struct {
@@ -14,25 +90,138 @@ type CardInfo struct {
 	CreateTime string `json:"create_time"`
 }
 
+type CardHistoryInfo struct {
+	HistoryID          int64  `json:"history_id"`
+	CardID             int64  `json:"card_id"`
+	Operation          string `json:"operation"`
+	Amount             int64  `json:"amount"`
+	BalanceAfter       int64  `json:"balance_after"`
+	CounterpartyCardID *int64 `json:"counterparty_card_id,omitempty"`
+	CreateTime         string `json:"create_time"`
+}
+
+type KycDocumentInfo struct {
+	DocumentID  int64  `json:"document_id"`
+	DocType     string `json:"doc_type"`
+	Status      string `json:"status"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	CreateTime  string `json:"create_time"`
+	StorageKey  string `json:"-"`
+}
+
+// PersonalDataExport is everything stored about a user, as returned by GET /users/:id/export.
+type PersonalDataExport struct {
+	ExportTime   string             `json:"export_time"`
+	User         *UserInfo          `json:"user"`
+	Cards        []*CardInfo        `json:"cards"`
+	History      []*CardHistoryInfo `json:"history"`
+	KycDocuments []*KycDocumentInfo `json:"kyc_documents"`
+}
+
 type Pagination struct {
 	Page       int         `json:"page,omitempty"`
 	Size       int         `json:"size,omitempty"`
-	PagesCount int         `json:"pagesCount"`
-	ItemsCount int         `json:"itemsCount"`
+	Limit      int         `json:"limit,omitempty"`
+	PagesCount *int        `json:"pagesCount,omitempty"`
+	ItemsCount *int        `json:"itemsCount,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
 	Items      []*UserInfo `json:"items"`
 }
 
 type FilterParams struct {
-	UserName string
-	Page     int `validate:"gte=1"`
-	Size     int `validate:"gte=1,lte=50"`
+	UserID         int
+	Query          string
+	UserName       string
+	FirstName      string
+	LastName       string
+	Email          string
+	Phone          string
+	BirthDate      string
+	CreatedFrom    time.Time
+	CreatedTo      time.Time
+	BalanceMin     *int64
+	BalanceMax     *int64
+	Sort           []sqlbuilder.SortField
+	IncludeDeleted bool
+	Page           int `query:"page" validate:"omitempty,gte=1"`
+	Size           int `query:"size" validate:"omitempty,gte=1,lte=50"`
+	After          string
+	Limit          int `query:"limit" validate:"omitempty,gte=1,lte=50"`
+	WithCount      bool
+}
+
+// CursorMode reports whether keyset pagination (after/limit) is requested
+// instead of page/size.
+func (f *FilterParams) CursorMode() bool {
+	return len(f.After) != 0 || f.Limit > 0
+}
+
+type UserProfile struct {
+	UserName   string         `json:"username"`
+	FirstName  string         `json:"first_name"`
+	LastName   string         `json:"last_name"`
+	MiddleName string         `json:"middle_name"`
+	Email      string         `json:"email"`
+	Phone      string         `json:"phone"`
+	BirthDate  string         `json:"birth_date"`
+	Addresses  []*AddressInfo `json:"addresses"`
 }
 
 type AddUserRequestParams struct {
-	UserName string
+	UserProfile
 }
 
 type UpdateUserRequestParams struct {
-	UserID   int
-	UserName string
+	UserID int
+	// IfMatch is the If-Match header, the update is refused unless it matches the ETag.
+	// Empty updates unconditionally, as gRPC offers; REST handlers require the header.
+	IfMatch string `json:"-"`
+	UserProfile
+}
+
+// PatchUserRequestParams holds a merge patch or a JSON Patch of the profile, see
+// UserInfo.Profile. IfMatch is optional, the patch is applied to the current
+// profile anyway.
+type PatchUserRequestParams struct {
+	UserID      int
+	IfMatch     string
+	ContentType string
+	Patch       []byte
+}
+
+type MergeUserRequestParams struct {
+	TargetUserID int
+	SourceUserID int `json:"source_user_id" validate:"gte=1"`
+}
+
+// MergeInfo records that SourceUserID was merged into TargetUserID. The record
+// outlives the source user so that its old id keeps redirecting to the survivor.
+type MergeInfo struct {
+	MergeID        int64  `json:"merge_id"`
+	SourceUserID   int64  `json:"source_user_id"`
+	TargetUserID   int64  `json:"target_user_id"`
+	CardsMoved     int64  `json:"cards_moved"`
+	DocumentsMoved int64  `json:"documents_moved"`
+	CreateTime     string `json:"create_time"`
+}
+
+// TwoFactorInfo is the state of TOTP second factor of a user. It is enabled once
+// the enrollment is confirmed with a code.
+type TwoFactorInfo struct {
+	UserID      int    `json:"user_id"`
+	Enabled     bool   `json:"enabled"`
+	CreateTime  string `json:"create_time,omitempty"`
+	ConfirmedAt string `json:"confirmed_at,omitempty"`
+}
+
+// TwoFactorEnrollment is returned once on enrollment, the secret is never shown again.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type TwoFactorCodeRequestParams struct {
+	UserID int
+	Code   string `json:"code" validate:"required"`
 }
@@ -1,49 +1,164 @@
 package users
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/services/imports"
+	"github.com/lenarsaitov/go-task/pkg/bind"
+	"github.com/lenarsaitov/go-task/pkg/blobstore"
+	"github.com/lenarsaitov/go-task/pkg/etag"
+	"github.com/lenarsaitov/go-task/pkg/logging"
+	"github.com/lenarsaitov/go-task/pkg/patch"
+	"github.com/lenarsaitov/go-task/pkg/totp"
+	"github.com/lenarsaitov/go-task/pkg/validate"
+	"io"
+	"path"
+	"time"
 )
 
+// totpSkew is the number of time steps before and after the current one in which
+// codes are accepted, to tolerate clock drift of the user's device.
+const totpSkew = 1
+
 type UserService struct {
-	storage *UserStorage
+	storage    *UserStorage
+	blobs      blobstore.Store
+	totpIssuer string
+}
+
+// NewUserService creates the service. totpIssuer names the service in authenticator apps.
+func NewUserService(storage *UserStorage, blobs blobstore.Store, totpIssuer string) *UserService {
+	return &UserService{storage: storage, blobs: blobs, totpIssuer: totpIssuer}
 }
 
-func NewUserService(storage *UserStorage) *UserService {
-	return &UserService{storage: storage}
+func (service *UserService) GetUser(c context.Context, userID int, includeDeleted bool) (*UserInfo, error) {
+	if err := auth.AuthorizeOwner(c, auth.PermUsersRead, int64(userID)); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindOne(c, userID, includeDeleted)
 }
 
-func (service *UserService) GetUser(c context.Context, userID int) (*UserInfo, error) {
-	return service.storage.FindOne(c, userID)
+// GetUsers returns the users with the ids, keyed by id, like GetUser does one by one.
+func (service *UserService) GetUsers(c context.Context, userIDs []int64) (map[int64]*UserInfo, error) {
+	if err := auth.AuthorizeOwners(c, auth.PermUsersRead, userIDs); err != nil {
+		return nil, err
+	}
+
+	return service.storage.FindByIDs(c, userIDs)
 }
 
+// GetListUsers lists all users for callers with users:read, other callers only see themselves.
 func (service *UserService) GetListUsers(c context.Context, params *FilterParams) (*Pagination, error) {
+	ownerID, err := auth.OwnerScope(c, auth.PermUsersRead)
+	if err != nil {
+		return nil, err
+	}
+	params.UserID = int(ownerID)
+
 	return service.storage.FindMany(c, params)
 }
 
-func (service *UserService) AddUser(c context.Context, params *AddUserRequestParams) (*int64, error) {
-	return service.storage.AddUserItem(c, params)
+// ExportUsers passes the users matching the filter to fn one by one, in the scope of
+// GetListUsers. Paging parameters are ignored.
+func (service *UserService) ExportUsers(c context.Context, params *FilterParams, fn func(*UserInfo) error) error {
+	ownerID, err := auth.OwnerScope(c, auth.PermUsersRead)
+	if err != nil {
+		return err
+	}
+	params.UserID = int(ownerID)
+
+	return service.storage.ExportMany(c, params, fn)
 }
 
-func (service *UserService) UpdateUser(c context.Context, params *UpdateUserRequestParams) (bool, error) {
-	err := service.storage.UpdateUserItem(c, params)
+func (service *UserService) AddUser(c context.Context, params *AddUserRequestParams) (*UserInfo, error) {
+	if err := auth.Authorize(c, auth.PermUsersWrite); err != nil {
+		return nil, err
+	}
+	if err := normalizeProfile(&params.UserProfile); err != nil {
+		return nil, err
+	}
+
+	id, err := service.storage.AddUserItem(c, params)
+	if err != nil || id == nil {
+		return nil, err
+	}
+
+	return service.storage.FindOne(c, int(*id), false)
+}
+
+// UpdateUser returns the new ETag of the user, empty if the user is not found.
+func (service *UserService) UpdateUser(c context.Context, params *UpdateUserRequestParams) (string, error) {
+	if err := auth.AuthorizeOwner(c, auth.PermUsersWrite, int64(params.UserID)); err != nil {
+		return "", err
+	}
+	if err := normalizeProfile(&params.UserProfile); err != nil {
+		return "", err
+	}
+
+	tag, err := service.storage.UpdateUserItem(c, params)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return false, nil
+			return "", nil
 		}
-		return false, err
+		return "", err
 	}
-	return true, nil
+	return tag, nil
+}
+
+// PatchUser applies the patch to the current profile of the user and updates it
+// with the result like UpdateUser. The update is conditional on the ETag the patch
+// was applied to, so that a concurrent change makes it fail instead of being lost.
+func (service *UserService) PatchUser(c context.Context, params *PatchUserRequestParams) (string, error) {
+	if err := auth.AuthorizeOwner(c, auth.PermUsersWrite, int64(params.UserID)); err != nil {
+		return "", err
+	}
+
+	u, err := service.storage.FindOne(c, params.UserID, false)
+	if err != nil || u == nil {
+		return "", err
+	}
+	if len(params.IfMatch) != 0 && !etag.StrongMatch(params.IfMatch, u.ETag()) {
+		return "", etag.ErrPreconditionFailed
+	}
+
+	doc, err := json.Marshal(u.Profile())
+	if err != nil {
+		return "", err
+	}
+	doc, err = patch.Apply(params.ContentType, doc, params.Patch)
+	if err != nil {
+		return "", err
+	}
+
+	profile := &UserProfile{}
+	if err = bind.DecodeJSON(bytes.NewReader(doc), profile); err != nil {
+		return "", err
+	}
+	update := &UpdateUserRequestParams{UserID: params.UserID, IfMatch: u.ETag(), UserProfile: *profile}
+	if err = validate.Struct(update); err != nil {
+		return "", err
+	}
+
+	return service.UpdateUser(c, update)
 }
 
 func (service *UserService) DeleteUser(c context.Context, userID int) (bool, error) {
+	if err := auth.Authorize(c, auth.PermUsersWrite); err != nil {
+		return false, err
+	}
 	isExist, err := service.storage.isExistCards(c, userID)
 	if err != nil {
 		return false, err
 	}
 	if isExist {
-		return false, errors.New("Cant delete user, because exist his cards")
+		return false, ErrHasCards
 	}
 
 	err = service.storage.DeleteUserItem(c, userID)
@@ -55,3 +170,333 @@ func (service *UserService) DeleteUser(c context.Context, userID int) (bool, err
 	}
 	return true, nil
 }
+
+func (service *UserService) RestoreUser(c context.Context, userID int) (bool, error) {
+	if err := auth.Authorize(c, auth.PermUsersWrite); err != nil {
+		return false, err
+	}
+	err := service.storage.RestoreUserItem(c, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (service *UserService) PurgeDeleted(c context.Context, before time.Time) (int64, error) {
+	return service.storage.PurgeDeleted(c, before)
+}
+
+func (service *UserService) ExportUser(c context.Context, userID int) (*PersonalDataExport, error) {
+	if err := auth.AuthorizeOwner(c, auth.PermUsersRead, int64(userID)); err != nil {
+		return nil, err
+	}
+	return service.storage.ExportPersonalData(c, userID)
+}
+
+// WriteExportArchive writes export as a zip archive with one JSON file per kind of data
+// and the original KYC document files.
+func (service *UserService) WriteExportArchive(c context.Context, export *PersonalDataExport, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"user.json", export.User},
+		{"cards.json", export.Cards},
+		{"history.json", export.History},
+		{"kyc_documents.json", export.KycDocuments},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", INDENT)
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range export.KycDocuments {
+		if len(d.StorageKey) == 0 {
+			continue
+		}
+		if err := service.writeDocument(c, zw, d); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func (service *UserService) writeDocument(c context.Context, zw *zip.Writer, d *KycDocumentInfo) error {
+	r, err := service.blobs.Get(c, d.StorageKey)
+	if err != nil {
+		if errors.Is(err, blobstore.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	defer r.Close()
+
+	fw, err := zw.Create(fmt.Sprintf("documents/%d_%s", d.DocumentID, path.Base(d.FileName)))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func (service *UserService) EraseUser(c context.Context, userID int) (bool, error) {
+	if err := auth.AuthorizeOwner(c, auth.PermUsersWrite, int64(userID)); err != nil {
+		return false, err
+	}
+	keys, err := service.storage.ErasePersonalData(c, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	logger := logging.GetLogger()
+	for _, key := range keys {
+		if err := service.blobs.Delete(c, key); err != nil {
+			logger.Errorf("failed to delete document %s of erased user %d: %s", key, userID, err)
+		}
+	}
+
+	return true, nil
+}
+
+func (service *UserService) MergeUsers(c context.Context, params *MergeUserRequestParams) (*MergeInfo, error) {
+	if err := auth.Authorize(c, auth.PermUsersWrite); err != nil {
+		return nil, err
+	}
+	if params.SourceUserID <= 0 {
+		return nil, &ValidationError{Field: "source_user_id", Msg: "is required"}
+	}
+	if params.SourceUserID == params.TargetUserID {
+		return nil, ErrMergeSelf
+	}
+
+	m, err := service.storage.MergeUsers(c, params)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetMergeTarget returns the user the user was merged into, nil if it wasn't merged.
+// It is authorized like GetUser, which callers try first.
+func (service *UserService) GetMergeTarget(c context.Context, userID int) (*int64, error) {
+	if err := auth.AuthorizeOwner(c, auth.PermUsersRead, int64(userID)); err != nil {
+		return nil, err
+	}
+	return service.storage.FindMergeTarget(c, userID)
+}
+
+func (service *UserService) GetTwoFactor(c context.Context, userID int) (*TwoFactorInfo, error) {
+	if err := auth.AuthorizeOwner(c, auth.PermUsersRead, int64(userID)); err != nil {
+		return nil, err
+	}
+	return service.storage.FindTwoFactor(c, userID)
+}
+
+// EnrollTwoFactor generates a TOTP secret for the user. The second factor is enabled
+// only after ConfirmTwoFactor with a code from the authenticator app.
+func (service *UserService) EnrollTwoFactor(c context.Context, userID int) (*TwoFactorEnrollment, error) {
+	if err := auth.AuthorizeSelf(c, int64(userID)); err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = service.storage.AddTwoFactor(c, userID, secret)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(service.totpIssuer, fmt.Sprintf("user-%d", userID), secret),
+	}, nil
+}
+
+func (service *UserService) ConfirmTwoFactor(c context.Context, params *TwoFactorCodeRequestParams) error {
+	if err := auth.AuthorizeSelf(c, int64(params.UserID)); err != nil {
+		return err
+	}
+
+	secret, _, confirmed, err := service.storage.findTwoFactorSecret(c, params.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrTwoFactorNotEnrolled
+		}
+		return err
+	}
+	if confirmed {
+		return ErrTwoFactorEnabled
+	}
+
+	step, ok := totp.Validate(secret, params.Code, time.Now(), totpSkew)
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	err = service.storage.ConfirmTwoFactor(c, params.UserID, step)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrTwoFactorEnabled
+		}
+		return err
+	}
+	return nil
+}
+
+// DisableTwoFactor removes the second factor. The user has to confirm it with a
+// current code, while callers with users:write may reset it for a user who lost the device.
+func (service *UserService) DisableTwoFactor(c context.Context, params *TwoFactorCodeRequestParams) (bool, error) {
+	if err := auth.AuthorizeOwner(c, auth.PermUsersWrite, int64(params.UserID)); err != nil {
+		return false, err
+	}
+
+	var usedStep *int64
+	if !auth.PrincipalFromContext(c).Can(auth.PermUsersWrite) {
+		secret, lastStep, confirmed, err := service.storage.findTwoFactorSecret(c, params.UserID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return false, nil
+			}
+			return false, err
+		}
+		if confirmed {
+			step, ok := totp.Validate(secret, params.Code, time.Now(), totpSkew)
+			if !ok || (lastStep != nil && step <= *lastStep) {
+				return false, ErrInvalidCode
+			}
+			usedStep = &step
+		}
+	}
+
+	err := service.storage.DeleteTwoFactor(c, params.UserID, usedStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// importColumns are the columns of user imports, addresses are not imported.
+var importColumns = []string{"username", "first_name", "last_name", "middle_name", "email", "phone", "birth_date"}
+
+// Authorize implements imports.Importer.
+func (service *UserService) Authorize(c context.Context) error {
+	return auth.Authorize(c, auth.PermUsersWrite)
+}
+
+// Columns implements imports.Importer.
+func (service *UserService) Columns() []string {
+	return importColumns
+}
+
+// Import implements imports.Importer. Rows are validated like bodies of AddUser, a
+// row fails if its email or phone is used by an earlier row or an existing user.
+func (service *UserService) Import(c context.Context, importID int64, rows []*imports.Row, errs []*imports.RowError) (*imports.Result, error) {
+	res := &imports.Result{Errors: errs}
+	total := len(rows) + len(errs)
+	rowError := func(row *imports.Row, field string, msg string) {
+		res.Errors = append(res.Errors, &imports.RowError{Row: row.Number, Field: field, Message: msg})
+	}
+
+	valid := make([]*imports.Row, 0, len(rows))
+	profiles := make(map[*imports.Row]*UserProfile, len(rows))
+	var emails, phones []string
+	for _, row := range rows {
+		p := &UserProfile{
+			UserName:   row.Fields["username"],
+			FirstName:  row.Fields["first_name"],
+			LastName:   row.Fields["last_name"],
+			MiddleName: row.Fields["middle_name"],
+			Email:      row.Fields["email"],
+			Phone:      row.Fields["phone"],
+			BirthDate:  row.Fields["birth_date"],
+		}
+		if err := normalizeProfile(p); err != nil {
+			var ve *ValidationError
+			if !errors.As(err, &ve) {
+				return nil, err
+			}
+			field := ve.Field
+			if field == "user_full_name" {
+				field = "username"
+			}
+			rowError(row, field, ve.Msg)
+			continue
+		}
+		valid = append(valid, row)
+		profiles[row] = p
+		if len(p.Email) != 0 {
+			emails = append(emails, p.Email)
+		}
+		if len(p.Phone) != 0 {
+			phones = append(phones, p.Phone)
+		}
+	}
+
+	usedEmails, usedPhones, err := service.storage.FindUsedContacts(c, emails, phones)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*UserProfile, 0, len(valid))
+	numbers := make([]int, 0, len(valid))
+	for _, row := range valid {
+		p := profiles[row]
+		switch {
+		case len(p.Email) != 0 && usedEmails[p.Email] != 0:
+			rowError(row, "email", usedBy(usedEmails[p.Email]))
+		case len(p.Phone) != 0 && usedPhones[p.Phone] != 0:
+			rowError(row, "phone", usedBy(usedPhones[p.Phone]))
+		default:
+			if len(p.Email) != 0 {
+				usedEmails[p.Email] = -int64(row.Number)
+			}
+			if len(p.Phone) != 0 {
+				usedPhones[p.Phone] = -int64(row.Number)
+			}
+			items = append(items, p)
+			numbers = append(numbers, row.Number)
+		}
+	}
+
+	if err = service.storage.ImportUsers(c, importID, total, numbers, items, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// usedBy describes who uses a contact: positive values are ids of existing users,
+// negative ones are numbers of earlier rows of the import.
+func usedBy(by int64) string {
+	if by < 0 {
+		return fmt.Sprintf("is already used by row %d", -by)
+	}
+	return "is already used by another user"
+}
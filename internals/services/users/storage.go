@@ -3,13 +3,57 @@ package users
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/lenarsaitov/go-task/internals/services/audit"
+	"github.com/lenarsaitov/go-task/internals/services/auth"
+	"github.com/lenarsaitov/go-task/internals/services/imports"
+	"github.com/lenarsaitov/go-task/internals/tenant"
+	"github.com/lenarsaitov/go-task/pkg/etag"
+	"github.com/lenarsaitov/go-task/pkg/sqlbuilder"
+	"github.com/lib/pq"
 	"math"
 	"strings"
 	"sync/atomic"
+	"time"
 )
 
+var (
+	ErrAlreadyExists = errors.New("User already exists")
+	ErrErased        = errors.New("User personal data is erased")
+	ErrMerged        = errors.New("User is merged into another user")
+	ErrMergeSelf     = errors.New("User cannot be merged into itself")
+	ErrMergeSource   = errors.New("Source user not found")
+	ErrUnknownTenant = errors.New("Unknown tenant")
+	ErrHasCards      = errors.New("Cant delete user, because exist his cards")
+
+	ErrTwoFactorEnabled     = errors.New("Two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("Two-factor authentication is not enrolled")
+	ErrInvalidCode          = errors.New("Invalid two-factor code")
+)
+
+const userColumns = `users.user_id, users.user_full_name,
+	COALESCE(users.first_name, ''), COALESCE(users.last_name, ''), COALESCE(users.middle_name, ''),
+	COALESCE(users.email, ''), COALESCE(users.phone, ''), COALESCE(to_char(users.birth_date, 'YYYY-MM-DD'), ''),
+	users.kyc_level, users.create_time, COALESCE(users.deleted_at::text, ''), COALESCE(users.erased_at::text, ''), users.version`
+
+// userSnapshotQuery selects the user with addresses for the audit log, see audit.Snapshot.
+// The log can't be changed, so personal data is left out of the snapshots: it would
+// outlive ErasePersonalData there.
+const userSnapshotQuery = `SELECT (to_jsonb(users) - ARRAY['user_full_name', 'first_name', 'last_name', 'middle_name',
+	'email', 'phone', 'birth_date']) || jsonb_build_object('addresses', COALESCE(
+	(SELECT jsonb_agg(to_jsonb(user_addresses) - ARRAY['region', 'city', 'street', 'postal_code']
+	                  ORDER BY user_addresses.address_id)
+	   FROM user_addresses WHERE user_addresses.user_id = users.user_id), '[]'))
+	FROM users WHERE user_id = $1`
+
+// exportBatchSize is the number of rows fetched at once by ExportMany.
+const exportBatchSize = 1000
+
+const totalBalanceExpr = `(SELECT COALESCE(SUM(cards.balance), 0) FROM cards WHERE cards.user_id = users.user_id AND cards.deleted_at IS NULL)`
+
 type UserStorage struct {
 	db atomic.Value
 }
@@ -33,12 +77,17 @@ func (s *UserStorage) getDB() *sqlx.DB {
 	return s.db.Load().(*sqlx.DB)
 }
 
-func (s *UserStorage) FindOne(ctx context.Context, id int) (*UserInfo, error) {
-	query := `SELECT user_id, user_full_name, create_time
+// querier returns the connection bound to the tenant of the request, see tenant.DB.
+func (s *UserStorage) querier(ctx context.Context) tenant.Querier {
+	return tenant.DB(ctx, s.getDB())
+}
+
+func (s *UserStorage) FindOne(ctx context.Context, id int, includeDeleted bool) (*UserInfo, error) {
+	query := `SELECT ` + userColumns + `
 	          FROM users
-	          WHERE user_id = $1;`
+	          WHERE user_id = $1 AND ($2 OR deleted_at IS NULL) AND tenant_id = $3;`
 
-	row := s.getDB().QueryRowContext(ctx, query, id)
+	row := s.querier(ctx).QueryRowContext(ctx, query, id, includeDeleted, tenant.FromContext(ctx))
 
 	m, err := s.readUserInfo(row)
 	if err != nil {
@@ -48,37 +97,206 @@ func (s *UserStorage) FindOne(ctx context.Context, id int) (*UserInfo, error) {
 		return nil, err
 	}
 
+	m.Addresses, err = s.findAddresses(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
 	return m, nil
 }
 
-func (s *UserStorage) readUserInfo(r QueryResult) (*UserInfo, error) {
+func (s *UserStorage) readUserInfo(r QueryResult, extra ...interface{}) (*UserInfo, error) {
 	userInfo := &UserInfo{}
-	err := r.Scan(&userInfo.UserID, &userInfo.UserName, &userInfo.CreateTime)
+	dest := []interface{}{&userInfo.UserID, &userInfo.UserName, &userInfo.FirstName, &userInfo.LastName, &userInfo.MiddleName,
+		&userInfo.Email, &userInfo.Phone, &userInfo.BirthDate, &userInfo.KycLevel, &userInfo.CreateTime, &userInfo.DeletedAt, &userInfo.ErasedAt, &userInfo.Version}
+	err := r.Scan(append(dest, extra...)...)
 	if err != nil {
 		return nil, err
 	}
 	return userInfo, nil
 }
 
-func (s *UserStorage) buildFindManyWhereClause(filter *FilterParams, pos int) (clause string, args []interface{}) {
-	var predicates []string
+func (s *UserStorage) findAddresses(ctx context.Context, userID int) ([]*AddressInfo, error) {
+	query := `SELECT address_id, kind, country, COALESCE(region, ''), city, street, postal_code
+	          FROM user_addresses
+	          WHERE user_id = $1
+	          ORDER BY (address_id);`
+
+	rows, err := s.querier(ctx).QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query addresses: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]*AddressInfo, 0)
+	for rows.Next() {
+		a := &AddressInfo{}
+		err := rows.Scan(&a.AddressID, &a.Kind, &a.Country, &a.Region, &a.City, &a.Street, &a.PostalCode)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read address info: %w", err)
+		}
+		items = append(items, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	return items, nil
+}
+
+func (s *UserStorage) replaceAddresses(ctx context.Context, tx *sql.Tx, userID int64, addresses []*AddressInfo) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM user_addresses WHERE user_id = $1;`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range addresses {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO user_addresses
+			              (user_id, kind, country, region, city, street, postal_code)
+			        VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7);`,
+			userID, a.Kind, a.Country, a.Region, a.City, a.Street, a.PostalCode)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// searchPhone keeps only digits of the search term so that "+7 900" matches stored "+7900...".
+// Terms which do not look like a phone number are not searched in phones at all.
+func searchPhone(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' || r == ' ' || r == '-' || r == '(' || r == ')':
+		default:
+			return ""
+		}
+	}
+	return b.String()
+}
+
+func uniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		switch pqErr.Constraint {
+		case "users_email_key":
+			return fmt.Errorf("%w: email is already used", ErrAlreadyExists)
+		case "users_phone_key":
+			return fmt.Errorf("%w: phone is already used", ErrAlreadyExists)
+		default:
+			return ErrAlreadyExists
+		}
+	}
+	return err
+}
+
+func (s *UserStorage) buildFindManyWhereClause(b *sqlbuilder.Builder, filter *FilterParams) {
+	if !filter.IncludeDeleted {
+		b.Where("deleted_at IS NULL")
+	}
+
+	if filter.UserID != 0 {
+		b.Where("user_id = ?", filter.UserID)
+	}
 
 	if len(filter.UserName) != 0 {
-		predicates = append(predicates,
-			fmt.Sprintf("user_full_name = $%d", pos))
-		args = append(args, filter.UserName)
-		pos++
+		b.Where("(user_full_name ILIKE ('%' || ?::text || '%') OR ?::text <% user_full_name)",
+			escapeLike(filter.UserName), filter.UserName)
+	}
+
+	if len(filter.Query) != 0 {
+		alternatives := []string{
+			b.Expr("user_full_name ILIKE ('%' || ?::text || '%')", escapeLike(filter.Query)),
+			b.Expr("?::text <% user_full_name", filter.Query),
+			b.Expr("email ILIKE ('%' || ?::text || '%')", escapeLike(filter.Query)),
+		}
+		if phone := searchPhone(filter.Query); len(phone) != 0 {
+			alternatives = append(alternatives, b.Expr("phone LIKE ('%' || ?::text || '%')", phone))
+		}
+		b.WhereAny(alternatives...)
+	}
+
+	if len(filter.FirstName) != 0 {
+		b.Where("first_name = ?", filter.FirstName)
+	}
+
+	if len(filter.LastName) != 0 {
+		b.Where("last_name = ?", filter.LastName)
+	}
+
+	if len(filter.Email) != 0 {
+		b.Where("email = ?", strings.ToLower(filter.Email))
+	}
+
+	if len(filter.Phone) != 0 {
+		b.Where("phone = ?", normalizePhone(filter.Phone))
+	}
+
+	if len(filter.BirthDate) != 0 {
+		b.Where("birth_date = ?", filter.BirthDate)
+	}
+
+	if !filter.CreatedFrom.IsZero() {
+		b.Where("create_time >= ?", filter.CreatedFrom)
+	}
+
+	if !filter.CreatedTo.IsZero() {
+		b.Where("create_time < ?", filter.CreatedTo)
+	}
+
+	if filter.BalanceMin != nil {
+		b.Where(totalBalanceExpr+" >= ?", *filter.BalanceMin)
+	}
+
+	if filter.BalanceMax != nil {
+		b.Where(totalBalanceExpr+" <= ?", *filter.BalanceMax)
+	}
+}
+
+// buildFindManyOrderClause applies explicit sorting if requested. Otherwise matches
+// of a name search go first: prefix matches, then substring matches, then by trigram
+// word similarity. user_id is always the last term so that pages are stable.
+func (s *UserStorage) buildFindManyOrderClause(b *sqlbuilder.Builder, filter *FilterParams) {
+	term := filter.Query
+	if len(term) == 0 {
+		term = filter.UserName
 	}
 
-	clause = strings.Join(predicates, " and ")
-	if len(clause) > 0 {
-		clause = "where " + clause
+	switch {
+	case len(filter.Sort) != 0:
+		b.Sort(filter.Sort)
+	case len(term) != 0:
+		b.OrderBy(`(CASE WHEN user_full_name ILIKE (?::text || '%') THEN 2
+		                 WHEN user_full_name ILIKE ('%' || ?::text || '%') THEN 1
+		                 ELSE 0 END)`, true, escapeLike(term), escapeLike(term))
+		b.OrderBy("word_similarity(?::text, user_full_name)", true, term)
 	}
 
-	return
+	b.OrderBy("user_id", false)
 }
 
 func (s *UserStorage) FindMany(ctx context.Context, filter *FilterParams) (*Pagination, error) {
+	b := sqlbuilder.New()
+	b.Where("tenant_id = ?", tenant.FromContext(ctx))
+	s.buildFindManyWhereClause(b, filter)
+	countWhereClause, countArgs := b.WhereClause(), b.Args()
+
+	s.buildFindManyOrderClause(b, filter)
+
+	if filter.CursorMode() {
+		return s.findManyAfter(ctx, b, filter, countWhereClause, countArgs)
+	}
+
 	limit := filter.Size
 	if limit <= 0 {
 		limit = math.MaxInt32
@@ -89,17 +307,12 @@ func (s *UserStorage) FindMany(ctx context.Context, filter *FilterParams) (*Pagi
 		offset = (filter.Page - 1) * filter.Size
 	}
 
-	paginationArgs := []interface{}{limit, offset}
+	query := `SELECT ` + userColumns + `
+	          FROM users ` + b.WhereClause() + `
+	          ` + b.OrderClause() + `
+	          LIMIT ` + b.Arg(limit) + ` OFFSET ` + b.Arg(offset) + `;`
 
-	whereClause, whereArgs := s.buildFindManyWhereClause(filter, 3)
-
-	template := `SELECT user_id, user_full_name, create_time
-	             FROM users %s
-	             ORDER BY (user_id)
-	             LIMIT $1 OFFSET $2;`
-
-	query := fmt.Sprintf(template, whereClause)
-	rows, err := s.getDB().QueryContext(ctx, query, append(paginationArgs, whereArgs...)...)
+	rows, err := s.querier(ctx).QueryContext(ctx, query, b.Args()...)
 	if err != nil {
 		return nil, fmt.Errorf("Cant query services: %w", err)
 	}
@@ -118,12 +331,9 @@ func (s *UserStorage) FindMany(ctx context.Context, filter *FilterParams) (*Pagi
 		return nil, fmt.Errorf("Query error: %w", err)
 	}
 
-	countWhereClause, countWhereArgs := s.buildFindManyWhereClause(filter, 1)
-	countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM users %s;`, countWhereClause)
-	row := s.getDB().QueryRowContext(ctx, countQuery, countWhereArgs...)
-	var count int
-	if err := row.Scan(&count); err != nil {
-		return nil, fmt.Errorf("Items count query error: %w", err)
+	count, err := s.count(ctx, countWhereClause, countArgs)
+	if err != nil {
+		return nil, err
 	}
 
 	pc := count / limit
@@ -134,12 +344,156 @@ func (s *UserStorage) FindMany(ctx context.Context, filter *FilterParams) (*Pagi
 	return &Pagination{
 		Page:       filter.Page,
 		Size:       filter.Size,
-		PagesCount: pc,
-		ItemsCount: count,
+		PagesCount: &pc,
+		ItemsCount: &count,
 		Items:      items,
 	}, nil
 }
 
+// findManyAfter reads one page in keyset mode: the cursor holds ORDER BY values of
+// the last row of the previous page, so rows inserted meanwhile don't shift pages.
+func (s *UserStorage) findManyAfter(ctx context.Context, b *sqlbuilder.Builder, filter *FilterParams,
+	countWhereClause string, countArgs []interface{}) (*Pagination, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultCursorLimit
+	}
+
+	if len(filter.After) != 0 {
+		values, err := sqlbuilder.DecodeCursor(filter.After)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.After(values); err != nil {
+			return nil, err
+		}
+	}
+
+	orderColumns := b.OrderColumns()
+	query := `SELECT ` + userColumns + `, ` + strings.Join(orderColumns, ", ") + `
+	          FROM users ` + b.WhereClause() + `
+	          ` + b.OrderClause() + `
+	          LIMIT ` + b.Arg(limit+1) + `;`
+
+	rows, err := s.querier(ctx).QueryContext(ctx, query, b.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query services: %w", err)
+	}
+	defer rows.Close()
+
+	values, dests := sqlbuilder.CursorValues(len(orderColumns))
+	var last []interface{}
+	hasMore := false
+
+	items := make([]*UserInfo, 0, limit)
+	for rows.Next() {
+		if len(items) == limit {
+			hasMore = true
+			break
+		}
+		userInfo, err := s.readUserInfo(rows, dests...)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read user info: %w", err)
+		}
+		items = append(items, userInfo)
+		last = append(last[:0], values...)
+	}
+
+	// closed before the count query, which may run on the same connection
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	res := &Pagination{Limit: limit, Items: items}
+
+	if hasMore {
+		res.NextCursor, err = sqlbuilder.EncodeCursor(last)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if filter.WithCount {
+		count, err := s.count(ctx, countWhereClause, countArgs)
+		if err != nil {
+			return nil, err
+		}
+		res.ItemsCount = &count
+	}
+
+	return res, nil
+}
+
+// ExportMany reads the users matching the filter through a server-side cursor in
+// batches of exportBatchSize, so that memory use doesn't grow with the result. The
+// transaction is read only and repeatable read, rows are read from one snapshot.
+func (s *UserStorage) ExportMany(ctx context.Context, filter *FilterParams, fn func(*UserInfo) error) error {
+	b := sqlbuilder.New()
+	b.Where("tenant_id = ?", tenant.FromContext(ctx))
+	s.buildFindManyWhereClause(b, filter)
+	s.buildFindManyOrderClause(b, filter)
+
+	tx, err := s.querier(ctx).BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DECLARE users_export NO SCROLL CURSOR FOR
+	          SELECT `+userColumns+`
+	          FROM users `+b.WhereClause()+`
+	          `+b.OrderClause()+`;`, b.Args()...)
+	if err != nil {
+		return fmt.Errorf("Cant query users: %w", err)
+	}
+
+	for {
+		n, err := s.fetchExport(ctx, tx, fn)
+		if err != nil {
+			return err
+		}
+		if n < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+func (s *UserStorage) fetchExport(ctx context.Context, tx *sql.Tx, fn func(*UserInfo) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`FETCH FORWARD %d FROM users_export;`, exportBatchSize))
+	if err != nil {
+		return 0, fmt.Errorf("Cant query users: %w", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		userInfo, err := s.readUserInfo(rows)
+		if err != nil {
+			return 0, fmt.Errorf("Cannot read user info: %w", err)
+		}
+		if err := fn(userInfo); err != nil {
+			return 0, err
+		}
+		n++
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("Query error: %w", err)
+	}
+	return n, nil
+}
+
+func (s *UserStorage) count(ctx context.Context, whereClause string, args []interface{}) (int, error) {
+	countQuery := `SELECT COUNT(*) FROM users ` + whereClause + `;`
+	row := s.querier(ctx).QueryRowContext(ctx, countQuery, args...)
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return 0, fmt.Errorf("Items count query error: %w", err)
+	}
+	return count, nil
+}
+
 func (s *UserStorage) readCardsInfo(r QueryResult) (*CardInfo, error) {
 	cardInfo := &CardInfo{}
 	err := r.Scan(&cardInfo.CardID)
@@ -152,9 +506,10 @@ func (s *UserStorage) readCardsInfo(r QueryResult) (*CardInfo, error) {
 func (s *UserStorage) isExistCards(ctx context.Context, userID int) (bool, error) {
 	query := `SELECT card_id
 	          FROM cards
-	          WHERE user_id = $1;`
+	          WHERE user_id = $1 AND deleted_at IS NULL AND tenant_id = $2
+	          LIMIT 1;`
 
-	row := s.getDB().QueryRowContext(ctx, query, userID)
+	row := s.querier(ctx).QueryRowContext(ctx, query, userID, tenant.FromContext(ctx))
 
 	_, err := s.readCardsInfo(row)
 	if err != nil {
@@ -167,23 +522,130 @@ func (s *UserStorage) isExistCards(ctx context.Context, userID int) (bool, error
 	return true, nil
 }
 
+// auditUser records the change of the user within tx, before is the snapshot taken prior to it.
+func (s *UserStorage) auditUser(ctx context.Context, tx *sql.Tx, action string, userID int64, before string) error {
+	after, err := audit.Snapshot(ctx, tx, userSnapshotQuery, userID)
+	if err != nil {
+		return err
+	}
+	return audit.Record(ctx, tx, &audit.Change{
+		Action:     action,
+		EntityType: audit.EntityUser,
+		EntityID:   userID,
+		Before:     before,
+		After:      after,
+	})
+}
+
 func (s *UserStorage) AddUserItem(ctx context.Context, req *AddUserRequestParams) (*int64, error) {
-	row := s.getDB().QueryRowContext(ctx,
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	row := tx.QueryRowContext(ctx,
 		`INSERT INTO users
-		              (user_full_name)
-		        VALUES ($1)
-		        RETURNING user_id;`, req.UserName)
+		              (user_full_name, first_name, last_name, middle_name, email, phone, birth_date, tenant_id)
+		        VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, '')::date, $8)
+		        RETURNING user_id;`,
+		req.UserName, req.FirstName, req.LastName, req.MiddleName, req.Email, req.Phone, req.BirthDate, tenant.FromContext(ctx))
 
 	var requestID int64
-	err := row.Scan(&requestID)
+	err = row.Scan(&requestID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" && pqErr.Constraint == "users_tenant_id_fkey" {
+			err = ErrUnknownTenant
+			return nil, err
+		}
+		err = uniqueViolation(err)
+		return nil, err
+	}
+
+	err = s.replaceAddresses(ctx, tx, requestID, req.Addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.auditUser(ctx, tx, audit.ActionCreate, requestID, "")
 	if err != nil {
 		return nil, err
 	}
+
 	return &requestID, nil
 }
 
-func (s *UserStorage) UpdateUserItem(ctx context.Context, req *UpdateUserRequestParams) error {
-	tx, err := s.getDB().BeginTx(ctx, nil)
+// UpdateUserItem overwrites the profile if req.IfMatch matches the current ETag of
+// the user and returns the new one.
+func (s *UserStorage) UpdateUserItem(ctx context.Context, req *UpdateUserRequestParams) (string, error) {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	cardRow := tx.QueryRowContext(ctx, `SELECT erased_at IS NOT NULL, version FROM users WHERE user_id = $1 AND deleted_at IS NULL AND tenant_id = $2 FOR UPDATE;`,
+		req.UserID, tenant.FromContext(ctx))
+	var erased bool
+	var version int64
+	err = cardRow.Scan(&erased, &version)
+	if err != nil {
+		return "", err
+	}
+	if erased {
+		err = ErrErased
+		return "", err
+	}
+	if len(req.IfMatch) != 0 && !etag.StrongMatch(req.IfMatch, userTag(version)) {
+		err = etag.ErrPreconditionFailed
+		return "", err
+	}
+
+	before, err := audit.Snapshot(ctx, tx, userSnapshotQuery, req.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`UPDATE users
+		    SET user_full_name = $2, first_name = NULLIF($3, ''), last_name = NULLIF($4, ''), middle_name = NULLIF($5, ''),
+		        email = NULLIF($6, ''), phone = NULLIF($7, ''), birth_date = NULLIF($8, '')::date
+		  WHERE user_id = $1
+		 RETURNING version;`,
+		req.UserID, req.UserName, req.FirstName, req.LastName, req.MiddleName, req.Email, req.Phone, req.BirthDate).Scan(&version)
+	if err != nil {
+		err = uniqueViolation(err)
+		return "", err
+	}
+
+	err = s.replaceAddresses(ctx, tx, int64(req.UserID), req.Addresses)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.auditUser(ctx, tx, audit.ActionUpdate, int64(req.UserID), before)
+	if err != nil {
+		return "", err
+	}
+
+	return userTag(version), nil
+}
+
+func (s *UserStorage) DeleteUserItem(ctx context.Context, userID int) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
 	if err != nil {
 		return fmt.Errorf("Cannot start transaction: %w", err)
 	}
@@ -195,14 +657,30 @@ func (s *UserStorage) UpdateUserItem(ctx context.Context, req *UpdateUserRequest
 		}
 	}()
 
-	cardRow := tx.QueryRowContext(ctx, `SELECT user_full_name FROM users WHERE user_id = $1 FOR UPDATE;`, req.UserID)
+	cardRow := tx.QueryRowContext(ctx, `SELECT user_full_name FROM users WHERE user_id = $1 AND deleted_at IS NULL AND tenant_id = $2 FOR UPDATE;`,
+		userID, tenant.FromContext(ctx))
 	var UserName string
 	err = cardRow.Scan(&UserName)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `UPDATE users SET user_full_name = $2 WHERE user_id = $1;`, req.UserID, req.UserName)
+	before, err := audit.Snapshot(ctx, tx, userSnapshotQuery, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET deleted_at = now() WHERE user_id = $1;`, userID)
+	if err != nil {
+		return err
+	}
+
+	err = auth.RevokeTokens(ctx, tx, int64(userID))
+	if err != nil {
+		return err
+	}
+
+	err = s.auditUser(ctx, tx, audit.ActionDelete, int64(userID), before)
 	if err != nil {
 		return err
 	}
@@ -210,8 +688,8 @@ func (s *UserStorage) UpdateUserItem(ctx context.Context, req *UpdateUserRequest
 	return nil
 }
 
-func (s *UserStorage) DeleteUserItem(ctx context.Context, userID int) error {
-	tx, err := s.getDB().BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+func (s *UserStorage) RestoreUserItem(ctx context.Context, userID int) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("Cannot start transaction: %w", err)
 	}
@@ -223,20 +701,720 @@ func (s *UserStorage) DeleteUserItem(ctx context.Context, userID int) error {
 		}
 	}()
 
-	cardRow := tx.QueryRowContext(ctx, `SELECT user_full_name FROM users WHERE user_id = $1 FOR UPDATE;`, userID)
+	cardRow := tx.QueryRowContext(ctx, `SELECT user_full_name FROM users WHERE user_id = $1 AND deleted_at IS NOT NULL AND tenant_id = $2 FOR UPDATE;`,
+		userID, tenant.FromContext(ctx))
 	var UserName string
 	err = cardRow.Scan(&UserName)
 	if err != nil {
 		return err
 	}
 
-	res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE user_id=$1", userID)
-	if err == nil {
-		_, err := res.RowsAffected()
-		if err != nil {
-			return err
-		}
+	mergedRow := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM user_merges WHERE source_user_id = $1);`, userID)
+	var merged bool
+	err = mergedRow.Scan(&merged)
+	if err != nil {
+		return err
+	}
+	if merged {
+		err = ErrMerged
+		return err
+	}
+
+	before, err := audit.Snapshot(ctx, tx, userSnapshotQuery, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET deleted_at = NULL WHERE user_id = $1;`, userID)
+	if err != nil {
+		err = uniqueViolation(err)
+		return err
+	}
+
+	err = s.auditUser(ctx, tx, audit.ActionRestore, int64(userID), before)
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
+
+// PurgeDeleted removes users soft-deleted before the given time. Users that still
+// own cards (even deleted ones not yet purged) are kept until their cards are gone.
+// It is run by the retention job for all tenants at once.
+func (s *UserStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.querier(ctx).ExecContext(ctx,
+		`DELETE FROM users
+		  WHERE deleted_at < $1
+		    AND NOT EXISTS (SELECT 1 FROM cards WHERE cards.user_id = users.user_id);`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *UserStorage) ExportPersonalData(ctx context.Context, userID int) (*PersonalDataExport, error) {
+	user, err := s.FindOne(ctx, userID, true)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	res := &PersonalDataExport{
+		ExportTime:   time.Now().UTC().Format(time.RFC3339),
+		User:         user,
+		Cards:        make([]*CardInfo, 0),
+		History:      make([]*CardHistoryInfo, 0),
+		KycDocuments: make([]*KycDocumentInfo, 0),
+	}
+
+	rows, err := s.querier(ctx).QueryContext(ctx,
+		`SELECT card_id, balance, user_id, create_time
+		   FROM cards
+		  WHERE user_id = $1
+		  ORDER BY (card_id);`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query cards: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		card := &CardInfo{UserName: user.UserName}
+		if err := rows.Scan(&card.CardID, &card.Balance, &card.UserID, &card.CreateTime); err != nil {
+			return nil, fmt.Errorf("Cannot read card info: %w", err)
+		}
+		res.Cards = append(res.Cards, card)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	historyRows, err := s.querier(ctx).QueryContext(ctx,
+		`SELECT history_id, card_id, operation, amount, balance_after, counterparty_card_id, create_time
+		   FROM cards_history
+		  WHERE card_id IN (SELECT card_id FROM cards WHERE user_id = $1)
+		  ORDER BY (history_id);`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query card history: %w", err)
+	}
+	defer historyRows.Close()
+
+	for historyRows.Next() {
+		h := &CardHistoryInfo{}
+		err := historyRows.Scan(&h.HistoryID, &h.CardID, &h.Operation, &h.Amount, &h.BalanceAfter, &h.CounterpartyCardID, &h.CreateTime)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read card history: %w", err)
+		}
+		res.History = append(res.History, h)
+	}
+	if err := historyRows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	docRows, err := s.querier(ctx).QueryContext(ctx,
+		`SELECT document_id, doc_type, status, file_name, content_type, create_time, storage_key
+		   FROM kyc_documents
+		  WHERE user_id = $1
+		  ORDER BY (document_id);`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Cant query documents: %w", err)
+	}
+	defer docRows.Close()
+
+	for docRows.Next() {
+		d := &KycDocumentInfo{}
+		err := docRows.Scan(&d.DocumentID, &d.DocType, &d.Status, &d.FileName, &d.ContentType, &d.CreateTime, &d.StorageKey)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read document info: %w", err)
+		}
+		res.KycDocuments = append(res.KycDocuments, d)
+	}
+	if err := docRows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+
+	return res, nil
+}
+
+// ErasePersonalData pseudonymises the user: profile fields and addresses are removed
+// and KYC document files are detached, while cards and their history stay intact as
+// financial records. It returns blob keys of KYC files to be deleted after commit.
+func (s *UserStorage) ErasePersonalData(ctx context.Context, userID int) ([]string, error) {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	cardRow := tx.QueryRowContext(ctx, `SELECT user_full_name FROM users WHERE user_id = $1 AND tenant_id = $2 FOR UPDATE;`,
+		userID, tenant.FromContext(ctx))
+	var UserName string
+	err = cardRow.Scan(&UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE users
+		    SET user_full_name = 'Erased user ' || user_id, first_name = NULL, last_name = NULL, middle_name = NULL,
+		        email = NULL, phone = NULL, birth_date = NULL, erased_at = now()
+		  WHERE user_id = $1;`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_addresses WHERE user_id = $1;`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1;`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = auth.RevokeTokens(ctx, tx, int64(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT storage_key FROM kyc_documents WHERE user_id = $1 AND storage_key <> '' FOR UPDATE;`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE kyc_documents SET file_name = 'erased', storage_key = '' WHERE user_id = $1;`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the personal data must not survive in the audit log, so there is no snapshot before
+	err = s.auditUser(ctx, tx, audit.ActionErase, int64(userID), "")
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// MergeUsers moves cards (with their history), KYC documents and missing profile
+// fields of the source user to the target user and soft-deletes the source.
+// Both users are locked in id order so that concurrent merges can't deadlock.
+func (s *UserStorage) MergeUsers(ctx context.Context, req *MergeUserRequestParams) (*MergeInfo, error) {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT user_id, erased_at IS NOT NULL
+		   FROM users
+		  WHERE user_id IN ($1, $2) AND deleted_at IS NULL AND tenant_id = $3
+		  ORDER BY (user_id)
+		    FOR UPDATE;`, req.TargetUserID, req.SourceUserID, tenant.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[int]bool{}
+	erased := false
+	for rows.Next() {
+		var userID int
+		var isErased bool
+		if err = rows.Scan(&userID, &isErased); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		found[userID] = true
+		erased = erased || isErased
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !found[req.TargetUserID]:
+		err = sql.ErrNoRows
+		return nil, err
+	case !found[req.SourceUserID]:
+		err = ErrMergeSource
+		return nil, err
+	case erased:
+		err = ErrErased
+		return nil, err
+	}
+
+	beforeTarget, err := audit.Snapshot(ctx, tx, userSnapshotQuery, req.TargetUserID)
+	if err != nil {
+		return nil, err
+	}
+	beforeSource, err := audit.Snapshot(ctx, tx, userSnapshotQuery, req.SourceUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET deleted_at = now() WHERE user_id = $1;`, req.SourceUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = auth.RevokeTokens(ctx, tx, int64(req.SourceUserID))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE users AS t
+		    SET first_name = COALESCE(t.first_name, s.first_name), last_name = COALESCE(t.last_name, s.last_name),
+		        middle_name = COALESCE(t.middle_name, s.middle_name), email = COALESCE(t.email, s.email),
+		        phone = COALESCE(t.phone, s.phone), birth_date = COALESCE(t.birth_date, s.birth_date),
+		        kyc_level = CASE WHEN 'full' IN (t.kyc_level, s.kyc_level) THEN 'full'
+		                         WHEN 'basic' IN (t.kyc_level, s.kyc_level) THEN 'basic'
+		                         ELSE t.kyc_level END
+		   FROM users AS s
+		  WHERE t.user_id = $1 AND s.user_id = $2;`, req.TargetUserID, req.SourceUserID)
+	if err != nil {
+		err = uniqueViolation(err)
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE user_addresses SET user_id = $1
+		  WHERE user_id = $2
+		    AND NOT EXISTS (SELECT 1 FROM user_addresses WHERE user_id = $1);`, req.TargetUserID, req.SourceUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx, `UPDATE cards SET user_id = $1 WHERE user_id = $2;`, req.TargetUserID, req.SourceUserID)
+	if err != nil {
+		return nil, err
+	}
+	cardsMoved, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err = tx.ExecContext(ctx, `UPDATE kyc_documents SET user_id = $1 WHERE user_id = $2;`, req.TargetUserID, req.SourceUserID)
+	if err != nil {
+		return nil, err
+	}
+	documentsMoved, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	row := tx.QueryRowContext(ctx,
+		`INSERT INTO user_merges
+		              (source_user_id, target_user_id, cards_moved, documents_moved)
+		        VALUES ($1, $2, $3, $4)
+		        RETURNING merge_id, source_user_id, target_user_id, cards_moved, documents_moved, create_time;`,
+		req.SourceUserID, req.TargetUserID, cardsMoved, documentsMoved)
+
+	m := &MergeInfo{}
+	err = row.Scan(&m.MergeID, &m.SourceUserID, &m.TargetUserID, &m.CardsMoved, &m.DocumentsMoved, &m.CreateTime)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.auditUser(ctx, tx, audit.ActionMerge, int64(req.TargetUserID), beforeTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.auditUser(ctx, tx, audit.ActionMerge, int64(req.SourceUserID), beforeSource)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// FindMergeTarget follows merge records starting from userID and returns the user
+// it was finally merged into, or nil if userID was never merged.
+func (s *UserStorage) FindMergeTarget(ctx context.Context, userID int) (*int64, error) {
+	query := `WITH RECURSIVE chain (user_id, depth) AS (
+	              SELECT target_user_id, 1 FROM user_merges WHERE source_user_id = $1
+	            UNION ALL
+	              SELECT m.target_user_id, chain.depth + 1
+	                FROM user_merges AS m INNER JOIN chain ON m.source_user_id = chain.user_id
+	               WHERE chain.depth < 32
+	          )
+	          SELECT chain.user_id
+	            FROM chain INNER JOIN users
+	            ON users.user_id = chain.user_id
+	           WHERE users.tenant_id = $2
+	           ORDER BY (chain.depth) DESC LIMIT 1;`
+
+	row := s.querier(ctx).QueryRowContext(ctx, query, userID, tenant.FromContext(ctx))
+
+	var targetID int64
+	err := row.Scan(&targetID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &targetID, nil
+}
+
+func (s *UserStorage) FindTwoFactor(ctx context.Context, userID int) (*TwoFactorInfo, error) {
+	query := `SELECT users.user_id, user_totp.confirmed_at IS NOT NULL,
+	                 COALESCE(user_totp.create_time::text, ''), COALESCE(user_totp.confirmed_at::text, '')
+	          FROM users LEFT JOIN user_totp
+	          ON user_totp.user_id = users.user_id
+	          WHERE users.user_id = $1 AND users.deleted_at IS NULL AND users.tenant_id = $2;`
+
+	row := s.querier(ctx).QueryRowContext(ctx, query, userID, tenant.FromContext(ctx))
+
+	t := &TwoFactorInfo{}
+	err := row.Scan(&t.UserID, &t.Enabled, &t.CreateTime, &t.ConfirmedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// findTwoFactorSecret returns the TOTP secret of the user and the last time step
+// a code was accepted for.
+func (s *UserStorage) findTwoFactorSecret(ctx context.Context, userID int) (string, *int64, bool, error) {
+	row := s.querier(ctx).QueryRowContext(ctx,
+		`SELECT secret, last_used_step, confirmed_at IS NOT NULL FROM user_totp WHERE user_id = $1;`, userID)
+
+	var secret string
+	var lastStep *int64
+	var confirmed bool
+	err := row.Scan(&secret, &lastStep, &confirmed)
+	if err != nil {
+		return "", nil, false, err
+	}
+	return secret, lastStep, confirmed, nil
+}
+
+// AddTwoFactor starts enrollment of a new secret. An unconfirmed secret is replaced,
+// so that enrollment can be restarted if the first QR code was lost.
+func (s *UserStorage) AddTwoFactor(ctx context.Context, userID int, secret string) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	userRow := tx.QueryRowContext(ctx, `SELECT user_id FROM users WHERE user_id = $1 AND deleted_at IS NULL AND tenant_id = $2 FOR UPDATE;`,
+		userID, tenant.FromContext(ctx))
+	var id int
+	err = userRow.Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	var enabled bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM user_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL);`, userID).Scan(&enabled)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		err = ErrTwoFactorEnabled
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_totp (user_id, secret)
+		      VALUES ($1, $2)
+		 ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, create_time = now(), last_used_step = NULL;`,
+		userID, secret)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ConfirmTwoFactor enables the enrolled secret. step is the time step of the code
+// the user confirmed with, it can't be used again.
+func (s *UserStorage) ConfirmTwoFactor(ctx context.Context, userID int, step int64) error {
+	res, err := s.querier(ctx).ExecContext(ctx,
+		`UPDATE user_totp SET confirmed_at = now(), last_used_step = $2
+		  WHERE user_id = $1 AND confirmed_at IS NULL;`, userID, step)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteTwoFactor disables the second factor of the user. step is the time step of the
+// TOTP code the user confirmed it with, nil for callers who don't need one; the code
+// is used up first, so that it can't be accepted twice.
+func (s *UserStorage) DeleteTwoFactor(ctx context.Context, userID int, step *int64) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	if step != nil {
+		var res sql.Result
+		res, err = tx.ExecContext(ctx,
+			`UPDATE user_totp SET last_used_step = $2
+			  WHERE user_id = $1 AND (last_used_step IS NULL OR last_used_step < $2);`, userID, *step)
+		if err != nil {
+			return err
+		}
+		var n int64
+		n, err = res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			err = ErrInvalidCode
+			return err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM user_totp
+		  WHERE user_id = (SELECT user_id FROM users WHERE user_id = $1 AND tenant_id = $2);`, userID, tenant.FromContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		err = sql.ErrNoRows
+		return err
+	}
+
+	return nil
+}
+
+// FindByIDs returns the users of the tenant with the ids which are not deleted, keyed
+// by id. Addresses are not read.
+func (s *UserStorage) FindByIDs(ctx context.Context, ids []int64) (map[int64]*UserInfo, error) {
+	rows, err := s.querier(ctx).QueryContext(ctx,
+		`SELECT `+userColumns+`
+		   FROM users
+		  WHERE users.user_id = ANY($1) AND users.deleted_at IS NULL AND users.tenant_id = $2;`,
+		pq.Array(ids), tenant.FromContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Cant query users: %w", err)
+	}
+	defer rows.Close()
+
+	res := make(map[int64]*UserInfo)
+	for rows.Next() {
+		u, err := s.readUserInfo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read user: %w", err)
+		}
+		res[u.UserID] = u
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Query error: %w", err)
+	}
+	return res, nil
+}
+
+// FindUsedContacts returns the ids of users of the tenant which use the emails and
+// the phones, keyed by them.
+func (s *UserStorage) FindUsedContacts(ctx context.Context, emails []string, phones []string) (map[string]int64, map[string]int64, error) {
+	usedEmails := make(map[string]int64)
+	usedPhones := make(map[string]int64)
+
+	rows, err := s.querier(ctx).QueryContext(ctx,
+		`SELECT user_id, COALESCE(email, ''), COALESCE(phone, '')
+		   FROM users
+		  WHERE tenant_id = $1 AND deleted_at IS NULL AND (email = ANY($2) OR phone = ANY($3));`,
+		tenant.FromContext(ctx), pq.Array(emails), pq.Array(phones))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cant query users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int64
+		var email, phone string
+		if err := rows.Scan(&userID, &email, &phone); err != nil {
+			return nil, nil, fmt.Errorf("Cannot read user: %w", err)
+		}
+		if len(email) != 0 {
+			usedEmails[email] = userID
+		}
+		if len(phone) != 0 {
+			usedPhones[phone] = userID
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("Query error: %w", err)
+	}
+	return usedEmails, usedPhones, nil
+}
+
+// ImportUsers copies the profiles into a temporary table and inserts them from it
+// in one statement. numbers are the rows of the profiles in the file: profiles
+// whose email or phone was taken after it was checked are skipped and reported in
+// res as errors of their rows. The import is recorded in the audit log as a single
+// entry with the ids of the created users and completed with res in the same
+// transaction, see imports.Complete.
+func (s *UserStorage) ImportUsers(ctx context.Context, importID int64, total int, numbers []int, profiles []*UserProfile, res *imports.Result) error {
+	tx, err := s.querier(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx,
+		`CREATE TEMP TABLE import_users (
+		        ord INT, user_full_name text, first_name text, last_name text,
+		        middle_name text, email text, phone text, birth_date text, user_id INT
+		 ) ON COMMIT DROP;`)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("import_users",
+		"ord", "user_full_name", "first_name", "last_name", "middle_name", "email", "phone", "birth_date"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, p := range profiles {
+		_, err = stmt.ExecContext(ctx, i, p.UserName, p.FirstName, p.LastName, p.MiddleName, p.Email, p.Phone, p.BirthDate)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+
+	// ids are taken before the insert, so that the rows which were skipped on a
+	// conflict can be told from the inserted ones
+	_, err = tx.ExecContext(ctx, `UPDATE import_users SET user_id = nextval(pg_get_serial_sequence('users', 'user_id'));`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`WITH inserted AS (
+		      INSERT INTO users
+		             (user_id, user_full_name, first_name, last_name, middle_name, email, phone, birth_date, tenant_id)
+		      SELECT user_id, user_full_name, NULLIF(first_name, ''), NULLIF(last_name, ''), NULLIF(middle_name, ''),
+		             NULLIF(email, ''), NULLIF(phone, ''), NULLIF(birth_date, '')::date, $1
+		        FROM import_users
+		       ORDER BY ord
+		          ON CONFLICT DO NOTHING
+		   RETURNING user_id
+		 )
+		 SELECT i.ord, inserted.user_id
+		   FROM import_users i
+		   LEFT JOIN inserted ON inserted.user_id = i.user_id
+		  ORDER BY i.ord;`, tenant.FromContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int64, 0, len(profiles))
+	for rows.Next() {
+		var ord int
+		var id sql.NullInt64
+		if err = rows.Scan(&ord, &id); err != nil {
+			rows.Close()
+			return err
+		}
+		if !id.Valid {
+			res.Errors = append(res.Errors, &imports.RowError{Row: numbers[ord], Message: "email or phone is already used by another user"})
+			continue
+		}
+		ids = append(ids, id.Int64)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	after, err := json.Marshal(map[string]interface{}{"entity": "users", "user_ids": ids})
+	if err != nil {
+		return err
+	}
+	err = audit.Record(ctx, tx, &audit.Change{
+		Action:     audit.ActionImport,
+		EntityType: audit.EntityImport,
+		EntityID:   importID,
+		After:      string(after),
+	})
+	if err != nil {
+		return err
+	}
+
+	res.Imported = len(ids)
+	err = imports.Complete(ctx, tx, importID, total, res)
+	return err
+}
@@ -0,0 +1,144 @@
+package users
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const birthDateLayout = "2006-01-02"
+
+var (
+	emailRegexp = regexp.MustCompile(`^[a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)+$`)
+	phoneRegexp = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+)
+
+var addressKinds = map[string]bool{
+	"home":    true,
+	"work":    true,
+	"billing": true,
+	"postal":  true,
+}
+
+type ValidationError struct {
+	Field string
+	Msg   string
+}
+
+func (ve *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid value of %s: %s", ve.Field, ve.Msg)
+}
+
+func normalizeProfile(p *UserProfile) error {
+	p.UserName = strings.TrimSpace(p.UserName)
+	p.FirstName = strings.TrimSpace(p.FirstName)
+	p.LastName = strings.TrimSpace(p.LastName)
+	p.MiddleName = strings.TrimSpace(p.MiddleName)
+	p.Email = strings.ToLower(strings.TrimSpace(p.Email))
+	p.Phone = normalizePhone(p.Phone)
+	p.BirthDate = strings.TrimSpace(p.BirthDate)
+
+	if len(p.UserName) == 0 {
+		p.UserName = strings.Join(nonEmpty(p.LastName, p.FirstName, p.MiddleName), " ")
+	}
+
+	// limits are in characters, like the varchar columns
+	switch {
+	case len(p.UserName) == 0:
+		return &ValidationError{Field: "user_full_name", Msg: "full name or first and last name are required"}
+	case utf8.RuneCountInString(p.UserName) > 100:
+		return &ValidationError{Field: "user_full_name", Msg: "must be at most 100 characters"}
+	case utf8.RuneCountInString(p.FirstName) > 50:
+		return &ValidationError{Field: "first_name", Msg: "must be at most 50 characters"}
+	case utf8.RuneCountInString(p.LastName) > 50:
+		return &ValidationError{Field: "last_name", Msg: "must be at most 50 characters"}
+	case utf8.RuneCountInString(p.MiddleName) > 50:
+		return &ValidationError{Field: "middle_name", Msg: "must be at most 50 characters"}
+	case len(p.Email) != 0 && (len(p.Email) > 254 || !emailRegexp.MatchString(p.Email)):
+		return &ValidationError{Field: "email", Msg: "must be a valid email address"}
+	case len(p.Phone) != 0 && !phoneRegexp.MatchString(p.Phone):
+		return &ValidationError{Field: "phone", Msg: "must be in E.164 format, e.g. +79001234567"}
+	}
+
+	if len(p.BirthDate) != 0 {
+		birthDate, err := time.Parse(birthDateLayout, p.BirthDate)
+		if err != nil {
+			return &ValidationError{Field: "birth_date", Msg: "must be in YYYY-MM-DD format"}
+		}
+		now := time.Now().UTC()
+		if birthDate.After(now) || birthDate.Before(now.AddDate(-150, 0, 0)) {
+			return &ValidationError{Field: "birth_date", Msg: "is out of range"}
+		}
+	}
+
+	for i, a := range p.Addresses {
+		if err := normalizeAddress(a); err != nil {
+			err.Field = fmt.Sprintf("addresses[%d].%s", i, err.Field)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func normalizeAddress(a *AddressInfo) *ValidationError {
+	if a == nil {
+		return &ValidationError{Field: "address", Msg: "must not be null"}
+	}
+
+	a.Kind = strings.ToLower(strings.TrimSpace(a.Kind))
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	a.Region = strings.TrimSpace(a.Region)
+	a.City = strings.TrimSpace(a.City)
+	a.Street = strings.TrimSpace(a.Street)
+	a.PostalCode = strings.TrimSpace(a.PostalCode)
+
+	if len(a.Kind) == 0 {
+		a.Kind = "home"
+	}
+
+	switch {
+	case !addressKinds[a.Kind]:
+		return &ValidationError{Field: "kind", Msg: "must be one of home, work, billing, postal"}
+	case len(a.Country) != 2:
+		return &ValidationError{Field: "country", Msg: "must be an ISO 3166-1 alpha-2 code"}
+	case len(a.City) == 0 || utf8.RuneCountInString(a.City) > 100:
+		return &ValidationError{Field: "city", Msg: "must be between 1 and 100 characters"}
+	case len(a.Street) == 0 || utf8.RuneCountInString(a.Street) > 200:
+		return &ValidationError{Field: "street", Msg: "must be between 1 and 200 characters"}
+	case len(a.PostalCode) == 0 || utf8.RuneCountInString(a.PostalCode) > 20:
+		return &ValidationError{Field: "postal_code", Msg: "must be between 1 and 20 characters"}
+	case utf8.RuneCountInString(a.Region) > 100:
+		return &ValidationError{Field: "region", Msg: "must be at most 100 characters"}
+	}
+
+	return nil
+}
+
+func normalizePhone(phone string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')':
+		default:
+			return phone
+		}
+	}
+	return b.String()
+}
+
+func nonEmpty(values ...string) []string {
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		if len(v) != 0 {
+			res = append(res, v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,81 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/jmoiron/sqlx"
+	"github.com/lenarsaitov/go-task/pkg/logging"
+)
+
+// Querier is the part of *sql.DB and *sql.Conn used by storages.
+type Querier interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+var (
+	_ Querier = &sqlx.DB{}
+	_ Querier = &sql.Conn{}
+)
+
+type connKey struct{}
+
+// DB returns the connection bound to the tenant of the request when row-level
+// security is enabled, the pool of Maintenance for jobs, otherwise db itself.
+func DB(ctx context.Context, db *sqlx.DB) Querier {
+	if q, ok := ctx.Value(connKey{}).(Querier); ok {
+		return q
+	}
+	return db
+}
+
+// Maintenance returns ctx whose queries run on db, for jobs working across tenants
+// like retention. Policies of row-level security show no rows to connections without
+// a tenant, so with it db must connect as a role with BYPASSRLS.
+func Maintenance(ctx context.Context, db *sqlx.DB) context.Context {
+	return context.WithValue(ctx, connKey{}, db)
+}
+
+func bind(ctx context.Context, db *sqlx.DB, id string) (*sql.Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.ExecContext(ctx, `SELECT set_config('app.tenant_id', $1, false);`, id)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// release resets the tenant before the connection goes back to the pool. If that
+// fails the connection is discarded, it must not serve other tenants.
+func release(conn *sql.Conn) {
+	_, err := conn.ExecContext(context.Background(), `SELECT set_config('app.tenant_id', '', false);`)
+	if err != nil {
+		logging.GetLogger().Errorf("failed to reset tenant of connection: %s", err)
+		conn.Raw(func(interface{}) error {
+			return driver.ErrBadConn
+		})
+	}
+	conn.Close()
+}
+
+// Bind binds a connection to the tenant of ctx for work outside of requests, like
+// Middleware does for requests. The returned func releases the connection.
+func Bind(ctx context.Context, db *sqlx.DB, rls bool) (context.Context, func(), error) {
+	if !rls {
+		return ctx, func() {}, nil
+	}
+	conn, err := bind(ctx, db, FromContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	return context.WithValue(ctx, connKey{}, conn), func() { release(conn) }, nil
+}
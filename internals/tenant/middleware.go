@@ -0,0 +1,45 @@
+package tenant
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/pkg/problem"
+	"net/http"
+)
+
+var errorCodes = problem.Codes{
+	ErrInvalidID: "invalid_tenant",
+}
+
+// Middleware resolves the tenant of the request, see Resolve.
+//
+// With rls every request holds its own connection with app.tenant_id set, so that
+// Postgres row-level security policies filter rows even if a query misses the
+// tenant condition, and connections without a tenant see no rows at all. Policies
+// don't apply to superusers, so the application must connect as an ordinary role
+// for them to take effect.
+func Middleware(db *sqlx.DB, rls bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			ctx, err := Resolve(req.Context(), req.Header.Get(HeaderTenantID))
+			if err != nil {
+				return problem.Error(c, http.StatusBadRequest, err, errorCodes)
+			}
+
+			if rls {
+				conn, err := bind(ctx, db, FromContext(ctx))
+				if err != nil {
+					return problem.Error(c, http.StatusInternalServerError, err, errorCodes)
+				}
+				defer release(conn)
+				ctx = context.WithValue(ctx, connKey{}, conn)
+			}
+
+			c.SetRequest(req.WithContext(ctx))
+			return next(c)
+		}
+	}
+}
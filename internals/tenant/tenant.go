@@ -0,0 +1,66 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	// DefaultID is the tenant of requests which don't name one, and of all data
+	// created before multi-tenancy was introduced.
+	DefaultID = "default"
+
+	HeaderTenantID = "X-Tenant-ID"
+)
+
+var (
+	ErrInvalidID = errors.New("Invalid tenant id")
+	ErrMismatch  = errors.New("Credentials belong to another tenant")
+)
+
+var idRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+func Validate(id string) error {
+	if !idRegexp.MatchString(id) {
+		return fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+	return nil
+}
+
+type tenantKey struct{}
+
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// FromContext returns the tenant of the request. Contexts which never went through
+// the middleware, like background jobs, belong to DefaultID.
+func FromContext(ctx context.Context) string {
+	if id, ok := lookup(ctx); ok {
+		return id
+	}
+	return DefaultID
+}
+
+// Resolve puts the tenant of the request into ctx: a tenant already put there by
+// authentication wins, then comes header, the value of X-Tenant-ID, and DefaultID.
+func Resolve(ctx context.Context, header string) (context.Context, error) {
+	id, ok := lookup(ctx)
+	if !ok {
+		id = header
+		if len(id) == 0 {
+			id = DefaultID
+		}
+	}
+	if err := Validate(id); err != nil {
+		return nil, err
+	}
+	return WithID(ctx, id), nil
+}
+
+func lookup(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(tenantKey{}).(string)
+	return id, ok && len(id) != 0
+}
@@ -0,0 +1,111 @@
+package versioning
+
+import (
+	"context"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderDeprecation = "Deprecation"
+	HeaderSunset      = "Sunset"
+	HeaderLink        = "Link"
+)
+
+// Version is a group of routes mounted under Prefix. Handlers behave the same in
+// all versions until a breaking change needs FromContext to tell them apart.
+type Version struct {
+	Prefix string
+	// Legacy marks the routes mounted at the root before versioning, they behave
+	// as V1 and are deprecated.
+	Legacy bool
+}
+
+var (
+	V1     = Version{Prefix: "/v1"}
+	V2     = Version{Prefix: "/v2"}
+	Legacy = Version{Prefix: "", Legacy: true}
+)
+
+// Versions lists the versions to mount, the legacy root routes only while they
+// are enabled.
+func Versions(legacy bool) []Version {
+	versions := []Version{V1, V2}
+	if legacy {
+		versions = append(versions, Legacy)
+	}
+	return versions
+}
+
+const dateLayout = "2006-01-02"
+
+// Deprecation holds the dates announced for the legacy routes.
+type Deprecation struct {
+	At     time.Time
+	Sunset time.Time
+}
+
+// ParseDeprecation parses YYYY-MM-DD dates, sunset may be empty while the date of
+// removal is not decided.
+func ParseDeprecation(at string, sunset string) (Deprecation, error) {
+	var d Deprecation
+	var err error
+
+	d.At, err = time.Parse(dateLayout, at)
+	if err != nil {
+		return d, fmt.Errorf("Invalid deprecation date %q: expected YYYY-MM-DD", at)
+	}
+	if len(sunset) != 0 {
+		d.Sunset, err = time.Parse(dateLayout, sunset)
+		if err != nil {
+			return d, fmt.Errorf("Invalid sunset date %q: expected YYYY-MM-DD", sunset)
+		}
+	}
+	return d, nil
+}
+
+type versionKey struct{}
+
+func WithVersion(ctx context.Context, v Version) context.Context {
+	return context.WithValue(ctx, versionKey{}, v)
+}
+
+// FromContext returns the version of the request, V1 outside of versioned groups.
+func FromContext(ctx context.Context) Version {
+	if v, ok := ctx.Value(versionKey{}).(Version); ok {
+		return v
+	}
+	return V1
+}
+
+// Path prefixes an absolute API path with the version of the request, for links
+// like Location headers to stay in the version the client uses.
+func Path(ctx context.Context, p string) string {
+	return FromContext(ctx).Prefix + p
+}
+
+// Middleware puts the version into the request context. Responses of legacy
+// routes announce the deprecation (RFC 9745) and the sunset (RFC 8594) and link
+// to the same route of V1.
+func Middleware(v Version, d Deprecation) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			if v.Legacy {
+				h := c.Response().Header()
+				h.Set(HeaderDeprecation, "@"+strconv.FormatInt(d.At.Unix(), 10))
+				if !d.Sunset.IsZero() {
+					h.Set(HeaderSunset, d.Sunset.UTC().Format(http.TimeFormat))
+				}
+				h.Add(HeaderLink, fmt.Sprintf(`<%s%s>; rel="successor-version"`, V1.Prefix, req.URL.EscapedPath()))
+			}
+
+			c.SetRequest(req.WithContext(WithVersion(req.Context(), v)))
+			return next(c)
+		}
+	}
+}
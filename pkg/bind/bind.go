@@ -6,12 +6,19 @@ import (
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 
 	"github.com/golang/gddo/httputil/header"
 )
 
+// MaxBodySize is the largest body of JSON and patch requests.
+const MaxBodySize = 1048576
+
+// errTooLarge is the message of reads past the limit of http.MaxBytesReader.
+const errTooLarge = "http: request body too large"
+
 type MalformedRequest struct {
 	Status int
 	Msg    string
@@ -30,9 +37,43 @@ func DecodeJSONBody(c echo.Context, dst interface{}) error {
 		}
 	}
 
-	//c.Request().Body = http.MaxBytesReader(w, c.Request().Body, 1048576)
+	LimitBody(c, MaxBodySize)
+
+	return DecodeJSON(c.Request().Body, dst)
+}
+
+// LimitBody makes reads of the request body fail once they go past limit bytes,
+// see IsTooLarge, and closes the connection then.
+func LimitBody(c echo.Context, limit int64) {
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, limit)
+}
+
+// IsTooLarge reports whether err comes from reading the body past the limit of
+// LimitBody, also when a parser reported it in an error of its own.
+func IsTooLarge(err error) bool {
+	return err != nil && strings.HasSuffix(err.Error(), errTooLarge)
+}
+
+// ReadBody reads the request body of at most MaxBodySize bytes, a larger one is a
+// MalformedRequest with 413.
+func ReadBody(c echo.Context) ([]byte, error) {
+	LimitBody(c, MaxBodySize)
+
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		if IsTooLarge(err) {
+			msg := "Request body must not be larger than 1MB"
+			return nil, &MalformedRequest{Status: http.StatusRequestEntityTooLarge, Msg: msg}
+		}
+		return nil, err
+	}
+	return body, nil
+}
 
-	dec := json.NewDecoder(c.Request().Body)
+// DecodeJSON decodes a single JSON object without unknown fields, like
+// DecodeJSONBody does, from documents built by handlers, e.g. patched resources.
+func DecodeJSON(r io.Reader, dst interface{}) error {
+	dec := json.NewDecoder(r)
 	dec.DisallowUnknownFields()
 
 	err := dec.Decode(&dst)
@@ -62,7 +103,7 @@ func DecodeJSONBody(c echo.Context, dst interface{}) error {
 			msg := "Request body must not be empty"
 			return &MalformedRequest{Status: http.StatusBadRequest, Msg: msg}
 
-		case err.Error() == "http: request body too large":
+		case IsTooLarge(err):
 			msg := "Request body must not be larger than 1MB"
 			return &MalformedRequest{Status: http.StatusRequestEntityTooLarge, Msg: msg}
 
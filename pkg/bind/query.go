@@ -0,0 +1,60 @@
+package bind
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// ParseTimeFrom parses the lower bound of a time range given as RFC 3339 time or a date.
+func ParseTimeFrom(name string, value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(dateLayout, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid value of %s: expected RFC 3339 time or YYYY-MM-DD date", name)
+}
+
+// ParseTimeTo parses the exclusive upper bound of a time range. A date means
+// the whole day is included, so the bound is moved to the start of the next day.
+func ParseTimeTo(name string, value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(dateLayout, value); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid value of %s: expected RFC 3339 time or YYYY-MM-DD date", name)
+}
+
+// ParseDate checks that value is a YYYY-MM-DD date and returns it as is.
+func ParseDate(name string, value string) (string, error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+	if _, err := time.Parse(dateLayout, value); err != nil {
+		return "", fmt.Errorf("Invalid value of %s: expected YYYY-MM-DD date", name)
+	}
+	return value, nil
+}
+
+func ParseOptionalInt64(name string, value string) (*int64, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid value of %s: expected integer", name)
+	}
+	return &v, nil
+}
@@ -0,0 +1,25 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrNotFound = errors.New("Blob not found")
+
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+func New(kind string, dir string) (Store, error) {
+	switch kind {
+	case "", "local":
+		return NewLocalStore(dir)
+	default:
+		return nil, fmt.Errorf("Unknown blob store type: %s", kind)
+	}
+}
@@ -0,0 +1,67 @@
+package dataloader
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchFunc loads the values of keys at once. Keys without a value are left out of
+// the map, the error applies to all of them.
+type BatchFunc func(ctx context.Context, keys []int64) (map[int64]interface{}, error)
+
+// Thunk returns the value of a key, loading it on the first call.
+type Thunk func() (interface{}, error)
+
+// Loader collects the keys requested while a level of a query is resolved and loads
+// them with one call of BatchFunc, instead of a query per key. Load only records the
+// key, the batch runs when the first of the returned thunks is called. Values are
+// cached, so a loader serves one request only.
+type Loader struct {
+	batch BatchFunc
+
+	mu      sync.Mutex
+	pending []int64
+	queued  map[int64]bool
+	loaded  map[int64]*result
+}
+
+type result struct {
+	value interface{}
+	err   error
+}
+
+func New(batch BatchFunc) *Loader {
+	return &Loader{batch: batch, queued: make(map[int64]bool), loaded: make(map[int64]*result)}
+}
+
+func (l *Loader) Load(ctx context.Context, key int64) Thunk {
+	l.mu.Lock()
+	if _, ok := l.loaded[key]; !ok && !l.queued[key] {
+		l.pending = append(l.pending, key)
+		l.queued[key] = true
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if _, ok := l.loaded[key]; !ok {
+			l.dispatch(ctx)
+		}
+		r := l.loaded[key]
+		return r.value, r.err
+	}
+}
+
+// dispatch loads the pending keys, the caller holds the lock.
+func (l *Loader) dispatch(ctx context.Context) {
+	keys := l.pending
+	l.pending = nil
+
+	values, err := l.batch(ctx, keys)
+	for _, k := range keys {
+		l.loaded[k] = &result{value: values[k], err: err}
+		delete(l.queued, k)
+	}
+}
@@ -0,0 +1,84 @@
+package etag
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	HeaderETag        = "ETag"
+	HeaderIfMatch     = "If-Match"
+	HeaderIfNoneMatch = "If-None-Match"
+)
+
+var (
+	ErrPreconditionRequired = errors.New("If-Match header is required, send the ETag of the resource")
+	ErrPreconditionFailed   = errors.New("Resource was modified, fetch it again to get the current ETag")
+)
+
+// Tag formats a strong entity tag from the versions the representation depends on,
+// e.g. the version of the row and values computed on read.
+func Tag(versions ...int64) string {
+	parts := make([]string, 0, len(versions))
+	for _, v := range versions {
+		parts = append(parts, strconv.FormatInt(v, 10))
+	}
+	return `"` + strings.Join(parts, ".") + `"`
+}
+
+// IfMatch returns the If-Match header of the request, ErrPreconditionRequired if
+// it is missing, so that writes are never based on a representation of unknown age.
+func IfMatch(c echo.Context) (string, error) {
+	header := c.Request().Header.Get(HeaderIfMatch)
+	if len(strings.TrimSpace(header)) == 0 {
+		return "", ErrPreconditionRequired
+	}
+	return header, nil
+}
+
+// StrongMatch reports whether the If-Match header matches tag. Weak tags never
+// match, as required by RFC 9110 for If-Match.
+func StrongMatch(header string, tag string) bool {
+	for _, t := range split(header) {
+		if t == "*" || (!strings.HasPrefix(t, "W/") && t == tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// WeakMatch reports whether the If-None-Match header matches tag, ignoring the
+// weakness of the tags.
+func WeakMatch(header string, tag string) bool {
+	for _, t := range split(header) {
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// NotModified sets the ETag of the representation and responds 304 when the
+// If-None-Match header of the request matches it. Handlers return the error as is
+// when the result is true.
+func NotModified(c echo.Context, tag string) (bool, error) {
+	c.Response().Header().Set(HeaderETag, tag)
+	header := c.Request().Header.Get(HeaderIfNoneMatch)
+	if len(header) == 0 || !WeakMatch(header, tag) {
+		return false, nil
+	}
+	return true, c.NoContent(http.StatusNotModified)
+}
+
+func split(header string) []string {
+	var tags []string
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); len(t) != 0 {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
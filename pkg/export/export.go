@@ -0,0 +1,146 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/pkg/logging"
+	"github.com/lenarsaitov/go-task/pkg/requestid"
+	"net/http"
+	"strings"
+)
+
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+var ErrUnsupportedFormat = errors.New("Unsupported export format. Allowed: ndjson, csv")
+
+var contentTypes = map[string]string{
+	FormatNDJSON: "application/x-ndjson",
+	FormatCSV:    "text/csv; charset=utf-8",
+}
+
+// flushEvery is the number of records written between flushes of the response.
+const flushEvery = 500
+
+// formulaPrefixes are the first characters of CSV values which spreadsheets
+// evaluate as formulas.
+const formulaPrefixes = "=+-@\t\r"
+
+// Record is an exported item: it is written to NDJSON as JSON and to CSV as the
+// values of the columns given to NewWriter, escaped by escapeCell.
+type Record interface {
+	CSV() []string
+}
+
+// Writer streams records to the response. The status and headers are only sent with
+// the first record, so that errors of the query can still be reported as problems.
+type Writer struct {
+	c       echo.Context
+	format  string
+	name    string
+	columns []string
+	csv     *csv.Writer
+	enc     *json.Encoder
+	count   int
+}
+
+// NewWriter creates a writer of the format, ndjson when empty. name is the file
+// name offered to the client, without the extension.
+func NewWriter(c echo.Context, format string, name string, columns []string) (*Writer, error) {
+	if len(format) == 0 {
+		format = FormatNDJSON
+	}
+	if _, ok := contentTypes[format]; !ok {
+		return nil, ErrUnsupportedFormat
+	}
+	return &Writer{c: c, format: format, name: name, columns: columns}, nil
+}
+
+func (w *Writer) start() error {
+	res := w.c.Response()
+	res.Header().Set(echo.HeaderContentType, contentTypes[w.format])
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", w.name+"."+w.format))
+	res.WriteHeader(http.StatusOK)
+
+	if w.format == FormatCSV {
+		w.csv = csv.NewWriter(res)
+		return w.csv.Write(w.columns)
+	}
+	w.enc = json.NewEncoder(res)
+	return nil
+}
+
+func (w *Writer) Write(r Record) error {
+	if !w.c.Response().Committed {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if w.csv != nil {
+		values := r.CSV()
+		for i, v := range values {
+			values[i] = escapeCell(v)
+		}
+		err = w.csv.Write(values)
+	} else {
+		err = w.enc.Encode(r)
+	}
+	if err != nil {
+		return err
+	}
+
+	if w.count++; w.count%flushEvery == 0 {
+		return w.flush()
+	}
+	return nil
+}
+
+// escapeCell prefixes values which spreadsheets would evaluate as formulas with a
+// quote, so that values entered by users can't run in the spreadsheet of the
+// one opening the export.
+func escapeCell(v string) string {
+	if len(v) != 0 && strings.IndexByte(formulaPrefixes, v[0]) >= 0 {
+		return "'" + v
+	}
+	return v
+}
+
+func (w *Writer) flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	w.c.Response().Flush()
+	return nil
+}
+
+// Close ends the export with the error of producing it. An error before the first
+// record is returned to be reported as usual. Once records were sent the status
+// can't change, so the error is logged and the connection is aborted instead of
+// ending the response, which lets clients tell an incomplete export from a full one.
+func (w *Writer) Close(err error) error {
+	if err != nil {
+		if !w.c.Response().Committed {
+			return err
+		}
+		logging.GetLogger().WithField("request_id", requestid.FromContext(w.c.Request().Context())).
+			Errorf("export aborted after %d records: %s", w.count, err)
+		panic(http.ErrAbortHandler)
+	}
+
+	if !w.c.Response().Committed {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	return w.flush()
+}
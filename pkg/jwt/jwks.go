@@ -0,0 +1,96 @@
+package jwt
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+)
+
+// Key is a verification key: an RSA public key for RS256 or a shared secret for HS256.
+type Key struct {
+	ID        string
+	Algorithm string
+	public    *rsa.PublicKey
+	secret    []byte
+	// own marks Config.Secret, the key of the tokens the service issues itself.
+	own bool
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// LoadJWKS reads RSA ("kty": "RSA") and symmetric ("kty": "oct") keys from a JWKS file.
+// Keys meant for encryption ("use": "enc") are skipped.
+func LoadJWKS(path string) ([]*Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read JWKS file: %w", err)
+	}
+
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("Cannot parse JWKS file: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		key, err := k.toKey()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key #%d in JWKS file: %w", i, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (k jwk) toKey() (*Key, error) {
+	switch k.Kty {
+	case "RSA":
+		if len(k.Alg) != 0 && k.Alg != RS256 {
+			return nil, fmt.Errorf("unsupported algorithm %s", k.Alg)
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 {
+			return nil, fmt.Errorf("invalid RSA key")
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+		return &Key{ID: k.Kid, Algorithm: RS256, public: public}, nil
+	case "oct":
+		if len(k.Alg) != 0 && k.Alg != HS256 {
+			return nil, fmt.Errorf("unsupported algorithm %s", k.Alg)
+		}
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("invalid secret")
+		}
+		return &Key{ID: k.Kid, Algorithm: HS256, secret: secret}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
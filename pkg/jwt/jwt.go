@@ -0,0 +1,241 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+var (
+	ErrMalformed            = errors.New("Malformed token")
+	ErrUnsupportedAlgorithm = errors.New("Unsupported token algorithm")
+	ErrUnknownKey           = errors.New("Unknown token signing key")
+	ErrInvalidSignature     = errors.New("Invalid token signature")
+	ErrExpired              = errors.New("Token is expired")
+	ErrNotYetValid          = errors.New("Token is not valid yet")
+	ErrInvalidIssuer        = errors.New("Invalid token issuer")
+	ErrInvalidAudience      = errors.New("Invalid token audience")
+)
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Audience is the "aud" claim, which may be either a string or an array of strings.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var list []string
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		*a = list
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*a = Audience{s}
+	return nil
+}
+
+func (a Audience) Contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	// Own is set by Verify for tokens signed with Config.Secret, i.e. issued by the
+	// service itself rather than by an identity provider of the JWKS file.
+	Own bool `json:"-"`
+}
+
+// Scopes splits the space separated "scope" claim.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+type Config struct {
+	Secret   string
+	JWKSFile string
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+}
+
+// Verifier checks signatures and registered claims of compact JWS tokens. Keys come
+// from the HS256 secret and from a local JWKS file; tokens with a "kid" header are
+// checked only against the key with that id.
+type Verifier struct {
+	keys     []*Key
+	issuer   string
+	audience string
+	leeway   time.Duration
+}
+
+func NewVerifier(cfg Config) (*Verifier, error) {
+	v := &Verifier{issuer: cfg.Issuer, audience: cfg.Audience, leeway: cfg.Leeway}
+
+	if len(cfg.Secret) != 0 {
+		v.keys = append(v.keys, &Key{Algorithm: HS256, secret: []byte(cfg.Secret), own: true})
+	}
+
+	if len(cfg.JWKSFile) != 0 {
+		keys, err := LoadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys...)
+	}
+
+	if len(v.keys) == 0 {
+		return nil, errors.New("No JWT verification keys configured")
+	}
+
+	return v, nil
+}
+
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrMalformed
+	}
+	if h.Alg != HS256 && h.Alg != RS256 {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	var verified *Key
+	for _, key := range v.keys {
+		if key.Algorithm != h.Alg || (len(h.Kid) != 0 && key.ID != h.Kid) {
+			continue
+		}
+		if key.verify(signed, signature) {
+			verified = key
+			break
+		}
+	}
+	if verified == nil {
+		if len(h.Kid) != 0 && !v.hasKey(h.Kid) {
+			return nil, ErrUnknownKey
+		}
+		return nil, ErrInvalidSignature
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, ErrMalformed
+	}
+	claims.Own = verified.own
+
+	if err := v.validate(claims, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *Verifier) validate(c *Claims, now time.Time) error {
+	if c.ExpiresAt == 0 || now.Add(-v.leeway).Unix() >= c.ExpiresAt {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(v.leeway).Unix() < c.NotBefore {
+		return ErrNotYetValid
+	}
+	if len(v.issuer) != 0 && c.Issuer != v.issuer {
+		return ErrInvalidIssuer
+	}
+	if len(v.audience) != 0 && !c.Audience.Contains(v.audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+func (v *Verifier) hasKey(kid string) bool {
+	for _, key := range v.keys {
+		if key.ID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func (k *Key) verify(signed []byte, signature []byte) bool {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case RS256:
+		hashed := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(k.public, crypto.SHA256, hashed[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// SignHS256 issues a compact JWS token with claims signed by secret.
+func SignHS256(claims *Claims, secret []byte) (string, error) {
+	h, err := json.Marshal(header{Alg: HS256, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
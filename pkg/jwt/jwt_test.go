@@ -0,0 +1,209 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testSecret   = "service-secret"
+	testIssuer   = "https://id.example.com"
+	testAudience = "go-task"
+)
+
+func segment(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims *Claims) string {
+	signed := segment(t, header{Alg: RS256, Kid: kid, Typ: "JWT"}) + "." + segment(t, claims)
+	hashed := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func signHS256(t *testing.T, secret []byte, kid string, claims *Claims) string {
+	signed := segment(t, header{Alg: HS256, Kid: kid, Typ: "JWT"}) + "." + segment(t, claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// writeJWKS writes the public keys by their ids into a JWKS file.
+func writeJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) string {
+	set := jwks{}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Alg: RS256,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerify(t *testing.T) {
+	provider, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVerifier(Config{
+		Secret:   testSecret,
+		JWKSFile: writeJWKS(t, map[string]*rsa.PrivateKey{"provider": provider, "other": other}),
+		Issuer:   testIssuer,
+		Audience: testAudience,
+		Leeway:   30 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the public key is no secret, an HS256 token keyed with it must not pass as
+	// signed by the provider
+	der, err := x509.MarshalPKIXPublicKey(&provider.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	now := time.Now()
+	claims := func(change func(c *Claims)) *Claims {
+		c := &Claims{
+			Subject:   "auth0|42",
+			Issuer:    testIssuer,
+			Audience:  Audience{testAudience},
+			ExpiresAt: now.Add(time.Hour).Unix(),
+			IssuedAt:  now.Unix(),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+		own   bool
+	}{
+		{
+			name:  "token of the provider",
+			token: signRS256(t, provider, "provider", claims(nil)),
+		},
+		{
+			name:  "token of the service",
+			token: signHS256(t, []byte(testSecret), "", claims(nil)),
+			own:   true,
+		},
+		{
+			name:  "expired",
+			token: signRS256(t, provider, "provider", claims(func(c *Claims) { c.ExpiresAt = now.Add(-time.Minute).Unix() })),
+			err:   ErrExpired,
+		},
+		{
+			name:  "expired within leeway",
+			token: signRS256(t, provider, "provider", claims(func(c *Claims) { c.ExpiresAt = now.Add(-10 * time.Second).Unix() })),
+		},
+		{
+			name:  "without expiry",
+			token: signRS256(t, provider, "provider", claims(func(c *Claims) { c.ExpiresAt = 0 })),
+			err:   ErrExpired,
+		},
+		{
+			name:  "not yet valid",
+			token: signRS256(t, provider, "provider", claims(func(c *Claims) { c.NotBefore = now.Add(time.Minute).Unix() })),
+			err:   ErrNotYetValid,
+		},
+		{
+			name:  "wrong audience",
+			token: signRS256(t, provider, "provider", claims(func(c *Claims) { c.Audience = Audience{"another-service"} })),
+			err:   ErrInvalidAudience,
+		},
+		{
+			name:  "wrong issuer",
+			token: signRS256(t, provider, "provider", claims(func(c *Claims) { c.Issuer = "https://evil.example.com" })),
+			err:   ErrInvalidIssuer,
+		},
+		{
+			name:  "algorithm swapped to HS256 with the RSA public key",
+			token: signHS256(t, publicPEM, "provider", claims(nil)),
+			err:   ErrInvalidSignature,
+		},
+		{
+			name:  "algorithm swapped to HS256 with the RSA modulus",
+			token: signHS256(t, provider.N.Bytes(), "", claims(nil)),
+			err:   ErrInvalidSignature,
+		},
+		{
+			name:  "unknown kid",
+			token: signRS256(t, provider, "rotated", claims(nil)),
+			err:   ErrUnknownKey,
+		},
+		{
+			name:  "kid of another key",
+			token: signRS256(t, provider, "other", claims(nil)),
+			err:   ErrInvalidSignature,
+		},
+		{
+			name:  "alg none",
+			token: segment(t, header{Alg: "none"}) + "." + segment(t, claims(nil)) + ".",
+			err:   ErrUnsupportedAlgorithm,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := v.Verify(tt.token)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Own != tt.own {
+				t.Errorf("Own = %v, want %v", c.Own, tt.own)
+			}
+		})
+	}
+}
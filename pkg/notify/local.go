@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/lenarsaitov/go-task/pkg/logging"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LogNotifier writes messages to the application log. It is meant for local development only.
+type LogNotifier struct {
+	logger logging.Logger
+}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{logger: logging.GetLogger()}
+}
+
+func (n *LogNotifier) Send(ctx context.Context, msg *Message) error {
+	n.logger.Infof("notification to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileNotifier appends messages as JSON lines to a file.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) (*FileNotifier, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("Notifications file is not set")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("Cannot create notifications directory: %w", err)
+	}
+	return &FileNotifier{path: path}, nil
+}
+
+func (n *FileNotifier) Send(ctx context.Context, msg *Message) error {
+	line, err := json.Marshal(struct {
+		Time string `json:"time"`
+		*Message
+	}{time.Now().UTC().Format(time.RFC3339), msg})
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
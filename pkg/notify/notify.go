@@ -0,0 +1,28 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+)
+
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers messages to users, e.g. password reset links.
+type Notifier interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+func New(kind string, path string) (Notifier, error) {
+	switch kind {
+	case "", "log":
+		return NewLogNotifier(), nil
+	case "file":
+		return NewFileNotifier(path)
+	default:
+		return nil, fmt.Errorf("Unknown notifier type: %s", kind)
+	}
+}
@@ -0,0 +1,410 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/gddo/httputil/header"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// MergePatchType is RFC 7386 JSON Merge Patch, plain application/json bodies are
+	// treated as merge patches too.
+	MergePatchType = "application/merge-patch+json"
+	// JSONPatchType is RFC 6902 JSON Patch.
+	JSONPatchType = "application/json-patch+json"
+)
+
+var (
+	ErrUnsupportedType = errors.New("Content-Type must be " + MergePatchType + " or " + JSONPatchType)
+	ErrInvalid         = errors.New("Invalid patch")
+	// ErrConflict means a valid JSON Patch can't be applied to the current document:
+	// a path doesn't exist or a test operation failed.
+	ErrConflict = errors.New("Patch can't be applied to the resource")
+)
+
+// ContentType returns the media type of the request, see Apply.
+func ContentType(r *http.Request) string {
+	value, _ := header.ParseValueAndParams(r.Header, "Content-Type")
+	return value
+}
+
+// Apply applies the patch of the media type to the JSON document and returns the
+// patched document. The document is not validated, callers decode and validate the
+// result the way they do request bodies.
+func Apply(contentType string, doc []byte, patch []byte) ([]byte, error) {
+	switch contentType {
+	case MergePatchType, "application/json":
+		return Merge(doc, patch)
+	case JSONPatchType:
+		return JSONPatch(doc, patch)
+	default:
+		return nil, ErrUnsupportedType
+	}
+}
+
+// Merge applies an RFC 7386 merge patch: members of objects are merged recursively,
+// null removes a member and any other value, arrays included, replaces the target.
+func Merge(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = merge(t[name], value)
+		}
+	}
+	return t
+}
+
+// Operation is an operation of RFC 6902 JSON Patch.
+type Operation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from,omitempty"`
+	Value *json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch applies the operations of an RFC 6902 JSON Patch in order. The patch is
+// atomic: an error of any operation fails it as a whole.
+func JSONPatch(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []*Operation
+	if err = json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: JSON Patch must be an array of operations", ErrInvalid)
+	}
+
+	for i, op := range ops {
+		target, err = apply(target, op)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d (%s %s): %s", errors.Unwrap(err), i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+// opError wraps ErrInvalid or ErrConflict, JSONPatch adds the operation to the message.
+type opError struct {
+	kind error
+	msg  string
+}
+
+func (e *opError) Error() string {
+	return e.msg
+}
+
+func (e *opError) Unwrap() error {
+	return e.kind
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &opError{kind: ErrInvalid, msg: fmt.Sprintf(format, args...)}
+}
+
+func conflict(format string, args ...interface{}) error {
+	return &opError{kind: ErrConflict, msg: fmt.Sprintf(format, args...)}
+}
+
+func apply(doc interface{}, op *Operation) (interface{}, error) {
+	path, err := pointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, invalid("value is required")
+		}
+		value, err := decode(*op.Value)
+		if err != nil {
+			return nil, invalid("invalid value")
+		}
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			if doc, err = remove(doc, path); err != nil {
+				return nil, err
+			}
+			return add(doc, path, value)
+		default:
+			current, err := get(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !equal(current, value) {
+				return nil, conflict("test failed")
+			}
+			return doc, nil
+		}
+	case "remove":
+		return remove(doc, path)
+	case "move", "copy":
+		from, err := pointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			return add(doc, path, clone(value))
+		}
+		if len(path) > len(from) && prefix(from, path) {
+			return nil, invalid("can't move a value into itself")
+		}
+		if doc, err = remove(doc, from); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	default:
+		return nil, invalid("unknown operation %q", op.Op)
+	}
+}
+
+// pointer splits an RFC 6901 JSON Pointer into unescaped reference tokens.
+func pointer(p string) ([]string, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, invalid("path %q must start with /", p)
+	}
+	tokens := bytes.Split([]byte(p[1:]), []byte("/"))
+	path := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		t = bytes.ReplaceAll(t, []byte("~1"), []byte("/"))
+		t = bytes.ReplaceAll(t, []byte("~0"), []byte("~"))
+		path = append(path, string(t))
+	}
+	return path, nil
+}
+
+func prefix(p []string, of []string) bool {
+	for i := range p {
+		if p[i] != of[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// index parses an array index, "-" means the end of the array when allowed.
+func index(token string, n int, end bool) (int, error) {
+	if token == "-" && end {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || strconv.Itoa(i) != token {
+		return 0, invalid("invalid array index %q", token)
+	}
+	if i < 0 || i > n || (i == n && !end) {
+		return 0, conflict("array index %d is out of range", i)
+	}
+	return i, nil
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			value, ok := d[token]
+			if !ok {
+				return nil, conflict("member %q doesn't exist", token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := index(token, len(d), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = d[i]
+		default:
+			return nil, conflict("%q is not a member of an object or an array", token)
+		}
+	}
+	return doc, nil
+}
+
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	token := path[0]
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			d[token] = value
+			return d, nil
+		}
+		child, ok := d[token]
+		if !ok {
+			return nil, conflict("member %q doesn't exist", token)
+		}
+		child, err := add(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		d[token] = child
+		return d, nil
+	case []interface{}:
+		i, err := index(token, len(d), len(path) == 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 1 {
+			d = append(d, nil)
+			copy(d[i+1:], d[i:])
+			d[i] = value
+			return d, nil
+		}
+		d[i], err = add(d[i], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	default:
+		return nil, conflict("%q is not a member of an object or an array", token)
+	}
+}
+
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, invalid("the whole document can't be removed")
+	}
+
+	token := path[0]
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		child, ok := d[token]
+		if !ok {
+			return nil, conflict("member %q doesn't exist", token)
+		}
+		if len(path) == 1 {
+			delete(d, token)
+			return d, nil
+		}
+		child, err := remove(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		d[token] = child
+		return d, nil
+	case []interface{}:
+		i, err := index(token, len(d), false)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 1 {
+			return append(d[:i], d[i+1:]...), nil
+		}
+		d[i], err = remove(d[i], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	default:
+		return nil, conflict("%q is not a member of an object or an array", token)
+	}
+}
+
+// equal compares JSON values, numbers by value so that 1 equals 1.0.
+func equal(a interface{}, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		n, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, errA := a.Float64()
+		y, errB := n.Float64()
+		return errA == nil && errB == nil && x == y
+	case map[string]interface{}:
+		m, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(m) {
+			return false
+		}
+		for name, value := range a {
+			other, ok := m[name]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		s, ok := b.([]interface{})
+		if !ok || len(a) != len(s) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], s[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func clone(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for name, value := range v {
+			m[name] = clone(value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, value := range v {
+			s[i] = clone(value)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+// decode keeps numbers as json.Number, so that large integers survive the round trip.
+func decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("must only contain a single JSON value")
+	}
+	return v, nil
+}
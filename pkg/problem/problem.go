@@ -0,0 +1,178 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/lenarsaitov/go-task/pkg/bind"
+	"github.com/lenarsaitov/go-task/pkg/logging"
+	"github.com/lenarsaitov/go-task/pkg/requestid"
+	"github.com/lenarsaitov/go-task/pkg/validate"
+	"net/http"
+	"strconv"
+)
+
+// ContentType of RFC 7807 problem details.
+const ContentType = "application/problem+json"
+
+// TypeBase prefixes codes to build type URIs, relative to the API root.
+const TypeBase = "/problems/"
+
+const (
+	CodeValidationFailed = "validation_failed"
+	CodeMalformedRequest = "malformed_request"
+	CodeInternal         = "internal_error"
+)
+
+// internalDetail replaces messages of unexpected errors, which may contain SQL or
+// driver details. The request id lets support find the original error in logs.
+const internalDetail = "Internal server error, report the request id to support"
+
+// codes of statuses, used for errors without a code of their own
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusPreconditionRequired:  "precondition_required",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusServiceUnavailable:    "service_unavailable",
+}
+
+type Problem struct {
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Status    int                    `json:"status"`
+	Code      string                 `json:"code"`
+	Detail    string                 `json:"detail,omitempty"`
+	Instance  string                 `json:"instance,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Errors    []*validate.FieldError `json:"errors,omitempty"`
+}
+
+// Codes maps errors of a service to stable codes. The messages of these errors are
+// written for clients and are returned as details.
+type Codes map[error]string
+
+// Lookup returns the code of err or of an error it wraps.
+func (codes Codes) Lookup(err error) (string, bool) {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if code, ok := codes[e]; ok {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+func New(c echo.Context, status int, code string, detail string) *Problem {
+	return &Problem{
+		Type:      TypeBase + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Detail:    detail,
+		Instance:  c.Request().URL.Path,
+		RequestID: requestid.FromContext(c.Request().Context()),
+	}
+}
+
+func Write(c echo.Context, p *Problem) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.Blob(p.Status, ContentType, b)
+}
+
+// Error responds with the problem of err. Errors of server statuses without a code
+// are unexpected: they are logged with the request id and hidden from the client.
+func Error(c echo.Context, status int, err error, codes Codes) error {
+	var verr validate.Errors
+	if errors.As(err, &verr) {
+		return Validation(c, verr)
+	}
+
+	code, ok := codes.Lookup(err)
+	detail := err.Error()
+	var mr *bind.MalformedRequest
+	var nerr *strconv.NumError
+	switch {
+	case ok:
+	case errors.As(err, &mr):
+		code = CodeMalformedRequest
+	case errors.As(err, &nerr):
+		// path and query parameters are parsed with strconv, its messages name Go functions
+		code, detail = CodeMalformedRequest, fmt.Sprintf("Invalid value %q: expected %s", nerr.Num, numKind(nerr))
+	case status >= http.StatusInternalServerError:
+		logging.GetLogger().WithField("request_id", requestid.FromContext(c.Request().Context())).
+			Errorf("%s %s: %s", c.Request().Method, c.Request().URL.Path, err)
+		code, detail = statusCode(status), internalDetail
+	default:
+		code = statusCode(status)
+	}
+
+	return Write(c, New(c, status, code, detail))
+}
+
+func numKind(err *strconv.NumError) string {
+	if err.Func == "ParseBool" {
+		return "true or false"
+	}
+	return "integer"
+}
+
+// NotFound responds 404 with the message, which names what is missing.
+func NotFound(c echo.Context, message string) error {
+	return Write(c, New(c, http.StatusNotFound, statusCode(http.StatusNotFound), message))
+}
+
+// Validation responds 400 with an entry per invalid field.
+func Validation(c echo.Context, errs validate.Errors) error {
+	p := New(c, http.StatusBadRequest, CodeValidationFailed, "One or more fields are invalid")
+	p.Errors = errs
+	return Write(c, p)
+}
+
+// HTTPErrorHandler replaces the echo default, so that unknown routes, wrong methods
+// and errors returned by handlers are problem details too.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		message := http.StatusText(he.Code)
+		if m, ok := he.Message.(string); ok {
+			message = m
+		}
+		err = errors.New(message)
+		if he.Internal != nil && he.Code >= http.StatusInternalServerError {
+			err = he.Internal
+		}
+		err = Error(c, he.Code, err, nil)
+	} else {
+		err = Error(c, http.StatusInternalServerError, err, nil)
+	}
+
+	if err != nil {
+		logging.GetLogger().Errorf("failed to write error response: %s", err)
+	}
+}
+
+func statusCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return statusCodes[http.StatusBadRequest]
+}
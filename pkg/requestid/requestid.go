@@ -0,0 +1,54 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/labstack/echo/v4"
+	"regexp"
+)
+
+// incoming ids are accepted only if they are safe to log and store
+var idRegexp = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,64}$`)
+
+type requestIDKey struct{}
+
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// FromContext returns the id of the request, empty outside of requests.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Middleware takes the request id from the X-Request-ID header or generates one,
+// returns it in the response header and puts it into the request context.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			id := Ensure(req.Header.Get(echo.HeaderXRequestID))
+
+			c.Response().Header().Set(echo.HeaderXRequestID, id)
+			c.SetRequest(req.WithContext(WithID(req.Context(), id)))
+			return next(c)
+		}
+	}
+}
+
+// Ensure returns the incoming id if it is acceptable, otherwise a generated one.
+func Ensure(id string) string {
+	if !idRegexp.MatchString(id) {
+		return generate()
+	}
+	return id
+}
+
+func generate() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,37 @@
+package response
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+var INDENT = "  "
+
+type Status string
+
+var OK Status = "OK"
+
+// Envelope wraps responses which carry a message or a created resource, so that
+// clients read ids from data instead of parsing messages.
+type Envelope struct {
+	Status  Status      `json:"status"`
+	Message string      `json:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func Success(c echo.Context, statusCode int, message string) error {
+	return c.JSONPretty(statusCode, Envelope{Status: OK, Message: message}, INDENT)
+}
+
+// Created responds 201 with the URL of the new resource in the Location header and
+// the resource itself in data.
+func Created(c echo.Context, location string, data interface{}) error {
+	c.Response().Header().Set(echo.HeaderLocation, location)
+	return c.JSONPretty(http.StatusCreated, Envelope{Status: OK, Data: data}, INDENT)
+}
+
+// Location builds the URL of a resource with the id under the collection path.
+func Location(collection string, id int64) string {
+	return collection + "/" + strconv.FormatInt(id, 10)
+}
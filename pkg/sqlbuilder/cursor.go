@@ -0,0 +1,66 @@
+package sqlbuilder
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// CursorValues prepares destinations for scanning ORDER BY columns of a row.
+func CursorValues(n int) (values []interface{}, dests []interface{}) {
+	values = make([]interface{}, n)
+	dests = make([]interface{}, n)
+	for i := range values {
+		dests[i] = &values[i]
+	}
+	return
+}
+
+// EncodeCursor turns ORDER BY values of the last row of a page into an opaque token.
+func EncodeCursor(values []interface{}) (string, error) {
+	normalized := make([]interface{}, len(values))
+	for i, v := range values {
+		switch value := v.(type) {
+		case []byte:
+			normalized[i] = string(value)
+		case time.Time:
+			normalized[i] = value.Format(time.RFC3339Nano)
+		default:
+			normalized[i] = value
+		}
+	}
+
+	b, err := json.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func DecodeCursor(cursor string) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var values []interface{}
+	if err := dec.Decode(&values); err != nil || len(values) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	for _, v := range values {
+		switch v.(type) {
+		case string, json.Number, bool:
+		default:
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	return values, nil
+}
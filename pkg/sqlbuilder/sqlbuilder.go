@@ -0,0 +1,198 @@
+package sqlbuilder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Builder collects WHERE predicates and ORDER BY terms together with their arguments
+// and numbers placeholders as $1, $2, ... in the order arguments are added.
+// Column names never come from user input directly: sort fields are resolved
+// through a whitelist by ParseSort.
+type Builder struct {
+	args       []interface{}
+	predicates []string
+	orderBy    []orderTerm
+}
+
+type orderTerm struct {
+	expr string
+	desc bool
+}
+
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+type InvalidSortError struct {
+	Field string
+}
+
+func (e *InvalidSortError) Error() string {
+	return fmt.Sprintf("Sorting by field %q is not supported", e.Field)
+}
+
+func New() *Builder {
+	return &Builder{}
+}
+
+// Arg registers value as the next argument and returns its placeholder.
+func (b *Builder) Arg(value interface{}) string {
+	b.args = append(b.args, value)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+// Where adds predicate joined with AND. Every "?" in predicate is replaced
+// by the placeholder of the corresponding value from args.
+func (b *Builder) Where(predicate string, args ...interface{}) {
+	b.predicates = append(b.predicates, b.bind(predicate, args))
+}
+
+// WhereAny adds the OR-combination of predicates as a single AND term.
+func (b *Builder) WhereAny(predicates ...string) {
+	if len(predicates) == 0 {
+		return
+	}
+	b.predicates = append(b.predicates, "("+strings.Join(predicates, " OR ")+")")
+}
+
+// Expr binds args into expr the same way Where does without adding it to the clause.
+func (b *Builder) Expr(expr string, args ...interface{}) string {
+	return b.bind(expr, args)
+}
+
+func (b *Builder) OrderBy(expr string, desc bool, args ...interface{}) {
+	b.orderBy = append(b.orderBy, orderTerm{expr: b.bind(expr, args), desc: desc})
+}
+
+func (b *Builder) Sort(fields []SortField) {
+	for _, f := range fields {
+		b.OrderBy(f.Column, f.Desc)
+	}
+}
+
+// OrderColumns returns ORDER BY expressions to be selected along with a row,
+// so that the values of the last row can be put into a cursor.
+func (b *Builder) OrderColumns() []string {
+	res := make([]string, 0, len(b.orderBy))
+	for _, t := range b.orderBy {
+		res = append(res, t.expr)
+	}
+	return res
+}
+
+// After restricts the query to rows following the row whose ORDER BY values are
+// given, i.e. keyset pagination. All order expressions must be non-null and the
+// last one must be unique, which is why every list ends its order with the id.
+func (b *Builder) After(values []interface{}) error {
+	if len(values) != len(b.orderBy) {
+		return ErrInvalidCursor
+	}
+
+	placeholders := make([]string, len(values))
+	for i, v := range values {
+		placeholders[i] = b.Arg(v)
+	}
+
+	alternatives := make([]string, 0, len(values))
+	for i, t := range b.orderBy {
+		terms := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			terms = append(terms, fmt.Sprintf("%s = %s", b.orderBy[j].expr, placeholders[j]))
+		}
+		op := ">"
+		if t.desc {
+			op = "<"
+		}
+		terms = append(terms, fmt.Sprintf("%s %s %s", t.expr, op, placeholders[i]))
+		alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+	}
+
+	b.WhereAny(alternatives...)
+	return nil
+}
+
+func (b *Builder) WhereClause() string {
+	if len(b.predicates) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(b.predicates, " AND ")
+}
+
+func (b *Builder) OrderClause() string {
+	if len(b.orderBy) == 0 {
+		return ""
+	}
+
+	terms := make([]string, 0, len(b.orderBy))
+	for _, t := range b.orderBy {
+		if t.desc {
+			terms = append(terms, t.expr+" DESC")
+		} else {
+			terms = append(terms, t.expr+" ASC")
+		}
+	}
+	return "ORDER BY " + strings.Join(terms, ", ")
+}
+
+// Args returns a copy of arguments registered so far, so a query built from
+// the current state (e.g. COUNT without LIMIT) gets exactly its own arguments.
+func (b *Builder) Args() []interface{} {
+	return append([]interface{}(nil), b.args...)
+}
+
+func (b *Builder) bind(expr string, args []interface{}) string {
+	if len(args) == 0 {
+		return expr
+	}
+
+	var sb strings.Builder
+	i := 0
+	for _, r := range expr {
+		if r == '?' && i < len(args) {
+			sb.WriteString(b.Arg(args[i]))
+			i++
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// ParseSort parses "field,-field" where "-" means descending order.
+// allowed maps public field names to SQL columns.
+func ParseSort(value string, allowed map[string]string) ([]SortField, error) {
+	var fields []SortField
+	seen := map[string]bool{}
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		desc := false
+		switch part[0] {
+		case '-':
+			desc = true
+			part = part[1:]
+		case '+':
+			part = part[1:]
+		}
+
+		column, ok := allowed[part]
+		if !ok {
+			return nil, &InvalidSortError{Field: part}
+		}
+		if seen[part] {
+			continue
+		}
+		seen[part] = true
+
+		fields = append(fields, SortField{Column: column, Desc: desc})
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,106 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// secret is the SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890" in base32.
+const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the SHA-1 test vectors of RFC 6238 Appendix B. The RFC lists
+// 8 digit codes, 6 digit codes are their last digits.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(secret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.code {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	code := func(step int64) string {
+		c, err := Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name string
+		code string
+		ok   bool
+		step int64
+	}{
+		{name: "current step", code: code(current), ok: true, step: current},
+		{name: "previous step within skew", code: code(current - 1), ok: true, step: current - 1},
+		{name: "next step within skew", code: code(current + 1), ok: true, step: current + 1},
+		{name: "step outside skew", code: code(current - 2), ok: false},
+		{name: "surrounding spaces", code: " " + code(current) + " ", ok: true, step: current},
+		{name: "wrong length", code: code(current)[:5], ok: false},
+		{name: "wrong code", code: "000000", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(secret, tt.code, now, 1)
+			if ok != tt.ok || (ok && step != tt.step) {
+				t.Errorf("Validate = %d, %v, want %d, %v", step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+// TestValidateReplay checks that a code accepted once is told apart from a new one
+// by its step: callers remember the last accepted step and refuse codes which are not
+// after it, even while the code is still within the skew.
+func TestValidateReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	c, err := Code(secret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastStep, ok := Validate(secret, c, now, 1)
+	if !ok {
+		t.Fatal("code of the current step is refused")
+	}
+
+	for _, at := range []time.Time{now, now.Add(Period)} {
+		step, ok := Validate(secret, c, at, 1)
+		if !ok {
+			t.Fatalf("code is refused at %s within the skew", at)
+		}
+		if step > lastStep {
+			t.Errorf("replayed code at %s matched step %d after the accepted %d", at, step, lastStep)
+		}
+	}
+
+	next, err := Code(secret, Step(now)+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step, ok := Validate(secret, next, now.Add(Period), 1); !ok || step <= lastStep {
+		t.Errorf("code of the next step = %d, %v, want a step after %d", step, ok, lastStep)
+	}
+}
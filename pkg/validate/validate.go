@@ -0,0 +1,224 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Tag holds comma separated rules, a subset of the go-playground/validator syntax:
+//
+//	required   value is not zero
+//	omitempty  skip other rules when value is zero
+//	gte=N      number >= N, length >= N for strings and slices
+//	lte=N      number <= N, length <= N for strings and slices
+//	gt=N, lt=N strict versions of gte and lte
+//	min=N      alias of gte
+//	max=N      alias of lte
+//	len=N      length is exactly N
+//	oneof=a b  value is one of the space separated words
+//
+// Structs, pointers to structs and slices of them are validated recursively.
+const Tag = "validate"
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, f := range e {
+		messages = append(messages, fmt.Sprintf("%s %s", f.Field, f.Message))
+	}
+	return "Invalid request: " + strings.Join(messages, "; ")
+}
+
+// Validator plugs the rules into echo, handlers call c.Validate.
+type Validator struct{}
+
+func New() *Validator {
+	return &Validator{}
+}
+
+func (v *Validator) Validate(i interface{}) error {
+	return Struct(i)
+}
+
+// Struct checks the fields of s against their rules. It returns Errors listing
+// every failed field, or nil.
+func Struct(s interface{}) error {
+	var errs Errors
+	walk(reflect.ValueOf(s), "", &errs)
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+func walk(v reflect.Value, prefix string, errs *Errors) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walk(v.Index(i), fmt.Sprintf("%s[%d]", prefix, i), errs)
+		}
+		return
+	default:
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) != 0 && !f.Anonymous {
+			continue
+		}
+
+		name := prefix
+		if !f.Anonymous {
+			name = join(prefix, FieldName(f))
+		}
+
+		if tag := f.Tag.Get(Tag); len(tag) != 0 {
+			if e := check(v.Field(i), tag); e != nil {
+				e.Field = name
+				*errs = append(*errs, e)
+				continue
+			}
+		}
+		walk(v.Field(i), name, errs)
+	}
+}
+
+// FieldName returns the name clients use for the field: its json or query tag,
+// otherwise the Go name.
+func FieldName(f reflect.StructField) string {
+	for _, key := range []string{"json", "query"} {
+		if name := strings.Split(f.Tag.Get(key), ",")[0]; len(name) != 0 && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func join(prefix string, name string) string {
+	if len(prefix) == 0 {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func check(v reflect.Value, tag string) *FieldError {
+	rules := strings.Split(tag, ",")
+	for _, rule := range rules {
+		if rule == "omitempty" && v.IsZero() {
+			return nil
+		}
+	}
+
+	for _, rule := range rules {
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+
+		var ok bool
+		var message string
+		switch name {
+		case "omitempty":
+			continue
+		case "required":
+			ok, message = !v.IsZero(), "is required"
+		case "gte", "min":
+			ok, message = compare(v, param, func(a, b float64) bool { return a >= b }), bound(v, "at least", param)
+		case "lte", "max":
+			ok, message = compare(v, param, func(a, b float64) bool { return a <= b }), bound(v, "at most", param)
+		case "gt":
+			ok, message = compare(v, param, func(a, b float64) bool { return a > b }), bound(v, "greater than", param)
+		case "lt":
+			ok, message = compare(v, param, func(a, b float64) bool { return a < b }), bound(v, "less than", param)
+		case "len":
+			ok, message = compare(v, param, func(a, b float64) bool { return a == b }), bound(v, "exactly", param)
+		case "oneof":
+			ok, message = oneOf(v, strings.Fields(param)), "must be one of: "+strings.Join(strings.Fields(param), ", ")
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q", rule))
+		}
+
+		if !ok {
+			return &FieldError{Rule: name, Message: message}
+		}
+	}
+	return nil
+}
+
+// size is the value of numbers and the length of strings, slices and maps.
+func size(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return float64(len([]rune(v.String())))
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return 0
+		}
+		return size(v.Elem())
+	}
+	panic(fmt.Sprintf("validate: rule on unsupported kind %s", v.Kind()))
+}
+
+func compare(v reflect.Value, param string, f func(a, b float64) bool) bool {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validate: invalid parameter %q", param))
+	}
+	return f(size(v), n)
+}
+
+func bound(v reflect.Value, relation string, param string) string {
+	for v.Kind() == reflect.Ptr {
+		v = reflect.Zero(v.Type().Elem())
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("must be %s %s characters long", relation, param)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprintf("must contain %s %s items", relation, param)
+	}
+	return fmt.Sprintf("must be %s %s", relation, param)
+}
+
+func oneOf(v reflect.Value, values []string) bool {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	s := fmt.Sprint(v.Interface())
+	for _, value := range values {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
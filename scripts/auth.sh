@@ -0,0 +1,109 @@
+API_KEY=${API_KEY:-dev-admin-key-change-me}
+
+echo "Negative case of request without credentials"
+curl "localhost:10000/v1/users"
+
+echo "\n Who am I (admin key from config.yml)"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/auth/me"
+
+echo "\n Create role for billing service"
+curl --header "X-API-Key: $API_KEY" --request PUT "localhost:10000/v1/admin/roles/billing" --data '{"description" : "Billing service", "permissions" : ["cards:read", "cards:balance"]}'
+
+echo "\n Create API key for billing service with billing role, the key itself is shown only once"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/admin/api-keys" --data '{"name" : "billing", "scopes" : ["billing"]}'
+
+echo "\n Create API key expiring at the end of year"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/admin/api-keys" --data '{"name" : "temporary", "scopes" : [], "expires_at" : "2026-12-31T23:59:59Z"}'
+
+echo "\n All active API keys"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/admin/api-keys"
+
+echo "\n Revoke first API key"
+curl --header "X-API-Key: $API_KEY" --request DELETE "localhost:10000/v1/admin/api-keys/1"
+
+echo "\n All API keys including revoked"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/admin/api-keys?include_revoked=true"
+
+echo "\n All permissions and roles"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/admin/permissions"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/admin/roles"
+
+echo "\n Negative case of role with unknown permission"
+curl --header "X-API-Key: $API_KEY" --request PUT "localhost:10000/v1/admin/roles/auditor" --data '{"permissions" : ["everything"]}'
+
+echo "\n Make second user a support employee"
+curl --header "X-API-Key: $API_KEY" --request PUT "localhost:10000/v1/admin/users/2/roles" --data '{"roles" : ["support"]}'
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/admin/users/2/roles"
+
+echo "\n Link subject of an identity provider to second user, its tokens act as the user"
+curl --header "X-API-Key: $API_KEY" --request PUT "localhost:10000/v1/admin/users/2/identities" --data '{"identities" : [{"issuer" : "https://id.example.com", "subject" : "auth0|42"}]}'
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/admin/users/2/identities"
+
+echo "\n Negative case of managing keys without api_keys:manage permission (pass key of billing service)"
+curl --header "Authorization: Bearer $SERVICE_KEY" "localhost:10000/v1/admin/api-keys"
+
+echo "\n Request with JWT issued for user 3 (HS256, signed with auth.jwt.secret)"
+curl --header "Authorization: Bearer $USER_TOKEN" "localhost:10000/v1/auth/me"
+
+echo "\n User 3 gets only own cards"
+curl --header "Authorization: Bearer $USER_TOKEN" "localhost:10000/v1/cards"
+
+echo "\n Negative case of user 3 reading another user"
+curl --header "Authorization: Bearer $USER_TOKEN" "localhost:10000/v1/users/1"
+
+echo "\n Negative case of user 3 editing balance"
+curl --header "Authorization: Bearer $USER_TOKEN" --header "If-Match: *" --request PUT "localhost:10000/v1/cards/1" --data '{"balance" : 1000000}'
+
+echo "\n Register user with password"
+curl --request POST "localhost:10000/v1/auth/register" --data '{"username" : "Ivan Petrov", "email" : "ivan.petrov@example.com", "password" : "correct horse battery"}'
+
+echo "\n Negative case of too short password"
+curl --request POST "localhost:10000/v1/auth/register" --data '{"username" : "Ivan Petrov", "email" : "ivan2@example.com", "password" : "short"}'
+
+echo "\n Negative case of login with wrong password"
+curl --request POST "localhost:10000/v1/auth/login" --data '{"email" : "ivan.petrov@example.com", "password" : "wrong password"}'
+
+echo "\n Login, returns access and refresh tokens"
+curl --request POST "localhost:10000/v1/auth/login" --data '{"email" : "ivan.petrov@example.com", "password" : "correct horse battery"}'
+
+echo "\n Exchange refresh token for a new pair (pass refresh token from login), the old one can't be used again"
+curl --request POST "localhost:10000/v1/auth/refresh" --data "{\"refresh_token\" : \"$REFRESH_TOKEN\"}"
+
+echo "\n Negative case of refresh token reuse, revokes the whole session"
+curl --request POST "localhost:10000/v1/auth/refresh" --data "{\"refresh_token\" : \"$REFRESH_TOKEN\"}"
+
+echo "\n Logout of the session"
+curl --request POST "localhost:10000/v1/auth/logout" --data "{\"refresh_token\" : \"$REFRESH_TOKEN\"}"
+
+echo "\n Logout of all sessions (pass access token from login)"
+curl --header "Authorization: Bearer $ACCESS_TOKEN" --request POST "localhost:10000/v1/auth/logout-all"
+
+echo "\n Request password reset, the token is written to the server log"
+curl --request POST "localhost:10000/v1/auth/password-reset" --data '{"email" : "ivan.petrov@example.com"}'
+
+echo "\n Set new password by reset token (pass token from the server log)"
+curl --request POST "localhost:10000/v1/auth/password-reset/confirm" --data "{\"token\" : \"$RESET_TOKEN\", \"password\" : \"new correct horse battery\"}"
+
+echo "\n Create API key pinned to a tenant (the tenant must exist in the tenants table)"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/admin/api-keys" --data '{"name" : "partner backend", "scopes" : ["support"], "tenant_id" : "default"}'
+
+echo "\n Register user of a tenant named by the header"
+curl --header "X-Tenant-ID: default" --request POST "localhost:10000/v1/auth/register" --data '{"username" : "Petr Ivanov", "email" : "petr.ivanov@example.com", "password" : "correct horse battery"}'
+
+echo "\n Negative case of a tenant key acting on another tenant (pass key of the tenant)"
+curl --header "X-API-Key: $TENANT_API_KEY" --header "X-Tenant-ID: other" --request GET "localhost:10000/v1/users"
+
+echo "\n Negative case of invalid tenant id"
+curl --header "X-API-Key: $API_KEY" --header "X-Tenant-ID: Not Valid" --request GET "localhost:10000/v1/users"
+
+echo "\n Audit log of manual balance edits of a card"
+curl --header "X-API-Key: $API_KEY" --request GET "localhost:10000/v1/audit?entity_type=card&entity_id=1&action=update"
+
+echo "\n Audit log of one request (pass X-Request-ID returned by the request)"
+curl --header "X-API-Key: $API_KEY" --request GET "localhost:10000/v1/audit?request_id=$REQUEST_ID"
+
+echo "\n Verify the hash chain of the audit log"
+curl --header "X-API-Key: $API_KEY" --request GET "localhost:10000/v1/audit/verify"
+
+echo "\n OpenAPI specification, no credentials required"
+curl --request GET "localhost:10000/openapi.json"
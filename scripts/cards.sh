@@ -1,53 +1,107 @@
+API_KEY=${API_KEY:-dev-admin-key-change-me}
+
 echo "All cards"
-curl "localhost:10000/cards"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards"
 
 echo "\n Add first card with userId=2"
-curl --request POST "localhost:10000/cards" --data '{"balance" : 1000, "userId" : 2}'
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/cards" --data '{"balance" : 1000, "userId" : 2}'
 
 echo "\n Add second card with userId=3"
-curl --request POST "localhost:10000/cards" --data '{"balance" : 2000, "userId" : 3}'
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/cards" --data '{"balance" : 2000, "userId" : 3}'
 
 echo "\n Add third card with userId=4"
-curl --request POST "localhost:10000/cards" --data '{"balance" : 3000, "userId" : 4}'
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/cards" --data '{"balance" : 3000, "userId" : 4}'
 
 echo "\n Get list of cards"
-curl "localhost:10000/cards"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards"
 
 echo "\n Get info about first card"
-curl "localhost:10000/cards/1"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards/1"
 
 echo "\n Get info about non-existent card"
-curl "localhost:10000/cards/101"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards/101"
+
+echo "\n Edit info about 1 card (balance to 5000), If-Match holds the ETag of the read card"
+ETAG=$(curl --silent --output /dev/null --dump-header - --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards/1" | grep -i '^etag:' | cut -d' ' -f2 | tr -d '\r')
+curl --header "X-API-Key: $API_KEY" --header "If-Match: $ETAG" --request PUT "localhost:10000/v1/cards/1" --data '{"balance" : 5000}'
+
+echo "\n Negative case of editing with outdated ETag (412 Precondition Failed)"
+curl --header "X-API-Key: $API_KEY" --header "If-Match: $ETAG" --request PUT "localhost:10000/v1/cards/1" --data '{"balance" : 6000}'
 
-echo "\n Edit info about 1 card (balance to 5000)"
-curl --request PUT "localhost:10000/cards/1" --data '{"balance" : 5000}'
+echo "\n Change balance of 1 card with JSON Merge Patch"
+curl --header "X-API-Key: $API_KEY" --header "Content-Type: application/merge-patch+json" --request PATCH "localhost:10000/v1/cards/1" --data '{"balance" : 7000}'
+
+echo "\n Negative case of JSON Patch with failed test (409 Conflict)"
+curl --header "X-API-Key: $API_KEY" --header "Content-Type: application/json-patch+json" --request PATCH "localhost:10000/v1/cards/1" --data '[{"op" : "test", "path" : "/balance", "value" : 1}, {"op" : "replace", "path" : "/balance", "value" : 0}]'
 
 echo "\n Edit info about non-existent card"
-curl --request PUT "localhost:10000/cards/101" --data '{"balance" : 5000}'
+curl --header "X-API-Key: $API_KEY" --header "If-Match: *" --request PUT "localhost:10000/v1/cards/101" --data '{"balance" : 5000}'
 
 echo "\n Get list of cards"
-curl "localhost:10000/cards"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards"
 
 echo "\n Delete info about first card"
-curl --request DELETE "localhost:10000/cards/1"
+curl --header "X-API-Key: $API_KEY" --request DELETE "localhost:10000/v1/cards/1"
 
 echo "\n Delete info about non-existent card"
-curl --request DELETE "localhost:10000/cards/1"
+curl --header "X-API-Key: $API_KEY" --request DELETE "localhost:10000/v1/cards/1"
+
+echo "\n Get list of cards including deleted (admin)"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards?include_deleted=true"
+
+echo "\n Restore first card"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/cards/1/restore"
 
 echo "\n Get list of cards"
-curl "localhost:10000/cards"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards"
 
 echo "\n Refill second card"
-curl --request POST "localhost:10000/cards/2" --data '{"AddBalance" : 100000}'
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/cards/2" --data '{"AddBalance" : 100000}'
 
 echo "\n Refill non-existent card"
-curl --request POST "localhost:10000/cards/200" --data '{"AddBalance" : 100000}'
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/cards/200" --data '{"AddBalance" : 100000}'
 
 echo "\n Transfer from one to other card (from second card to third card, 100 counts"
-curl --request POST "localhost:10000/cards/transfer" --data '{"CardFrom" : 2, "CardTo": 3, "AddBalance" : 100}'
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/cards/transfer" --data '{"CardFrom" : 2, "CardTo": 3, "AddBalance" : 100}'
 
 echo "\n Negative case of transfer from one to other card"
-curl --request POST "localhost:10000/cards/transfer" --data '{"CardFrom" : 3, "CardTo": 4, "AddBalance" : 100000}'
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/cards/transfer" --data '{"CardFrom" : 3, "CardTo": 4, "AddBalance" : 100000}'
 
 echo "\n Get list of cards"
-curl "localhost:10000/cards"
\ No newline at end of file
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards"
+
+echo "\n Get list of cards with balance between 1000 and 5000, biggest first"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards?balance_min=1000&balance_max=5000&sort=-balance"
+
+echo "\n Get list of cards created since 2022-04-01"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards?created_from=2022-04-01"
+
+echo "\n Get first page of cards in cursor mode (use next_cursor from response as after)"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards?limit=2&count=true"
+
+echo "\n Get history of second card"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards/2/history?limit=10"
+
+echo "\n Negative case of invalid cursor"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/cards?after=broken"
+
+echo "\n High-value transfer by user (pass access token of card owner with enrolled 2FA), returns pending transfer and holds the amount"
+curl --header "Authorization: Bearer $USER_TOKEN" --request POST "localhost:10000/v1/cards/transfer" --data '{"CardFrom" : 2, "CardTo": 3, "AddBalance" : 60000}'
+
+echo "\n Get pending transfer"
+curl --header "Authorization: Bearer $USER_TOKEN" "localhost:10000/v1/cards/transfers/1"
+
+echo "\n Negative case of confirmation with wrong code"
+curl --header "Authorization: Bearer $USER_TOKEN" --request POST "localhost:10000/v1/cards/transfers/1/confirm" --data '{"code" : "000000"}'
+
+echo "\n Confirm transfer with code from authenticator app"
+curl --header "Authorization: Bearer $USER_TOKEN" --request POST "localhost:10000/v1/cards/transfers/1/confirm" --data "{\"code\" : \"$TOTP_CODE\"}"
+
+echo "\n Cancel pending transfer"
+curl --header "Authorization: Bearer $USER_TOKEN" --request DELETE "localhost:10000/v1/cards/transfers/2"
+
+echo "\n Export cards with balance from 100 as CSV"
+curl --header "X-API-Key: $API_KEY" --output cards.csv "localhost:10002/v1/cards/export?format=csv&balance_min=100"
+
+echo "\n Export cards of the user as NDJSON (pass access token of the user)"
+curl --header "Authorization: Bearer $USER_TOKEN" "localhost:10002/v1/cards/export"
@@ -0,0 +1,25 @@
+API_KEY=${API_KEY:-dev-admin-key-change-me}
+
+echo "User with cards and recent transfers"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/graphql" --data '{"query": "{ user(user_id: 2) { user_id user_full_name email cards { card_id balance } recent_transfers(limit: 5) { card_id operation amount create_time } } }"}'
+
+echo "\n Users with their cards, cards are read with one query for the whole page"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/graphql" --data '{"query": "query Users($limit: Int) { users(limit: $limit, sort: \"-create_time\") { items { user_id user_full_name cards { card_id balance } } next_cursor } }", "variables": {"limit": 5}}'
+
+echo "\n Card with its owner"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/graphql" --data '{"query": "{ card(card_id: 1) { card_id balance owner { user_id user_full_name } } }"}'
+
+echo "\n Refill card"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/graphql" --data '{"query": "mutation { refill_card(card_id: 1, amount: 500) { card_id balance } }"}'
+
+echo "\n Transfer"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/graphql" --data '{"query": "mutation { transfer(card_from: 1, card_to: 2, amount: 200) { pending transfer { transfer_id expires_at } card_from { card_id balance } } }"}'
+
+echo "\n Negative case of insufficient funds, insufficient_funds in extensions"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/graphql" --data '{"query": "mutation { transfer(card_from: 2, card_to: 1, amount: 5000000) { pending } }"}'
+
+echo "\n Negative case of a query nested too deep, query_too_deep"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/graphql" --data '{"query": "{ user(user_id: 2) { cards { owner { cards { owner { cards { card_id } } } } } } }"}'
+
+echo "\n Negative case without credentials, unauthenticated problem details"
+curl --request POST "localhost:10000/v1/graphql" --data '{"query": "{ card(card_id: 1) { card_id } }"}'
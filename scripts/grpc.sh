@@ -0,0 +1,27 @@
+API_KEY=${API_KEY:-dev-admin-key-change-me}
+
+echo "Services exposed through reflection"
+grpcurl -plaintext localhost:10001 list
+
+echo "\n Create user"
+grpcurl -plaintext -H "x-api-key: $API_KEY" -d '{"profile": {"username": "Petrov Ivan", "email": "petrov@example.com"}}' localhost:10001 gotask.v1.UsersService/CreateUser
+
+echo "\n Get user"
+grpcurl -plaintext -H "x-api-key: $API_KEY" -d '{"user_id": 1}' localhost:10001 gotask.v1.UsersService/GetUser
+
+echo "\n List users"
+grpcurl -plaintext -H "x-api-key: $API_KEY" -d '{"limit": 10, "sort": "-create_time"}' localhost:10001 gotask.v1.UsersService/ListUsers
+
+echo "\n Create cards and refill"
+grpcurl -plaintext -H "x-api-key: $API_KEY" -d '{"user_id": 1, "balance": 100}' localhost:10001 gotask.v1.CardsService/CreateCard
+grpcurl -plaintext -H "x-api-key: $API_KEY" -d '{"user_id": 1}' localhost:10001 gotask.v1.CardsService/CreateCard
+grpcurl -plaintext -H "x-api-key: $API_KEY" -d '{"card_id": 1, "amount": 500}' localhost:10001 gotask.v1.CardsService/RefillCard
+
+echo "\n Transfer"
+grpcurl -plaintext -H "x-api-key: $API_KEY" -d '{"card_from": 1, "card_to": 2, "amount": 200}' localhost:10001 gotask.v1.CardsService/TransferBalance
+
+echo "\n Negative case of insufficient funds, FAILED_PRECONDITION"
+grpcurl -plaintext -H "x-api-key: $API_KEY" -d '{"card_from": 2, "card_to": 1, "amount": 5000}' localhost:10001 gotask.v1.CardsService/TransferBalance
+
+echo "\n Negative case without credentials, UNAUTHENTICATED"
+grpcurl -plaintext -d '{"card_id": 1}' localhost:10001 gotask.v1.CardsService/GetCard
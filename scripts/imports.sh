@@ -0,0 +1,22 @@
+API_KEY=${API_KEY:-dev-admin-key-change-me}
+
+echo "Import users from CSV, responds 202 with the import to poll"
+printf 'username,first_name,last_name,email,phone,birth_date\n,Anna,Smirnova,smirnova@example.com,+79001112233,1991-02-03\nKuznetsov Oleg,,,kuznetsov@example.com,,\n,,,broken-email,,\n' | \
+curl --header "X-API-Key: $API_KEY" --header "Content-Type: text/csv" --request POST "localhost:10000/v1/imports?entity=users" --data-binary @-
+
+echo "\n Status and counts of the import"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/imports/1"
+
+echo "\n Rows which were not imported"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/imports/1/errors?page=1&size=10"
+
+echo "\n Import cards from JSON Lines"
+printf '{"user_id" : 1, "balance" : 100}\n{"user_id" : 2}\n{"user_id" : 100000}\n' | \
+curl --header "X-API-Key: $API_KEY" --header "Content-Type: application/x-ndjson" --request POST "localhost:10000/v1/imports?entity=cards" --data-binary @-
+
+echo "\n Negative case of unknown CSV column, the import fails as a whole"
+printf 'user_id,owner\n1,x\n' | \
+curl --header "X-API-Key: $API_KEY" --header "Content-Type: text/csv" --request POST "localhost:10000/v1/imports?entity=cards" --data-binary @-
+
+echo "\n Negative case of unsupported file type"
+curl --header "X-API-Key: $API_KEY" --header "Content-Type: application/json" --request POST "localhost:10000/v1/imports?entity=users" --data '[]'
@@ -1,38 +1,160 @@
+API_KEY=${API_KEY:-dev-admin-key-change-me}
+
 echo "All users"
-curl "localhost:10000/users"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users"
 
 echo "\n Add first user Petrov Petr Petrovich"
-curl --request POST "localhost:10000/users" --data '{"username" : "Petrov Petr Petrovich"}'
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users" --data '{"username" : "Petrov Petr Petrovich"}'
 
 echo "\n Add second user Ivanov Ivan Asetrovich"
-curl --request POST "localhost:10000/users" --data '{"username" : "Ivanov Ivan Asetrovich"}'
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users" --data '{"username" : "Ivanov Ivan Asetrovich"}'
 
 echo "\n Add third user Alex Alexov Alexovich"
-curl --request POST "localhost:10000/users" --data '{"username" : "Alex Alexov Alexovich"}'
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users" --data '{"username" : "Alex Alexov Alexovich"}'
 
 echo "\n Add fourth user Vlad Kek Alexovich"
-curl --request POST "localhost:10000/users" --data '{"username" : "Vlad Kek Alexovich"}'
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users" --data '{"username" : "Vlad Kek Alexovich"}'
+
+echo "\n Add fifth user with full profile"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users" --data '{"first_name" : "Sergey", "last_name" : "Sidorov", "email" : "sidorov@example.com", "phone" : "+7 (900) 123-45-67", "birth_date" : "1990-05-17", "addresses" : [{"kind" : "home", "country" : "RU", "city" : "Kazan", "street" : "Baumana 1", "postal_code" : "420111"}]}'
+
+echo "\n Negative case of adding user with already used email"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users" --data '{"username" : "Sidorov Sergey", "email" : "SIDOROV@example.com"}'
+
+echo "\n Negative case of adding user with invalid phone"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users" --data '{"username" : "Sidorov Sergey", "phone" : "8900"}'
 
 echo "\n Get list of users"
-curl "localhost:10000/users"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users"
+
+echo "\n Search users by part of name (case-insensitive)"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users?user_name=petrov"
+
+echo "\n Search users by name with typo, email or phone"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users?q=Petorv"
+
+echo "\n Get list of users sorted by last name and newest first"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users?sort=last_name,-create_time"
+
+echo "\n Get list of users created in April 2022 with total balance at least 1000"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users?created_from=2022-04-01&created_to=2022-04-30&balance_min=1000"
+
+echo "\n Negative case of sorting by unsupported field"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users?sort=password"
+
+echo "\n Negative case of invalid pagination, errors are listed per field"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users?page=-5&size=100000"
+
+echo "\n Get first page of users in cursor mode (use next_cursor from response as after)"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users?limit=2"
+
+echo "\n Get list of users filtered by email"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users?email=sidorov@example.com"
 
 echo "\n Get info about first user"
-curl "localhost:10000/users/1"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users/1"
 
 echo "\n Get info about non-existent user"
-curl "localhost:10000/users/101"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users/101"
+
+echo "\n Get info about second user only if it changed since the ETag (304 Not Modified otherwise)"
+ETAG=$(curl --silent --output /dev/null --dump-header - --header "X-API-Key: $API_KEY" "localhost:10000/v1/users/2" | grep -i '^etag:' | cut -d' ' -f2 | tr -d '\r')
+curl --include --header "X-API-Key: $API_KEY" --header "If-None-Match: $ETAG" "localhost:10000/v1/users/2"
+
+echo "\n Edit info about 2 user to Some Some Somisch, If-Match holds the ETag of the read user"
+curl --header "X-API-Key: $API_KEY" --header "If-Match: $ETAG" --request PUT "localhost:10000/v1/users/2" --data '{"username" : "Some Some Somisch"}'
+
+echo "\n Negative case of editing with outdated ETag (412 Precondition Failed)"
+curl --header "X-API-Key: $API_KEY" --header "If-Match: $ETAG" --request PUT "localhost:10000/v1/users/2" --data '{"username" : "Some Some Somisch"}'
 
-echo "\n Edit info about 2 user to Some Some Somisch"
-curl --request PUT "localhost:10000/users/2" --data '{"username" : "Some Some Somisch"}'
+echo "\n Negative case of editing without If-Match (428 Precondition Required)"
+curl --header "X-API-Key: $API_KEY" --request PUT "localhost:10000/v1/users/2" --data '{"username" : "Some Some Somisch"}'
+
+echo "\n Change only the email of second user with JSON Merge Patch, other fields are kept"
+curl --header "X-API-Key: $API_KEY" --header "Content-Type: application/merge-patch+json" --request PATCH "localhost:10000/v1/users/2" --data '{"email" : "somisch@example.com"}'
+
+echo "\n Remove the phone of second user and check the name first with JSON Patch"
+curl --header "X-API-Key: $API_KEY" --header "Content-Type: application/json-patch+json" --request PATCH "localhost:10000/v1/users/2" --data '[{"op" : "test", "path" : "/username", "value" : "Some Some Somisch"}, {"op" : "replace", "path" : "/phone", "value" : ""}]'
+
+echo "\n Negative case of patch with invalid result (validation errors as on PUT)"
+curl --header "X-API-Key: $API_KEY" --header "Content-Type: application/merge-patch+json" --request PATCH "localhost:10000/v1/users/2" --data '{"email" : "not an email"}'
 
 echo "\n Edit info about non-existent user to Some Some Somisch"
-curl --request PUT "localhost:10000/users/1000" --data '{"username" : "Some Some Somisch"}'
+curl --header "X-API-Key: $API_KEY" --header "If-Match: *" --request PUT "localhost:10000/v1/users/1000" --data '{"username" : "Some Some Somisch"}'
 
 echo "\n Delete info about 1 user"
-curl --request DELETE "localhost:10000/users/1"
+curl --header "X-API-Key: $API_KEY" --request DELETE "localhost:10000/v1/users/1"
 
 echo "\n Delete info non-existent 1 user"
-curl --request DELETE "localhost:10000/users/100"
+curl --header "X-API-Key: $API_KEY" --request DELETE "localhost:10000/v1/users/100"
+
+echo "\n Get info about deleted first user (admin)"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users/1?include_deleted=true"
+
+echo "\n Restore deleted first user"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users/1/restore"
+
+echo "\n Negative case of restoring not deleted user"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users/2/restore"
 
 echo "\n Get list of users"
-curl "localhost:10000/users"
\ No newline at end of file
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users"
+echo "\n Upload passport of second user for KYC verification"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users/2/kyc/documents" -F "type=passport" -F "file=@passport.pdf"
+
+echo "\n Get KYC status of second user"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users/2/kyc"
+
+echo "\n Get list of pending KYC documents"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/admin/kyc/documents?status=pending"
+
+echo "\n Approve first KYC document with basic level"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/admin/kyc/documents/1/approve" --data '{"level" : "basic"}'
+
+echo "\n Negative case of rejecting already reviewed document"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/admin/kyc/documents/1/reject" --data '{"comment" : "Blurred photo"}'
+
+echo "\n Merge duplicate fourth user into third user (cards, history and documents move to third)"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users/3/merge" --data '{"source_user_id" : 4}'
+
+echo "\n Get merged user, redirects to the user it was merged into"
+curl --header "X-API-Key: $API_KEY" --location "localhost:10000/v1/users/4"
+
+echo "\n Negative case of merging user into itself"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users/3/merge" --data '{"source_user_id" : 3}'
+
+echo "\n Export all personal data of fifth user as zip archive"
+curl --header "X-API-Key: $API_KEY" --output user-5-export.zip "localhost:10000/v1/users/5/export"
+
+echo "\n Export all personal data of fifth user as single JSON"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users/5/export?format=json"
+
+echo "\n Erase personal data of fifth user (cards and history are kept)"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users/5/erase"
+
+echo "\n Negative case of editing erased user"
+curl --header "X-API-Key: $API_KEY" --header "If-Match: *" --request PUT "localhost:10000/v1/users/5" --data '{"username" : "Sidorov Sergey"}'
+
+echo "\n Enroll two-factor authentication (pass access token of the user), returns secret and otpauth link"
+curl --header "Authorization: Bearer $USER_TOKEN" --request POST "localhost:10000/v1/users/3/2fa"
+
+echo "\n Confirm enrollment with code from authenticator app"
+curl --header "Authorization: Bearer $USER_TOKEN" --request POST "localhost:10000/v1/users/3/2fa/confirm" --data "{\"code\" : \"$TOTP_CODE\"}"
+
+echo "\n Two-factor authentication state"
+curl --header "X-API-Key: $API_KEY" "localhost:10000/v1/users/3/2fa"
+
+echo "\n Negative case of enrollment on behalf of another user"
+curl --header "X-API-Key: $API_KEY" --request POST "localhost:10000/v1/users/3/2fa"
+
+echo "\n Reset two-factor authentication of user who lost the device"
+curl --header "X-API-Key: $API_KEY" --request DELETE "localhost:10000/v1/users/3/2fa" --data '{}'
+
+echo "\n Export users with last name Sidorov as NDJSON"
+curl --header "X-API-Key: $API_KEY" "localhost:10002/v1/users/export?last_name=Sidorov"
+
+echo "\n Export all users including deleted as CSV"
+curl --header "X-API-Key: $API_KEY" --output users.csv "localhost:10002/v1/users/export?format=csv&include_deleted=true&sort=create_time"
+
+echo "\n Negative case of unsupported export format"
+curl --header "X-API-Key: $API_KEY" "localhost:10002/v1/users/export?format=xml"